@@ -0,0 +1,128 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/spidernet-io/egressgateway/pkg/egressctl"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+var (
+	namespace       string
+	datapathOptions egressctl.DatapathOptions
+)
+
+// rootCmd represents the base command, kubectl runs it as "kubectl egress".
+var rootCmd = &cobra.Command{
+	Use:           "kubectl-egress",
+	Short:         "Inspect and troubleshoot the egress gateway",
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the gateways, the ippools and the gateway nodes",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return run(func(i *egressctl.Inspector) error {
+			return i.Status(cmd.Context())
+		})
+	},
+}
+
+var whoCmd = &cobra.Command{
+	Use:   "who <pod>",
+	Short: "Show the policies, the EIP and the gateway node of a pod",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return run(func(i *egressctl.Inspector) error {
+			return i.Who(cmd.Context(), namespace, args[0])
+		})
+	},
+}
+
+var traceCmd = &cobra.Command{
+	Use:   "trace <pod> <dest>",
+	Short: "Show the expected path of the traffic of a pod to a destination IP",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return run(func(i *egressctl.Inspector) error {
+			return i.Trace(cmd.Context(), namespace, args[0], args[1])
+		})
+	},
+}
+
+var nodesCmd = &cobra.Command{
+	Use:   "nodes",
+	Short: "Show the tunnel of the nodes and the reachability of their peers",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return run(func(i *egressctl.Inspector) error {
+			return i.Nodes(cmd.Context())
+		})
+	},
+}
+
+var datapathCmd = &cobra.Command{
+	Use:   "datapath <node>",
+	Short: "Show the desired and the actual datapath of the agent of a node, with the drift",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return run(func(i *egressctl.Inspector) error {
+			return i.Datapath(cmd.Context(), args[0], datapathOptions)
+		})
+	},
+}
+
+func run(f func(i *egressctl.Inspector) error) error {
+	cfg, err := ctrl.GetConfig()
+	if err != nil {
+		return err
+	}
+	cli, err := client.New(cfg, client.Options{Scheme: schema.GetScheme()})
+	if err != nil {
+		return err
+	}
+	clientSet, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	// the agents are reached through the API server, as their port may not be
+	// reachable from outside of the cluster
+	proxy := func(ctx context.Context, namespace, pod string, port int, path string) ([]byte, error) {
+		return clientSet.CoreV1().Pods(namespace).ProxyGet("http", pod, strconv.Itoa(port), path, nil).DoRaw(ctx)
+	}
+	return f(egressctl.New(cli, os.Stdout).WithProxy(proxy))
+}
+
+// Execute adds all child commands to the root command sets flags appropriately.
+// This is called by main.main(). It only needs to happen once to the rootCmd.
+func Execute() {
+	// the kubeconfig flag is registered by controller-runtime
+	rootCmd.PersistentFlags().AddGoFlagSet(flag.CommandLine)
+	rootCmd.PersistentFlags().StringVarP(&namespace, "namespace", "n", "default", "Namespace of the pod")
+
+	datapathCmd.Flags().StringVar(&datapathOptions.AgentSelector, "agent-selector",
+		"app.kubernetes.io/component=egressgateway-agent", "Label selector of the agent pods")
+	datapathCmd.Flags().IntVar(&datapathOptions.Port, "port", 5813, "Debug API port of the agent")
+	datapathCmd.Flags().StringVarP(&datapathOptions.Output, "output", "o", "", "Output format, json prints the state reported by the agent")
+
+	rootCmd.AddCommand(statusCmd, whoCmd, traceCmd, nodesCmd, datapathCmd)
+	if err := rootCmd.ExecuteContext(context.Background()); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
@@ -4,107 +4,167 @@
 package batch
 
 import (
-	"bufio"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"github.com/spidernet-io/egressgateway/cmd/nettools/flag"
 	"io"
-	"net"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/spidernet-io/egressgateway/cmd/nettools/client/probe"
+	"github.com/spidernet-io/egressgateway/cmd/nettools/flag"
 )
 
-type Case func(ctx context.Context, config flag.Config) error
+// The exit codes of the batch mode
+const (
+	ExitOK = 0
+	// ExitError is the exit code of the other errors, e.g. an invalid flag
+	ExitError = 1
+	// ExitConnectFailed is the exit code when a protocol gets no reply of the server
+	ExitConnectFailed = 2
+	// ExitMismatch is the exit code when the source IP observed by the server is not
+	// the expected one, it wins over ExitConnectFailed
+	ExitMismatch = 3
+)
 
-// Batch is used to e2e
-func Batch(ctx context.Context, config flag.Config) error {
+var (
+	ErrConnectFailed = errors.New("connection failed")
+	ErrMismatch      = errors.New("egress IP mismatch")
+)
+
+// Result is the result of the check of a protocol
+type Result struct {
+	Protocol string `json:"protocol"`
+	// LocalAddr is the local address of the connection
+	LocalAddr string `json:"localAddr,omitempty"`
+	// SourceIP is the source IP of the connection observed by the server
+	SourceIP string `json:"sourceIP,omitempty"`
+	// EgressIP is the expected egress IP, given by -eip
+	EgressIP string `json:"eip,omitempty"`
+	// EIPMatch reports whether SourceIP is EgressIP, it is not set without -eip or reply
+	EIPMatch *bool `json:"eipMatch,omitempty"`
+	// LatencyMs is the time to connect in milliseconds, for udp the time to get the reply
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Batch is used to e2e. It checks each protocol once and returns the results, the error
+// wraps ErrMismatch when a source IP is not the expected one, ErrConnectFailed when
+// a protocol gets no reply.
+func Batch(ctx context.Context, config flag.Config) ([]Result, error) {
 	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(*config.Timeout))
 	defer cancel()
 
-	var cases []Case
-
+	var protocols []string
 	switch *config.Proto {
-	case "udp":
-		cases = []Case{udp}
-	case "tcp":
-		cases = []Case{tcp}
-	case "wss":
-		cases = []Case{wss}
+	case flag.ProtocolUdp:
+		protocols = []string{flag.ProtocolUdp}
+	case flag.ProtocolTcp:
+		protocols = []string{flag.ProtocolTcp}
+	case flag.ProtocolWeb, "wss":
+		protocols = []string{flag.ProtocolWeb}
 	default:
-		cases = []Case{tcp, udp, wss}
+		protocols = []string{flag.ProtocolTcp, flag.ProtocolUdp, flag.ProtocolWeb}
 	}
 
+	results := make([]Result, len(protocols))
 	wg := &sync.WaitGroup{}
-
-	for _, c := range cases {
-		cc := c
+	for i, protocol := range protocols {
 		wg.Add(1)
-		go func(wg *sync.WaitGroup) {
-			err := cc(ctx, config)
-			if err != nil {
-				fmt.Println(err)
-				cancel()
-			}
-			wg.Done()
-		}(wg)
+		go func(i int, protocol string) {
+			defer wg.Done()
+			results[i] = newResult(probe.Check(ctx, protocol, config), *config.EgressIP)
+		}(i, protocol)
 	}
-
 	wg.Wait()
-	return nil
+
+	return results, check(results, *config.Contain)
 }
 
-func tcp(ctx context.Context, config flag.Config) error {
-	addrStr := fmt.Sprintf("%s:%s", *config.Addr, *config.TcpPort)
-	dialer := net.Dialer{Timeout: 3 * time.Second}
-	conn, err := dialer.DialContext(ctx, "tcp", addrStr)
-	if err != nil {
-		return fmt.Errorf("failed to connect tcp server: %v", err)
+func newResult(res probe.Result, eip string) Result {
+	item := Result{
+		Protocol:  res.Protocol,
+		LocalAddr: res.Local,
+		SourceIP:  res.Source,
+		EgressIP:  eip,
+		LatencyMs: float64(res.Latency.Microseconds()) / 1000,
 	}
-	defer func() {
-		_ = conn.Close()
-	}()
-
-	reader := bufio.NewReader(conn)
-	b := []byte(conn.LocalAddr().String() + " Say hello to TCP Server... \n")
-	_, err = conn.Write(b)
-	if err != nil {
-		return fmt.Errorf("failed send msg to tcp server: %v", err)
+	if res.Err != nil {
+		item.Error = res.Err.Error()
+		return item
 	}
+	if eip != "" {
+		match := probe.Match(res.Source, eip)
+		item.EIPMatch = &match
+	}
+	return item
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return fmt.Errorf("cancel to check egress IP")
-		default:
-			msg, err := reader.ReadString('\n')
-
-			if err != nil || err == io.EOF {
-				fmt.Println(err)
-				break
-			}
-
-			if strings.Contains(msg, *config.EgressIP) {
-				if *config.Contain {
-					return nil
-				} else {
-					continue
-				}
-			} else {
-				if !*config.Contain {
-					return nil
-				} else {
-					return fmt.Errorf("wo got egressIP")
-				}
-			}
+// check returns the error of the results, contain is false when the source IP is
+// expected not to be the egress IP
+func check(results []Result, contain bool) error {
+	var mismatches, failures []string
+	for _, res := range results {
+		switch {
+		case res.Error != "":
+			failures = append(failures, fmt.Sprintf("%s: %s", res.Protocol, res.Error))
+		case res.EIPMatch != nil && *res.EIPMatch != contain:
+			mismatches = append(mismatches, fmt.Sprintf("%s: source IP %s, egress IP %s, contain %v",
+				res.Protocol, res.SourceIP, res.EgressIP, contain))
 		}
 	}
+	switch {
+	case len(mismatches) > 0:
+		return fmt.Errorf("%w: %s", ErrMismatch, strings.Join(append(mismatches, failures...), "; "))
+	case len(failures) > 0:
+		return fmt.Errorf("%w: %s", ErrConnectFailed, strings.Join(failures, "; "))
+	}
+	return nil
 }
 
-func udp(ctx context.Context, config flag.Config) error {
-	return nil
+// ExitCode returns the exit code of the error of Batch
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, ErrMismatch):
+		return ExitMismatch
+	case errors.Is(err, ErrConnectFailed):
+		return ExitConnectFailed
+	}
+	return ExitError
 }
 
-func wss(ctx context.Context, config flag.Config) error {
+// Print writes the results, as JSON when output is json
+func Print(w io.Writer, output string, results []Result, err error) error {
+	if output == "json" {
+		res := struct {
+			Results []Result `json:"results"`
+			Error   string   `json:"error,omitempty"`
+		}{Results: results}
+		if err != nil {
+			res.Error = err.Error()
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	}
+
+	for _, res := range results {
+		switch {
+		case res.Error != "":
+			fmt.Fprintf(w, "%s: %s\n", res.Protocol, res.Error)
+		case res.EIPMatch != nil:
+			fmt.Fprintf(w, "%s: %s -> source IP %s, egress IP %s, match %v, %.3fms\n",
+				res.Protocol, res.LocalAddr, res.SourceIP, res.EgressIP, *res.EIPMatch, res.LatencyMs)
+		default:
+			fmt.Fprintf(w, "%s: %s -> source IP %s, %.3fms\n", res.Protocol, res.LocalAddr, res.SourceIP, res.LatencyMs)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(w, err)
+	}
 	return nil
 }
@@ -0,0 +1,74 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package batch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/cmd/nettools/client/probe"
+	"github.com/spidernet-io/egressgateway/cmd/nettools/flag"
+)
+
+func TestNewResult(t *testing.T) {
+	res := newResult(probe.Result{Protocol: flag.ProtocolTcp, Local: "10.21.0.5:41236", Source: "172.18.1.2",
+		Latency: 1500 * time.Microsecond}, "172.18.1.2")
+	assert.Equal(t, 1.5, res.LatencyMs)
+	if assert.NotNil(t, res.EIPMatch) {
+		assert.True(t, *res.EIPMatch)
+	}
+
+	res = newResult(probe.Result{Protocol: flag.ProtocolTcp, Source: "172.18.1.2"}, "")
+	assert.Nil(t, res.EIPMatch)
+
+	res = newResult(probe.Result{Protocol: flag.ProtocolUdp, Err: errors.New("i/o timeout")}, "172.18.1.2")
+	assert.Nil(t, res.EIPMatch)
+	assert.Equal(t, "i/o timeout", res.Error)
+}
+
+func TestCheck(t *testing.T) {
+	match, mismatch := true, false
+	good := Result{Protocol: flag.ProtocolTcp, EIPMatch: &match}
+	wrong := Result{Protocol: flag.ProtocolUdp, EIPMatch: &mismatch}
+	failed := Result{Protocol: flag.ProtocolWeb, Error: "connection refused"}
+
+	assert.NoError(t, check([]Result{good}, true))
+	assert.Equal(t, ExitOK, ExitCode(check([]Result{good}, true)))
+	assert.Equal(t, ExitConnectFailed, ExitCode(check([]Result{good, failed}, true)))
+	assert.Equal(t, ExitMismatch, ExitCode(check([]Result{good, wrong}, true)))
+	// a mismatch wins over a connection failure
+	assert.Equal(t, ExitMismatch, ExitCode(check([]Result{failed, wrong}, true)))
+	// -contain=false expects another source IP than the egress IP
+	assert.Equal(t, ExitOK, ExitCode(check([]Result{wrong}, false)))
+	assert.Equal(t, ExitMismatch, ExitCode(check([]Result{good}, false)))
+	assert.Equal(t, ExitError, ExitCode(errors.New("invalid flag")))
+}
+
+func TestPrintJSON(t *testing.T) {
+	match := true
+	results := []Result{{Protocol: flag.ProtocolTcp, SourceIP: "172.18.1.2", EgressIP: "172.18.1.2", EIPMatch: &match}}
+	buf := &bytes.Buffer{}
+	err := Print(buf, "json", results, ErrConnectFailed)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var out map[string]interface{}
+	if !assert.NoError(t, json.Unmarshal(buf.Bytes(), &out)) {
+		return
+	}
+	assert.Equal(t, ErrConnectFailed.Error(), out["error"])
+	items, _ := out["results"].([]interface{})
+	if assert.Len(t, items, 1) {
+		item := items[0].(map[string]interface{})
+		assert.Equal(t, "172.18.1.2", item["sourceIP"])
+		assert.Equal(t, "172.18.1.2", item["eip"])
+		assert.Equal(t, true, item["eipMatch"])
+	}
+}
@@ -11,14 +11,20 @@ import (
 	"log"
 	"net"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlconfig "sigs.k8s.io/controller-runtime/pkg/client/config"
 
 	"github.com/spidernet-io/egressgateway/cmd/nettools/client/batch"
+	"github.com/spidernet-io/egressgateway/cmd/nettools/client/probe"
 	"github.com/spidernet-io/egressgateway/cmd/nettools/flag"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
 )
 
 var wg sync.WaitGroup
@@ -29,15 +35,30 @@ func main() {
 		log.Fatalln("err: server addr no provide")
 	}
 
-	if *config.Batch {
-		log.Println("batch mode")
-		err := batch.Batch(context.Background(), config)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-			return
+	if *config.Output != "" && *config.Output != "json" {
+		log.Fatalf("output: %s don't support, available outputs: json", *config.Output)
+	}
+
+	// the probe mode runs until it is stopped and exports metrics, it has no output
+	if *config.Probe {
+		if *config.Batch || *config.Output != "" {
+			log.Fatalln("probe mode can't be used with -batch or -output")
+		}
+		if err := runProbe(config); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	if *config.Batch || *config.Output == "json" {
+		if *config.Output != "json" {
+			log.Println("batch mode")
+		}
+		results, err := batch.Batch(context.Background(), config)
+		if err := batch.Print(os.Stdout, *config.Output, results, err); err != nil {
+			log.Println(err)
 		}
-		os.Exit(0)
+		os.Exit(batch.ExitCode(err))
 		return
 	}
 
@@ -68,6 +89,46 @@ func main() {
 	time.Sleep(time.Second * time.Duration(*config.Timeout))
 }
 
+func runProbe(config flag.Config) error {
+	var protocols []string
+	switch protocol := strings.ToLower(*config.Proto); protocol {
+	case flag.ProtocolTcp, flag.ProtocolUdp, flag.ProtocolWeb:
+		protocols = []string{protocol}
+	case flag.ProtocolAll:
+		protocols = []string{flag.ProtocolTcp, flag.ProtocolUdp, flag.ProtocolWeb}
+	default:
+		return fmt.Errorf("protocol: %s don't support, available protocols: tcp,udp,web,all", *config.Proto)
+	}
+
+	var expect probe.ExpectFunc
+	switch {
+	case *config.EgressIP != "":
+		expect = probe.StaticEIP(*config.EgressIP)
+	case *config.Policy != "":
+		restConfig, err := ctrlconfig.GetConfig()
+		if err != nil {
+			return fmt.Errorf("failed to get the kubeconfig: %w", err)
+		}
+		cli, err := client.New(restConfig, client.Options{Scheme: schema.GetScheme()})
+		if err != nil {
+			return fmt.Errorf("failed to create the client: %w", err)
+		}
+		// the pod name is set by the downward API, it is the hostname otherwise
+		pod := os.Getenv("POD_NAME")
+		if pod == "" {
+			pod, _ = os.Hostname()
+		}
+		ip := net.ParseIP(*config.Addr)
+		expect = probe.PolicyEIP(cli, *config.Policy, pod, ip != nil && ip.To4() == nil)
+	default:
+		return fmt.Errorf("probe mode requires -eip or -policy")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return probe.New(config, protocols, expect).Run(ctx)
+}
+
 func tcpClient(config flag.Config) {
 	defer wg.Done()
 
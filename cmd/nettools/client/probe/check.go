@@ -0,0 +1,143 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package probe
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/spidernet-io/egressgateway/cmd/nettools/flag"
+	"github.com/spidernet-io/egressgateway/cmd/nettools/reply"
+)
+
+// Result is the result of a check of a protocol
+type Result struct {
+	Protocol string
+	// Local is the local address of the connection
+	Local string
+	// Source is the source IP of the connection observed by the server
+	Source string
+	// Latency is the time to connect, for udp the time to get the reply
+	Latency time.Duration
+	Err     error
+}
+
+// Check connects to the server with the protocol, a new connection each time, and
+// returns the source IP observed by the server
+func Check(ctx context.Context, protocol string, config flag.Config) Result {
+	var res Result
+	switch protocol {
+	case flag.ProtocolTcp:
+		res = checkTCP(ctx, net.JoinHostPort(*config.Addr, *config.TcpPort))
+	case flag.ProtocolUdp:
+		res = checkUDP(ctx, net.JoinHostPort(*config.Addr, *config.UdpPort))
+	case flag.ProtocolWeb:
+		res = checkWeb(ctx, fmt.Sprintf("ws://%s/ws", net.JoinHostPort(*config.Addr, *config.WebPort)))
+	default:
+		res.Err = fmt.Errorf("protocol %s is not supported", protocol)
+	}
+	res.Protocol = protocol
+	return res
+}
+
+func checkTCP(ctx context.Context, addr string) Result {
+	res := Result{}
+	dialer := net.Dialer{}
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, flag.ProtocolTcp, addr)
+	if err != nil {
+		res.Err = fmt.Errorf("failed to connect tcp server %s: %w", addr, err)
+		return res
+	}
+	defer conn.Close()
+	res.Latency = time.Since(start)
+	res.Local = conn.LocalAddr().String()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write([]byte(res.Local + " probe TCP Server... " + reply.JSONRequest + "\n")); err != nil {
+		res.Err = fmt.Errorf("failed to send to tcp server %s: %w", addr, err)
+		return res
+	}
+	r := bufio.NewReader(conn)
+	msg, err := r.ReadString('\n')
+	if err != nil {
+		res.Err = fmt.Errorf("failed to read from tcp server %s: %w", addr, err)
+		return res
+	}
+	// the JSON line is sent with the plain echo, the servers before it only send the echo
+	if r.Buffered() > 0 {
+		line, _ := r.ReadString('\n')
+		msg += line
+	}
+	res.Source, res.Err = reply.ParseClientIP(msg)
+	return res
+}
+
+func checkUDP(ctx context.Context, addr string) Result {
+	res := Result{}
+	dialer := net.Dialer{}
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, flag.ProtocolUdp, addr)
+	if err != nil {
+		res.Err = fmt.Errorf("failed to connect udp server %s: %w", addr, err)
+		return res
+	}
+	defer conn.Close()
+	res.Local = conn.LocalAddr().String()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write([]byte(res.Local + " probe UDP Server... " + reply.JSONRequest + "\n")); err != nil {
+		res.Err = fmt.Errorf("failed to send to udp server %s: %w", addr, err)
+		return res
+	}
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		res.Err = fmt.Errorf("failed to read from udp server %s: %w", addr, err)
+		return res
+	}
+	// udp has no handshake, the latency is the round trip of the first reply
+	res.Latency = time.Since(start)
+	res.Source, res.Err = reply.ParseClientIP(string(buf[:n]))
+	return res
+}
+
+func checkWeb(ctx context.Context, url string) Result {
+	res := Result{}
+	dialer := websocket.Dialer{}
+	start := time.Now()
+	conn, _, err := dialer.DialContext(ctx, url, nil)
+	if err != nil {
+		res.Err = fmt.Errorf("failed to connect websocket server %s: %w", url, err)
+		return res
+	}
+	defer conn.Close()
+	res.Latency = time.Since(start)
+	res.Local = conn.LocalAddr().String()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetReadDeadline(deadline)
+		_ = conn.SetWriteDeadline(deadline)
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(res.Local+" probe Web Server... "+reply.JSONRequest+"\n")); err != nil {
+		res.Err = fmt.Errorf("failed to send to websocket server %s: %w", url, err)
+		return res
+	}
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		res.Err = fmt.Errorf("failed to read from websocket server %s: %w", url, err)
+		return res
+	}
+	res.Source, res.Err = reply.ParseClientIP(string(msg))
+	return res
+}
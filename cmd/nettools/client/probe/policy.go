@@ -0,0 +1,69 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package probe
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// ExpectFunc returns the EIP expected as the source of the connections
+type ExpectFunc func(ctx context.Context) (string, error)
+
+// StaticEIP expects the EIP given by -eip
+func StaticEIP(eip string) ExpectFunc {
+	return func(ctx context.Context) (string, error) {
+		return eip, nil
+	}
+}
+
+// PolicyEIP expects the EIP in the status of the policy, namespace/name of an
+// EgressPolicy or the name of an EgressClusterPolicy. The EIP of the pod is expected
+// when the policy allocates it its own EIP, i.e. a pod of a StatefulSet of a sticky
+// policy. ipv6 selects the family of the server.
+func PolicyEIP(cli client.Reader, policy, pod string, ipv6 bool) ExpectFunc {
+	key := types.NamespacedName{Name: policy}
+	if ns, name, ok := strings.Cut(policy, "/"); ok {
+		key = types.NamespacedName{Namespace: ns, Name: name}
+	}
+	return func(ctx context.Context) (string, error) {
+		var status egressv1.EgressPolicyStatus
+		if key.Namespace != "" {
+			obj := new(egressv1.EgressPolicy)
+			if err := cli.Get(ctx, key, obj); err != nil {
+				return "", fmt.Errorf("failed to get EgressPolicy %s: %w", key, err)
+			}
+			status = obj.Status
+		} else {
+			obj := new(egressv1.EgressClusterPolicy)
+			if err := cli.Get(ctx, key, obj); err != nil {
+				return "", fmt.Errorf("failed to get EgressClusterPolicy %s: %w", key.Name, err)
+			}
+			status = obj.Status
+		}
+
+		eip := status.Eip.Ipv4
+		if ipv6 {
+			eip = status.Eip.Ipv6
+		}
+		for _, item := range status.PodEips {
+			if item.Pod == pod {
+				eip = item.IPv4
+				if ipv6 {
+					eip = item.IPv6
+				}
+			}
+		}
+		if eip == "" {
+			return "", fmt.Errorf("policy %s has no EIP", policy)
+		}
+		return eip, nil
+	}
+}
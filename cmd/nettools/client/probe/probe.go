@@ -0,0 +1,210 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package probe checks periodically that the connections to nettools-server leave
+// the cluster with the expected EIP, and exports the results as Prometheus metrics.
+package probe
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/spidernet-io/egressgateway/cmd/nettools/flag"
+)
+
+// metrics are the metrics of the checks, labeled by protocol
+type metrics struct {
+	checks       *prometheus.CounterVec
+	mismatches   *prometheus.CounterVec
+	errors       *prometheus.CounterVec
+	expectErrors prometheus.Counter
+	latency      *prometheus.HistogramVec
+	failing      *prometheus.GaugeVec
+	failoverGap  *prometheus.HistogramVec
+}
+
+func newMetrics(reg prometheus.Registerer) *metrics {
+	m := &metrics{
+		checks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nettools_probe_checks_total",
+			Help: "Number of the checks",
+		}, []string{"protocol"}),
+		mismatches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nettools_probe_mismatches_total",
+			Help: "Number of the checks whose source IP observed by the server is not the expected EIP",
+		}, []string{"protocol"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "nettools_probe_errors_total",
+			Help: "Number of the checks which failed to get a reply of the server",
+		}, []string{"protocol"}),
+		expectErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "nettools_probe_expect_errors_total",
+			Help: "Number of the rounds skipped because the expected EIP is unknown",
+		}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "nettools_probe_connect_latency_seconds",
+			Help:    "Time to connect to the server, for udp the time to get the reply",
+			Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"protocol"}),
+		failing: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "nettools_probe_failing",
+			Help: "1 when the last check failed or got another source IP than the expected EIP",
+		}, []string{"protocol"}),
+		failoverGap: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "nettools_probe_failover_gap_seconds",
+			Help:    "Time from the last good check before failed checks to the next good check",
+			Buckets: []float64{1, 2, 5, 10, 20, 30, 60, 120, 300},
+		}, []string{"protocol"}),
+	}
+	reg.MustRegister(m.checks, m.mismatches, m.errors, m.expectErrors, m.latency, m.failing, m.failoverGap)
+	return m
+}
+
+// state is the state of the checks of a protocol
+type state struct {
+	// lastGood is the time of the last good check
+	lastGood time.Time
+	failing  bool
+}
+
+// Prober checks the protocols periodically
+type Prober struct {
+	config    flag.Config
+	protocols []string
+	expect    ExpectFunc
+	registry  *prometheus.Registry
+	metrics   *metrics
+	states    map[string]*state
+}
+
+// New returns a Prober of the protocols, the expected EIP is given by expect
+func New(config flag.Config, protocols []string, expect ExpectFunc) *Prober {
+	registry := prometheus.NewRegistry()
+	p := &Prober{
+		config:    config,
+		protocols: protocols,
+		expect:    expect,
+		registry:  registry,
+		metrics:   newMetrics(registry),
+		states:    make(map[string]*state),
+	}
+	for _, protocol := range protocols {
+		p.states[protocol] = &state{}
+		// the series exist before the first failure, so that the alerts on their increase fire
+		p.metrics.checks.WithLabelValues(protocol)
+		p.metrics.mismatches.WithLabelValues(protocol)
+		p.metrics.errors.WithLabelValues(protocol)
+		p.metrics.failing.WithLabelValues(protocol).Set(0)
+	}
+	return p
+}
+
+// Run checks the protocols every interval and serves the metrics on -metricsAddr
+// until ctx is done
+func (p *Prober) Run(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Addr: *p.config.MetricsAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ListenAndServe()
+	}()
+	defer server.Close()
+	log.Printf("probe %v of %s every %s, metrics on %s", p.protocols, *p.config.Addr, *p.config.Interval, *p.config.MetricsAddr)
+
+	ticker := time.NewTicker(*p.config.Interval)
+	defer ticker.Stop()
+	for {
+		p.round(ctx)
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errCh:
+			return err
+		case <-ticker.C:
+		}
+	}
+}
+
+// round checks all the protocols once
+func (p *Prober) round(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, *p.config.ProbeTimeout)
+	defer cancel()
+
+	expected, err := p.expect(ctx)
+	if err != nil {
+		log.Printf("skip the checks: %v", err)
+		p.metrics.expectErrors.Inc()
+		return
+	}
+
+	results := make([]Result, len(p.protocols))
+	wg := sync.WaitGroup{}
+	for i, protocol := range p.protocols {
+		wg.Add(1)
+		go func(i int, protocol string) {
+			defer wg.Done()
+			results[i] = Check(ctx, protocol, p.config)
+		}(i, protocol)
+	}
+	wg.Wait()
+
+	now := time.Now()
+	for _, res := range results {
+		p.record(res, expected, now)
+	}
+}
+
+// record updates the metrics and the state of the protocol with the result
+func (p *Prober) record(res Result, expected string, now time.Time) {
+	protocol := res.Protocol
+	p.metrics.checks.WithLabelValues(protocol).Inc()
+
+	good := false
+	switch {
+	case res.Err != nil:
+		log.Printf("%s: %v", protocol, res.Err)
+		p.metrics.errors.WithLabelValues(protocol).Inc()
+	case Match(res.Source, expected) != *p.config.Contain:
+		p.metrics.latency.WithLabelValues(protocol).Observe(res.Latency.Seconds())
+		log.Printf("%s: %s mismatch, source IP %s observed by the server, expected EIP %s (contain=%v)",
+			protocol, res.Local, res.Source, expected, *p.config.Contain)
+		p.metrics.mismatches.WithLabelValues(protocol).Inc()
+	default:
+		p.metrics.latency.WithLabelValues(protocol).Observe(res.Latency.Seconds())
+		good = true
+	}
+
+	st := p.states[protocol]
+	if !good {
+		st.failing = true
+		p.metrics.failing.WithLabelValues(protocol).Set(1)
+		return
+	}
+	if st.failing {
+		if !st.lastGood.IsZero() {
+			gap := now.Sub(st.lastGood)
+			log.Printf("%s: recovered after %s", protocol, gap)
+			p.metrics.failoverGap.WithLabelValues(protocol).Observe(gap.Seconds())
+		}
+		st.failing = false
+		p.metrics.failing.WithLabelValues(protocol).Set(0)
+	}
+	st.lastGood = now
+}
+
+// Match reports whether the source IP observed by the server is the EIP
+func Match(source, eip string) bool {
+	a, b := net.ParseIP(source), net.ParseIP(eip)
+	if a == nil || b == nil {
+		return source == eip
+	}
+	return a.Equal(b)
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package probe
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/cmd/nettools/flag"
+)
+
+// value returns the value of the counter or gauge, or the count of the histogram, of
+// the protocol
+func value(p *Prober, name, protocol string) float64 {
+	families, _ := p.registry.Gather()
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetValue() == protocol {
+					return m.GetCounter().GetValue() + m.GetGauge().GetValue() + float64(m.GetHistogram().GetSampleCount())
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func testConfig(addr, port string, contain bool) flag.Config {
+	interval, timeout := time.Second, time.Second
+	metricsAddr := ""
+	return flag.Config{
+		Addr:         &addr,
+		TcpPort:      &port,
+		Contain:      &contain,
+		Interval:     &interval,
+		ProbeTimeout: &timeout,
+		MetricsAddr:  &metricsAddr,
+	}
+}
+
+func TestCheckTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = bufio.NewReader(conn).ReadString('\n')
+			_, _ = conn.Write([]byte("now clientIP=" + conn.RemoteAddr().String() + " TCP Server Say hello! \n"))
+			_ = conn.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	res := Check(ctx, flag.ProtocolTcp, testConfig("127.0.0.1", port, true))
+	assert.NoError(t, res.Err)
+	assert.Equal(t, flag.ProtocolTcp, res.Protocol)
+	assert.Equal(t, "127.0.0.1", res.Source)
+	assert.NotEmpty(t, res.Local)
+}
+
+func TestRecord(t *testing.T) {
+	p := New(testConfig("127.0.0.1", "8080", true), []string{flag.ProtocolTcp}, StaticEIP("10.6.1.21"))
+	start := time.Now()
+	good := Result{Protocol: flag.ProtocolTcp, Source: "10.6.1.21", Latency: time.Millisecond}
+	mismatch := Result{Protocol: flag.ProtocolTcp, Source: "10.6.0.5", Latency: time.Millisecond}
+	failed := Result{Protocol: flag.ProtocolTcp, Err: errors.New("connection refused")}
+
+	p.record(good, "10.6.1.21", start)
+	assert.Equal(t, float64(0), value(p, "nettools_probe_failing", flag.ProtocolTcp))
+
+	// the gateway node fails, the connections fail then leave with the node IP
+	p.record(failed, "10.6.1.21", start.Add(time.Second))
+	p.record(mismatch, "10.6.1.21", start.Add(2*time.Second))
+	assert.Equal(t, float64(1), value(p, "nettools_probe_failing", flag.ProtocolTcp))
+	assert.Equal(t, float64(1), value(p, "nettools_probe_errors_total", flag.ProtocolTcp))
+	assert.Equal(t, float64(1), value(p, "nettools_probe_mismatches_total", flag.ProtocolTcp))
+
+	// the EIP moved to another node
+	p.record(good, "10.6.1.21", start.Add(3*time.Second))
+	assert.Equal(t, float64(0), value(p, "nettools_probe_failing", flag.ProtocolTcp))
+	assert.Equal(t, float64(1), value(p, "nettools_probe_failover_gap_seconds", flag.ProtocolTcp))
+	assert.Equal(t, float64(4), value(p, "nettools_probe_checks_total", flag.ProtocolTcp))
+	assert.Equal(t, float64(3), value(p, "nettools_probe_connect_latency_seconds", flag.ProtocolTcp))
+
+	// -contain false expects another source IP than the EIP
+	contain := false
+	p.config.Contain = &contain
+	p.record(mismatch, "10.6.1.21", start.Add(4*time.Second))
+	assert.Equal(t, float64(0), value(p, "nettools_probe_failing", flag.ProtocolTcp))
+}
+
+func TestMatch(t *testing.T) {
+	assert.True(t, Match("fd00::21", "fd00:0::21"))
+	assert.False(t, Match("10.6.1.21", "10.6.1.22"))
+	assert.False(t, Match("", "10.6.1.21"))
+}
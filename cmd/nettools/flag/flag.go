@@ -3,7 +3,10 @@
 
 package flag
 
-import "flag"
+import (
+	"flag"
+	"time"
+)
 
 const (
 	ProtocolTcp = "tcp"
@@ -18,19 +21,29 @@ type Config struct {
 	EgressIP                               *string
 	Contain                                *bool
 	Batch                                  *bool
+	Probe                                  *bool
+	Interval, ProbeTimeout                 *time.Duration
+	Policy, MetricsAddr                    *string
+	Output                                 *string
 }
 
 func ParseClientFlag() Config {
 	config := Config{
-		Addr:     flag.String("addr", "", "server listen ip addr, default is all local addresses"),
-		Proto:    flag.String("protocol", "tcp", "server listen protocol, available options: tcp,udp,web(websocket),all"),
-		TcpPort:  flag.String("tcpPort", "8080", "tcp listen port"),
-		UdpPort:  flag.String("udpPort", "8081", "udp listen port"),
-		WebPort:  flag.String("webPort", "8082", "webSocket listen port"),
-		Timeout:  flag.Int("timeout", 10, "command execution seconds time"),
-		EgressIP: flag.String("eip", "", "egress IP"),
-		Contain:  flag.Bool("contain", true, "contain egressIP"),
-		Batch:    flag.Bool("batch", false, "batch mode"),
+		Addr:         flag.String("addr", "", "server listen ip addr, default is all local addresses"),
+		Proto:        flag.String("protocol", "tcp", "server listen protocol, available options: tcp,udp,web(websocket),all"),
+		TcpPort:      flag.String("tcpPort", "8080", "tcp listen port"),
+		UdpPort:      flag.String("udpPort", "8081", "udp listen port"),
+		WebPort:      flag.String("webPort", "8082", "webSocket listen port"),
+		Timeout:      flag.Int("timeout", 10, "command execution seconds time"),
+		EgressIP:     flag.String("eip", "", "egress IP"),
+		Contain:      flag.Bool("contain", true, "contain egressIP"),
+		Batch:        flag.Bool("batch", false, "batch mode"),
+		Probe:        flag.Bool("probe", false, "probe mode, check the egress IP periodically and export the results as metrics"),
+		Interval:     flag.Duration("interval", time.Second, "probe mode, interval of the checks"),
+		ProbeTimeout: flag.Duration("probeTimeout", 3*time.Second, "probe mode, timeout of a check"),
+		Policy:       flag.String("policy", "", "probe mode, namespace/name of the EgressPolicy or name of the EgressClusterPolicy whose EIP is expected without -eip"),
+		MetricsAddr:  flag.String("metricsAddr", ":9090", "probe mode, listen address of the metrics"),
+		Output:       flag.String("output", "", "batch mode, output format, json prints the result of each protocol as JSON, it implies -batch"),
 	}
 
 	flag.Parse()
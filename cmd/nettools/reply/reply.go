@@ -0,0 +1,79 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package reply is the reply of nettools-server to each message of a client, a plain
+// text line followed, when the client asks for it, by the same information as a JSON line.
+package reply
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reply is the JSON line of a reply
+type Reply struct {
+	Protocol string `json:"protocol"`
+	// ClientIP and ClientPort are the source of the connection observed by the server
+	ClientIP   string    `json:"clientIP"`
+	ClientPort int       `json:"clientPort"`
+	Time       time.Time `json:"time"`
+}
+
+// New returns the reply to the client with the remote address addr
+func New(protocol, addr string) Reply {
+	res := Reply{Protocol: protocol, ClientIP: addr, Time: time.Now()}
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		res.ClientIP = host
+		res.ClientPort, _ = strconv.Atoi(port)
+	}
+	return res
+}
+
+// JSONRequest in the message of a client asks for the JSON line, like the query of
+// the HTTP server. The other clients read one line per message.
+const JSONRequest = "output=json"
+
+// WantJSON returns whether the message of a client asks for the JSON line
+func WantJSON(msg []byte) bool {
+	return bytes.Contains(msg, []byte(JSONRequest))
+}
+
+// Message returns the reply, the plain echo then the JSON line when withJSON is set.
+// name is the name of the server in the plain echo, e.g. TCP Server.
+func (r Reply) Message(addr, name string, withJSON bool) []byte {
+	msg := fmt.Sprintf("%s clientIP=%s %s Say hello! \n", r.Time.String(), addr, name)
+	if withJSON {
+		raw, _ := json.Marshal(r)
+		msg += string(raw) + "\n"
+	}
+	return []byte(msg)
+}
+
+// clientIPPattern matches the address of the client in the plain echo
+var clientIPPattern = regexp.MustCompile(`clientIP=(\S+)`)
+
+// ParseClientIP returns the source IP the server observed from a reply, its JSON line
+// or its plain echo, the servers before the JSON line only send the plain echo
+func ParseClientIP(msg string) (string, error) {
+	for _, line := range strings.Split(msg, "\n") {
+		res := Reply{}
+		if strings.HasPrefix(strings.TrimSpace(line), "{") && json.Unmarshal([]byte(line), &res) == nil && res.ClientIP != "" {
+			return res.ClientIP, nil
+		}
+	}
+	match := clientIPPattern.FindStringSubmatch(msg)
+	if match == nil {
+		return "", fmt.Errorf("no client IP in the reply %q", msg)
+	}
+	host, _, err := net.SplitHostPort(match[1])
+	if err != nil {
+		return "", fmt.Errorf("invalid client address in the reply %q: %w", msg, err)
+	}
+	return host, nil
+}
@@ -0,0 +1,57 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package reply
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessage(t *testing.T) {
+	r := New("udp", "[fd00::21]:40000")
+	assert.Equal(t, "fd00::21", r.ClientIP)
+	assert.Equal(t, 40000, r.ClientPort)
+
+	// the clients which do not ask for the JSON line read one line per message
+	lines := strings.Split(strings.TrimSuffix(string(r.Message("[fd00::21]:40000", "UDP Server", false)), "\n"), "\n")
+	if assert.Len(t, lines, 1) {
+		assert.Contains(t, lines[0], "clientIP=[fd00::21]:40000 UDP Server Say hello!")
+	}
+
+	lines = strings.Split(strings.TrimSuffix(string(r.Message("[fd00::21]:40000", "UDP Server", true)), "\n"), "\n")
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+	assert.Contains(t, lines[0], "clientIP=[fd00::21]:40000 UDP Server Say hello!")
+	res := Reply{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &res))
+	assert.Equal(t, "udp", res.Protocol)
+	assert.Equal(t, "fd00::21", res.ClientIP)
+}
+
+func TestParseClientIP(t *testing.T) {
+	ip, err := ParseClientIP(string(New("tcp", "10.6.1.21:40000").Message("10.6.1.21:40000", "TCP Server", true)))
+	assert.NoError(t, err)
+	assert.Equal(t, "10.6.1.21", ip)
+
+	// the plain echo of the servers without the JSON line
+	ip, err = ParseClientIP("2024-01-02 10:00:00 +0000 UTC m=+1.5 clientIP=10.6.1.21:40000 TCP Server Say hello! \n")
+	assert.NoError(t, err)
+	assert.Equal(t, "10.6.1.21", ip)
+
+	ip, err = ParseClientIP(`{"protocol":"web","clientIP":"fd00::21","clientPort":40000}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "fd00::21", ip)
+
+	_, err = ParseClientIP("hello")
+	assert.Error(t, err)
+}
+
+func TestWantJSON(t *testing.T) {
+	assert.True(t, WantJSON([]byte("10.6.1.21:40000 probe TCP Server... "+JSONRequest+"\n")))
+	assert.False(t, WantJSON([]byte("10.6.1.21:40000 write data to TCP Server... \n")))
+}
@@ -5,6 +5,7 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
@@ -12,10 +13,11 @@ import (
 	"net/http"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/gorilla/websocket"
+
 	"github.com/spidernet-io/egressgateway/cmd/nettools/flag"
+	"github.com/spidernet-io/egressgateway/cmd/nettools/reply"
 )
 
 var (
@@ -90,11 +92,8 @@ func tcpPipe(conn *net.TCPConn) {
 		}
 		fmt.Println(string(message))
 
-		time.Sleep(time.Second * 2)
-
-		msg := time.Now().String() + " clientIP=" + conn.RemoteAddr().String() + " TCP Server Say hello! \n"
-
-		b := []byte(msg)
+		// the clients pace their messages, the probes expect the reply at once
+		b := reply.New(flag.ProtocolTcp, ipStr).Message(ipStr, "TCP Server", reply.WantJSON([]byte(message)))
 
 		_, err = conn.Write(b)
 		if err != nil {
@@ -128,8 +127,9 @@ func udpServer(config flag.ServerConfig) {
 		fmt.Println(read, remoteAddr)
 		fmt.Printf("%s\n", data)
 
+		withJSON := reply.WantJSON(data[:read])
 		go func() {
-			senddata := []byte(time.Now().String() + " clientIP=" + remoteAddr.String() + " UDP Server Say hello! \n")
+			senddata := reply.New(flag.ProtocolUdp, remoteAddr.String()).Message(remoteAddr.String(), "UDP Server", withJSON)
 			_, err = udpConn.WriteTo(senddata, remoteAddr)
 			if err != nil {
 				fmt.Println("UDP: send meg failed!", err)
@@ -157,7 +157,8 @@ func websocketServer(config flag.ServerConfig) {
 			fmt.Println(string(msg))
 
 			// Write message back to browser
-			senddata := []byte(time.Now().String() + " clientIP=" + conn.RemoteAddr().String() + " WebSocket Server Say hello! \n")
+			addr := conn.RemoteAddr().String()
+			senddata := reply.New(flag.ProtocolWeb, addr).Message(addr, "WebSocket Server", reply.WantJSON(msg))
 			if err = conn.WriteMessage(msgType, senddata); err != nil {
 				return
 			}
@@ -165,6 +166,12 @@ func websocketServer(config flag.ServerConfig) {
 	})
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		// the structured reply is sent when asked by ?output=json or the Accept header
+		if r.URL.Query().Get("output") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(reply.New("http", r.RemoteAddr))
+			return
+		}
 		_, _ = fmt.Fprintf(w, "Remote IP: %v\n", r.RemoteAddr)
 	})
 
@@ -6,8 +6,10 @@ package agent
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -16,9 +18,11 @@ import (
 
 	"github.com/spidernet-io/egressgateway/pkg/agent/metrics"
 	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/layer2"
 	"github.com/spidernet-io/egressgateway/pkg/logger"
 	"github.com/spidernet-io/egressgateway/pkg/profiling"
 	"github.com/spidernet-io/egressgateway/pkg/schema"
+	"github.com/spidernet-io/egressgateway/pkg/tracing"
 	"github.com/spidernet-io/egressgateway/pkg/types"
 )
 
@@ -41,6 +45,15 @@ func New(cfg *config.Config) (types.Service, error) {
 		GracefulShutdownTimeout: &t,
 	}
 
+	// the announcer and the datapath debug are created before the manager, their state
+	// is served by the debug API
+	announce, err := layer2.New(log, cfg.FileConfig.AnnounceExcludeRegexp)
+	if err != nil {
+		return nil, err
+	}
+
+	debug := newDatapathDebug(cfg)
+
 	if cfg.MetricsBindAddress != "" {
 		mgrOpts.Metrics.BindAddress = cfg.MetricsBindAddress
 	}
@@ -71,23 +84,70 @@ func New(cfg *config.Config) (types.Service, error) {
 		return nil, err
 	}
 
+	if cfg.DebugAPIBindAddress != "" {
+		err = mgr.Add(&debugServer{
+			addr: cfg.DebugAPIBindAddress,
+			handlers: map[string]http.Handler{
+				"/debug/layer2":   layer2.Handler(announce),
+				"/debug/datapath": debug,
+			},
+			log: log.WithName("debug"),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.FileConfig.Tracing.Enable {
+		provider, err := tracing.New(tracing.Options{
+			Endpoint:          cfg.FileConfig.Tracing.Endpoint,
+			ExportInterval:    time.Duration(cfg.FileConfig.Tracing.ExportInterval) * time.Second,
+			CorrelationWindow: time.Duration(cfg.FileConfig.Tracing.CorrelationWindow) * time.Second,
+			Service:           "egressgateway-agent",
+			Resource: []attribute.KeyValue{
+				attribute.String("k8s.pod.name", cfg.PodName),
+				attribute.String("k8s.namespace.name", cfg.PodNamespace),
+				attribute.String("k8s.node.name", cfg.NodeName),
+			},
+			Log: log.WithName("tracing"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := mgr.Add(provider); err != nil {
+			return nil, err
+		}
+		tracing.SetProvider(provider)
+	}
+
 	metrics.RegisterMetricCollectors()
 
-	err = newEgressTunnelController(mgr, cfg, log)
+	status := newDatapathStatus(mgr.GetClient(), log.WithName("agentstatus"), cfg)
+	err = mgr.Add(status)
+	if err != nil {
+		return nil, err
+	}
+
+	err = newEgressTunnelController(mgr, cfg, log, status, debug)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create node controller: %w", err)
 	}
 
-	err = newPolicyController(mgr, log, cfg)
+	err = newPolicyController(mgr, log, cfg, status, debug)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create egress gateway policy controller: %w", err)
 	}
 
-	err = newEipCtrl(mgr, log, cfg)
+	err = newEipCtrl(mgr, log, cfg, announce)
 	if err != nil {
 		return nil, fmt.Errorf("failed to eip controller: %w", err)
 	}
 
+	err = newFlowLogger(mgr, log, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create flow logger: %w", err)
+	}
+
 	return &Agent{client: mgr.GetClient(), manager: mgr}, err
 }
 
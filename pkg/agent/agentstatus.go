@@ -0,0 +1,327 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/lock"
+)
+
+const (
+	// maxDatapathErrors is the number of errors kept in the EgressAgentStatus
+	maxDatapathErrors = 10
+	// agentStatusInterval is the minimum interval between two writes of the EgressAgentStatus
+	agentStatusInterval = 5 * time.Second
+	// agentStatusResync rewrites the EgressAgentStatus, e.g. after it was deleted by hand
+	agentStatusResync = time.Minute
+)
+
+// componentError is a datapath error of a component, e.g. iptables
+type componentError struct {
+	component string
+	err       error
+}
+
+func (e *componentError) Error() string { return e.err.Error() }
+func (e *componentError) Unwrap() error { return e.err }
+
+func withComponent(component string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &componentError{component: component, err: err}
+}
+
+// datapathStatus collects the results of the datapath programming of the agent, they
+// are published in the EgressAgentStatus of the node.
+type datapathStatus struct {
+	lock.Mutex
+	client client.Client
+	log    logr.Logger
+	node   string
+
+	iptables   egressv1.IPTablesInfo
+	generation int64
+	lastSync   metav1.Time
+	// syncErr is the error of the last programming of all the policies
+	syncErr  error
+	synced   bool
+	policies map[egressv1.Policy]egressv1.AppliedPolicy
+	errors   []egressv1.DatapathError
+	peers    map[string]egressv1.TunnelPeer
+
+	// changed is signaled when the status changed since it was written
+	changed chan struct{}
+	// interval is the minimum interval between two writes, resync rewrites the status
+	interval time.Duration
+	resync   time.Duration
+}
+
+func newDatapathStatus(cli client.Client, log logr.Logger, cfg *config.Config) *datapathStatus {
+	return &datapathStatus{
+		client: cli,
+		log:    log,
+		node:   cfg.NodeName,
+		iptables: egressv1.IPTablesInfo{
+			Backend: cfg.FileConfig.IPTables.BackendMode,
+			Version: cfg.FileConfig.IPTables.Version,
+		},
+		policies: make(map[egressv1.Policy]egressv1.AppliedPolicy),
+		peers:    make(map[string]egressv1.TunnelPeer),
+		changed:  make(chan struct{}, 1),
+		interval: agentStatusInterval,
+		resync:   agentStatusResync,
+	}
+}
+
+func (s *datapathStatus) notify() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Synced records a programming of all the policies, applied are the policies
+// programmed by it. The policies are kept when it fails.
+func (s *datapathStatus) Synced(applied []egressv1.AppliedPolicy, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.synced = true
+	s.syncErr = err
+	if err != nil {
+		s.recordError(err)
+		s.notify()
+		return
+	}
+	s.generation++
+	s.lastSync = metav1.Now()
+	s.policies = make(map[egressv1.Policy]egressv1.AppliedPolicy, len(applied))
+	for _, item := range applied {
+		item.Programmed = true
+		s.policies[egressv1.Policy{Name: item.Name, Namespace: item.Namespace}] = item
+	}
+	s.notify()
+}
+
+// SetPolicy records the programming of a policy.
+func (s *datapathStatus) SetPolicy(policy egressv1.AppliedPolicy, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	policy.Programmed = err == nil
+	policy.Error = ""
+	if err != nil {
+		policy.Error = err.Error()
+		s.recordError(withComponent(egressv1.ComponentIPSet, err))
+	}
+	key := egressv1.Policy{Name: policy.Name, Namespace: policy.Namespace}
+	if old, ok := s.policies[key]; ok && old == policy {
+		return
+	}
+	s.policies[key] = policy
+	s.notify()
+}
+
+func (s *datapathStatus) RemovePolicy(policy egressv1.Policy) {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.policies[policy]; !ok {
+		return
+	}
+	delete(s.policies, policy)
+	s.notify()
+}
+
+// SyncErr returns the error of the last programming of all the policies, the rules
+// of every policy are written by it.
+func (s *datapathStatus) SyncErr() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.syncErr
+}
+
+// RecordError records an error of a component.
+func (s *datapathStatus) RecordError(component string, err error) {
+	if err == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.recordError(withComponent(component, err))
+}
+
+// recordError adds the error of a component, a repeated error is recorded once.
+// The errors without component are only reported by the Ready condition.
+func (s *datapathStatus) recordError(err error) {
+	var ce *componentError
+	if !errors.As(err, &ce) {
+		return
+	}
+	if len(s.errors) > 0 && s.errors[0].Component == ce.component && s.errors[0].Message == ce.Error() {
+		return
+	}
+	item := egressv1.DatapathError{Component: ce.component, Message: ce.Error(), Time: metav1.Now()}
+	s.errors = append([]egressv1.DatapathError{item}, s.errors...)
+	if len(s.errors) > maxDatapathErrors {
+		s.errors = s.errors[:maxDatapathErrors]
+	}
+	s.notify()
+}
+
+// SetPeers records the state of all the tunnel peers.
+func (s *datapathStatus) SetPeers(peers []egressv1.TunnelPeer) {
+	s.Lock()
+	defer s.Unlock()
+
+	m := make(map[string]egressv1.TunnelPeer, len(peers))
+	for _, peer := range peers {
+		m[peer.Node] = peer
+	}
+	if reflect.DeepEqual(m, s.peers) {
+		return
+	}
+	s.peers = m
+	s.notify()
+}
+
+// status returns the status of obj to publish, the conditions are set on the current ones.
+func (s *datapathStatus) status(obj *egressv1.EgressAgentStatus) egressv1.AgentStatus {
+	s.Lock()
+	defer s.Unlock()
+
+	status := egressv1.AgentStatus{
+		DatapathGeneration: s.generation,
+		LastSyncTime:       s.lastSync,
+		IPTables:           s.iptables,
+		Errors:             append([]egressv1.DatapathError(nil), s.errors...),
+		Conditions:         obj.Status.DeepCopy().Conditions,
+	}
+	for _, item := range s.policies {
+		status.Policies = append(status.Policies, item)
+	}
+	sort.Slice(status.Policies, func(i, j int) bool {
+		if status.Policies[i].Namespace != status.Policies[j].Namespace {
+			return status.Policies[i].Namespace < status.Policies[j].Namespace
+		}
+		return status.Policies[i].Name < status.Policies[j].Name
+	})
+	for _, peer := range s.peers {
+		status.Peers = append(status.Peers, peer)
+	}
+	sort.Slice(status.Peers, func(i, j int) bool {
+		return status.Peers[i].Node < status.Peers[j].Node
+	})
+
+	cond := metav1.Condition{
+		Type:               egressv1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: obj.Generation,
+		Reason:             egressv1.ReasonSynced,
+		Message:            "The datapath of all the policies is programmed",
+	}
+	switch {
+	case !s.synced:
+		cond.Status = metav1.ConditionUnknown
+		cond.Reason = egressv1.ReasonPending
+		cond.Message = "The datapath is not programmed yet"
+	case s.syncErr != nil:
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = egressv1.ReasonSyncFailed
+		cond.Message = s.syncErr.Error()
+	}
+	meta.SetStatusCondition(&status.Conditions, cond)
+	return status
+}
+
+// Start writes the EgressAgentStatus when the status changes, at most once per
+// agentStatusInterval.
+func (s *datapathStatus) Start(ctx context.Context) error {
+	resync := time.NewTicker(s.resync)
+	defer resync.Stop()
+
+	for {
+		if err := s.write(ctx); err != nil {
+			s.log.Error(err, "failed to update the egress agent status")
+			// retried after the interval
+			s.notify()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(s.interval):
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.changed:
+		case <-resync.C:
+		}
+	}
+}
+
+// NeedLeaderElection implements the LeaderElectionRunnable interface, every agent
+// writes the status of its node.
+func (s *datapathStatus) NeedLeaderElection() bool {
+	return false
+}
+
+func (s *datapathStatus) write(ctx context.Context) error {
+	obj := new(egressv1.EgressAgentStatus)
+	err := s.client.Get(ctx, types.NamespacedName{Name: s.node}, obj)
+	if apierr.IsNotFound(err) {
+		obj, err = s.create(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	status := s.status(obj)
+	if reflect.DeepEqual(status, obj.Status) {
+		return nil
+	}
+	obj.Status = status
+	return s.client.Status().Update(ctx, obj)
+}
+
+// create creates the EgressAgentStatus of the node, it is owned by the node so it
+// is removed with the node.
+func (s *datapathStatus) create(ctx context.Context) (*egressv1.EgressAgentStatus, error) {
+	node := new(corev1.Node)
+	if err := s.client.Get(ctx, types.NamespacedName{Name: s.node}, node); err != nil {
+		return nil, err
+	}
+	obj := &egressv1.EgressAgentStatus{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: s.node,
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "v1",
+				Kind:       "Node",
+				Name:       node.Name,
+				UID:        node.UID,
+			}},
+		},
+	}
+	if err := s.client.Create(ctx, obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
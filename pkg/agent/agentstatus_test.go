@@ -0,0 +1,167 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func newTestDatapathStatus(cli client.Client) *datapathStatus {
+	cfg := new(config.Config)
+	cfg.NodeName = "node1"
+	cfg.FileConfig.IPTables.BackendMode = "nft"
+	return newDatapathStatus(cli, logger.NewLogger(logger.Config{}), cfg)
+}
+
+func readyCondition(status egressv1.AgentStatus) metav1.Condition {
+	if cond := meta.FindStatusCondition(status.Conditions, egressv1.ConditionReady); cond != nil {
+		return *cond
+	}
+	return metav1.Condition{}
+}
+
+func TestDatapathStatusPolicies(t *testing.T) {
+	s := newTestDatapathStatus(nil)
+	obj := &egressv1.EgressAgentStatus{ObjectMeta: metav1.ObjectMeta{Name: "node1", Generation: 1}}
+
+	status := s.status(obj)
+	assert.Equal(t, metav1.ConditionUnknown, readyCondition(status).Status)
+	assert.Equal(t, "nft", status.IPTables.Backend)
+
+	p1 := egressv1.AppliedPolicy{Name: "p1", Namespace: "default", Generation: 1, Gateway: true}
+	p2 := egressv1.AppliedPolicy{Name: "p2", Generation: 2}
+	s.Synced([]egressv1.AppliedPolicy{p2, p1}, nil)
+	status = s.status(obj)
+	assert.Equal(t, metav1.ConditionTrue, readyCondition(status).Status)
+	assert.Equal(t, int64(1), status.DatapathGeneration)
+	assert.False(t, status.LastSyncTime.IsZero())
+	p1.Programmed, p2.Programmed = true, true
+	// the policies are sorted by namespace and name
+	assert.Equal(t, []egressv1.AppliedPolicy{p2, p1}, status.Policies)
+
+	// a policy which failed to be programmed
+	s.SetPolicy(egressv1.AppliedPolicy{Name: "p1", Namespace: "default", Generation: 2}, errors.New("ipset failed"))
+	status = s.status(obj)
+	if assert.Len(t, status.Policies, 2) {
+		assert.False(t, status.Policies[1].Programmed)
+		assert.Equal(t, "ipset failed", status.Policies[1].Error)
+		assert.Equal(t, int64(2), status.Policies[1].Generation)
+	}
+	if assert.Len(t, status.Errors, 1) {
+		assert.Equal(t, egressv1.ComponentIPSet, status.Errors[0].Component)
+	}
+
+	// a failed programming of all the policies keeps the policies
+	s.Synced(nil, withComponent(egressv1.ComponentIPTables, errors.New("iptables failed")))
+	status = s.status(obj)
+	ready := readyCondition(status)
+	assert.Equal(t, metav1.ConditionFalse, ready.Status)
+	assert.Equal(t, egressv1.ReasonSyncFailed, ready.Reason)
+	assert.Equal(t, "iptables failed", ready.Message)
+	assert.Equal(t, int64(1), status.DatapathGeneration)
+	assert.Len(t, status.Policies, 2)
+	assert.EqualError(t, s.SyncErr(), "iptables failed")
+
+	s.RemovePolicy(egressv1.Policy{Name: "p2"})
+	s.Synced([]egressv1.AppliedPolicy{p1}, nil)
+	status = s.status(obj)
+	assert.Equal(t, metav1.ConditionTrue, readyCondition(status).Status)
+	assert.Equal(t, int64(2), status.DatapathGeneration)
+	assert.Len(t, status.Policies, 1)
+	assert.NoError(t, s.SyncErr())
+}
+
+func TestDatapathStatusErrors(t *testing.T) {
+	s := newTestDatapathStatus(nil)
+
+	// the errors without component are only reported by the Ready condition
+	s.Synced(nil, errors.New("list gateways failed"))
+	assert.Empty(t, s.errors)
+
+	// a repeated error is recorded once
+	s.RecordError(egressv1.ComponentRoute, errors.New("route failed"))
+	s.RecordError(egressv1.ComponentRoute, errors.New("route failed"))
+	s.RecordError(egressv1.ComponentRoute, nil)
+	assert.Len(t, s.errors, 1)
+
+	for i := 0; i < maxDatapathErrors+5; i++ {
+		s.RecordError(egressv1.ComponentFDB, fmt.Errorf("fdb failed %d", i))
+	}
+	if !assert.Len(t, s.errors, maxDatapathErrors) {
+		return
+	}
+	// the newest error goes first
+	assert.Equal(t, fmt.Sprintf("fdb failed %d", maxDatapathErrors+4), s.errors[0].Message)
+	assert.Equal(t, "fdb failed 5", s.errors[maxDatapathErrors-1].Message)
+}
+
+func TestDatapathStatusWrite(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid1"}}
+	var updates atomic.Int32
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(node).
+		WithStatusSubresource(&egressv1.EgressAgentStatus{}).
+		WithInterceptorFuncs(interceptor.Funcs{
+			SubResourceUpdate: func(ctx context.Context, cli client.Client, subResourceName string, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+				updates.Add(1)
+				return cli.SubResource(subResourceName).Update(ctx, obj, opts...)
+			},
+		}).
+		Build()
+
+	s := newTestDatapathStatus(cli)
+	s.interval = 200 * time.Millisecond
+	s.resync = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = s.Start(ctx)
+	}()
+
+	// the status is created, owned by the node
+	obj := new(egressv1.EgressAgentStatus)
+	assert.Eventually(t, func() bool {
+		return cli.Get(ctx, types.NamespacedName{Name: "node1"}, obj) == nil && updates.Load() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	if assert.Len(t, obj.OwnerReferences, 1) {
+		assert.Equal(t, node.UID, obj.OwnerReferences[0].UID)
+	}
+	assert.Equal(t, metav1.ConditionUnknown, readyCondition(obj.Status).Status)
+
+	// the changes within the interval are written at once
+	for i := 0; i < 10; i++ {
+		s.SetPolicy(egressv1.AppliedPolicy{Name: "p1", Generation: int64(i)}, nil)
+	}
+	s.Synced(nil, nil)
+	assert.Eventually(t, func() bool {
+		return updates.Load() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(3 * s.interval)
+	assert.Equal(t, int32(2), updates.Load())
+
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Name: "node1"}, obj))
+	assert.Equal(t, metav1.ConditionTrue, readyCondition(obj.Status).Status)
+	assert.Empty(t, obj.Status.Policies)
+}
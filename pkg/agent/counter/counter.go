@@ -0,0 +1,195 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package counter
+
+import (
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	"github.com/spidernet-io/egressgateway/pkg/lock"
+)
+
+// The rules of a policy which are counted
+const (
+	// RuleMarkRequest marks the traffic of the pods to the gateway node, see buildPolicyRule
+	RuleMarkRequest = "mark-request"
+	// RuleSNAT SNATs the traffic to the EIP on the gateway node, see buildEipRule and,
+	// for the stateful pods of the sticky policies, buildStatefulEipRules
+	RuleSNAT = "snat"
+)
+
+var (
+	descPackets = prometheus.NewDesc(
+		"egress_policy_packets_total",
+		"Number of packets matched by the datapath rules of the policy",
+		[]string{"policy", "namespace", "eip", "family", "rule"}, nil,
+	)
+	descBytes = prometheus.NewDesc(
+		"egress_policy_bytes_total",
+		"Number of bytes matched by the datapath rules of the policy",
+		[]string{"policy", "namespace", "eip", "family", "rule"}, nil,
+	)
+)
+
+// Key is a series of the policy counters.
+type Key struct {
+	Policy    string
+	Namespace string
+	EIP       string
+	// Family is ipv4 or ipv6
+	Family string
+	Rule   string
+}
+
+// Table is the source of the rule counters, see iptables.Table.
+type Table interface {
+	ReadCounters() (map[string]iptables.RuleCounter, error)
+}
+
+type tableState struct {
+	// chains are the series of the counted rules of each chain, indexed by rule hash
+	chains map[string]map[string]Key
+	// last are the counters of the rules on the last read
+	last map[string]iptables.RuleCounter
+}
+
+type total struct {
+	packets uint64
+	bytes   uint64
+}
+
+// Collector accumulates the counters of the policy rules into monotonic series. The
+// kernel counters of a rule restart from zero when the rule is rewritten, so the
+// collector adds the increase of each rule since the last read instead of exporting
+// the kernel counters.
+type Collector struct {
+	lock.Mutex
+	log    logr.Logger
+	tables map[Table]*tableState
+	totals map[Key]*total
+}
+
+func New(log logr.Logger) *Collector {
+	return &Collector{
+		log:    log,
+		tables: make(map[Table]*tableState),
+		totals: make(map[Key]*total),
+	}
+}
+
+// SetRules replaces the counted rules of the chain of the table, keys are indexed by
+// rule hash, nil keys stop counting the chain. Several rules may share a key. It should
+// be called after the rules are applied, and Sync before they are rewritten, so the
+// increase of the replaced rules since the last read is not lost.
+func (c *Collector) SetRules(table Table, chain string, keys map[string]Key) {
+	c.Lock()
+	defer c.Unlock()
+
+	state, ok := c.tables[table]
+	if !ok {
+		state = &tableState{chains: make(map[string]map[string]Key), last: make(map[string]iptables.RuleCounter)}
+		c.tables[table] = state
+	}
+	if len(keys) == 0 {
+		delete(state.chains, chain)
+	} else {
+		state.chains[chain] = keys
+	}
+	for hash := range state.last {
+		if _, ok := state.key(hash); !ok {
+			delete(state.last, hash)
+		}
+	}
+
+	// the series of the deleted policies are dropped
+	used := make(map[Key]struct{})
+	for _, item := range c.tables {
+		for _, keys := range item.chains {
+			for _, key := range keys {
+				used[key] = struct{}{}
+			}
+		}
+	}
+	for key := range c.totals {
+		if _, ok := used[key]; !ok {
+			delete(c.totals, key)
+		}
+	}
+}
+
+// key returns the series of the rule, the hashes of the rules are unique in a table
+func (s *tableState) key(hash string) (Key, bool) {
+	for _, keys := range s.chains {
+		if key, ok := keys[hash]; ok {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+// Sync reads the counters of the tables and adds their increase to the series.
+func (c *Collector) Sync() {
+	c.Lock()
+	defer c.Unlock()
+	c.sync()
+}
+
+func (c *Collector) sync() {
+	for table, state := range c.tables {
+		if len(state.chains) == 0 {
+			continue
+		}
+		counters, err := table.ReadCounters()
+		if err != nil {
+			c.log.Error(err, "failed to read the rule counters")
+			continue
+		}
+		for _, keys := range state.chains {
+			c.add(state, counters, keys)
+		}
+	}
+}
+
+// add adds the increase of the counted rules since the last read to their series
+func (c *Collector) add(state *tableState, counters map[string]iptables.RuleCounter, keys map[string]Key) {
+	for hash, key := range keys {
+		cur, ok := counters[hash]
+		if !ok {
+			// the rule is not applied yet
+			continue
+		}
+		delta := cur
+		if last, ok := state.last[hash]; ok && cur.Packets >= last.Packets && cur.Bytes >= last.Bytes {
+			delta.Packets -= last.Packets
+			delta.Bytes -= last.Bytes
+		}
+		state.last[hash] = cur
+
+		t, ok := c.totals[key]
+		if !ok {
+			t = &total{}
+			c.totals[key] = t
+		}
+		t.packets += delta.Packets
+		t.bytes += delta.Bytes
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- descPackets
+	ch <- descBytes
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.sync()
+	for key, t := range c.totals {
+		labels := []string{key.Policy, key.Namespace, key.EIP, key.Family, key.Rule}
+		ch <- prometheus.MustNewConstMetric(descPackets, prometheus.CounterValue, float64(t.packets), labels...)
+		ch <- prometheus.MustNewConstMetric(descBytes, prometheus.CounterValue, float64(t.bytes), labels...)
+	}
+}
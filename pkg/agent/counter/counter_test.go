@@ -0,0 +1,102 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package counter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+)
+
+// fakeTable returns the counters set by the test
+type fakeTable struct {
+	counters map[string]iptables.RuleCounter
+}
+
+func (f *fakeTable) ReadCounters() (map[string]iptables.RuleCounter, error) {
+	return f.counters, nil
+}
+
+func (c *Collector) total(key Key) total {
+	c.Lock()
+	defer c.Unlock()
+	if t, ok := c.totals[key]; ok {
+		return *t
+	}
+	return total{}
+}
+
+func TestCollector(t *testing.T) {
+	p1 := Key{Policy: "p1", Namespace: "default", EIP: "10.6.1.21", Family: "ipv4", Rule: RuleSNAT}
+	p2 := Key{Policy: "p2", Namespace: "default", EIP: "10.6.1.22", Family: "ipv4", Rule: RuleSNAT}
+	table := &fakeTable{counters: map[string]iptables.RuleCounter{
+		"hash1": {Packets: 10, Bytes: 1000},
+		"hash2": {Packets: 1, Bytes: 100},
+	}}
+
+	c := New(logger.NewLogger(logger.Config{}))
+	c.SetRules(table, "EGRESSGATEWAY-SNAT-EIP", map[string]Key{"hash1": p1, "hash2": p2})
+	c.Sync()
+	assert.Equal(t, total{10, 1000}, c.total(p1))
+
+	table.counters["hash1"] = iptables.RuleCounter{Packets: 15, Bytes: 1500}
+	c.Sync()
+	assert.Equal(t, total{15, 1500}, c.total(p1))
+
+	// p2 is inserted before p1, the rule of p1 is rewritten and its counter restarts
+	c.Sync()
+	table.counters = map[string]iptables.RuleCounter{
+		"hash3": {Packets: 1, Bytes: 100},
+		"hash4": {Packets: 2, Bytes: 200},
+	}
+	c.SetRules(table, "EGRESSGATEWAY-SNAT-EIP", map[string]Key{"hash3": p2, "hash4": p1})
+	c.Sync()
+	assert.Equal(t, total{17, 1700}, c.total(p1))
+	assert.Equal(t, total{2, 200}, c.total(p2))
+
+	// the counter of a rule flushed by someone else restarts as well
+	table.counters["hash4"] = iptables.RuleCounter{Packets: 1, Bytes: 50}
+	c.Sync()
+	assert.Equal(t, total{18, 1750}, c.total(p1))
+
+	// the series of a deleted policy is dropped
+	c.SetRules(table, "EGRESSGATEWAY-SNAT-EIP", map[string]Key{"hash4": p1})
+	c.Sync()
+	assert.Equal(t, total{}, c.total(p2))
+	assert.Equal(t, total{18, 1750}, c.total(p1))
+}
+
+func TestCollectorChains(t *testing.T) {
+	policy := Key{Policy: "p1", Namespace: "default", EIP: "10.6.1.21", Family: "ipv4", Rule: RuleSNAT}
+	pod := Key{Policy: "p1", Namespace: "default", EIP: "10.6.1.30", Family: "ipv4", Rule: RuleSNAT}
+	table := &fakeTable{counters: map[string]iptables.RuleCounter{
+		"hash1": {Packets: 10, Bytes: 1000},
+		"hash2": {Packets: 1, Bytes: 100},
+		"hash3": {Packets: 2, Bytes: 200},
+	}}
+
+	c := New(logger.NewLogger(logger.Config{}))
+	c.SetRules(table, "EGRESSGATEWAY-SNAT-EIP", map[string]Key{"hash1": policy})
+	// the rules of the IPs of a pod share the series of its EIP
+	c.SetRules(table, "EGRESSGATEWAY-POD-1", map[string]Key{"hash2": pod, "hash3": pod})
+	c.Sync()
+	assert.Equal(t, total{10, 1000}, c.total(policy))
+	assert.Equal(t, total{3, 300}, c.total(pod))
+
+	// the chain of the pods is rewritten alone
+	c.Sync()
+	table.counters["hash4"] = iptables.RuleCounter{Packets: 1, Bytes: 100}
+	c.SetRules(table, "EGRESSGATEWAY-POD-1", map[string]Key{"hash4": pod})
+	c.Sync()
+	assert.Equal(t, total{10, 1000}, c.total(policy))
+	assert.Equal(t, total{4, 400}, c.total(pod))
+
+	c.SetRules(table, "EGRESSGATEWAY-POD-1", nil)
+	c.Sync()
+	assert.Equal(t, total{}, c.total(pod))
+	assert.Equal(t, total{10, 1000}, c.total(policy))
+}
@@ -0,0 +1,155 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/layer2"
+)
+
+// ReasonEIPConflict is the reason of the Event emitted when an EIP is used by another host
+const ReasonEIPConflict = "EIPConflict"
+
+// prober probes the EIPs on the segment, see layer2.Announce
+type prober interface {
+	// AnnounceIP reports whether the IP is announced for the gateway
+	AnnounceIP(name string, ip net.IP) bool
+	// Probe returns the conflict of the IP found by count probes, nil if no host answered
+	Probe(adv layer2.IPAdvertisement, count int, interval time.Duration) (*layer2.Conflict, error)
+}
+
+// probeAdvertisements returns the advertisements whose IP is not used by another host
+// on the segment. Only the IPs which are not announced yet are probed, the IPs with a
+// conflict that has not expired are not announced.
+//
+// During a failover the old node may still answer for the EIP for a moment, so a
+// conflict is only reported when it is found by two probes in a row, reprobe is true
+// when the second probe is pending.
+func (r *eip) probeAdvertisements(ctx context.Context, log logr.Logger, gateway *egressv1.EgressGateway,
+	advs []layer2.IPAdvertisement) (res []layer2.IPAdvertisement, reprobe bool, err error) {
+	dad := r.cfg.FileConfig.DuplicateAddressDetection
+	if !dad.Enable {
+		return advs, false, nil
+	}
+	since := time.Now().Add(-time.Duration(dad.ConflictExpiration) * time.Second)
+
+	skip := make([]bool, len(advs))
+	probed := make([]bool, len(advs))
+	conflicts := make([]*layer2.Conflict, len(advs))
+	errs := make([]error, len(advs))
+	var wg sync.WaitGroup
+	for i := range advs {
+		ip := advs[i].IP()
+		if r.prober.AnnounceIP(gateway.Name, ip) {
+			continue
+		}
+		if conflict, ok := gateway.Status.GetConflict(ip.String(), since); ok {
+			log.V(1).Info("skip the conflicting EIP", "ip", ip, "mac", conflict.MAC)
+			skip[i] = true
+			continue
+		}
+		probed[i] = true
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conflicts[i], errs[i] = r.prober.Probe(advs[i], dad.ProbeCount, time.Duration(dad.ProbeIntervalMillis)*time.Millisecond)
+		}(i)
+	}
+	wg.Wait()
+
+	var found []layer2.Conflict
+	for i, adv := range advs {
+		if skip[i] {
+			continue
+		}
+		key := adv.IP().String()
+		if !probed[i] || conflicts[i] == nil {
+			if errs[i] != nil {
+				// the EIP is announced anyway, not answering is worse than a missed conflict
+				log.Error(errs[i], "failed to probe the EIP", "ip", key)
+			}
+			delete(r.suspects, key)
+			res = append(res, adv)
+			continue
+		}
+		if _, ok := r.suspects[key]; !ok {
+			log.Info("the EIP is used by another host, probe it again",
+				"ip", key, "mac", conflicts[i].MAC.String(), "interface", conflicts[i].Interface)
+			r.suspects[key] = struct{}{}
+			reprobe = true
+			continue
+		}
+		found = append(found, *conflicts[i])
+	}
+
+	if len(found) != 0 {
+		if err := r.reportConflicts(ctx, log, gateway.Name, found); err != nil {
+			return nil, false, err
+		}
+		for _, item := range found {
+			delete(r.suspects, item.IP.String())
+		}
+	}
+	return res, reprobe, nil
+}
+
+// reportConflicts records the conflicts in the status of the EgressGateway, the
+// controller steers the allocation away from the conflicting EIPs.
+func (r *eip) reportConflicts(ctx context.Context, log logr.Logger, name string, found []layer2.Conflict) error {
+	gateway := new(egressv1.EgressGateway)
+	if err := r.client.Get(ctx, types.NamespacedName{Name: name}, gateway); err != nil {
+		return err
+	}
+
+	now := metav1.Now()
+	for _, item := range found {
+		conflict := egressv1.EIPConflict{
+			IP:               item.IP.String(),
+			MAC:              item.MAC.String(),
+			Node:             r.cfg.NodeName,
+			Interface:        item.Interface,
+			LastDetectedTime: now,
+		}
+		exist := false
+		for i, cur := range gateway.Status.Conflicts {
+			if cur.Node == conflict.Node && net.ParseIP(cur.IP).Equal(item.IP) {
+				gateway.Status.Conflicts[i] = conflict
+				exist = true
+				break
+			}
+		}
+		if !exist {
+			gateway.Status.Conflicts = append(gateway.Status.Conflicts, conflict)
+		}
+	}
+
+	gateway.Status.SetConditions(gateway.Generation)
+	if err := r.client.Status().Update(ctx, gateway); err != nil {
+		return err
+	}
+	for _, item := range found {
+		log.Info("the EIP conflicts with another host, it is not announced",
+			"ip", item.IP.String(), "mac", item.MAC.String(), "interface", item.Interface)
+		r.recorder.Eventf(gateway, corev1.EventTypeWarning, ReasonEIPConflict,
+			"EIP %s is used by %s on interface %s of node %s, it is not announced",
+			item.IP, item.MAC, item.Interface, r.cfg.NodeName)
+	}
+	return nil
+}
+
+// probeDuration returns the time a probe of an EIP takes.
+func (r *eip) probeDuration() time.Duration {
+	dad := r.cfg.FileConfig.DuplicateAddressDetection
+	return time.Duration(dad.ProbeCount*dad.ProbeIntervalMillis) * time.Millisecond
+}
@@ -0,0 +1,202 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/layer2"
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+// fakeProber finds the conflicts set by the test
+type fakeProber struct {
+	sync.Mutex
+	announced map[string]bool
+	conflicts map[string]*layer2.Conflict
+	errs      map[string]error
+	probed    []string
+}
+
+func (f *fakeProber) AnnounceIP(_ string, ip net.IP) bool {
+	return f.announced[ip.String()]
+}
+
+func (f *fakeProber) Probe(adv layer2.IPAdvertisement, _ int, _ time.Duration) (*layer2.Conflict, error) {
+	f.Lock()
+	defer f.Unlock()
+	key := adv.IP().String()
+	f.probed = append(f.probed, key)
+	return f.conflicts[key], f.errs[key]
+}
+
+func advertisements(ips ...string) []layer2.IPAdvertisement {
+	res := make([]layer2.IPAdvertisement, 0, len(ips))
+	for _, ip := range ips {
+		res = append(res, layer2.NewIPAdvertisement(net.ParseIP(ip), true, nil))
+	}
+	return res
+}
+
+func advertisedIPs(advs []layer2.IPAdvertisement) []string {
+	res := make([]string, 0, len(advs))
+	for _, adv := range advs {
+		res = append(res, adv.IP().String())
+	}
+	return res
+}
+
+func TestProbeAdvertisements(t *testing.T) {
+	ctx := context.Background()
+	log := logger.NewLogger(logger.Config{})
+	gateway := &egressv1.EgressGateway{ObjectMeta: metav1.ObjectMeta{Name: "egw1"}}
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(gateway).
+		WithStatusSubresource(gateway).
+		Build()
+
+	cfg := new(config.Config)
+	cfg.NodeName = "node1"
+	cfg.FileConfig.DuplicateAddressDetection = config.DuplicateAddressDetection{
+		ProbeCount: 3, ProbeIntervalMillis: 100, ConflictExpiration: 600,
+	}
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	prober := &fakeProber{
+		announced: map[string]bool{"10.6.1.23": true},
+		conflicts: map[string]*layer2.Conflict{
+			"10.6.1.21": {IP: net.ParseIP("10.6.1.21"), MAC: mac, Interface: "eth0"},
+			"10.6.1.23": {IP: net.ParseIP("10.6.1.23"), MAC: mac, Interface: "eth0"},
+		},
+		errs: map[string]error{"10.6.1.24": errors.New("no interface")},
+	}
+	recorder := record.NewFakeRecorder(10)
+	r := &eip{
+		client:   cli,
+		log:      log,
+		cfg:      cfg,
+		prober:   prober,
+		recorder: recorder,
+		suspects: make(map[string]struct{}),
+	}
+	advs := advertisements("10.6.1.21", "10.6.1.22", "10.6.1.23", "10.6.1.24")
+	getGateway := func() *egressv1.EgressGateway {
+		res := new(egressv1.EgressGateway)
+		assert.NoError(t, cli.Get(ctx, types.NamespacedName{Name: "egw1"}, res))
+		return res
+	}
+
+	// the EIPs are announced without probe when the detection is disabled
+	res, reprobe, err := r.probeAdvertisements(ctx, log, gateway, advs)
+	assert.NoError(t, err)
+	assert.False(t, reprobe)
+	assert.Equal(t, advs, res)
+	assert.Empty(t, prober.probed)
+
+	cfg.FileConfig.DuplicateAddressDetection.Enable = true
+
+	// the first conflict may be the old node during a failover, the EIP is probed again
+	res, reprobe, err = r.probeAdvertisements(ctx, log, gateway, advs)
+	assert.NoError(t, err)
+	assert.True(t, reprobe)
+	// the announced EIP is not probed, the EIP failed to be probed is announced anyway
+	assert.ElementsMatch(t, []string{"10.6.1.21", "10.6.1.22", "10.6.1.24"}, prober.probed)
+	assert.Equal(t, []string{"10.6.1.22", "10.6.1.23", "10.6.1.24"}, advertisedIPs(res))
+	assert.Contains(t, r.suspects, "10.6.1.21")
+	assert.Empty(t, getGateway().Status.Conflicts)
+	assert.Empty(t, recorder.Events)
+
+	// the conflict found twice in a row is reported
+	res, reprobe, err = r.probeAdvertisements(ctx, log, gateway, advs)
+	assert.NoError(t, err)
+	assert.False(t, reprobe)
+	assert.Equal(t, []string{"10.6.1.22", "10.6.1.23", "10.6.1.24"}, advertisedIPs(res))
+	assert.Empty(t, r.suspects)
+	gateway = getGateway()
+	if assert.Len(t, gateway.Status.Conflicts, 1) {
+		conflict := gateway.Status.Conflicts[0]
+		assert.Equal(t, "10.6.1.21", conflict.IP)
+		assert.Equal(t, mac.String(), conflict.MAC)
+		assert.Equal(t, "node1", conflict.Node)
+		assert.Equal(t, "eth0", conflict.Interface)
+	}
+	assert.Len(t, recorder.Events, 1)
+
+	// the EIP with a conflict which has not expired is not probed nor announced
+	prober.probed = nil
+	delete(prober.conflicts, "10.6.1.21")
+	res, reprobe, err = r.probeAdvertisements(ctx, log, gateway, advs)
+	assert.NoError(t, err)
+	assert.False(t, reprobe)
+	assert.ElementsMatch(t, []string{"10.6.1.22", "10.6.1.24"}, prober.probed)
+	assert.Equal(t, []string{"10.6.1.22", "10.6.1.23", "10.6.1.24"}, advertisedIPs(res))
+}
+
+func TestProbeAdvertisementsConflictGone(t *testing.T) {
+	ctx := context.Background()
+	log := logger.NewLogger(logger.Config{})
+	gateway := &egressv1.EgressGateway{ObjectMeta: metav1.ObjectMeta{Name: "egw1"}}
+	cli := fake.NewClientBuilder().
+		WithScheme(schema.GetScheme()).
+		WithObjects(gateway).
+		WithStatusSubresource(gateway).
+		Build()
+
+	cfg := new(config.Config)
+	cfg.FileConfig.DuplicateAddressDetection = config.DuplicateAddressDetection{
+		Enable: true, ProbeCount: 3, ProbeIntervalMillis: 100, ConflictExpiration: 600,
+	}
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	prober := &fakeProber{conflicts: map[string]*layer2.Conflict{
+		"10.6.1.21": {IP: net.ParseIP("10.6.1.21"), MAC: mac, Interface: "eth0"},
+	}}
+	recorder := record.NewFakeRecorder(10)
+	r := &eip{
+		client:   cli,
+		log:      log,
+		cfg:      cfg,
+		prober:   prober,
+		recorder: recorder,
+		suspects: make(map[string]struct{}),
+	}
+	advs := advertisements("10.6.1.21")
+
+	res, reprobe, err := r.probeAdvertisements(ctx, log, gateway, advs)
+	assert.NoError(t, err)
+	assert.True(t, reprobe)
+	assert.Empty(t, res)
+
+	// the old node released the EIP before the second probe
+	delete(prober.conflicts, "10.6.1.21")
+	res, reprobe, err = r.probeAdvertisements(ctx, log, gateway, advs)
+	assert.NoError(t, err)
+	assert.False(t, reprobe)
+	assert.Equal(t, advs, res)
+	assert.Empty(t, r.suspects)
+
+	// a new conflict needs two probes again
+	prober.conflicts["10.6.1.21"] = &layer2.Conflict{IP: net.ParseIP("10.6.1.21"), MAC: mac, Interface: "eth0"}
+	_, reprobe, err = r.probeAdvertisements(ctx, log, gateway, advs)
+	assert.NoError(t, err)
+	assert.True(t, reprobe)
+
+	updated := new(egressv1.EgressGateway)
+	assert.NoError(t, cli.Get(ctx, types.NamespacedName{Name: "egw1"}, updated))
+	assert.Empty(t, updated.Status.Conflicts)
+	assert.Empty(t, recorder.Events)
+}
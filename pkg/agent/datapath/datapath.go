@@ -0,0 +1,127 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package datapath describes the desired and the actual state of the datapath of a
+// node, as served by the agent on /debug/datapath, and the drift between them.
+package datapath
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// State is the desired and the actual state of the datapath of a node. The entries
+// of the rules, routes, neighbours and FDB are rendered like the output of iproute2.
+type State struct {
+	Node       string    `json:"node"`
+	IPTables   []Table   `json:"iptables"`
+	IPSets     []Entries `json:"ipsets"`
+	Rules      Entries   `json:"rules"`
+	Routes     Entries   `json:"routes"`
+	Neighbours Entries   `json:"neighbours"`
+	FDB        Entries   `json:"fdb"`
+	// Drift lists the differences between the desired and the actual state, it is
+	// set by SetDrift
+	Drift []Drift `json:"drift"`
+}
+
+// Table is the state of the rules written by the agent in an iptables table
+type Table struct {
+	Name      string                `json:"name"`
+	IPVersion uint8                 `json:"ipVersion"`
+	Desired   []iptables.ChainState `json:"desired"`
+	// Actual are the hashes of the rules in the dataplane, indexed by chain
+	Actual map[string][]string `json:"actual"`
+	// Error is the error reading the actual state, the drift is unknown when it is set
+	Error string `json:"error,omitempty"`
+}
+
+// Entries is the state of a set of entries, e.g. the members of an ipset
+type Entries struct {
+	Name    string   `json:"name,omitempty"`
+	Desired []string `json:"desired"`
+	Actual  []string `json:"actual"`
+	// Error is the error reading the actual state, the drift is unknown when it is set
+	Error string `json:"error,omitempty"`
+}
+
+// Drift is a difference between the desired and the actual state of an object
+type Drift struct {
+	// Component is one of the components of the datapath errors, e.g. iptables
+	Component string `json:"component"`
+	// Object is the chain, the ipset or the kind of entries which differ
+	Object     string   `json:"object"`
+	Missing    []string `json:"missing,omitempty"`
+	Unexpected []string `json:"unexpected,omitempty"`
+	OutOfOrder bool     `json:"outOfOrder,omitempty"`
+}
+
+// SetDrift computes the drift of the state, the objects whose actual state could not
+// be read are skipped.
+func (s *State) SetDrift() {
+	s.Drift = make([]Drift, 0)
+	for _, table := range s.IPTables {
+		if table.Error != "" {
+			continue
+		}
+		for _, item := range iptables.Drift(table.Desired, table.Actual) {
+			s.Drift = append(s.Drift, Drift{
+				Component:  egressv1.ComponentIPTables,
+				Object:     fmt.Sprintf("ipv%d/%s/%s", table.IPVersion, table.Name, item.Chain),
+				Missing:    item.Missing,
+				Unexpected: item.Unexpected,
+				OutOfOrder: item.OutOfOrder,
+			})
+		}
+	}
+	for _, set := range s.IPSets {
+		s.addDrift(egressv1.ComponentIPSet, set.Name, set)
+	}
+	s.addDrift(egressv1.ComponentRoute, "rules", s.Rules)
+	s.addDrift(egressv1.ComponentRoute, "routes", s.Routes)
+	s.addDrift(egressv1.ComponentVXLAN, "neighbours", s.Neighbours)
+	s.addDrift(egressv1.ComponentFDB, "fdb", s.FDB)
+}
+
+func (s *State) addDrift(component, object string, entries Entries) {
+	if entries.Error != "" {
+		return
+	}
+	missing, unexpected := diff(entries.Desired, entries.Actual)
+	if len(missing) == 0 && len(unexpected) == 0 {
+		return
+	}
+	s.Drift = append(s.Drift, Drift{
+		Component:  component,
+		Object:     object,
+		Missing:    missing,
+		Unexpected: unexpected,
+	})
+}
+
+// diff returns the sorted desired entries which are not actual, and the actual
+// entries which are not desired
+func diff(desired, actual []string) (missing, unexpected []string) {
+	want := make(map[string]struct{}, len(desired))
+	for _, item := range desired {
+		want[item] = struct{}{}
+	}
+	got := make(map[string]struct{}, len(actual))
+	for _, item := range actual {
+		got[item] = struct{}{}
+		if _, ok := want[item]; !ok {
+			unexpected = append(unexpected, item)
+		}
+	}
+	for _, item := range desired {
+		if _, ok := got[item]; !ok {
+			missing = append(missing, item)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(unexpected)
+	return missing, unexpected
+}
@@ -0,0 +1,71 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package datapath
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+func TestSetDrift(t *testing.T) {
+	state := State{
+		Node: "node1",
+		IPTables: []Table{
+			{
+				Name:      "nat",
+				IPVersion: 4,
+				Desired: []iptables.ChainState{
+					{Chain: "EGRESSGATEWAY-SNAT-EIP", Rules: []string{"-A EGRESSGATEWAY-SNAT-EIP a"}, Hashes: []string{"a"}},
+				},
+				Actual: map[string][]string{"EGRESSGATEWAY-SNAT-EIP": {"a"}},
+			},
+			{
+				Name:      "mangle",
+				IPVersion: 4,
+				Desired: []iptables.ChainState{
+					{Chain: "EGRESSGATEWAY-MARK-REQUEST", Rules: []string{"-A EGRESSGATEWAY-MARK-REQUEST b"}, Hashes: []string{"b"}},
+				},
+				Error: "iptables-save failed",
+			},
+		},
+		IPSets: []Entries{
+			{Name: "egress-src-v4-a", Desired: []string{"10.0.0.1", "10.0.0.2"}, Actual: []string{"10.0.0.1", "10.0.0.3"}},
+			{Name: "egress-dst-v4-a", Desired: []string{"1.1.1.1"}, Actual: []string{"1.1.1.1"}},
+		},
+		Rules: Entries{
+			Desired: []string{"ipv4 fwmark 0x26000001 lookup 637534209"},
+			Actual:  []string{"ipv4 fwmark 0x26000001 lookup 637534209"},
+		},
+		Routes: Entries{
+			Desired: []string{"ipv4 table 637534209 via 192.200.0.2"},
+			Error:   "netlink failed",
+		},
+		Neighbours: Entries{
+			Desired: []string{"192.200.0.2 lladdr 66:50:00:00:00:02"},
+		},
+		FDB: Entries{
+			Actual: []string{"66:50:00:00:00:03 dst 10.6.1.23"},
+		},
+	}
+	state.SetDrift()
+
+	assert.Equal(t, []Drift{
+		{Component: egressv1.ComponentIPSet, Object: "egress-src-v4-a", Missing: []string{"10.0.0.2"}, Unexpected: []string{"10.0.0.3"}},
+		{Component: egressv1.ComponentVXLAN, Object: "neighbours", Missing: []string{"192.200.0.2 lladdr 66:50:00:00:00:02"}},
+		{Component: egressv1.ComponentFDB, Object: "fdb", Unexpected: []string{"66:50:00:00:00:03 dst 10.6.1.23"}},
+	}, state.Drift)
+
+	// the drift of the chains is prefixed with the table
+	state.IPTables[0].Actual = map[string][]string{}
+	state.SetDrift()
+	assert.Equal(t, Drift{
+		Component: egressv1.ComponentIPTables,
+		Object:    "ipv4/nat/EGRESSGATEWAY-SNAT-EIP",
+		Missing:   []string{"-A EGRESSGATEWAY-SNAT-EIP a"},
+	}, state.Drift[0])
+}
@@ -0,0 +1,280 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/datapath"
+	"github.com/spidernet-io/egressgateway/pkg/agent/vxlan"
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/iptables"
+	"github.com/spidernet-io/egressgateway/pkg/lock"
+)
+
+// datapathDebug serves the desired and the actual datapath of the node. It is created
+// before the manager, as its handler is served by the debug API, and the
+// reconcilers are attached to it when they are created.
+type datapathDebug struct {
+	lock.Mutex
+	cfg *config.Config
+
+	policy *policeReconciler
+	tunnel *vxlanReconciler
+
+	// tables are the desired state of the iptables tables, taken when they are applied,
+	// as the Table must not be read while the policy reconciler updates it
+	tables []tableState
+	// ipsets are the desired entries of the ipsets, indexed by set name
+	ipsets map[string][]string
+}
+
+type tableState struct {
+	table   *iptables.Table
+	desired []iptables.ChainState
+}
+
+func newDatapathDebug(cfg *config.Config) *datapathDebug {
+	return &datapathDebug{
+		cfg:    cfg,
+		ipsets: make(map[string][]string),
+	}
+}
+
+// SetTables records the desired state of the tables, it is called by the policy
+// reconciler before it applies them.
+func (d *datapathDebug) SetTables(tables []*iptables.Table) {
+	res := make([]tableState, 0, len(tables))
+	for _, table := range tables {
+		res = append(res, tableState{table: table, desired: table.DesiredState()})
+	}
+	d.Lock()
+	d.tables = res
+	d.Unlock()
+}
+
+// SetIPSet records the desired entries of an ipset
+func (d *datapathDebug) SetIPSet(name string, entries []string) {
+	d.Lock()
+	d.ipsets[name] = append([]string{}, entries...)
+	d.Unlock()
+}
+
+// DeleteIPSet forgets an ipset which is destroyed
+func (d *datapathDebug) DeleteIPSet(name string) {
+	d.Lock()
+	delete(d.ipsets, name)
+	d.Unlock()
+}
+
+func (d *datapathDebug) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(d.State())
+}
+
+// State reads the actual datapath and compares it with the desired one
+func (d *datapathDebug) State() datapath.State {
+	d.Lock()
+	tables := d.tables
+	ipsets := make(map[string][]string, len(d.ipsets))
+	for name, entries := range d.ipsets {
+		ipsets[name] = entries
+	}
+	d.Unlock()
+
+	state := datapath.State{
+		Node:     d.cfg.NodeName,
+		IPTables: make([]datapath.Table, 0, len(tables)),
+		IPSets:   d.ipsetState(ipsets),
+	}
+	for _, item := range tables {
+		table := datapath.Table{
+			Name:      item.table.Name,
+			IPVersion: item.table.IPVersion,
+			Desired:   item.desired,
+		}
+		actual, err := item.table.ActualState()
+		if err != nil {
+			table.Error = err.Error()
+		}
+		table.Actual = actual
+		state.IPTables = append(state.IPTables, table)
+	}
+	state.Rules, state.Routes = d.routeState()
+	state.Neighbours, state.FDB = d.neighbourState()
+	state.SetDrift()
+	return state
+}
+
+func (d *datapathDebug) ipsetState(desired map[string][]string) []datapath.Entries {
+	res := make([]datapath.Entries, 0)
+	if d.policy == nil {
+		return res
+	}
+	names := make(map[string]struct{}, len(desired))
+	for name := range desired {
+		names[name] = struct{}{}
+	}
+	sets, err := d.policy.ipset.ListSets()
+	if err != nil {
+		for name := range names {
+			res = append(res, datapath.Entries{Name: name, Desired: desired[name], Error: err.Error()})
+		}
+		sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+		return res
+	}
+	for _, name := range sets {
+		if strings.HasPrefix(name, "egress-") {
+			names[name] = struct{}{}
+		}
+	}
+
+	for name := range names {
+		item := datapath.Entries{Name: name, Desired: desired[name]}
+		actual, err := d.policy.ipset.ListEntries(name)
+		if err != nil {
+			item.Error = err.Error()
+		}
+		item.Actual = actual
+		res = append(res, item)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
+
+// routeState returns the policy routing of the tunnel, the traffic marked for a peer
+// looks up the table of the mark, which routes it to the tunnel IP of the peer.
+func (d *datapathDebug) routeState() (rules, routes datapath.Entries) {
+	if d.tunnel == nil {
+		return
+	}
+	tables := make(map[int]struct{})
+	d.tunnel.peerMap.Range(func(_ string, peer vxlan.Peer) bool {
+		if peer.Mark == 0 {
+			return true
+		}
+		for _, ip := range []*net.IP{peer.IPv4, peer.IPv6} {
+			if ip == nil {
+				continue
+			}
+			family := familyOf(*ip)
+			rules.Desired = append(rules.Desired, formatRule(family, peer.Mark, peer.Mark))
+			routes.Desired = append(routes.Desired, formatRoute(family, peer.Mark, *ip))
+			tables[peer.Mark] = struct{}{}
+		}
+		return true
+	})
+
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		items, err := d.tunnel.ruleRoute.Rules(family, d.cfg.FileConfig.Mark)
+		if err != nil {
+			rules.Error = err.Error()
+			break
+		}
+		for _, rule := range items {
+			rules.Actual = append(rules.Actual, formatRule(family, rule.Mark, rule.Table))
+			tables[rule.Table] = struct{}{}
+		}
+	}
+	for table := range tables {
+		for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+			items, err := d.tunnel.ruleRoute.Routes(family, table)
+			if err != nil {
+				routes.Error = err.Error()
+				break
+			}
+			for _, route := range items {
+				routes.Actual = append(routes.Actual, formatRoute(family, table, route.Gw))
+			}
+		}
+	}
+	sort.Strings(rules.Desired)
+	sort.Strings(rules.Actual)
+	sort.Strings(routes.Desired)
+	sort.Strings(routes.Actual)
+	return rules, routes
+}
+
+// neighbourState returns the permanent neighbours and FDB entries of the vxlan
+// device, one per peer.
+func (d *datapathDebug) neighbourState() (neigh, fdb datapath.Entries) {
+	if d.tunnel == nil || d.tunnel.vxlan == nil {
+		return
+	}
+	d.tunnel.peerMap.Range(func(node string, peer vxlan.Peer) bool {
+		if node == d.cfg.NodeName {
+			return true
+		}
+		for _, ip := range []*net.IP{peer.IPv4, peer.IPv6} {
+			if ip != nil {
+				neigh.Desired = append(neigh.Desired, formatNeigh(*ip, peer.MAC))
+			}
+		}
+		if peer.Parent != nil {
+			fdb.Desired = append(fdb.Desired, formatFDB(peer.MAC, peer.Parent))
+		}
+		return true
+	})
+
+	neighList, fdbList, err := d.tunnel.vxlan.Neighbours()
+	if err != nil {
+		neigh.Error = err.Error()
+		fdb.Error = err.Error()
+	}
+	for _, item := range neighList {
+		if item.State&netlink.NUD_PERMANENT != 0 {
+			neigh.Actual = append(neigh.Actual, formatNeigh(item.IP, item.HardwareAddr))
+		}
+	}
+	for _, item := range fdbList {
+		// the entries without IP are the local MAC of the device
+		if item.State&netlink.NUD_PERMANENT != 0 && item.IP != nil {
+			fdb.Actual = append(fdb.Actual, formatFDB(item.HardwareAddr, item.IP))
+		}
+	}
+	sort.Strings(neigh.Desired)
+	sort.Strings(neigh.Actual)
+	sort.Strings(fdb.Desired)
+	sort.Strings(fdb.Actual)
+	return neigh, fdb
+}
+
+func familyOf(ip net.IP) int {
+	if ip.To4() != nil {
+		return netlink.FAMILY_V4
+	}
+	return netlink.FAMILY_V6
+}
+
+func familyName(family int) string {
+	if family == netlink.FAMILY_V6 {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+func formatRule(family, mark, table int) string {
+	return fmt.Sprintf("%s fwmark %#x lookup %d", familyName(family), mark, table)
+}
+
+func formatRoute(family, table int, gw net.IP) string {
+	return fmt.Sprintf("%s table %d via %s", familyName(family), table, gw)
+}
+
+func formatNeigh(ip net.IP, mac net.HardwareAddr) string {
+	return fmt.Sprintf("%s lladdr %s", ip, mac)
+}
+
+func formatFDB(mac net.HardwareAddr, dst net.IP) string {
+	return fmt.Sprintf("%s dst %s", mac, dst)
+}
@@ -0,0 +1,54 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// debugServer serves the debug API of the agent on its own address. The API has no
+// authentication and shows the iptables rules, the ipset members and the MACs of the
+// layer2 requesters, so it is only started when its bind address is set.
+type debugServer struct {
+	addr     string
+	handlers map[string]http.Handler
+	log      logr.Logger
+}
+
+func (s *debugServer) Start(ctx context.Context) error {
+	mux := http.NewServeMux()
+	for path, handler := range s.handlers {
+		mux.Handle(path, handler)
+	}
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("debug API failed to listen on %s: %w", s.addr, err)
+	}
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	shutdown := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		if err := server.Shutdown(context.Background()); err != nil {
+			s.log.Error(err, "failed to shut down the debug API")
+		}
+		close(shutdown)
+	}()
+
+	s.log.Info("debug API is started", "addr", ln.Addr().String())
+	if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	<-shutdown
+	return nil
+}
+
+func (s *debugServer) NeedLeaderElection() bool { return false }
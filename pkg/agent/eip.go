@@ -7,10 +7,15 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/netip"
+	"time"
 
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -18,9 +23,13 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
+	"github.com/spidernet-io/egressgateway/pkg/agent/eipaddr"
+	"github.com/spidernet-io/egressgateway/pkg/agent/vlan"
+	"github.com/spidernet-io/egressgateway/pkg/bgp"
 	"github.com/spidernet-io/egressgateway/pkg/config"
 	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
 	"github.com/spidernet-io/egressgateway/pkg/layer2"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
 )
 
 type eip struct {
@@ -29,12 +38,31 @@ type eip struct {
 	cfg    *config.Config
 
 	announce *layer2.Announce
+	// prober probes the EIPs before they are announced, it is announce except in the tests
+	prober   prober
+	recorder record.EventRecorder
+	// suspects are the EIPs a conflict was found for once, see probeAdvertisements
+	suspects map[string]struct{}
+	// reannounced is the last AnnotationReannounce value handled for each gateway
+	reannounced map[string]string
+	// speaker announces the EIPs to the BGP peers instead of layer2 when the BGP mode is enabled
+	speaker *bgp.Speaker
+	// addresses adds the hosted EIPs to an interface of the node when it is not nil
+	addresses *eipaddr.Manager
+	// addressesExpected is true once the EgressGateways are passed to addresses.Expect
+	addressesExpected bool
+	// vlans provisions the VLAN sub-interfaces of the gateways which declare one
+	vlans *vlan.Manager
 }
 
 func (r *eip) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
 	log := r.log.WithValues("name", req.Name, "kind", "EgressGateway")
 	log.V(1).Info("reconcile")
 
+	if err := r.expectAddresses(ctx); err != nil {
+		return reconcile.Result{}, err
+	}
+
 	deleted := false
 	gateway := new(egressv1.EgressGateway)
 	err := r.client.Get(ctx, req.NamespacedName, gateway)
@@ -47,39 +75,325 @@ func (r *eip) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.R
 	deleted = deleted || !gateway.GetDeletionTimestamp().IsZero()
 
 	if deleted {
-		r.announce.DeleteBalancer(req.NamespacedName.Name)
+		if err := r.vlans.Set(req.Name, nil); err != nil {
+			return reconcile.Result{}, err
+		}
+	} else if err := r.vlans.Set(gateway.Name, buildVLAN(gateway, r.cfg.NodeName)); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if r.speaker != nil {
+		if deleted {
+			r.speaker.DeleteRoutes(req.Name)
+			return reconcile.Result{}, r.deleteAddresses(req.Name)
+		}
+		// the routes are withdrawn once the EIPs move to another node
+		routes := buildRoutes(log, gateway, r.cfg.NodeName)
+		r.speaker.SetRoutes(gateway.Name, routes)
+		if r.addresses != nil {
+			var ips []net.IP
+			for _, route := range routes {
+				ips = append(ips, route.Prefix.Addr().AsSlice())
+			}
+			if err := r.addresses.Set(gateway.Name, ips); err != nil {
+				return reconcile.Result{}, err
+			}
+		}
 		return reconcile.Result{}, nil
 	}
 
+	if deleted {
+		r.announce.DeleteBalancer(req.NamespacedName.Name)
+		delete(r.reannounced, req.Name)
+		return reconcile.Result{}, r.deleteAddresses(req.Name)
+	}
+
+	allInterfaces, interfaces, err := announceInterfaces(gateway.Spec.AnnounceInterfaces)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if v := gateway.Spec.VLAN; v != nil {
+		// the EIPs are answered on the sub-interface, in addition to the selected interfaces
+		name := vlan.Name(v.Parent, int(v.ID))
+		allInterfaces = false
+		interfaces.Insert(name)
+		r.ensureResponder(name)
+	}
+
+	profile := announcementProfile(gateway.Spec.Announcement)
+	var advs []layer2.IPAdvertisement
 	ips := gateway.Status.GetNodeIPs(r.cfg.NodeName)
 	for _, status := range ips {
 		ip := net.ParseIP(status.IPv4)
 		if ip.To4() != nil {
-			adv := layer2.NewIPAdvertisement(ip, true, sets.Set[string]{})
-			r.announce.SetBalancer(gateway.Name, adv)
+			advs = append(advs, layer2.NewIPAdvertisement(ip, allInterfaces, interfaces).WithProfile(profile))
 		}
 		ip = net.ParseIP(status.IPv6)
 		if ip.To16() != nil {
-			adv := layer2.NewIPAdvertisement(ip, true, sets.Set[string]{})
-			r.announce.SetBalancer(gateway.Name, adv)
+			advs = append(advs, layer2.NewIPAdvertisement(ip, allInterfaces, interfaces).WithProfile(profile))
+		}
+	}
+	if len(advs) > 0 && !allInterfaces && interfaces.Len() == 0 {
+		log.Info("no interface of the node is selected by announceInterfaces, the EIPs are not answered")
+	}
+
+	advs, reprobe, err := r.probeAdvertisements(ctx, log, gateway, advs)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// the advertisements of the EIPs which moved to another node are withdrawn,
+	// otherwise both nodes answer ARP/NDP for them after the failover
+	r.announce.SetBalancers(gateway.Name, advs)
+
+	if r.addresses != nil {
+		// the addresses are removed on failover as well, the conflicting EIPs are not added
+		ips := make([]net.IP, 0, len(advs))
+		for _, adv := range advs {
+			ips = append(ips, adv.IP())
 		}
+		if err := r.addresses.Set(gateway.Name, ips); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	token, ok := gateway.Annotations[egressv1.AnnotationReannounce]
+	if last, seen := r.reannounced[gateway.Name]; ok && seen && last != token {
+		log.Info("re-announce the EIPs", "token", token)
+		r.announce.Reannounce(gateway.Name)
 	}
+	r.reannounced[gateway.Name] = token
 
+	if reprobe {
+		return reconcile.Result{RequeueAfter: r.probeDuration()}, nil
+	}
+	if !allInterfaces {
+		// the selected interfaces change when interfaces or addresses are added to the node
+		return reconcile.Result{RequeueAfter: interfaceResyncPeriod}, nil
+	}
 	return reconcile.Result{}, nil
 }
 
+// expectAddresses lists the EgressGateways once, the addresses added before a restart
+// are not deleted until every gateway is reconciled.
+func (r *eip) expectAddresses(ctx context.Context) error {
+	if r.addresses == nil || r.addressesExpected {
+		return nil
+	}
+	list := new(egressv1.EgressGatewayList)
+	if err := r.client.List(ctx, list); err != nil {
+		return fmt.Errorf("failed to list EgressGateway: %w", err)
+	}
+	names := make([]string, 0, len(list.Items))
+	for _, item := range list.Items {
+		names = append(names, item.Name)
+	}
+	if err := r.addresses.Expect(names); err != nil {
+		return err
+	}
+	r.addressesExpected = true
+	return nil
+}
+
+func (r *eip) deleteAddresses(name string) error {
+	if r.addresses == nil {
+		return nil
+	}
+	return r.addresses.Delete(name)
+}
+
+const interfaceResyncPeriod = 10 * time.Second
+
+// ensureResponder refreshes the interfaces of the announcer when the sub-interface
+// was just created, so its EIPs are answered before the next interface scan.
+func (r *eip) ensureResponder(name string) {
+	for _, item := range r.announce.GetInterfaces() {
+		if item == name {
+			return
+		}
+	}
+	r.announce.RefreshInterfaces()
+}
+
+// buildVLAN returns the VLAN of the gateway on the node, it is nil if the gateway
+// declares no VLAN or the node is not selected by the gateway.
+func buildVLAN(gateway *egressv1.EgressGateway, nodeName string) *vlan.VLAN {
+	spec := gateway.Spec.VLAN
+	if spec == nil {
+		return nil
+	}
+	selected := false
+	for _, item := range gateway.Status.NodeList {
+		if item.Name == nodeName {
+			selected = true
+			break
+		}
+	}
+	if !selected {
+		return nil
+	}
+
+	res := &vlan.VLAN{
+		Parent:      spec.Parent,
+		ID:          int(spec.ID),
+		IPv4Gateway: net.ParseIP(spec.IPv4Gateway),
+		IPv6Gateway: net.ParseIP(spec.IPv6Gateway),
+	}
+	for _, item := range spec.Addresses {
+		if item.Node != nodeName {
+			continue
+		}
+		for _, addr := range []string{item.IPv4, item.IPv6} {
+			if addr != "" {
+				res.Addresses = append(res.Addresses, addr)
+			}
+		}
+	}
+	for _, status := range gateway.Status.GetNodeIPs(nodeName) {
+		for _, item := range []string{status.IPv4, status.IPv6} {
+			if ip := net.ParseIP(item); ip != nil {
+				res.EIPs = append(res.EIPs, ip)
+			}
+		}
+	}
+	return res
+}
+
+// announcementProfile returns the takeover burst of the EgressGateway, the unset
+// fields keep the values of layer2.DefaultProfile.
+func announcementProfile(spec *egressv1.AnnouncementProfile) layer2.Profile {
+	profile := layer2.DefaultProfile
+	if spec == nil {
+		return profile
+	}
+	if spec.Count != nil && *spec.Count > 0 {
+		profile.Count = int(*spec.Count)
+	}
+	if spec.IntervalMillis != nil && *spec.IntervalMillis > 0 {
+		profile.Interval = time.Duration(*spec.IntervalMillis) * time.Millisecond
+	}
+	if spec.Override != nil {
+		profile.Override = *spec.Override
+	}
+	if spec.Router != nil {
+		profile.Router = *spec.Router
+	}
+	return profile
+}
+
+// announceInterfaces returns the interfaces of the node selected by the EgressGateway,
+// allInterfaces is true if there is no selector.
+func announceInterfaces(sel *egressv1.AnnounceInterfaces) (allInterfaces bool, interfaces sets.Set[string], err error) {
+	if sel == nil || (len(sel.Names) == 0 && len(sel.Regexps) == 0 && len(sel.Subnets) == 0) {
+		return true, sets.Set[string]{}, nil
+	}
+	selector, err := layer2.NewInterfaceSelector(sel.Names, sel.Regexps, sel.Subnets)
+	if err != nil {
+		return false, nil, err
+	}
+	interfaces, err = selector.Interfaces()
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to select the announce interfaces: %w", err)
+	}
+	return false, interfaces, nil
+}
+
+// buildRoutes returns the host routes of the EIPs the node hosts for the gateway
+func buildRoutes(log logr.Logger, gateway *egressv1.EgressGateway, nodeName string) []bgp.Route {
+	var communities []uint32
+	var localPref *uint32
+	if gateway.Spec.BGP != nil {
+		for _, item := range gateway.Spec.BGP.Communities {
+			community, err := bgp.ParseCommunity(item)
+			if err != nil {
+				log.Error(err, "ignore the community")
+				continue
+			}
+			communities = append(communities, community)
+		}
+		localPref = gateway.Spec.BGP.LocalPref
+	}
+
+	var routes []bgp.Route
+	for _, status := range gateway.Status.GetNodeIPs(nodeName) {
+		for _, item := range []string{status.IPv4, status.IPv6} {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				continue
+			}
+			routes = append(routes, bgp.Route{
+				Prefix:      netip.PrefixFrom(addr, addr.BitLen()),
+				Communities: communities,
+				LocalPref:   localPref,
+			})
+		}
+	}
+	return routes
+}
+
+// newBGPSpeaker returns the BGP speaker, the router id defaults to the IPv4 InternalIP of the node
+func newBGPSpeaker(ctx context.Context, reader client.Reader, log logr.Logger, cfg *config.Config) (*bgp.Speaker, error) {
+	bgpCfg := cfg.FileConfig.BGP
+	routerID := bgpCfg.RouterID
+	if routerID == "" {
+		node := new(corev1.Node)
+		if err := reader.Get(ctx, types.NamespacedName{Name: cfg.NodeName}, node); err != nil {
+			return nil, fmt.Errorf("failed to get node %s: %w", cfg.NodeName, err)
+		}
+		routerID, _ = utils.GetNodeIP(node)
+	}
+	id, err := netip.ParseAddr(routerID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bgp router id %q: %w", routerID, err)
+	}
+
+	speakerCfg := bgp.Config{
+		ASN:      bgpCfg.LocalASN,
+		RouterID: id,
+		HoldTime: time.Duration(bgpCfg.HoldTime) * time.Second,
+	}
+	for _, peer := range bgpCfg.Peers {
+		addr, err := netip.ParseAddr(peer.Address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bgp peer address %q: %w", peer.Address, err)
+		}
+		speakerCfg.Peers = append(speakerCfg.Peers, bgp.PeerConfig{Address: addr, Port: peer.Port, ASN: peer.ASN})
+	}
+	return bgp.New(log.WithName("bgp"), speakerCfg)
+}
+
 // newEipCtrl return a new egress ip controller
-func newEipCtrl(mgr manager.Manager, log logr.Logger, cfg *config.Config) error {
-	an, err := layer2.New(log, cfg.FileConfig.AnnounceExcludeRegexp)
+func newEipCtrl(mgr manager.Manager, log logr.Logger, cfg *config.Config, an *layer2.Announce) error {
+	var err error
+	eip := &eip{
+		cfg:         cfg,
+		log:         log,
+		client:      mgr.GetClient(),
+		announce:    an,
+		prober:      an,
+		recorder:    mgr.GetEventRecorderFor("egressgateway-agent"),
+		suspects:    make(map[string]struct{}),
+		reannounced: make(map[string]string),
+	}
+
+	vlanMark, err := parseMark(cfg.FileConfig.VLANRoute.Mark)
 	if err != nil {
 		return err
 	}
+	eip.vlans = vlan.New(log.WithName("vlan"), cfg.FileConfig.VLANRoute.TableBase, vlanMark)
 
-	eip := &eip{
-		cfg:      cfg,
-		log:      log,
-		client:   mgr.GetClient(),
-		announce: an,
+	if cfg.FileConfig.EIPAddress.Enable {
+		eip.addresses = eipaddr.New(log.WithName("eipaddr"), cfg.FileConfig.EIPAddress.Interface, cfg.FileConfig.EIPAddress.StateFile)
+	}
+
+	if cfg.FileConfig.BGP.Enable {
+		eip.speaker, err = newBGPSpeaker(context.Background(), mgr.GetAPIReader(), log, cfg)
+		if err != nil {
+			return err
+		}
+		if err := mgr.Add(eip.speaker); err != nil {
+			return err
+		}
 	}
 
 	c, err := controller.New("eip", mgr, controller.Options{Reconciler: eip})
@@ -0,0 +1,294 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package eipaddr
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/spidernet-io/egressgateway/pkg/lock"
+)
+
+type NetLink struct {
+	LinkByName func(name string) (netlink.Link, error)
+	LinkAdd    func(link netlink.Link) error
+	LinkSetUp  func(link netlink.Link) error
+	AddrList   func(link netlink.Link, family int) ([]netlink.Addr, error)
+	AddrAdd    func(link netlink.Link, addr *netlink.Addr) error
+	AddrDel    func(link netlink.Link, addr *netlink.Addr) error
+}
+
+// arpSysctls stop the kernel from answering ARP on every interface for the EIPs,
+// the EIPs are only answered by the responder on the announce interfaces. The
+// kernel uses the maximum of the "all" and the interface values.
+var arpSysctls = map[string]string{
+	// reply only if the target address is configured on the incoming interface
+	"/proc/sys/net/ipv4/conf/all/arp_ignore": "1",
+	// never use an EIP as the source address of the ARP requests
+	"/proc/sys/net/ipv4/conf/all/arp_announce": "2",
+}
+
+// Manager adds the EIPs hosted by the node as /32 or /128 addresses of an interface.
+// Only the addresses added by the manager are deleted, they are recorded in the
+// state file so that the ownership survives the restarts of the agent. An EIP
+// which is already configured by the administrator is left as it is.
+type Manager struct {
+	lock.Mutex
+	log         logr.Logger
+	nl          NetLink
+	writeSysctl func(path, value string) error
+	intf        string
+	stateFile   string
+
+	// desired is the EIPs of each EgressGateway
+	desired map[string]sets.Set[string]
+	// owned is the addresses added by the manager
+	owned  sets.Set[string]
+	loaded bool
+	// expected is the EgressGateways listed at startup, it is nil until Expect is called.
+	// The owned addresses are not deleted before every expected gateway is seen, so
+	// that the first Set after a restart does not delete the EIPs of other gateways.
+	expected sets.Set[string]
+	// seen is the EgressGateways passed to Set
+	seen       sets.Set[string]
+	arpIgnored bool
+}
+
+func New(log logr.Logger, intf, stateFile string) *Manager {
+	return newManager(log, NetLink{
+		LinkByName: netlink.LinkByName,
+		LinkAdd:    netlink.LinkAdd,
+		LinkSetUp:  netlink.LinkSetUp,
+		AddrList:   netlink.AddrList,
+		AddrAdd:    netlink.AddrAdd,
+		AddrDel:    netlink.AddrDel,
+	}, func(path, value string) error {
+		return os.WriteFile(path, []byte(value), 0o644)
+	}, intf, stateFile)
+}
+
+func newManager(log logr.Logger, nl NetLink, writeSysctl func(path, value string) error, intf, stateFile string) *Manager {
+	return &Manager{
+		log:         log.WithValues("interface", intf),
+		nl:          nl,
+		writeSysctl: writeSysctl,
+		intf:        intf,
+		stateFile:   stateFile,
+		desired:     make(map[string]sets.Set[string]),
+		owned:       sets.New[string](),
+		seen:        sets.New[string](),
+	}
+}
+
+// Expect records the EgressGateways which exist when the agent starts, the
+// addresses owned before the restart are only deleted once all of them are set.
+func (m *Manager) Expect(names []string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	m.expected = sets.New[string](names...)
+	return m.sync()
+}
+
+// Set replaces the EIPs of the EgressGateway name with ips.
+func (m *Manager) Set(name string, ips []net.IP) error {
+	m.Lock()
+	defer m.Unlock()
+
+	m.seen.Insert(name)
+	desired := sets.New[string]()
+	for _, ip := range ips {
+		desired.Insert(ip.String())
+	}
+	if desired.Len() == 0 {
+		delete(m.desired, name)
+	} else {
+		m.desired[name] = desired
+	}
+	return m.sync()
+}
+
+// Delete removes the EIPs of the EgressGateway name.
+func (m *Manager) Delete(name string) error {
+	return m.Set(name, nil)
+}
+
+func (m *Manager) sync() error {
+	if !m.loaded {
+		if err := m.load(); err != nil {
+			return err
+		}
+		m.loaded = true
+	}
+
+	link, err := m.ensureLink()
+	if err != nil {
+		return err
+	}
+	addrs, err := m.nl.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("failed to list the addresses of %s: %w", m.intf, err)
+	}
+	present := make(map[string]netlink.Addr, len(addrs))
+	for _, addr := range addrs {
+		present[addr.IP.String()] = addr
+	}
+
+	want := sets.New[string]()
+	for _, ips := range m.desired {
+		want = want.Union(ips)
+	}
+
+	stale := m.owned.Difference(want)
+	if stale.Len() != 0 && (m.expected == nil || !m.seen.IsSuperset(m.expected)) {
+		m.log.V(1).Info("not all EgressGateways are seen, the stale EIP addresses are deleted later", "ips", sets.List(stale))
+		stale = sets.New[string]()
+	}
+
+	changed := false
+	var errs []error
+	for _, ip := range sets.List(stale) {
+		if addr, ok := present[ip]; ok {
+			if err := m.nl.AddrDel(link, &addr); err != nil {
+				errs = append(errs, fmt.Errorf("failed to delete address %s: %w", ip, err))
+				continue
+			}
+			m.log.Info("deleted the EIP address", "ip", ip)
+		}
+		m.owned.Delete(ip)
+		changed = true
+	}
+
+	for _, ip := range sets.List(want) {
+		if _, ok := present[ip]; ok {
+			if !m.owned.Has(ip) {
+				m.log.V(1).Info("the EIP address is configured by others, it is not managed", "ip", ip)
+			}
+			continue
+		}
+		if err := m.ignoreARP(net.ParseIP(ip)); err != nil {
+			errs = append(errs, fmt.Errorf("failed to add address %s: %w", ip, err))
+			continue
+		}
+		if err := m.nl.AddrAdd(link, hostAddr(net.ParseIP(ip))); err != nil {
+			errs = append(errs, fmt.Errorf("failed to add address %s: %w", ip, err))
+			continue
+		}
+		m.log.Info("added the EIP address", "ip", ip)
+		if !m.owned.Has(ip) {
+			m.owned.Insert(ip)
+			changed = true
+		}
+	}
+
+	if changed {
+		if err := m.save(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ensureLink returns the interface, a dummy interface is created if it does not exist.
+func (m *Manager) ensureLink() (netlink.Link, error) {
+	link, err := m.nl.LinkByName(m.intf)
+	if err != nil {
+		if !errors.As(err, &netlink.LinkNotFoundError{}) {
+			return nil, fmt.Errorf("failed to get interface %s: %w", m.intf, err)
+		}
+		dummy := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: m.intf}}
+		if err := m.nl.LinkAdd(dummy); err != nil {
+			return nil, fmt.Errorf("failed to create dummy interface %s: %w", m.intf, err)
+		}
+		m.log.Info("created the dummy interface for the EIP addresses")
+		if link, err = m.nl.LinkByName(m.intf); err != nil {
+			return nil, fmt.Errorf("failed to get interface %s: %w", m.intf, err)
+		}
+	}
+	if link.Attrs().Flags&net.FlagUp == 0 {
+		if err := m.nl.LinkSetUp(link); err != nil {
+			return nil, fmt.Errorf("failed to set interface %s up: %w", m.intf, err)
+		}
+	}
+	return link, nil
+}
+
+// ignoreARP sets arpSysctls before the first IPv4 EIP is added, otherwise the kernel
+// answers ARP for it on every interface, regardless of the announce interfaces.
+func (m *Manager) ignoreARP(ip net.IP) error {
+	if m.arpIgnored || ip.To4() == nil {
+		return nil
+	}
+	for path, value := range arpSysctls {
+		if err := m.writeSysctl(path, value); err != nil {
+			return fmt.Errorf("failed to set %s: %w", path, err)
+		}
+	}
+	m.arpIgnored = true
+	return nil
+}
+
+// hostAddr returns the /32 or /128 address of ip. The duplicate address detection
+// of the kernel is skipped for IPv6, the EIPs are probed before they are announced.
+func hostAddr(ip net.IP) *netlink.Addr {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &netlink.Addr{IPNet: &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}}
+	}
+	return &netlink.Addr{
+		IPNet: &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)},
+		Flags: unix.IFA_F_NODAD,
+	}
+}
+
+// The state file has a header line with the interface name and one owned address per line.
+func (m *Manager) load() error {
+	data, err := os.ReadFile(m.stateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read the EIP address state: %w", err)
+	}
+	lines := strings.Fields(string(data))
+	if len(lines) == 0 {
+		return nil
+	}
+	if lines[0] != m.intf {
+		// the addresses on the previous interface are left as they are
+		m.log.Info("the EIP address interface changed, the addresses of the previous interface are not managed", "previous", lines[0])
+		return nil
+	}
+	for _, ip := range lines[1:] {
+		if net.ParseIP(ip) != nil {
+			m.owned.Insert(ip)
+		}
+	}
+	return nil
+}
+
+func (m *Manager) save() error {
+	ips := sets.List(m.owned)
+	data := strings.Join(append([]string{m.intf}, ips...), "\n") + "\n"
+
+	if err := os.MkdirAll(filepath.Dir(m.stateFile), 0o755); err != nil {
+		return fmt.Errorf("failed to save the EIP address state: %w", err)
+	}
+	tmp := m.stateFile + ".tmp"
+	if err := os.WriteFile(tmp, []byte(data), 0o644); err != nil {
+		return fmt.Errorf("failed to save the EIP address state: %w", err)
+	}
+	if err := os.Rename(tmp, m.stateFile); err != nil {
+		return fmt.Errorf("failed to save the EIP address state: %w", err)
+	}
+	return nil
+}
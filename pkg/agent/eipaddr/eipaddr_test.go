@@ -0,0 +1,175 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package eipaddr
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+)
+
+// fakeLink keeps the interfaces and their addresses in memory
+type fakeLink struct {
+	links   map[string]netlink.Link
+	addrs   map[string]sets.Set[string]
+	sysctls map[string]string
+}
+
+func newFakeLink(existing map[string][]string) *fakeLink {
+	f := &fakeLink{links: map[string]netlink.Link{}, addrs: map[string]sets.Set[string]{}, sysctls: map[string]string{}}
+	for name, addrs := range existing {
+		f.links[name] = &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: name, Flags: net.FlagUp}}
+		f.addrs[name] = sets.New[string](addrs...)
+	}
+	return f
+}
+
+func (f *fakeLink) netLink() NetLink {
+	return NetLink{
+		LinkByName: func(name string) (netlink.Link, error) {
+			link, ok := f.links[name]
+			if !ok {
+				return nil, netlink.LinkNotFoundError{}
+			}
+			return link, nil
+		},
+		LinkAdd: func(link netlink.Link) error {
+			f.links[link.Attrs().Name] = link
+			f.addrs[link.Attrs().Name] = sets.New[string]()
+			return nil
+		},
+		LinkSetUp: func(link netlink.Link) error {
+			link.Attrs().Flags |= net.FlagUp
+			return nil
+		},
+		AddrList: func(link netlink.Link, family int) ([]netlink.Addr, error) {
+			var res []netlink.Addr
+			for _, ip := range sets.List(f.addrs[link.Attrs().Name]) {
+				res = append(res, netlink.Addr{IPNet: &net.IPNet{IP: net.ParseIP(ip)}})
+			}
+			return res, nil
+		},
+		AddrAdd: func(link netlink.Link, addr *netlink.Addr) error {
+			f.addrs[link.Attrs().Name].Insert(addr.IP.String())
+			return nil
+		},
+		AddrDel: func(link netlink.Link, addr *netlink.Addr) error {
+			f.addrs[link.Attrs().Name].Delete(addr.IP.String())
+			return nil
+		},
+	}
+}
+
+func (f *fakeLink) writeSysctl(path, value string) error {
+	f.sysctls[path] = value
+	return nil
+}
+
+func ips(items ...string) []net.IP {
+	var res []net.IP
+	for _, item := range items {
+		res = append(res, net.ParseIP(item))
+	}
+	return res
+}
+
+func TestManager(t *testing.T) {
+	log := logger.NewLogger(logger.Config{})
+	cases := map[string]struct {
+		intf     string
+		existing map[string][]string
+		// set are applied in order, name -> ips
+		set     []map[string][]string
+		expAddr []string
+	}{
+		"create the dummy interface": {
+			intf:    "egress-eip",
+			set:     []map[string][]string{{"egw1": {"10.6.1.21", "fd00::21"}}},
+			expAddr: []string{"10.6.1.21", "fd00::21"},
+		},
+		"remove the addresses on failover": {
+			intf: "egress-eip",
+			set: []map[string][]string{
+				{"egw1": {"10.6.1.21", "fd00::21"}},
+				{"egw1": {"10.6.1.21"}},
+			},
+			expAddr: []string{"10.6.1.21"},
+		},
+		"keep the address of another gateway": {
+			intf: "egress-eip",
+			set: []map[string][]string{
+				{"egw1": {"10.6.1.21"}, "egw2": {"10.6.1.21", "10.6.1.22"}},
+				{"egw1": nil},
+			},
+			expAddr: []string{"10.6.1.21", "10.6.1.22"},
+		},
+		"keep the addresses configured by the administrator": {
+			intf:     "eth0",
+			existing: map[string][]string{"eth0": {"10.6.0.10", "10.6.1.21"}},
+			set: []map[string][]string{
+				{"egw1": {"10.6.1.21", "10.6.1.22"}},
+				{"egw1": nil},
+			},
+			expAddr: []string{"10.6.0.10", "10.6.1.21"},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			fake := newFakeLink(c.existing)
+			m := newManager(log, fake.netLink(), fake.writeSysctl, c.intf, filepath.Join(t.TempDir(), "state"))
+			var names []string
+			for gateway := range c.set[0] {
+				names = append(names, gateway)
+			}
+			assert.NoError(t, m.Expect(names))
+			for _, set := range c.set {
+				for gateway, items := range set {
+					assert.NoError(t, m.Set(gateway, ips(items...)))
+				}
+			}
+			assert.Equal(t, c.expAddr, sets.List(fake.addrs[c.intf]))
+			assert.NotZero(t, fake.links[c.intf].Attrs().Flags&net.FlagUp)
+			assert.Equal(t, arpSysctls, fake.sysctls)
+		})
+	}
+}
+
+func TestManagerRestart(t *testing.T) {
+	log := logger.NewLogger(logger.Config{})
+	stateFile := filepath.Join(t.TempDir(), "run", "state")
+	fake := newFakeLink(map[string][]string{"eth0": {"10.6.1.21"}})
+
+	m := newManager(log, fake.netLink(), fake.writeSysctl, "eth0", stateFile)
+	assert.NoError(t, m.Expect([]string{"egw1", "egw2"}))
+	assert.NoError(t, m.Set("egw1", ips("10.6.1.21", "10.6.1.22")))
+	assert.NoError(t, m.Set("egw2", ips("10.6.1.31")))
+
+	// the addresses of egw2 are kept until egw2 is seen by the new agent
+	m = newManager(log, fake.netLink(), fake.writeSysctl, "eth0", stateFile)
+	assert.NoError(t, m.Set("egw1", ips("10.6.1.22")))
+	assert.Equal(t, []string{"10.6.1.21", "10.6.1.22", "10.6.1.31"}, sets.List(fake.addrs["eth0"]))
+	assert.NoError(t, m.Expect([]string{"egw1", "egw2"}))
+	assert.Equal(t, []string{"10.6.1.21", "10.6.1.22", "10.6.1.31"}, sets.List(fake.addrs["eth0"]))
+	assert.NoError(t, m.Set("egw2", ips("10.6.1.31")))
+
+	// the new agent removes the address it added before the restart, but not
+	// the one configured by the administrator
+	assert.NoError(t, m.Set("egw1", nil))
+	assert.NoError(t, m.Set("egw2", nil))
+	assert.Equal(t, []string{"10.6.1.21"}, sets.List(fake.addrs["eth0"]))
+
+	// the ownership is dropped when the interface changes
+	assert.NoError(t, m.Set("egw1", ips("10.6.1.23")))
+	m = newManager(log, fake.netLink(), fake.writeSysctl, "egress-eip", stateFile)
+	assert.NoError(t, m.Expect([]string{"egw1"}))
+	assert.NoError(t, m.Set("egw1", nil))
+	assert.Equal(t, []string{"10.6.1.21", "10.6.1.23"}, sets.List(fake.addrs["eth0"]))
+}
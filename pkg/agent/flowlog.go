@@ -0,0 +1,77 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/flowlog"
+	"github.com/spidernet-io/egressgateway/pkg/config"
+)
+
+func newFlowLogger(mgr manager.Manager, log logr.Logger, cfg *config.Config) error {
+	flowCfg := cfg.FileConfig.FlowLog
+	if !flowCfg.Enable && !flowCfg.IPFIX.Enable {
+		return nil
+	}
+
+	c := flowlog.Config{
+		Node:       cfg.NodeName,
+		SampleRate: flowCfg.SampleRate,
+		RateLimit:  flowCfg.RateLimit,
+		Burst:      flowCfg.Burst,
+	}
+	// the reply route mark is saved to the conntrack entry of the flows SNATed by the
+	// node, so the flows whose EIP moved are still logged
+	if cfg.FileConfig.EnableGatewayReplyRoute {
+		c.Mark = uint32(cfg.FileConfig.GatewayReplyRouteMark)
+		c.MarkMask = 0xffffffff
+	}
+
+	log = log.WithName("flowlog")
+	resolver := flowlog.NewCacheResolver(log, mgr.GetClient(), cfg.NodeName)
+	var handlers []flowlog.Handler
+
+	if flowCfg.Enable {
+		var out io.Writer = os.Stdout
+		if flowCfg.Output != "stdout" {
+			if err := os.MkdirAll(filepath.Dir(flowCfg.Output), 0o755); err != nil {
+				return fmt.Errorf("failed to create the directory of the flow log: %w", err)
+			}
+			file, err := os.OpenFile(flowCfg.Output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+			if err != nil {
+				return fmt.Errorf("failed to open the flow log: %w", err)
+			}
+			out = file
+		}
+		handlers = append(handlers, flowlog.New(log, c, resolver, out))
+	}
+
+	if flowCfg.IPFIX.Enable {
+		exporter, err := flowlog.NewExporter(log, c, flowlog.IPFIXConfig{
+			Collector:           flowCfg.IPFIX.Collector,
+			ActiveTimeout:       time.Duration(flowCfg.IPFIX.ActiveTimeout) * time.Second,
+			IdleTimeout:         time.Duration(flowCfg.IPFIX.IdleTimeout) * time.Second,
+			TemplateRefresh:     time.Duration(flowCfg.IPFIX.TemplateRefresh) * time.Second,
+			ObservationDomainID: flowCfg.IPFIX.ObservationDomainID,
+			EnterpriseNumber:    flowCfg.IPFIX.EnterpriseNumber,
+		}, resolver)
+		if err != nil {
+			return fmt.Errorf("failed to create the IPFIX exporter: %w", err)
+		}
+		if err := mgr.Add(exporter); err != nil {
+			return err
+		}
+		handlers = append(handlers, exporter)
+	}
+
+	return mgr.Add(flowlog.NewWatcher(log, handlers...))
+}
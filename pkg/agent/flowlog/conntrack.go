@@ -0,0 +1,292 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package flowlog
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// The conntrack multicast groups, see NFNLGRP_CONNTRACK_* in linux/netfilter/nfnetlink.h
+const (
+	groupConntrackNew     = 1
+	groupConntrackDestroy = 3
+)
+
+// The conntrack event types, see IPCTNL_MSG_CT_* in linux/netfilter/nfnetlink_conntrack.h
+const (
+	msgNew    = 0
+	msgDelete = nl.IPCTNL_MSG_CT_DELETE
+)
+
+// Tuple is a direction of a conntrack flow.
+type Tuple struct {
+	Src      net.IP
+	Dst      net.IP
+	SrcPort  uint16
+	DstPort  uint16
+	Protocol uint8
+	Packets  uint64
+	Bytes    uint64
+}
+
+// Flow is a conntrack event, the counters and timestamps are set when the kernel
+// accounting is enabled.
+type Flow struct {
+	// Destroy is set for the end of the flow, otherwise the flow is new
+	Destroy bool
+	ID      uint32
+	Mark    uint32
+	Orig    Tuple
+	Reply   Tuple
+	Start   time.Time
+	Stop    time.Time
+}
+
+// accountingSysctls enable the counters and timestamps of the conntrack entries
+var accountingSysctls = []string{
+	"/proc/sys/net/netfilter/nf_conntrack_acct",
+	"/proc/sys/net/netfilter/nf_conntrack_timestamp",
+}
+
+// Handler receives the conntrack events of the node.
+type Handler interface {
+	Handle(flow *Flow, now time.Time)
+}
+
+// Watcher subscribes to the conntrack events and passes them to the handlers.
+type Watcher struct {
+	log      logr.Logger
+	handlers []Handler
+}
+
+func NewWatcher(log logr.Logger, handlers ...Handler) *Watcher {
+	return &Watcher{log: log, handlers: handlers}
+}
+
+// Start subscribes to the conntrack events until ctx is done.
+func (w *Watcher) Start(ctx context.Context) error {
+	for _, item := range accountingSysctls {
+		if err := os.WriteFile(item, []byte("1"), 0o644); err != nil {
+			w.log.Error(err, "failed to enable the conntrack accounting, the flows have no counters or timestamps", "sysctl", item)
+		}
+	}
+
+	sock, err := nl.Subscribe(unix.NETLINK_NETFILTER, groupConntrackNew, groupConntrackDestroy)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		sock.Close()
+	}()
+
+	w.log.Info("subscribed to the conntrack events")
+	for {
+		msgs, _, err := sock.Receive()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			if errors.Is(err, unix.ENOBUFS) {
+				// the events of a burst of flows were dropped by the kernel
+				countRecords.WithLabelValues("overflow").Inc()
+				continue
+			}
+			w.log.Error(err, "failed to receive the conntrack events")
+			time.Sleep(time.Second)
+			continue
+		}
+		now := time.Now()
+		for _, msg := range msgs {
+			flow, err := parseFlow(msg.Header.Type, msg.Data)
+			if err != nil {
+				w.log.V(1).Info("ignore the conntrack event", "reason", err.Error())
+				continue
+			}
+			for _, handler := range w.handlers {
+				handler.Handle(flow, now)
+			}
+		}
+	}
+}
+
+func (w *Watcher) NeedLeaderElection() bool { return false }
+
+// dumpFlows lists the conntrack entries of all the families.
+func dumpFlows() ([]*Flow, error) {
+	req := nl.NewNetlinkRequest(unix.NFNL_SUBSYS_CTNETLINK<<8|nl.IPCTNL_MSG_CT_GET, unix.NLM_F_DUMP)
+	req.AddData(&nl.Nfgenmsg{NfgenFamily: unix.AF_UNSPEC, Version: nl.NFNETLINK_V0})
+	msgs, err := req.Execute(unix.NETLINK_NETFILTER, 0)
+	if err != nil {
+		return nil, err
+	}
+	flows := make([]*Flow, 0, len(msgs))
+	for _, msg := range msgs {
+		flow, err := parseFlow(unix.NFNL_SUBSYS_CTNETLINK<<8|msgNew, msg)
+		if err != nil {
+			continue
+		}
+		flows = append(flows, flow)
+	}
+	return flows, nil
+}
+
+type attr struct {
+	typ   uint16
+	value []byte
+}
+
+// parseAttrs splits b into netlink attributes.
+func parseAttrs(b []byte) ([]attr, error) {
+	var attrs []attr
+	for len(b) >= unix.SizeofNlAttr {
+		length := int(nl.NativeEndian().Uint16(b[0:2]))
+		typ := nl.NativeEndian().Uint16(b[2:4]) & nl.NLA_TYPE_MASK
+		if length < unix.SizeofNlAttr || length > len(b) {
+			return nil, fmt.Errorf("invalid attribute length %d", length)
+		}
+		attrs = append(attrs, attr{typ: typ, value: b[unix.SizeofNlAttr:length]})
+		aligned := (length + unix.NLA_ALIGNTO - 1) & ^(unix.NLA_ALIGNTO - 1)
+		if aligned > len(b) {
+			break
+		}
+		b = b[aligned:]
+	}
+	return attrs, nil
+}
+
+// parseFlow parses a ctnetlink message, msgType is the type of the netlink header.
+func parseFlow(msgType uint16, data []byte) (*Flow, error) {
+	if msgType>>8 != unix.NFNL_SUBSYS_CTNETLINK {
+		return nil, fmt.Errorf("unexpected subsystem %d", msgType>>8)
+	}
+	// skip the nfgenmsg header
+	if len(data) < 4 {
+		return nil, fmt.Errorf("message too short")
+	}
+	attrs, err := parseAttrs(data[4:])
+	if err != nil {
+		return nil, err
+	}
+
+	flow := &Flow{Destroy: msgType&0xff == msgDelete}
+	for _, a := range attrs {
+		switch a.typ {
+		case nl.CTA_TUPLE_ORIG:
+			err = parseTuple(a.value, &flow.Orig)
+		case nl.CTA_TUPLE_REPLY:
+			err = parseTuple(a.value, &flow.Reply)
+		case nl.CTA_COUNTERS_ORIG:
+			err = parseCounters(a.value, &flow.Orig)
+		case nl.CTA_COUNTERS_REPLY:
+			err = parseCounters(a.value, &flow.Reply)
+		case nl.CTA_MARK:
+			if len(a.value) >= 4 {
+				flow.Mark = binary.BigEndian.Uint32(a.value)
+			}
+		case nl.CTA_ID:
+			if len(a.value) >= 4 {
+				flow.ID = binary.BigEndian.Uint32(a.value)
+			}
+		case nl.CTA_TIMESTAMP:
+			err = parseTimestamp(a.value, flow)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return flow, nil
+}
+
+func parseTuple(b []byte, tuple *Tuple) error {
+	attrs, err := parseAttrs(b)
+	if err != nil {
+		return err
+	}
+	for _, a := range attrs {
+		switch a.typ {
+		case nl.CTA_TUPLE_IP:
+			ips, err := parseAttrs(a.value)
+			if err != nil {
+				return err
+			}
+			for _, ip := range ips {
+				switch ip.typ {
+				case nl.CTA_IP_V4_SRC, nl.CTA_IP_V6_SRC:
+					tuple.Src = net.IP(append([]byte(nil), ip.value...))
+				case nl.CTA_IP_V4_DST, nl.CTA_IP_V6_DST:
+					tuple.Dst = net.IP(append([]byte(nil), ip.value...))
+				}
+			}
+		case nl.CTA_TUPLE_PROTO:
+			protos, err := parseAttrs(a.value)
+			if err != nil {
+				return err
+			}
+			for _, proto := range protos {
+				switch {
+				case proto.typ == nl.CTA_PROTO_NUM && len(proto.value) >= 1:
+					tuple.Protocol = proto.value[0]
+				case proto.typ == nl.CTA_PROTO_SRC_PORT && len(proto.value) >= 2:
+					tuple.SrcPort = binary.BigEndian.Uint16(proto.value)
+				case proto.typ == nl.CTA_PROTO_DST_PORT && len(proto.value) >= 2:
+					tuple.DstPort = binary.BigEndian.Uint16(proto.value)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func parseCounters(b []byte, tuple *Tuple) error {
+	attrs, err := parseAttrs(b)
+	if err != nil {
+		return err
+	}
+	for _, a := range attrs {
+		if len(a.value) < 8 {
+			continue
+		}
+		switch a.typ {
+		case nl.CTA_COUNTERS_PACKETS:
+			tuple.Packets = binary.BigEndian.Uint64(a.value)
+		case nl.CTA_COUNTERS_BYTES:
+			tuple.Bytes = binary.BigEndian.Uint64(a.value)
+		}
+	}
+	return nil
+}
+
+func parseTimestamp(b []byte, flow *Flow) error {
+	attrs, err := parseAttrs(b)
+	if err != nil {
+		return err
+	}
+	for _, a := range attrs {
+		if len(a.value) < 8 {
+			continue
+		}
+		ts := binary.BigEndian.Uint64(a.value)
+		if ts == 0 {
+			continue
+		}
+		switch a.typ {
+		case nl.CTA_TIMESTAMP_START:
+			flow.Start = time.Unix(0, int64(ts))
+		case nl.CTA_TIMESTAMP_STOP:
+			flow.Stop = time.Unix(0, int64(ts))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package flowlog
+
+import (
+	"container/list"
+	"encoding/binary"
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"net"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/time/rate"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+const (
+	// maxPending bounds the start times of the flows remembered when the kernel
+	// timestamps are disabled, the oldest one is forgotten when it is reached.
+	maxPending = 65536
+	// pendingTTL forgets the start time of a flow whose end event was lost, e.g. when
+	// the kernel dropped the events, the start of the longer flows is unknown
+	pendingTTL = 24 * time.Hour
+	// pendingSweep is the interval the expired start times are forgotten
+	pendingSweep = time.Minute
+)
+
+var countRecords = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "egress_flow_log_records_total",
+	Help: "Number of the egress flows ended, by whether their record was written",
+}, []string{"result"})
+
+var countPendingEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "egress_flow_log_pending_evictions_total",
+	Help: "Number of the start times of the flows forgotten before the flows ended, by reason",
+}, []string{"reason"})
+
+// MetricCollectors returns the metrics of the flow logs
+func MetricCollectors() []prometheus.Collector {
+	return []prometheus.Collector{countRecords, countPendingEvictions, countExports}
+}
+
+// Endpoint is the pod a flow is sent from.
+type Endpoint struct {
+	Pod       string
+	Namespace string
+	Policy    egressv1.Policy
+}
+
+// Resolver looks up the pods and the EIPs of the node.
+type Resolver interface {
+	// Endpoint returns the pod of ip
+	Endpoint(ip net.IP) (Endpoint, bool)
+	// IsEIP reports whether ip is an EIP hosted by the node
+	IsEIP(ip net.IP) bool
+}
+
+type Config struct {
+	Node string
+	// SampleRate logs one of SampleRate flows, all flows are logged when it is 1
+	SampleRate int
+	// RateLimit is the number of records written per second, 0 is unlimited
+	RateLimit int
+	Burst     int
+	// Mark and MarkMask select the flows by conntrack mark, in addition to the flows
+	// SNATed to an EIP. The mark is not checked when MarkMask is 0.
+	Mark     uint32
+	MarkMask uint32
+}
+
+// Record is a flow log entry, written as a JSON line.
+type Record struct {
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	Protocol        string    `json:"protocol"`
+	Src             string    `json:"src"`
+	SrcPort         uint16    `json:"srcPort,omitempty"`
+	Dst             string    `json:"dst"`
+	DstPort         uint16    `json:"dstPort,omitempty"`
+	EIP             string    `json:"eip,omitempty"`
+	Packets         uint64    `json:"packets"`
+	Bytes           uint64    `json:"bytes"`
+	ReplyPackets    uint64    `json:"replyPackets"`
+	ReplyBytes      uint64    `json:"replyBytes"`
+	Pod             string    `json:"pod,omitempty"`
+	Namespace       string    `json:"namespace,omitempty"`
+	Policy          string    `json:"policy,omitempty"`
+	PolicyNamespace string    `json:"policyNamespace,omitempty"`
+	Node            string    `json:"node"`
+}
+
+// filter selects and samples the egress flows of the node.
+type filter struct {
+	cfg      Config
+	resolver Resolver
+}
+
+// Logger writes a record for each egress flow of the node when it ends.
+type Logger struct {
+	filter
+	log     logr.Logger
+	enc     *json.Encoder
+	limiter *rate.Limiter
+	// starts are the start times of the flows seen by this agent, indexed by conntrack ID,
+	// their elements are in pending from the oldest
+	starts    map[uint32]*list.Element
+	pending   *list.List
+	lastSweep time.Time
+}
+
+// pendingFlow is the start time of a flow remembered until the flow ends
+type pendingFlow struct {
+	id    uint32
+	start time.Time
+}
+
+func New(log logr.Logger, cfg Config, resolver Resolver, out io.Writer) *Logger {
+	l := &Logger{
+		filter:  filter{cfg: cfg, resolver: resolver},
+		log:     log,
+		enc:     json.NewEncoder(out),
+		starts:  make(map[uint32]*list.Element),
+		pending: list.New(),
+	}
+	if cfg.RateLimit > 0 {
+		burst := cfg.Burst
+		if burst < 1 {
+			burst = cfg.RateLimit
+		}
+		l.limiter = rate.NewLimiter(rate.Limit(cfg.RateLimit), burst)
+	}
+	return l
+}
+
+// match returns the EIP of an egress flow, ok is false if the flow is not an egress flow.
+func (f *filter) match(flow *Flow) (eip net.IP, ok bool) {
+	// the reply of a SNATed flow is sent to the EIP
+	if flow.Reply.Dst != nil && !flow.Reply.Dst.Equal(flow.Orig.Src) && f.resolver.IsEIP(flow.Reply.Dst) {
+		return flow.Reply.Dst, true
+	}
+	if f.cfg.MarkMask != 0 && flow.Mark&f.cfg.MarkMask == f.cfg.Mark {
+		return nil, true
+	}
+	return nil, false
+}
+
+// sampled selects one of SampleRate flows by their original tuple, so the decision
+// is the same for the start and the end of a flow.
+func (f *filter) sampled(flow *Flow) bool {
+	if f.cfg.SampleRate <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	_, _ = h.Write(flow.Orig.Src)
+	_, _ = h.Write(flow.Orig.Dst)
+	var b [5]byte
+	binary.BigEndian.PutUint16(b[0:2], flow.Orig.SrcPort)
+	binary.BigEndian.PutUint16(b[2:4], flow.Orig.DstPort)
+	b[4] = flow.Orig.Protocol
+	_, _ = h.Write(b[:])
+	return h.Sum32()%uint32(f.cfg.SampleRate) == 0
+}
+
+// Handle writes the record of a flow when it ends.
+func (l *Logger) Handle(flow *Flow, now time.Time) {
+	eip, ok := l.match(flow)
+	if !ok {
+		return
+	}
+	if !flow.Destroy {
+		if flow.Start.IsZero() && l.sampled(flow) {
+			l.remember(flow.ID, now)
+		}
+		return
+	}
+
+	var start time.Time
+	elem, seen := l.starts[flow.ID]
+	if seen {
+		start = elem.Value.(*pendingFlow).start
+		l.forget(elem)
+	}
+	if !l.sampled(flow) {
+		countRecords.WithLabelValues("sampled_out").Inc()
+		return
+	}
+	if l.limiter != nil && !l.limiter.Allow() {
+		countRecords.WithLabelValues("rate_limited").Inc()
+		return
+	}
+
+	record := Record{
+		Start:        flow.Start,
+		End:          flow.Stop,
+		Protocol:     protocolName(flow.Orig.Protocol),
+		Src:          flow.Orig.Src.String(),
+		SrcPort:      flow.Orig.SrcPort,
+		Dst:          flow.Orig.Dst.String(),
+		DstPort:      flow.Orig.DstPort,
+		Packets:      flow.Orig.Packets,
+		Bytes:        flow.Orig.Bytes,
+		ReplyPackets: flow.Reply.Packets,
+		ReplyBytes:   flow.Reply.Bytes,
+		Node:         l.cfg.Node,
+	}
+	if record.Start.IsZero() && seen {
+		record.Start = start
+	}
+	if record.End.IsZero() {
+		record.End = now
+	}
+	if eip != nil {
+		record.EIP = eip.String()
+	}
+	if ep, ok := l.resolver.Endpoint(flow.Orig.Src); ok {
+		record.Pod = ep.Pod
+		record.Namespace = ep.Namespace
+		record.Policy = ep.Policy.Name
+		record.PolicyNamespace = ep.Policy.Namespace
+	}
+	if err := l.enc.Encode(record); err != nil {
+		countRecords.WithLabelValues("error").Inc()
+		l.log.Error(err, "failed to write the flow record")
+		return
+	}
+	countRecords.WithLabelValues("emitted").Inc()
+}
+
+// remember records the start time of a flow, the start times older than pendingTTL
+// are forgotten, and the oldest one when maxPending is reached.
+func (l *Logger) remember(id uint32, now time.Time) {
+	if _, ok := l.starts[id]; ok {
+		return
+	}
+	if now.Sub(l.lastSweep) >= pendingSweep {
+		l.lastSweep = now
+		for elem := l.pending.Front(); elem != nil && now.Sub(elem.Value.(*pendingFlow).start) >= pendingTTL; elem = l.pending.Front() {
+			l.forget(elem)
+			countPendingEvictions.WithLabelValues("expired").Inc()
+		}
+	}
+	if len(l.starts) >= maxPending {
+		l.forget(l.pending.Front())
+		countPendingEvictions.WithLabelValues("overflow").Inc()
+	}
+	l.starts[id] = l.pending.PushBack(&pendingFlow{id: id, start: now})
+}
+
+func (l *Logger) forget(elem *list.Element) {
+	delete(l.starts, elem.Value.(*pendingFlow).id)
+	l.pending.Remove(elem)
+}
+
+func protocolName(proto uint8) string {
+	if name, ok := nl.L4ProtoMap[proto]; ok {
+		return name
+	}
+	return "unknown"
+}
@@ -0,0 +1,299 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package flowlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+)
+
+func be16(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+func be32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func be64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func tupleAttr(typ int, src, dst string, sport, dport uint16) *nl.RtAttr {
+	tuple := nl.NewRtAttr(typ|unix.NLA_F_NESTED, nil)
+	ip := tuple.AddRtAttr(nl.CTA_TUPLE_IP|unix.NLA_F_NESTED, nil)
+	ip.AddRtAttr(nl.CTA_IP_V4_SRC, net.ParseIP(src).To4())
+	ip.AddRtAttr(nl.CTA_IP_V4_DST, net.ParseIP(dst).To4())
+	proto := tuple.AddRtAttr(nl.CTA_TUPLE_PROTO|unix.NLA_F_NESTED, nil)
+	proto.AddRtAttr(nl.CTA_PROTO_NUM, []byte{unix.IPPROTO_TCP})
+	proto.AddRtAttr(nl.CTA_PROTO_SRC_PORT, be16(sport))
+	proto.AddRtAttr(nl.CTA_PROTO_DST_PORT, be16(dport))
+	return tuple
+}
+
+func countersAttr(typ int, packets, bytes uint64) *nl.RtAttr {
+	counters := nl.NewRtAttr(typ|unix.NLA_F_NESTED, nil)
+	counters.AddRtAttr(nl.CTA_COUNTERS_PACKETS, be64(packets))
+	counters.AddRtAttr(nl.CTA_COUNTERS_BYTES, be64(bytes))
+	return counters
+}
+
+// buildMessage encodes a ctnetlink event of a TCP flow from 10.6.1.21:40000 to
+// 1.1.1.1:443, SNATed to 10.6.1.100
+func buildMessage(destroy bool) (uint16, []byte) {
+	data := []byte{unix.AF_INET, 0, 0, 0}
+	ts := nl.NewRtAttr(nl.CTA_TIMESTAMP|unix.NLA_F_NESTED, nil)
+	ts.AddRtAttr(nl.CTA_TIMESTAMP_START, be64(uint64(time.Unix(100, 0).UnixNano())))
+	if destroy {
+		ts.AddRtAttr(nl.CTA_TIMESTAMP_STOP, be64(uint64(time.Unix(160, 0).UnixNano())))
+	}
+	for _, a := range []*nl.RtAttr{
+		tupleAttr(nl.CTA_TUPLE_ORIG, "10.6.1.21", "1.1.1.1", 40000, 443),
+		tupleAttr(nl.CTA_TUPLE_REPLY, "1.1.1.1", "10.6.1.100", 443, 40000),
+		nl.NewRtAttr(nl.CTA_MARK, be32(0x11000000)),
+		nl.NewRtAttr(nl.CTA_ID, be32(7)),
+		countersAttr(nl.CTA_COUNTERS_ORIG, 10, 1000),
+		countersAttr(nl.CTA_COUNTERS_REPLY, 8, 4000),
+		ts,
+	} {
+		data = append(data, a.Serialize()...)
+	}
+	msgType := uint16(unix.NFNL_SUBSYS_CTNETLINK<<8) | msgNew
+	if destroy {
+		msgType = uint16(unix.NFNL_SUBSYS_CTNETLINK<<8) | msgDelete
+	}
+	return msgType, data
+}
+
+func TestParseFlow(t *testing.T) {
+	flow, err := parseFlow(buildMessage(true))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.True(t, flow.Destroy)
+	assert.Equal(t, uint32(7), flow.ID)
+	assert.Equal(t, uint32(0x11000000), flow.Mark)
+	assert.Equal(t, "10.6.1.21", flow.Orig.Src.String())
+	assert.Equal(t, "1.1.1.1", flow.Orig.Dst.String())
+	assert.Equal(t, uint16(40000), flow.Orig.SrcPort)
+	assert.Equal(t, uint16(443), flow.Orig.DstPort)
+	assert.Equal(t, uint8(unix.IPPROTO_TCP), flow.Orig.Protocol)
+	assert.Equal(t, "10.6.1.100", flow.Reply.Dst.String())
+	assert.Equal(t, uint64(10), flow.Orig.Packets)
+	assert.Equal(t, uint64(4000), flow.Reply.Bytes)
+	assert.Equal(t, time.Unix(100, 0), flow.Start)
+	assert.Equal(t, time.Unix(160, 0), flow.Stop)
+
+	flow, err = parseFlow(buildMessage(false))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.False(t, flow.Destroy)
+
+	_, err = parseFlow(uint16(unix.NFNL_SUBSYS_CTNETLINK<<8), []byte{1, 2})
+	assert.Error(t, err)
+	_, err = parseFlow(uint16(unix.NFNL_SUBSYS_CTNETLINK<<8), []byte{0, 0, 0, 0, 0xff, 0, 1, 0})
+	assert.Error(t, err)
+}
+
+type fakeResolver struct {
+	endpoints map[string]Endpoint
+	eips      map[string]bool
+}
+
+func (r *fakeResolver) Endpoint(ip net.IP) (Endpoint, bool) {
+	ep, ok := r.endpoints[ip.String()]
+	return ep, ok
+}
+
+func (r *fakeResolver) IsEIP(ip net.IP) bool { return r.eips[ip.String()] }
+
+func newFakeResolver() *fakeResolver {
+	return &fakeResolver{
+		endpoints: map[string]Endpoint{
+			"10.6.1.21": {Pod: "pod1", Namespace: "default", Policy: egressv1.Policy{Name: "policy1", Namespace: "default"}},
+		},
+		eips: map[string]bool{"10.6.1.100": true},
+	}
+}
+
+func decodeRecords(t *testing.T, buf *bytes.Buffer) []Record {
+	var records []Record
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record Record
+		assert.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	return records
+}
+
+func evictions(reason string) float64 {
+	m := new(dto.Metric)
+	_ = countPendingEvictions.WithLabelValues(reason).Write(m)
+	return m.GetCounter().GetValue()
+}
+
+func TestLogger(t *testing.T) {
+	log := logger.NewLogger(logger.Config{})
+	now := time.Unix(200, 0)
+
+	t.Run("emit the SNATed flow", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		l := New(log, Config{Node: "node1", SampleRate: 1}, newFakeResolver(), buf)
+		flow, err := parseFlow(buildMessage(true))
+		if !assert.NoError(t, err) {
+			return
+		}
+		l.Handle(flow, now)
+
+		records := decodeRecords(t, buf)
+		if !assert.Len(t, records, 1) {
+			return
+		}
+		record := records[0]
+		assert.Equal(t, "tcp", record.Protocol)
+		assert.Equal(t, "10.6.1.100", record.EIP)
+		assert.Equal(t, "pod1", record.Pod)
+		assert.Equal(t, "default", record.Namespace)
+		assert.Equal(t, "policy1", record.Policy)
+		assert.Equal(t, "node1", record.Node)
+		assert.Equal(t, uint64(1000), record.Bytes)
+		assert.Equal(t, uint64(8), record.ReplyPackets)
+		assert.True(t, record.Start.Equal(time.Unix(100, 0)))
+		assert.True(t, record.End.Equal(time.Unix(160, 0)))
+	})
+
+	t.Run("ignore the flows of other nodes", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		resolver := newFakeResolver()
+		resolver.eips = nil
+		l := New(log, Config{SampleRate: 1}, resolver, buf)
+		flow, err := parseFlow(buildMessage(true))
+		if !assert.NoError(t, err) {
+			return
+		}
+		l.Handle(flow, now)
+		assert.Empty(t, buf.String())
+
+		// the flow is selected by its mark
+		l = New(log, Config{SampleRate: 1, Mark: 0x11000000, MarkMask: 0xffffffff}, resolver, buf)
+		l.Handle(flow, now)
+		assert.Len(t, decodeRecords(t, buf), 1)
+	})
+
+	t.Run("remember the start without kernel timestamps", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		l := New(log, Config{SampleRate: 1}, newFakeResolver(), buf)
+		flow, err := parseFlow(buildMessage(false))
+		if !assert.NoError(t, err) {
+			return
+		}
+		flow.Start = time.Time{}
+		l.Handle(flow, time.Unix(150, 0))
+		assert.Empty(t, buf.String())
+
+		flow, err = parseFlow(buildMessage(true))
+		if !assert.NoError(t, err) {
+			return
+		}
+		flow.Start, flow.Stop = time.Time{}, time.Time{}
+		l.Handle(flow, now)
+		records := decodeRecords(t, buf)
+		if !assert.Len(t, records, 1) {
+			return
+		}
+		assert.True(t, records[0].Start.Equal(time.Unix(150, 0)))
+		assert.True(t, records[0].End.Equal(now))
+		assert.Empty(t, l.starts)
+	})
+
+	t.Run("forget the start of the flows whose end is lost", func(t *testing.T) {
+		l := New(log, Config{SampleRate: 1}, newFakeResolver(), new(bytes.Buffer))
+		flow, err := parseFlow(buildMessage(false))
+		if !assert.NoError(t, err) {
+			return
+		}
+		flow.Start = time.Time{}
+		expired, overflow := evictions("expired"), evictions("overflow")
+
+		start := time.Unix(1000, 0)
+		for id := uint32(0); id < maxPending; id++ {
+			f := *flow
+			f.ID = id
+			l.Handle(&f, start.Add(time.Duration(id)*time.Millisecond))
+		}
+		assert.Len(t, l.starts, maxPending)
+
+		// the oldest flow is forgotten when the limit is reached
+		f := *flow
+		f.ID = maxPending
+		l.Handle(&f, start.Add(time.Hour))
+		assert.Len(t, l.starts, maxPending)
+		assert.NotContains(t, l.starts, uint32(0))
+		assert.Equal(t, overflow+1, evictions("overflow"))
+
+		// the flows older than pendingTTL are forgotten
+		f.ID = maxPending + 1
+		l.Handle(&f, start.Add(pendingTTL+30*time.Minute))
+		assert.Len(t, l.starts, 2)
+		assert.Equal(t, l.pending.Len(), len(l.starts))
+		assert.Equal(t, expired+maxPending-1, evictions("expired"))
+	})
+
+	t.Run("sample the flows", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		l := New(log, Config{SampleRate: 4}, newFakeResolver(), buf)
+		flow, err := parseFlow(buildMessage(true))
+		if !assert.NoError(t, err) {
+			return
+		}
+		for port := uint16(1); port <= 400; port++ {
+			f := *flow
+			f.Orig.SrcPort = port
+			l.Handle(&f, now)
+		}
+		n := len(decodeRecords(t, buf))
+		assert.Greater(t, n, 50)
+		assert.Less(t, n, 150)
+
+		// the decision is stable for a flow
+		assert.Equal(t, l.sampled(flow), l.sampled(flow))
+	})
+
+	t.Run("limit the rate of the records", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		l := New(log, Config{SampleRate: 1, RateLimit: 1, Burst: 3}, newFakeResolver(), buf)
+		flow, err := parseFlow(buildMessage(true))
+		if !assert.NoError(t, err) {
+			return
+		}
+		for i := 0; i < 10; i++ {
+			l.Handle(flow, now)
+		}
+		assert.Len(t, decodeRecords(t, buf), 3)
+	})
+}
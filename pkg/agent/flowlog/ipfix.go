@@ -0,0 +1,527 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package flowlog
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+
+	"github.com/spidernet-io/egressgateway/pkg/lock"
+)
+
+// The IPFIX message layout, see RFC 7011
+const (
+	ipfixVersion       = 10
+	ipfixHeaderLen     = 16
+	ipfixSetTemplate   = 2
+	ipfixVariableLen   = 65535
+	ipfixEnterpriseBit = 0x8000
+	// maxMessageSize keeps the messages in a datagram of a 1500 bytes MTU
+	maxMessageSize = 1400
+
+	templateIPv4 = 256
+	templateIPv6 = 257
+)
+
+// The IANA information elements, see https://www.iana.org/assignments/ipfix
+const (
+	ieOctetDeltaCount          = 1
+	iePacketDeltaCount         = 2
+	ieProtocolIdentifier       = 4
+	ieSourceTransportPort      = 7
+	ieSourceIPv4Address        = 8
+	ieDestinationTransportPort = 11
+	ieDestinationIPv4Address   = 12
+	ieSourceIPv6Address        = 27
+	ieDestinationIPv6Address   = 28
+	ieFlowEndReason            = 136
+	ieFlowStartMilliseconds    = 152
+	ieFlowEndMilliseconds      = 153
+	iePostNATSourceIPv4Address = 225
+	iePostNATSourceIPv6Address = 281
+)
+
+// reverseEnterprise is the enterprise number of the reverse information elements of
+// RFC 5103, the reverse counters count the direction to the pod
+const reverseEnterprise = 29305
+
+// The information elements of egressgateway, under the configured enterprise number
+const (
+	// ieEgressPolicy is the namespace/name of an EgressPolicy or the name of an EgressClusterPolicy
+	ieEgressPolicy = 1
+	iePodName      = 2
+	iePodNamespace = 3
+	ieGatewayNode  = 4
+)
+
+// The flowEndReason values
+const (
+	endIdleTimeout   = 1
+	endActiveTimeout = 2
+	endOfFlow        = 3
+	endForced        = 4
+)
+
+var countExports = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "egress_flow_ipfix_records_total",
+	Help: "Number of the IPFIX records of the egress flows, by whether they were exported",
+}, []string{"result"})
+
+type IPFIXConfig struct {
+	// Collector is the host:port of the UDP collector
+	Collector string
+	// ActiveTimeout exports the counters of a long-lived flow periodically
+	ActiveTimeout time.Duration
+	// IdleTimeout ends a flow which has no packet for the duration
+	IdleTimeout time.Duration
+	// TemplateRefresh resends the templates, a restarted collector learns them again
+	TemplateRefresh time.Duration
+	// ObservationDomainID is set in the header of the messages
+	ObservationDomainID uint32
+	// EnterpriseNumber is the private enterprise number of the egressgateway information elements
+	EnterpriseNumber uint32
+}
+
+type ieSpec struct {
+	id         uint16
+	length     uint16
+	enterprise uint32
+}
+
+// cachedFlow is a flow in the cache of the exporter, exported holds the counters
+// already exported so the records carry the delta counters.
+type cachedFlow struct {
+	flow       *Flow
+	start      time.Time
+	lastActive time.Time
+	lastExport time.Time
+	idle       bool
+	// missing is the number of dumps the flow was not found in, its end event was lost
+	missing  int
+	exported [4]uint64
+}
+
+func counters(flow *Flow) [4]uint64 {
+	return [4]uint64{flow.Orig.Packets, flow.Orig.Bytes, flow.Reply.Packets, flow.Reply.Bytes}
+}
+
+type ipfixRecord struct {
+	flow   *Flow
+	start  time.Time
+	end    time.Time
+	reason uint8
+	delta  [4]uint64
+}
+
+// record returns the record of the counters of cur since the last export.
+func (c *cachedFlow) record(cur *Flow, end time.Time, reason uint8) ipfixRecord {
+	r := ipfixRecord{flow: cur, start: c.start, end: end, reason: reason}
+	now := counters(cur)
+	for i := range now {
+		if now[i] >= c.exported[i] {
+			r.delta[i] = now[i] - c.exported[i]
+		}
+	}
+	c.exported = now
+	return r
+}
+
+// Exporter exports the egress flows of the node to an IPFIX collector.
+type Exporter struct {
+	lock.Mutex
+	filter
+	log     logr.Logger
+	ipfix   IPFIXConfig
+	limiter *rate.Limiter
+	conn    net.Conn
+	// dump lists the conntrack entries, the counters of the cached flows are
+	// refreshed from it for the active and idle timeouts
+	dump func() ([]*Flow, error)
+
+	flows        map[uint32]*cachedFlow
+	templates    []byte
+	templateSent time.Time
+	// sequence is the number of the data records exported
+	sequence uint32
+}
+
+func NewExporter(log logr.Logger, cfg Config, ipfix IPFIXConfig, resolver Resolver) (*Exporter, error) {
+	conn, err := net.Dial("udp", ipfix.Collector)
+	if err != nil {
+		return nil, err
+	}
+	e := &Exporter{
+		filter: filter{cfg: cfg, resolver: resolver},
+		log:    log,
+		ipfix:  ipfix,
+		conn:   conn,
+		dump:   dumpFlows,
+		flows:  make(map[uint32]*cachedFlow),
+	}
+	if cfg.RateLimit > 0 {
+		burst := cfg.Burst
+		if burst < 1 {
+			burst = cfg.RateLimit
+		}
+		e.limiter = rate.NewLimiter(rate.Limit(cfg.RateLimit), burst)
+	}
+	e.templates = append(e.templates, encodeTemplate(templateIPv4, e.fields(false))...)
+	e.templates = append(e.templates, encodeTemplate(templateIPv6, e.fields(true))...)
+	return e, nil
+}
+
+func (e *Exporter) fields(ipv6 bool) []ieSpec {
+	src, dst, postNAT := uint16(ieSourceIPv4Address), uint16(ieDestinationIPv4Address), uint16(iePostNATSourceIPv4Address)
+	ipLen := uint16(net.IPv4len)
+	if ipv6 {
+		src, dst, postNAT = ieSourceIPv6Address, ieDestinationIPv6Address, iePostNATSourceIPv6Address
+		ipLen = net.IPv6len
+	}
+	return []ieSpec{
+		{id: ieFlowStartMilliseconds, length: 8},
+		{id: ieFlowEndMilliseconds, length: 8},
+		{id: src, length: ipLen},
+		{id: dst, length: ipLen},
+		{id: ieSourceTransportPort, length: 2},
+		{id: ieDestinationTransportPort, length: 2},
+		{id: ieProtocolIdentifier, length: 1},
+		{id: postNAT, length: ipLen},
+		{id: iePacketDeltaCount, length: 8},
+		{id: ieOctetDeltaCount, length: 8},
+		{id: iePacketDeltaCount, length: 8, enterprise: reverseEnterprise},
+		{id: ieOctetDeltaCount, length: 8, enterprise: reverseEnterprise},
+		{id: ieFlowEndReason, length: 1},
+		{id: ieEgressPolicy, length: ipfixVariableLen, enterprise: e.ipfix.EnterpriseNumber},
+		{id: iePodName, length: ipfixVariableLen, enterprise: e.ipfix.EnterpriseNumber},
+		{id: iePodNamespace, length: ipfixVariableLen, enterprise: e.ipfix.EnterpriseNumber},
+		{id: ieGatewayNode, length: ipfixVariableLen, enterprise: e.ipfix.EnterpriseNumber},
+	}
+}
+
+func encodeTemplate(id uint16, fields []ieSpec) []byte {
+	b := binary.BigEndian.AppendUint16(nil, id)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(fields)))
+	for _, f := range fields {
+		if f.enterprise != 0 {
+			b = binary.BigEndian.AppendUint16(b, f.id|ipfixEnterpriseBit)
+			b = binary.BigEndian.AppendUint16(b, f.length)
+			b = binary.BigEndian.AppendUint32(b, f.enterprise)
+			continue
+		}
+		b = binary.BigEndian.AppendUint16(b, f.id)
+		b = binary.BigEndian.AppendUint16(b, f.length)
+	}
+	return b
+}
+
+// encodeRecord returns the template ID and the data record of r, in the order of fields.
+func (e *Exporter) encodeRecord(r ipfixRecord) (uint16, []byte) {
+	ipv6 := r.flow.Orig.Src.To4() == nil
+	id := uint16(templateIPv4)
+	if ipv6 {
+		id = templateIPv6
+	}
+	appendIP := func(b []byte, ip net.IP) []byte {
+		if ipv6 {
+			if ip = ip.To16(); ip == nil {
+				ip = net.IPv6zero
+			}
+			return append(b, ip...)
+		}
+		if ip = ip.To4(); ip == nil {
+			ip = net.IPv4zero.To4()
+		}
+		return append(b, ip...)
+	}
+	appendString := func(b []byte, s string) []byte {
+		if len(s) > 65535 {
+			s = s[:65535]
+		}
+		if len(s) < 255 {
+			b = append(b, uint8(len(s)))
+		} else {
+			b = append(b, 255)
+			b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+		}
+		return append(b, s...)
+	}
+
+	var ep Endpoint
+	if item, ok := e.resolver.Endpoint(r.flow.Orig.Src); ok {
+		ep = item
+	}
+	policy := ep.Policy.Name
+	if policy != "" && ep.Policy.Namespace != "" {
+		policy = ep.Policy.Namespace + "/" + policy
+	}
+
+	b := make([]byte, 0, 128)
+	b = binary.BigEndian.AppendUint64(b, uint64(r.start.UnixMilli()))
+	b = binary.BigEndian.AppendUint64(b, uint64(r.end.UnixMilli()))
+	b = appendIP(b, r.flow.Orig.Src)
+	b = appendIP(b, r.flow.Orig.Dst)
+	b = binary.BigEndian.AppendUint16(b, r.flow.Orig.SrcPort)
+	b = binary.BigEndian.AppendUint16(b, r.flow.Orig.DstPort)
+	b = append(b, r.flow.Orig.Protocol)
+	// the reply of the flow is sent to the translated source
+	b = appendIP(b, r.flow.Reply.Dst)
+	for _, v := range r.delta {
+		b = binary.BigEndian.AppendUint64(b, v)
+	}
+	b = append(b, r.reason)
+	b = appendString(b, policy)
+	b = appendString(b, ep.Pod)
+	b = appendString(b, ep.Namespace)
+	b = appendString(b, e.cfg.Node)
+	return id, b
+}
+
+// Handle caches the new flows and exports the flows which end.
+func (e *Exporter) Handle(flow *Flow, now time.Time) {
+	if _, ok := e.match(flow); !ok {
+		return
+	}
+	if !e.sampled(flow) {
+		if flow.Destroy {
+			countExports.WithLabelValues("sampled_out").Inc()
+		}
+		return
+	}
+
+	e.Lock()
+	defer e.Unlock()
+
+	c, ok := e.flows[flow.ID]
+	if !flow.Destroy {
+		if !ok && len(e.flows) < maxPending {
+			start := flow.Start
+			if start.IsZero() {
+				start = now
+			}
+			e.flows[flow.ID] = &cachedFlow{flow: flow, start: start, lastActive: now, lastExport: now}
+		}
+		return
+	}
+
+	delete(e.flows, flow.ID)
+	if !ok {
+		// the flow started before the agent or was not cached
+		c = &cachedFlow{start: flow.Start}
+		if c.start.IsZero() {
+			c.start = now
+		}
+	}
+	if c.idle && counters(flow) == c.exported {
+		// the end of the flow was exported on its idle timeout
+		return
+	}
+	end := flow.Stop
+	if end.IsZero() {
+		end = now
+	}
+	e.export(now, []ipfixRecord{c.record(flow, end, endOfFlow)})
+}
+
+// expire refreshes the counters of the cached flows and exports the flows which
+// reached their active or idle timeout.
+func (e *Exporter) expire(now time.Time) {
+	e.Lock()
+	empty := len(e.flows) == 0
+	e.Unlock()
+	if empty {
+		return
+	}
+
+	flows, err := e.dump()
+	if err != nil {
+		e.log.Error(err, "failed to list the conntrack entries")
+		return
+	}
+	current := make(map[uint32]*Flow, len(flows))
+	for _, flow := range flows {
+		current[flow.ID] = flow
+	}
+
+	e.Lock()
+	defer e.Unlock()
+
+	var records []ipfixRecord
+	for id, c := range e.flows {
+		cur, ok := current[id]
+		if !ok {
+			// the end event is lost when the flow is missing from two dumps, the
+			// first one may race with the end event
+			if c.missing++; c.missing >= 2 {
+				delete(e.flows, id)
+				if !c.idle {
+					records = append(records, c.record(c.flow, now, endForced))
+				}
+			}
+			continue
+		}
+		c.missing = 0
+		if counters(cur) != counters(c.flow) {
+			c.lastActive = now
+			if c.idle {
+				// the flow resumed after its idle timeout, it is exported as a new flow
+				c.idle = false
+				c.start = now
+				c.lastExport = now
+			}
+		}
+		c.flow = cur
+		if c.idle {
+			continue
+		}
+
+		switch {
+		case now.Sub(c.lastActive) >= e.ipfix.IdleTimeout:
+			records = append(records, c.record(cur, c.lastActive, endIdleTimeout))
+			c.idle = true
+		case now.Sub(c.lastExport) >= e.ipfix.ActiveTimeout:
+			records = append(records, c.record(cur, now, endActiveTimeout))
+			c.lastExport = now
+		}
+	}
+	e.export(now, records)
+}
+
+// export sends the records to the collector, the templates are sent first when
+// they are due.
+func (e *Exporter) export(now time.Time, records []ipfixRecord) {
+	withTemplates := e.templateSent.IsZero() || now.Sub(e.templateSent) >= e.ipfix.TemplateRefresh
+	if len(records) == 0 && !withTemplates {
+		return
+	}
+
+	msg := e.newMessage(now)
+	if withTemplates {
+		msg.add(ipfixSetTemplate, e.templates, false)
+	}
+	for _, r := range records {
+		if e.limiter != nil && !e.limiter.Allow() {
+			countExports.WithLabelValues("rate_limited").Inc()
+			continue
+		}
+		id, data := e.encodeRecord(r)
+		if !msg.add(id, data, true) {
+			e.send(msg)
+			msg = e.newMessage(now)
+			msg.add(id, data, true)
+		}
+	}
+	if msg.records > 0 || withTemplates {
+		e.send(msg)
+	}
+	if withTemplates {
+		e.templateSent = now
+	}
+}
+
+func (e *Exporter) newMessage(now time.Time) *message {
+	b := make([]byte, ipfixHeaderLen, maxMessageSize)
+	binary.BigEndian.PutUint16(b[0:2], ipfixVersion)
+	binary.BigEndian.PutUint32(b[4:8], uint32(now.Unix()))
+	binary.BigEndian.PutUint32(b[8:12], e.sequence)
+	binary.BigEndian.PutUint32(b[12:16], e.ipfix.ObservationDomainID)
+	return &message{buf: b, setStart: -1}
+}
+
+func (e *Exporter) send(msg *message) {
+	_, err := e.conn.Write(msg.finish())
+	if err != nil {
+		countExports.WithLabelValues("error").Add(float64(msg.records))
+		e.log.Error(err, "failed to send the IPFIX message", "collector", e.ipfix.Collector)
+		return
+	}
+	// the sequence counts the data records sent, the lost records are detected by the collector
+	e.sequence += msg.records
+	countExports.WithLabelValues("exported").Add(float64(msg.records))
+}
+
+// message is an IPFIX message being built.
+type message struct {
+	buf      []byte
+	setStart int
+	setID    uint16
+	records  uint32
+}
+
+// add appends a record to the set of id, it returns false if the message is full.
+func (m *message) add(id uint16, data []byte, isData bool) bool {
+	size := len(data)
+	if m.setStart < 0 || m.setID != id {
+		size += 4
+	}
+	if len(m.buf)+size > maxMessageSize && len(m.buf) > ipfixHeaderLen {
+		return false
+	}
+	if m.setStart < 0 || m.setID != id {
+		m.closeSet()
+		m.setStart, m.setID = len(m.buf), id
+		m.buf = append(m.buf, 0, 0, 0, 0)
+	}
+	m.buf = append(m.buf, data...)
+	if isData {
+		m.records++
+	}
+	return true
+}
+
+func (m *message) closeSet() {
+	if m.setStart < 0 {
+		return
+	}
+	binary.BigEndian.PutUint16(m.buf[m.setStart:], m.setID)
+	binary.BigEndian.PutUint16(m.buf[m.setStart+2:], uint16(len(m.buf)-m.setStart))
+	m.setStart = -1
+}
+
+func (m *message) finish() []byte {
+	m.closeSet()
+	binary.BigEndian.PutUint16(m.buf[2:4], uint16(len(m.buf)))
+	return m.buf
+}
+
+// Start expires the cached flows until ctx is done, the flows still cached are
+// then exported as forced ends.
+func (e *Exporter) Start(ctx context.Context) error {
+	period := e.ipfix.IdleTimeout
+	if e.ipfix.ActiveTimeout < period {
+		period = e.ipfix.ActiveTimeout
+	}
+	if period /= 2; period < time.Second {
+		period = time.Second
+	}
+
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			e.Lock()
+			now := time.Now()
+			records := make([]ipfixRecord, 0, len(e.flows))
+			for id, c := range e.flows {
+				if !c.idle {
+					records = append(records, c.record(c.flow, now, endForced))
+				}
+				delete(e.flows, id)
+			}
+			e.export(now, records)
+			e.Unlock()
+			return e.conn.Close()
+		case now := <-ticker.C:
+			e.expire(now)
+		}
+	}
+}
+
+func (e *Exporter) NeedLeaderElection() bool { return false }
@@ -0,0 +1,180 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package flowlog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/flowlog/ipfixtest"
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+)
+
+const testEnterprise = 99999
+
+var (
+	fieldStart   = ipfixtest.Field{ID: ieFlowStartMilliseconds}
+	fieldEnd     = ipfixtest.Field{ID: ieFlowEndMilliseconds}
+	fieldSrc     = ipfixtest.Field{ID: ieSourceIPv4Address}
+	fieldDst     = ipfixtest.Field{ID: ieDestinationIPv4Address}
+	fieldDstPort = ipfixtest.Field{ID: ieDestinationTransportPort}
+	fieldProto   = ipfixtest.Field{ID: ieProtocolIdentifier}
+	fieldPostNAT = ipfixtest.Field{ID: iePostNATSourceIPv4Address}
+	fieldPackets = ipfixtest.Field{ID: iePacketDeltaCount}
+	fieldBytes   = ipfixtest.Field{ID: ieOctetDeltaCount}
+	fieldRBytes  = ipfixtest.Field{ID: ieOctetDeltaCount, Enterprise: reverseEnterprise}
+	fieldReason  = ipfixtest.Field{ID: ieFlowEndReason}
+	fieldPolicy  = ipfixtest.Field{ID: ieEgressPolicy, Enterprise: testEnterprise}
+	fieldPod     = ipfixtest.Field{ID: iePodName, Enterprise: testEnterprise}
+	fieldNode    = ipfixtest.Field{ID: ieGatewayNode, Enterprise: testEnterprise}
+)
+
+func newTestExporter(t *testing.T) (*Exporter, *ipfixtest.Collector) {
+	collector, err := ipfixtest.NewCollector()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { _ = collector.Close() })
+
+	e, err := NewExporter(logger.NewLogger(logger.Config{}), Config{Node: "node1", SampleRate: 1}, IPFIXConfig{
+		Collector:           collector.Addr(),
+		ActiveTimeout:       60 * time.Second,
+		IdleTimeout:         15 * time.Second,
+		TemplateRefresh:     600 * time.Second,
+		ObservationDomainID: 7,
+		EnterpriseNumber:    testEnterprise,
+	}, newFakeResolver())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { _ = e.conn.Close() })
+	return e, collector
+}
+
+func testFlow(t *testing.T, destroy bool, packets, bytes uint64) *Flow {
+	flow, err := parseFlow(buildMessage(destroy))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	flow.Orig.Packets, flow.Orig.Bytes = packets, bytes
+	flow.Reply.Packets, flow.Reply.Bytes = packets, bytes*4
+	return flow
+}
+
+func TestExporter(t *testing.T) {
+	t0 := time.Unix(100, 0)
+
+	t.Run("export the end of a flow", func(t *testing.T) {
+		e, collector := newTestExporter(t)
+		e.Handle(testFlow(t, false, 1, 60), t0)
+		e.Handle(testFlow(t, true, 10, 1000), t0.Add(time.Minute))
+
+		records, err := collector.Receive(1, time.Second)
+		if !assert.NoError(t, err) || !assert.Len(t, records, 1) {
+			return
+		}
+		r := records[0]
+		assert.Equal(t, uint32(7), r.ObservationDomainID)
+		assert.Equal(t, uint16(templateIPv4), r.TemplateID)
+		assert.Equal(t, uint64(time.Unix(100, 0).UnixMilli()), r.Uint(fieldStart))
+		assert.Equal(t, uint64(time.Unix(160, 0).UnixMilli()), r.Uint(fieldEnd))
+		assert.Equal(t, "10.6.1.21", r.IP(fieldSrc).String())
+		assert.Equal(t, "1.1.1.1", r.IP(fieldDst).String())
+		assert.Equal(t, uint64(443), r.Uint(fieldDstPort))
+		assert.Equal(t, uint64(6), r.Uint(fieldProto))
+		assert.Equal(t, "10.6.1.100", r.IP(fieldPostNAT).String())
+		assert.Equal(t, uint64(10), r.Uint(fieldPackets))
+		assert.Equal(t, uint64(1000), r.Uint(fieldBytes))
+		assert.Equal(t, uint64(4000), r.Uint(fieldRBytes))
+		assert.Equal(t, uint64(endOfFlow), r.Uint(fieldReason))
+		assert.Equal(t, "default/policy1", r.String(fieldPolicy))
+		assert.Equal(t, "pod1", r.String(fieldPod))
+		assert.Equal(t, "node1", r.String(fieldNode))
+	})
+
+	t.Run("export the delta counters on the active timeout", func(t *testing.T) {
+		e, collector := newTestExporter(t)
+		var dumped []*Flow
+		e.dump = func() ([]*Flow, error) { return dumped, nil }
+
+		e.Handle(testFlow(t, false, 1, 60), t0)
+		dumped = []*Flow{testFlow(t, false, 10, 1000)}
+		e.expire(t0.Add(61 * time.Second))
+		dumped = []*Flow{testFlow(t, false, 15, 1500)}
+		e.expire(t0.Add(122 * time.Second))
+		e.Handle(testFlow(t, true, 20, 2000), t0.Add(130*time.Second))
+
+		records, err := collector.Receive(3, time.Second)
+		if !assert.NoError(t, err) || !assert.Len(t, records, 3) {
+			return
+		}
+		for i, item := range []struct {
+			reason  uint64
+			packets uint64
+			bytes   uint64
+		}{
+			{endActiveTimeout, 10, 1000},
+			{endActiveTimeout, 5, 500},
+			{endOfFlow, 5, 500},
+		} {
+			assert.Equal(t, item.reason, records[i].Uint(fieldReason), "record %d", i)
+			assert.Equal(t, item.packets, records[i].Uint(fieldPackets), "record %d", i)
+			assert.Equal(t, item.bytes, records[i].Uint(fieldBytes), "record %d", i)
+			assert.Equal(t, uint64(t0.UnixMilli()), records[i].Uint(fieldStart), "record %d", i)
+		}
+	})
+
+	t.Run("end an idle flow", func(t *testing.T) {
+		e, collector := newTestExporter(t)
+		e.dump = func() ([]*Flow, error) { return []*Flow{testFlow(t, false, 10, 1000)}, nil }
+
+		e.Handle(testFlow(t, false, 1, 60), t0)
+		e.expire(t0.Add(5 * time.Second))
+		e.expire(t0.Add(25 * time.Second))
+		// the end of the flow has no new packet
+		e.Handle(testFlow(t, true, 10, 1000), t0.Add(time.Minute))
+
+		records, err := collector.Receive(2, 200*time.Millisecond)
+		assert.Error(t, err)
+		if !assert.Len(t, records, 1) {
+			return
+		}
+		assert.Equal(t, uint64(endIdleTimeout), records[0].Uint(fieldReason))
+		assert.Equal(t, uint64(t0.Add(5*time.Second).UnixMilli()), records[0].Uint(fieldEnd))
+		assert.Equal(t, uint64(10), records[0].Uint(fieldPackets))
+		assert.Empty(t, e.flows)
+	})
+
+	t.Run("force the end of a flow missing from the dumps", func(t *testing.T) {
+		e, collector := newTestExporter(t)
+		e.dump = func() ([]*Flow, error) { return nil, nil }
+
+		e.Handle(testFlow(t, false, 1, 60), t0)
+		e.expire(t0.Add(time.Second))
+		assert.Len(t, e.flows, 1)
+		e.expire(t0.Add(2 * time.Second))
+		assert.Empty(t, e.flows)
+
+		records, err := collector.Receive(1, time.Second)
+		if !assert.NoError(t, err) || !assert.Len(t, records, 1) {
+			return
+		}
+		assert.Equal(t, uint64(endForced), records[0].Uint(fieldReason))
+		assert.Equal(t, uint64(1), records[0].Uint(fieldPackets))
+	})
+
+	t.Run("ignore the flows of other nodes", func(t *testing.T) {
+		e, collector := newTestExporter(t)
+		e.resolver.(*fakeResolver).eips = nil
+		e.Handle(testFlow(t, false, 1, 60), t0)
+		e.Handle(testFlow(t, true, 10, 1000), t0.Add(time.Minute))
+		assert.Empty(t, e.flows)
+
+		records, err := collector.Receive(1, 200*time.Millisecond)
+		assert.Error(t, err)
+		assert.Empty(t, records)
+	})
+}
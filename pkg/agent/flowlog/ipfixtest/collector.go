@@ -0,0 +1,212 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package ipfixtest is a minimal IPFIX collector over UDP, it decodes the template
+// and data sets of RFC 7011 for the tests of the exporters.
+package ipfixtest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	version       = 10
+	setTemplate   = 2
+	minDataSetID  = 256
+	variableLen   = 65535
+	enterpriseBit = 0x8000
+)
+
+// Field is an information element, Enterprise is 0 for the IANA elements.
+type Field struct {
+	Enterprise uint32
+	ID         uint16
+}
+
+type fieldSpec struct {
+	Field
+	length uint16
+}
+
+// Record is a decoded data record.
+type Record struct {
+	ObservationDomainID uint32
+	TemplateID          uint16
+	Fields              map[Field][]byte
+}
+
+// Uint returns the unsigned integer value of the field.
+func (r Record) Uint(f Field) uint64 {
+	var v uint64
+	for _, b := range r.Fields[f] {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+// IP returns the address value of the field.
+func (r Record) IP(f Field) net.IP {
+	return net.IP(r.Fields[f])
+}
+
+// String returns the string value of the field.
+func (r Record) String(f Field) string {
+	return string(r.Fields[f])
+}
+
+// Collector receives the IPFIX messages on a local UDP port.
+type Collector struct {
+	conn      net.PacketConn
+	templates map[uint32]map[uint16][]fieldSpec
+}
+
+// NewCollector listens on a random port of the loopback address.
+func NewCollector() (*Collector, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return &Collector{conn: conn, templates: make(map[uint32]map[uint16][]fieldSpec)}, nil
+}
+
+// Addr returns the host:port the exporters send to.
+func (c *Collector) Addr() string {
+	return c.conn.LocalAddr().String()
+}
+
+func (c *Collector) Close() error {
+	return c.conn.Close()
+}
+
+// Receive decodes the messages until n data records are received or timeout elapses,
+// the records received so far are returned with the error.
+func (c *Collector) Receive(n int, timeout time.Duration) ([]Record, error) {
+	if err := c.conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	var records []Record
+	buf := make([]byte, 65535)
+	for len(records) < n {
+		length, _, err := c.conn.ReadFrom(buf)
+		if err != nil {
+			return records, err
+		}
+		// the records refer to the datagram, it is not reused
+		items, err := c.decode(append([]byte(nil), buf[:length]...))
+		records = append(records, items...)
+		if err != nil {
+			return records, err
+		}
+	}
+	return records, nil
+}
+
+func (c *Collector) decode(b []byte) ([]Record, error) {
+	if len(b) < 16 {
+		return nil, fmt.Errorf("message too short")
+	}
+	if v := binary.BigEndian.Uint16(b[0:2]); v != version {
+		return nil, fmt.Errorf("unexpected version %d", v)
+	}
+	if length := int(binary.BigEndian.Uint16(b[2:4])); length != len(b) {
+		return nil, fmt.Errorf("message length %d mismatches the datagram length %d", length, len(b))
+	}
+	domain := binary.BigEndian.Uint32(b[12:16])
+	if c.templates[domain] == nil {
+		c.templates[domain] = make(map[uint16][]fieldSpec)
+	}
+
+	var records []Record
+	for b = b[16:]; len(b) > 0; {
+		if len(b) < 4 {
+			return records, fmt.Errorf("set header too short")
+		}
+		id := binary.BigEndian.Uint16(b[0:2])
+		length := int(binary.BigEndian.Uint16(b[2:4]))
+		if length < 4 || length > len(b) {
+			return records, fmt.Errorf("invalid set length %d", length)
+		}
+		body := b[4:length]
+		b = b[length:]
+
+		switch {
+		case id == setTemplate:
+			if err := c.decodeTemplates(domain, body); err != nil {
+				return records, err
+			}
+		case id >= minDataSetID:
+			specs, ok := c.templates[domain][id]
+			if !ok {
+				return records, fmt.Errorf("data set %d received before its template", id)
+			}
+			items, err := decodeData(domain, id, specs, body)
+			records = append(records, items...)
+			if err != nil {
+				return records, err
+			}
+		}
+	}
+	return records, nil
+}
+
+func (c *Collector) decodeTemplates(domain uint32, b []byte) error {
+	for len(b) >= 4 {
+		id := binary.BigEndian.Uint16(b[0:2])
+		count := int(binary.BigEndian.Uint16(b[2:4]))
+		b = b[4:]
+		specs := make([]fieldSpec, 0, count)
+		for i := 0; i < count; i++ {
+			if len(b) < 4 {
+				return fmt.Errorf("template %d too short", id)
+			}
+			spec := fieldSpec{
+				Field:  Field{ID: binary.BigEndian.Uint16(b[0:2])},
+				length: binary.BigEndian.Uint16(b[2:4]),
+			}
+			b = b[4:]
+			if spec.ID&enterpriseBit != 0 {
+				if len(b) < 4 {
+					return fmt.Errorf("template %d too short", id)
+				}
+				spec.ID &^= enterpriseBit
+				spec.Enterprise = binary.BigEndian.Uint32(b[0:4])
+				b = b[4:]
+			}
+			specs = append(specs, spec)
+		}
+		c.templates[domain][id] = specs
+	}
+	return nil
+}
+
+func decodeData(domain uint32, id uint16, specs []fieldSpec, b []byte) ([]Record, error) {
+	var records []Record
+	for len(b) > 0 {
+		record := Record{ObservationDomainID: domain, TemplateID: id, Fields: make(map[Field][]byte)}
+		for _, spec := range specs {
+			length := int(spec.length)
+			if spec.length == variableLen {
+				if len(b) < 1 {
+					return records, fmt.Errorf("record of template %d too short", id)
+				}
+				length, b = int(b[0]), b[1:]
+				if length == 255 {
+					if len(b) < 2 {
+						return records, fmt.Errorf("record of template %d too short", id)
+					}
+					length, b = int(binary.BigEndian.Uint16(b[0:2])), b[2:]
+				}
+			}
+			if len(b) < length {
+				return records, fmt.Errorf("record of template %d too short", id)
+			}
+			record.Fields[spec.Field] = b[:length]
+			b = b[length:]
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
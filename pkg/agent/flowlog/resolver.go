@@ -0,0 +1,111 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package flowlog
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/lock"
+)
+
+// resyncInterval bounds how often the endpoints and EIPs are listed from the cache
+const resyncInterval = 10 * time.Second
+
+// CacheResolver resolves the flows from the EgressEndpointSlices, EgressClusterEndpointSlices
+// and EgressGateways in the cache of the manager.
+type CacheResolver struct {
+	lock.Mutex
+	reader client.Reader
+	log    logr.Logger
+	node   string
+
+	synced    time.Time
+	endpoints map[string]Endpoint
+	eips      map[string]struct{}
+}
+
+func NewCacheResolver(log logr.Logger, reader client.Reader, node string) *CacheResolver {
+	return &CacheResolver{reader: reader, log: log, node: node}
+}
+
+func (r *CacheResolver) Endpoint(ip net.IP) (Endpoint, bool) {
+	r.Lock()
+	defer r.Unlock()
+	r.sync()
+	ep, ok := r.endpoints[ip.String()]
+	return ep, ok
+}
+
+func (r *CacheResolver) IsEIP(ip net.IP) bool {
+	r.Lock()
+	defer r.Unlock()
+	r.sync()
+	_, ok := r.eips[ip.String()]
+	return ok
+}
+
+func (r *CacheResolver) sync() {
+	if time.Since(r.synced) < resyncInterval {
+		return
+	}
+	r.synced = time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), resyncInterval)
+	defer cancel()
+
+	endpoints := make(map[string]Endpoint)
+	add := func(policy egressv1.Policy, items []egressv1.EgressEndpoint) {
+		for _, item := range items {
+			ep := Endpoint{Pod: item.Pod, Namespace: item.Namespace, Policy: policy}
+			for _, ip := range append(append([]string(nil), item.IPv4...), item.IPv6...) {
+				if parsed := net.ParseIP(ip); parsed != nil {
+					endpoints[parsed.String()] = ep
+				}
+			}
+		}
+	}
+
+	slices := new(egressv1.EgressEndpointSliceList)
+	if err := r.reader.List(ctx, slices); err != nil {
+		r.log.Error(err, "failed to list the EgressEndpointSlices of the flow logs")
+		return
+	}
+	for _, item := range slices.Items {
+		policy := egressv1.Policy{Name: item.Labels[egressv1.LabelPolicyName], Namespace: item.Namespace}
+		add(policy, item.Endpoints)
+	}
+	clusterSlices := new(egressv1.EgressClusterEndpointSliceList)
+	if err := r.reader.List(ctx, clusterSlices); err != nil {
+		r.log.Error(err, "failed to list the EgressClusterEndpointSlices of the flow logs")
+		return
+	}
+	for _, item := range clusterSlices.Items {
+		add(egressv1.Policy{Name: item.Labels[egressv1.LabelPolicyName]}, item.Endpoints)
+	}
+
+	gateways := new(egressv1.EgressGatewayList)
+	if err := r.reader.List(ctx, gateways); err != nil {
+		r.log.Error(err, "failed to list the EgressGateways of the flow logs")
+		return
+	}
+	eips := make(map[string]struct{})
+	for _, item := range gateways.Items {
+		for _, eip := range item.Status.GetNodeIPs(r.node) {
+			for _, ip := range []string{eip.IPv4, eip.IPv6} {
+				if parsed := net.ParseIP(ip); parsed != nil {
+					eips[parsed.String()] = struct{}{}
+				}
+			}
+		}
+	}
+
+	r.endpoints = endpoints
+	r.eips = eips
+}
@@ -16,11 +16,15 @@ import (
 	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/spidernet-io/egressgateway/pkg/agent/counter"
 	"github.com/spidernet-io/egressgateway/pkg/config"
 	"github.com/spidernet-io/egressgateway/pkg/ipset"
 	"github.com/spidernet-io/egressgateway/pkg/iptables"
 	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/tracing"
 	"github.com/spidernet-io/egressgateway/pkg/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	apierr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -31,6 +35,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
@@ -54,6 +59,15 @@ type policeReconciler struct {
 	filterTables  []*iptables.Table
 	natTables     []*iptables.Table
 	policyMapNode *utils.SyncMap[egressv1.Policy, string]
+	// statefulChains are the sticky policies whose stateful pods have their SNAT chain
+	// on the node, see statefulChainName
+	statefulChains *utils.SyncMap[egressv1.Policy, struct{}]
+	// counters exports the counters of the per-policy rules
+	counters *counter.Collector
+	// status publishes the results of the programming in the EgressAgentStatus
+	status *datapathStatus
+	// debug serves the desired and the actual datapath
+	debug *datapathDebug
 }
 
 func (r *policeReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
@@ -71,6 +85,20 @@ func (r *policeReconciler) Reconcile(ctx context.Context, req reconcile.Request)
 		return reconcile.Result{}, err
 	}
 	log := r.log.WithValues("kind", kind)
+	if kind == "EgressPolicy" || kind == "EgressClusterPolicy" {
+		// the reconciles of the agents are traced only as part of the change of a policy,
+		// the resyncs would start a trace per policy and node otherwise
+		var span trace.Span
+		ctx, span = tracing.StartChild(r.policyContext(ctx, kind, newReq), "agent reconcile policy",
+			tracing.ObjectAttributes(kind, &metav1.ObjectMeta{Name: newReq.Name, Namespace: newReq.Namespace})...)
+		if span.IsRecording() {
+			log = log.WithValues("traceID", span.SpanContext().TraceID().String())
+		}
+		defer func() {
+			tracing.RecordError(span, err)
+			span.End()
+		}()
+	}
 	var res reconcile.Result
 	switch kind {
 	case "EgressGateway":
@@ -87,10 +115,28 @@ func (r *policeReconciler) Reconcile(ctx context.Context, req reconcile.Request)
 	return res, err
 }
 
+// policyContext returns the context of the reconcile of a policy, its spans join the
+// trace of the last change of the policy
+func (r *policeReconciler) policyContext(ctx context.Context, kind string, req reconcile.Request) context.Context {
+	if !tracing.Enabled() {
+		return ctx
+	}
+	var obj client.Object = new(egressv1.EgressPolicy)
+	if kind == "EgressClusterPolicy" {
+		obj = new(egressv1.EgressClusterPolicy)
+	}
+	if err := r.client.Get(ctx, req.NamespacedName, obj); err != nil {
+		return ctx
+	}
+	return tracing.ContextFromObject(ctx, obj)
+}
+
 type PolicyCommon struct {
 	NodeName   string
 	DestSubnet []string
 	IP         IP
+	// VLANMark routes the traffic out of the VLAN of the gateway, it is 0 without VLAN
+	VLANMark uint32
 }
 
 type IP struct {
@@ -98,84 +144,126 @@ type IP struct {
 	V6 string
 }
 
-// initApplyPolicy init applies the given policy
+// initApplyPolicy applies the policies and records the result in the agent status
+func (r *policeReconciler) initApplyPolicy() error {
+	applied, err := r.applyPolicy()
+	r.status.Synced(applied, err)
+	return err
+}
+
+// applyPolicy applies the policies and returns the policies applied
 // list egress gateway
 // list policy/cluster-policy
 // range policy, list egress endpoint slices/egress cluster policies
 // build ipset
 // build route table rule
 // build iptables
-func (r *policeReconciler) initApplyPolicy() error {
+func (r *policeReconciler) applyPolicy() ([]egressv1.AppliedPolicy, error) {
 	r.log.Info("apply policy")
 	ctx := context.Background()
 
 	gateways := new(egressv1.EgressGatewayList)
 	err := r.client.List(ctx, gateways)
 	if err != nil {
-		return fmt.Errorf("failed to list gateway: %v", err)
+		return nil, fmt.Errorf("failed to list gateway: %v", err)
 	}
 
 	if len(gateways.Items) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	err = r.ensureClusterInfoIPSet()
 	if err != nil {
-		return fmt.Errorf("ensure cluster info ipset with error: %v", err)
+		return nil, withComponent(egressv1.ComponentIPSet, fmt.Errorf("ensure cluster info ipset with error: %v", err))
 	}
 
 	unSnatPolicies := make(map[egressv1.Policy]*PolicyCommon)
 	snatPolicies := make(map[egressv1.Policy]*PolicyCommon)
+	statefulEips := make(map[egressv1.Policy][]egressv1.StatefulEip)
+	vlanBase, err := parseMark(r.cfg.FileConfig.VLANRoute.Mark)
+	if err != nil {
+		return nil, err
+	}
+
 	isEgressNode := false
 	for _, item := range gateways.Items {
+		vlanMark := uint32(0)
+		if item.Spec.VLAN != nil {
+			vlanMark = vlanBase | uint32(item.Spec.VLAN.ID)
+		}
 		for _, list := range item.Status.NodeList {
 			if list.Name == r.cfg.NodeName {
 				isEgressNode = true
+				for _, eip := range list.StatefulEips {
+					statefulEips[eip.Policy] = append(statefulEips[eip.Policy], eip)
+				}
 				for _, eip := range list.Eips {
 					for _, policy := range eip.Policies {
 						snatPolicies[policy] = &PolicyCommon{
 							NodeName: list.Name,
 							IP:       IP{V4: eip.IPv4, V6: eip.IPv6},
+							VLANMark: vlanMark,
 						}
 					}
 				}
 			} else {
 				for _, eip := range list.Eips {
 					for _, policy := range eip.Policies {
-						unSnatPolicies[policy] = &PolicyCommon{NodeName: list.Name}
+						unSnatPolicies[policy] = &PolicyCommon{
+							NodeName: list.Name,
+							IP:       IP{V4: eip.IPv4, V6: eip.IPv6},
+						}
 					}
 				}
 			}
 		}
 	}
 
+	applied := make([]egressv1.AppliedPolicy, 0, len(unSnatPolicies)+len(snatPolicies))
 	for policy, val := range unSnatPolicies {
-		val.DestSubnet, err = r.getPolicySubnet(policy.Namespace, policy.Name)
+		var generation int64
+		val.DestSubnet, generation, err = r.getPolicySubnet(policy.Namespace, policy.Name)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		err := r.updatePolicyIPSet(policy.Namespace, policy.Name, false, val.DestSubnet)
 		if err != nil {
-			return err
+			return nil, withComponent(egressv1.ComponentIPSet, err)
 		}
+		applied = append(applied, egressv1.AppliedPolicy{Name: policy.Name, Namespace: policy.Namespace, Generation: generation})
 	}
 
 	for policy, val := range snatPolicies {
-		val.DestSubnet, err = r.getPolicySubnet(policy.Namespace, policy.Name)
+		var generation int64
+		val.DestSubnet, generation, err = r.getPolicySubnet(policy.Namespace, policy.Name)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		err := r.updatePolicyIPSet(policy.Namespace, policy.Name, true, val.DestSubnet)
 		if err != nil {
-			return err
+			return nil, withComponent(egressv1.ComponentIPSet, err)
+		}
+		applied = append(applied, egressv1.AppliedPolicy{Name: policy.Name, Namespace: policy.Namespace, Generation: generation, Gateway: true})
+	}
+
+	statefulEndpoints := make(map[egressv1.Policy]map[string]egressv1.EgressEndpoint)
+	for policy := range statefulEips {
+		statefulEndpoints[policy], err = r.getPolicyEndpoints(policy.Namespace, policy.Name)
+		if err != nil {
+			return nil, err
 		}
 	}
 
 	baseMark, err := parseMark(r.cfg.FileConfig.Mark)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	// the counters of the rules are read before they are rewritten
+	r.counters.Sync()
+	// counted are the keys of the counted rules of each chain of each table
+	counted := make(map[*iptables.Table]map[string]map[string]counter.Key)
+
 	for _, table := range r.filterTables {
 		chainMapRules := buildFilterStaticRule(baseMark)
 		for chain, rules := range chainMapRules {
@@ -186,6 +274,7 @@ func (r *policeReconciler) initApplyPolicy() error {
 	for _, table := range r.mangleTables {
 		table.UpdateChain(&iptables.Chain{Name: "EGRESSGATEWAY-REPLY-ROUTING"})
 		table.UpdateChain(&iptables.Chain{Name: "EGRESSGATEWAY-MARK-REQUEST"})
+		table.UpdateChain(&iptables.Chain{Name: "EGRESSGATEWAY-VLAN-ROUTING"})
 		chainMapRules := buildMangleStaticRule(
 			baseMark,
 			isEgressNode,
@@ -213,6 +302,7 @@ func (r *policeReconciler) initApplyPolicy() error {
 
 	for _, table := range r.mangleTables {
 		rules := make([]iptables.Rule, 0)
+		keys := make([]counter.Key, 0)
 		for policy, val := range unSnatPolicies {
 			node := new(egressv1.EgressTunnel)
 			err := r.client.Get(context.Background(), types.NamespacedName{Name: val.NodeName}, node)
@@ -227,7 +317,7 @@ func (r *policeReconciler) initApplyPolicy() error {
 
 			mark, err := parseMark(node.Status.Mark)
 			if err != nil {
-				return err
+				return nil, err
 			}
 
 			isIgnoreInternalCIDR := false
@@ -237,11 +327,33 @@ func (r *policeReconciler) initApplyPolicy() error {
 
 			rule := r.buildPolicyRule(policyName, mark, table.IPVersion, isIgnoreInternalCIDR)
 			rules = append(rules, *rule)
+			keys = append(keys, counterKey(policy, val.IP, table.IPVersion, counter.RuleMarkRequest))
 		}
 		table.UpdateChain(&iptables.Chain{
 			Name:  "EGRESSGATEWAY-MARK-REQUEST",
 			Rules: rules,
 		})
+		counted[table] = map[string]map[string]counter.Key{
+			"EGRESSGATEWAY-MARK-REQUEST": ruleKeys(table, "EGRESSGATEWAY-MARK-REQUEST", keys),
+		}
+		vlanRules := make([]iptables.Rule, 0)
+		for policy, val := range snatPolicies {
+			if val.VLANMark == 0 {
+				continue
+			}
+			policyName := policy.Name
+			if policy.Namespace != "" {
+				policyName = fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
+			}
+			rule := buildVLANRoutingRule(policyName, val.IP, val.VLANMark, table.IPVersion, len(val.DestSubnet) <= 0)
+			if rule != nil {
+				vlanRules = append(vlanRules, *rule)
+			}
+		}
+		table.UpdateChain(&iptables.Chain{
+			Name:  "EGRESSGATEWAY-VLAN-ROUTING",
+			Rules: vlanRules,
+		})
 		table.UpdateChain(&iptables.Chain{
 			Name: "EGRESSGATEWAY-REPLY-ROUTING",
 			Rules: buildPreroutingReplyRouting(r.cfg.FileConfig.VXLAN.Name,
@@ -249,8 +361,34 @@ func (r *policeReconciler) initApplyPolicy() error {
 		})
 	}
 
+	statefulChains := make(map[egressv1.Policy]struct{})
 	for _, table := range r.natTables {
 		rules := make([]iptables.Rule, 0)
+		keys := make([]counter.Key, 0)
+		counted[table] = make(map[string]map[string]counter.Key)
+		// the jumps to the chains of the stateful pods go first, their traffic is also matched
+		// by the rule of the policy
+		for policy, eips := range statefulEips {
+			val, ok := snatPolicies[policy]
+			if !ok {
+				continue
+			}
+			policyName := fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
+			chain := statefulChainName(policy)
+			chainRules, chainKeys := buildStatefulChainRules(policyName, eips, statefulEndpoints[policy], table.IPVersion, len(val.DestSubnet) <= 0)
+			table.UpdateChain(&iptables.Chain{Name: chain, Rules: chainRules})
+			counted[table][chain] = ruleKeys(table, chain, chainKeys)
+			rules = append(rules, *buildStatefulJumpRule(policyName, chain, table.IPVersion))
+			keys = append(keys, counter.Key{})
+			statefulChains[policy] = struct{}{}
+		}
+		r.statefulChains.Range(func(policy egressv1.Policy, _ struct{}) bool {
+			if _, ok := statefulChains[policy]; !ok {
+				table.RemoveChainByName(statefulChainName(policy))
+				counted[table][statefulChainName(policy)] = nil
+			}
+			return true
+		})
 		for policy, val := range snatPolicies {
 			policyName := policy.Name
 			if policy.Namespace != "" {
@@ -265,10 +403,12 @@ func (r *policeReconciler) initApplyPolicy() error {
 			rule := buildEipRule(policyName, val.IP, table.IPVersion, isIgnoreInternalCIDR)
 			if rule != nil {
 				rules = append(rules, *rule)
+				keys = append(keys, counterKey(policy, val.IP, table.IPVersion, counter.RuleSNAT))
 			}
 		}
 
 		table.UpdateChain(&iptables.Chain{Name: "EGRESSGATEWAY-SNAT-EIP", Rules: rules})
+		counted[table]["EGRESSGATEWAY-SNAT-EIP"] = ruleKeys(table, "EGRESSGATEWAY-SNAT-EIP", keys)
 		chainMapRules := buildNatStaticRule(baseMark)
 		for chain, rules := range chainMapRules {
 			table.InsertOrAppendRules(chain, rules)
@@ -277,17 +417,32 @@ func (r *policeReconciler) initApplyPolicy() error {
 
 	allTables := append(r.natTables, r.filterTables...)
 	allTables = append(allTables, r.mangleTables...)
+	r.debug.SetTables(allTables)
 	for _, table := range allTables {
 		_, err := table.Apply()
 		if err != nil {
-			return fmt.Errorf("failed to apply rule %v: %v", table.Name, err)
+			return nil, withComponent(egressv1.ComponentIPTables, fmt.Errorf("failed to apply rule %v: %v", table.Name, err))
 		}
 	}
+	for table, chains := range counted {
+		for chain, keys := range chains {
+			r.counters.SetRules(table, chain, keys)
+		}
+	}
+	r.statefulChains.Range(func(policy egressv1.Policy, _ struct{}) bool {
+		if _, ok := statefulChains[policy]; !ok {
+			r.statefulChains.Delete(policy)
+		}
+		return true
+	})
+	for policy := range statefulChains {
+		r.statefulChains.Store(policy, struct{}{})
+	}
 
 	setList, err := r.ipset.ListSets()
 	if err != nil {
 		r.log.Error(err, "list ipset")
-		return err
+		return nil, withComponent(egressv1.ComponentIPSet, err)
 	}
 
 	for _, name := range setList {
@@ -307,10 +462,10 @@ func (r *policeReconciler) initApplyPolicy() error {
 		}
 	}
 
-	return nil
+	return applied, nil
 }
 
-func (r *policeReconciler) getPolicySubnet(ns, name string) ([]string, error) {
+func (r *policeReconciler) getPolicySubnet(ns, name string) ([]string, int64, error) {
 	var obj client.Object
 	key := types.NamespacedName{Namespace: ns, Name: name}
 	getSubnet := func(obj client.Object) []string {
@@ -331,10 +486,10 @@ func (r *policeReconciler) getPolicySubnet(ns, name string) ([]string, error) {
 	err := r.client.Get(context.Background(), key, obj)
 	if err != nil {
 		if !apierr.IsNotFound(err) {
-			return nil, err
+			return nil, 0, err
 		}
 	}
-	return getSubnet(obj), nil
+	return getSubnet(obj), obj.GetGeneration(), nil
 }
 
 func (r *policeReconciler) updatePolicyIPSet(policyNs string, policyName string, isEipNodeSet bool, destSubnet []string) error {
@@ -361,6 +516,7 @@ func (r *policeReconciler) updatePolicyIPSet(policyNs string, policyName string,
 
 	toAddList := make(map[string][]string, 0)
 	toDelList := make(map[string][]string, 0)
+	desired := make(map[string][]string, 0)
 	setNames := buildIPSetNamesByPolicy(policyNs, policyName, r.cfg.FileConfig.EnableIPv4, r.cfg.FileConfig.EnableIPv6)
 
 	err = setNames.Map(func(set SetName) error {
@@ -383,14 +539,18 @@ func (r *policeReconciler) updatePolicyIPSet(policyNs string, policyName string,
 		case IPSrc:
 			if set.Stack == IPv4 && r.cfg.FileConfig.EnableIPv4 {
 				toAddList[set.Name], toDelList[set.Name] = findDiff(oldIPList, srcIPv4List)
+				desired[set.Name] = srcIPv4List
 			} else if r.cfg.FileConfig.EnableIPv6 {
 				toAddList[set.Name], toDelList[set.Name] = findDiff(oldIPList, srcIPv6List)
+				desired[set.Name] = srcIPv6List
 			}
 		case IPDst:
 			if set.Stack == IPv4 && r.cfg.FileConfig.EnableIPv4 {
 				toAddList[set.Name], toDelList[set.Name] = findDiff(oldIPList, dstIPv4List)
+				desired[set.Name] = dstIPv4List
 			} else if r.cfg.FileConfig.EnableIPv6 {
 				toAddList[set.Name], toDelList[set.Name] = findDiff(oldIPList, dstIPv6List)
+				desired[set.Name] = dstIPv6List
 			}
 		}
 		return nil
@@ -422,6 +582,9 @@ func (r *policeReconciler) updatePolicyIPSet(policyNs string, policyName string,
 		}
 	}
 
+	for name, ips := range desired {
+		r.debug.SetIPSet(name, ips)
+	}
 	return nil
 }
 
@@ -475,6 +638,146 @@ func (r *policeReconciler) getPolicySrcIPs(policyNs, policyName string, filter f
 	return ipv4List, ipv6List, nil
 }
 
+// getPolicyEndpoints returns the endpoints of the policy by pod name
+func (r *policeReconciler) getPolicyEndpoints(policyNs, policyName string) (map[string]egressv1.EgressEndpoint, error) {
+	eps := new(egressv1.EgressEndpointSliceList)
+	err := r.client.List(context.Background(), eps, client.InNamespace(policyNs),
+		client.MatchingLabels{egressv1.LabelPolicyName: policyName})
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]egressv1.EgressEndpoint)
+	for _, ep := range eps.Items {
+		if ep.DeletionTimestamp.IsZero() {
+			for _, e := range ep.Endpoints {
+				res[e.Pod] = e
+			}
+		}
+	}
+	return res, nil
+}
+
+// applyStatefulPolicy rewrites only the chain of the stateful pods of the sticky policy,
+// the IPs of the pods change when they are rescheduled. The whole datapath is applied
+// when the node has no chain of the policy yet.
+func (r *policeReconciler) applyStatefulPolicy(policy egressv1.Policy, gateway *egressv1.EgressGateway, destSubnet []string) error {
+	if _, ok := r.statefulChains.Load(policy); !ok {
+		return r.initApplyPolicy()
+	}
+
+	var eips []egressv1.StatefulEip
+	for _, node := range gateway.Status.NodeList {
+		if node.Name != r.cfg.NodeName {
+			continue
+		}
+		for _, eip := range node.StatefulEips {
+			if eip.Policy == policy {
+				eips = append(eips, eip)
+			}
+		}
+	}
+	endpoints, err := r.getPolicyEndpoints(policy.Namespace, policy.Name)
+	if err != nil {
+		return err
+	}
+
+	policyName := fmt.Sprintf("%s-%s", policy.Namespace, policy.Name)
+	chain := statefulChainName(policy)
+	// the counters of the rules are read before they are rewritten
+	r.counters.Sync()
+	for _, table := range r.natTables {
+		rules, keys := buildStatefulChainRules(policyName, eips, endpoints, table.IPVersion, len(destSubnet) <= 0)
+		table.UpdateChain(&iptables.Chain{Name: chain, Rules: rules})
+		if _, err := table.Apply(); err != nil {
+			return withComponent(egressv1.ComponentIPTables, fmt.Errorf("failed to apply rule %v: %v", table.Name, err))
+		}
+		r.counters.SetRules(table, chain, ruleKeys(table, chain, keys))
+	}
+	return nil
+}
+
+// statefulChainName returns the name of the chain of the SNAT rules of the stateful pods of
+// the policy, the chain names of iptables are limited to 28 characters
+func statefulChainName(policy egressv1.Policy) string {
+	return fmt.Sprintf("EGRESSGATEWAY-POD-%x", sha1.Sum([]byte(policy.Namespace+"/"+policy.Name)))[:28]
+}
+
+// buildStatefulJumpRule jumps from the traffic of the pods of the policy to the chain of its
+// stateful pods
+func buildStatefulJumpRule(policyName, chain string, version uint8) *iptables.Rule {
+	tmp := "v4-"
+	if version == 6 {
+		tmp = "v6-"
+	}
+	srcName := ipset.FormatName("egress-src-"+tmp, policyName)
+	return &iptables.Rule{
+		Match:  iptables.MatchCriteria{}.SourceIPSet(srcName),
+		Action: iptables.JumpAction{Target: chain},
+		Comment: []string{
+			fmt.Sprintf("snat stateful pods of policy %s", policyName),
+		},
+	}
+}
+
+// buildStatefulChainRules builds the SNAT rules of the stateful pods of a policy and the
+// counter keys of the rules, the rules of a pod are counted under the policy with the EIP
+// of the pod. The pods without endpoint are skipped.
+func buildStatefulChainRules(policyName string, eips []egressv1.StatefulEip, endpoints map[string]egressv1.EgressEndpoint,
+	version uint8, isIgnoreInternalCIDR bool) ([]iptables.Rule, []counter.Key) {
+	rules := make([]iptables.Rule, 0)
+	keys := make([]counter.Key, 0)
+	for _, eip := range eips {
+		ep, ok := endpoints[eip.Pod]
+		if !ok {
+			continue
+		}
+		eipRules := buildStatefulEipRules(policyName, eip, ep, version, isIgnoreInternalCIDR)
+		key := counterKey(eip.Policy, IP{V4: eip.IPv4, V6: eip.IPv6}, version, counter.RuleSNAT)
+		for range eipRules {
+			keys = append(keys, key)
+		}
+		rules = append(rules, eipRules...)
+	}
+	return rules, keys
+}
+
+// buildStatefulEipRules builds the SNAT rules from the IPs of a StatefulSet pod to its own EIP
+func buildStatefulEipRules(policyName string, eip egressv1.StatefulEip, ep egressv1.EgressEndpoint, version uint8, isIgnoreInternalCIDR bool) []iptables.Rule {
+	tmp := "v4-"
+	ip := eip.IPv4
+	srcIPs := ep.IPv4
+	ignoreName := EgressClusterCIDRIPv4
+	if version == 6 {
+		tmp = "v6-"
+		ip = eip.IPv6
+		srcIPs = ep.IPv6
+		ignoreName = EgressClusterCIDRIPv6
+	}
+	if ip == "" {
+		return nil
+	}
+	dstName := ipset.FormatName("egress-dst-"+tmp, policyName)
+
+	rules := make([]iptables.Rule, 0, len(srcIPs))
+	for _, src := range srcIPs {
+		matchCriteria := iptables.MatchCriteria{}.SourceNet(src).DestIPSet(dstName).
+			CTDirectionOriginal(iptables.DirectionOriginal)
+		if isIgnoreInternalCIDR {
+			matchCriteria = iptables.MatchCriteria{}.SourceNet(src).NotDestIPSet(ignoreName).
+				CTDirectionOriginal(iptables.DirectionOriginal)
+		}
+		rules = append(rules, iptables.Rule{
+			Match:  matchCriteria,
+			Action: iptables.SNATAction{ToAddr: ip},
+			Comment: []string{
+				fmt.Sprintf("snat pod %s of policy %s", eip.Pod, policyName),
+			},
+		})
+	}
+	return rules
+}
+
 func buildEipRule(policyName string, eip IP, version uint8, isIgnoreInternalCIDR bool) *iptables.Rule {
 	if eip.V4 == "" && eip.V6 == "" {
 		return nil
@@ -488,8 +791,8 @@ func buildEipRule(policyName string, eip IP, version uint8, isIgnoreInternalCIDR
 		ip = eip.V6
 		ignoreName = EgressClusterCIDRIPv6
 	}
-	srcName := formatIPSetName("egress-src-"+tmp, policyName)
-	dstName := formatIPSetName("egress-dst-"+tmp, policyName)
+	srcName := ipset.FormatName("egress-src-"+tmp, policyName)
+	dstName := ipset.FormatName("egress-dst-"+tmp, policyName)
 
 	matchCriteria := iptables.MatchCriteria{}.SourceIPSet(srcName).DestIPSet(dstName).
 		CTDirectionOriginal(iptables.DirectionOriginal)
@@ -506,6 +809,57 @@ func buildEipRule(policyName string, eip IP, version uint8, isIgnoreInternalCIDR
 	return rule
 }
 
+// buildVLANRoutingRule marks the traffic of the policy so it is routed out of the VLAN
+// of the gateway, the forwarded traffic is routed before it is SNATed to the EIP.
+func buildVLANRoutingRule(policyName string, eip IP, mark uint32, version uint8, isIgnoreInternalCIDR bool) *iptables.Rule {
+	tmp := "v4-"
+	ip := eip.V4
+	ignoreName := EgressClusterCIDRIPv4
+	if version == 6 {
+		tmp = "v6-"
+		ip = eip.V6
+		ignoreName = EgressClusterCIDRIPv6
+	}
+	if ip == "" {
+		return nil
+	}
+	srcName := ipset.FormatName("egress-src-"+tmp, policyName)
+	dstName := ipset.FormatName("egress-dst-"+tmp, policyName)
+
+	matchCriteria := iptables.MatchCriteria{}.SourceIPSet(srcName).DestIPSet(dstName)
+	if isIgnoreInternalCIDR {
+		matchCriteria = iptables.MatchCriteria{}.SourceIPSet(srcName).NotDestIPSet(ignoreName)
+	}
+
+	return &iptables.Rule{
+		Match:  matchCriteria,
+		Action: iptables.SetMaskedMarkAction{Mark: mark, Mask: 0xffffffff},
+		Comment: []string{
+			fmt.Sprintf("route policy %s out of the VLAN", policyName),
+		},
+	}
+}
+
+// counterKey returns the series of the counters of a policy rule
+func counterKey(policy egressv1.Policy, eip IP, version uint8, rule string) counter.Key {
+	key := counter.Key{Policy: policy.Name, Namespace: policy.Namespace, EIP: eip.V4, Family: "ipv4", Rule: rule}
+	if version == 6 {
+		key.EIP, key.Family = eip.V6, "ipv6"
+	}
+	return key
+}
+
+// ruleKeys indexes the keys of the rules of the chain by rule hash, a zero key is not counted
+func ruleKeys(table *iptables.Table, chain string, keys []counter.Key) map[string]counter.Key {
+	res := make(map[string]counter.Key)
+	for i, hash := range table.RuleHashes(chain) {
+		if i < len(keys) && keys[i] != (counter.Key{}) {
+			res[hash] = keys[i]
+		}
+	}
+	return res
+}
+
 func parseMark(mark string) (uint32, error) {
 	tmp := strings.ReplaceAll(mark, "0x", "")
 	i64, err := strconv.ParseInt(tmp, 16, 32)
@@ -523,8 +877,8 @@ func (r *policeReconciler) buildPolicyRule(policyName string, mark uint32, versi
 		tmp = "v6-"
 		ignoreInternalCIDRName = EgressClusterCIDRIPv6
 	}
-	srcName := formatIPSetName("egress-src-"+tmp, policyName)
-	dstName := formatIPSetName("egress-dst-"+tmp, policyName)
+	srcName := ipset.FormatName("egress-src-"+tmp, policyName)
+	dstName := ipset.FormatName("egress-dst-"+tmp, policyName)
 
 	matchCriteria := iptables.MatchCriteria{}.SourceIPSet(srcName).DestIPSet(dstName).
 		CTDirectionOriginal(iptables.DirectionOriginal)
@@ -679,6 +1033,9 @@ func (r *policeReconciler) reconcileClusterInfo(ctx context.Context, req reconci
 		return reconcile.Result{}, err
 	}
 
+	r.debug.SetIPSet(EgressClusterCIDRIPv4, ipv4)
+	r.debug.SetIPSet(EgressClusterCIDRIPv6, ipv6)
+
 	ipSet4 := &ipset.IPSet{Name: EgressClusterCIDRIPv4, SetType: ipset.HashNet, HashFamily: "inet"}
 	ipSet6 := &ipset.IPSet{Name: EgressClusterCIDRIPv6, SetType: ipset.HashNet, HashFamily: "inet6"}
 
@@ -810,6 +1167,16 @@ func buildMangleStaticRule(base uint32,
 		})
 	}
 
+	if isEgressNode {
+		prerouting = append(prerouting, iptables.Rule{
+			Match:  iptables.MatchCriteria{},
+			Action: iptables.JumpAction{Target: "EGRESSGATEWAY-VLAN-ROUTING"},
+			Comment: []string{
+				"Checking for EgressPolicy traffic routed out of a VLAN",
+			},
+		})
+	}
+
 	res := map[string][]iptables.Rule{
 		"FORWARD":     forward,
 		"POSTROUTING": postrouting,
@@ -870,6 +1237,7 @@ func (r *policeReconciler) reconcilePolicy(ctx context.Context, req reconcile.Re
 			r.removeIPSet(log, set.Name)
 			return nil
 		})
+		r.status.RemovePolicy(egressv1.Policy{Name: req.Name, Namespace: req.Namespace})
 		return reconcile.Result{}, nil
 	}
 
@@ -899,7 +1267,42 @@ func (r *policeReconciler) reconcilePolicy(ctx context.Context, req reconcile.Re
 	}
 
 	// update event
+	_, span := tracing.StartChild(ctx, "updatePolicyIPSet", attribute.Bool("gateway", flag))
 	err = r.updatePolicyIPSet(policy.Namespace, policy.Name, flag, policy.Spec.DestSubnet)
+	tracing.RecordError(span, err)
+	span.End()
+	if nodeName != "" {
+		r.status.SetPolicy(egressv1.AppliedPolicy{
+			Name:       policy.Name,
+			Namespace:  policy.Namespace,
+			Generation: policy.Generation,
+			Gateway:    flag,
+		}, err)
+	}
+	// the IPs of stateful pods change when they are rescheduled, refresh their SNAT rules
+	if err == nil && flag && policy.Spec.EgressIP.AllocatorPolicy == egressv1.EipAllocatorSticky {
+		_, span := tracing.StartChild(ctx, "applyStatefulPolicy")
+		err = r.applyStatefulPolicy(egressv1.Policy{Name: policy.Name, Namespace: policy.Namespace}, gateway, policy.Spec.DestSubnet)
+		tracing.RecordError(span, err)
+		span.End()
+	}
+	if flag {
+		// the rules of the policy are written by the programming of all the policies,
+		// its failure is reported until a later programming succeeds
+		programErr := err
+		if programErr == nil {
+			programErr = r.status.SyncErr()
+		}
+		newPolicy := policy.DeepCopy()
+		if newPolicy.Status.SetDatapathProgrammedCondition(policy.Generation, nodeName, programErr) {
+			if updateErr := r.client.Status().Update(ctx, newPolicy); updateErr != nil {
+				if err == nil {
+					return reconcile.Result{}, fmt.Errorf("update egresspolicy status: %w", updateErr)
+				}
+				log.Error(updateErr, "update egresspolicy status")
+			}
+		}
+	}
 	if err != nil {
 		return reconcile.Result{Requeue: true}, err
 	}
@@ -932,6 +1335,7 @@ func (r *policeReconciler) reconcileClusterPolicy(ctx context.Context, req recon
 			r.removeIPSet(log, set.Name)
 			return nil
 		})
+		r.status.RemovePolicy(egressv1.Policy{Name: req.Name, Namespace: req.Namespace})
 		return reconcile.Result{}, nil
 	}
 
@@ -961,7 +1365,34 @@ func (r *policeReconciler) reconcileClusterPolicy(ctx context.Context, req recon
 	}
 
 	// update event
+	_, span := tracing.StartChild(ctx, "updatePolicyIPSet", attribute.Bool("gateway", flag))
 	err = r.updatePolicyIPSet(policy.Namespace, policy.Name, flag, policy.Spec.DestSubnet)
+	tracing.RecordError(span, err)
+	span.End()
+	if nodeName != "" {
+		r.status.SetPolicy(egressv1.AppliedPolicy{
+			Name:       policy.Name,
+			Generation: policy.Generation,
+			Gateway:    flag,
+		}, err)
+	}
+	if flag {
+		// the rules of the policy are written by the programming of all the policies,
+		// its failure is reported until a later programming succeeds
+		programErr := err
+		if programErr == nil {
+			programErr = r.status.SyncErr()
+		}
+		newPolicy := policy.DeepCopy()
+		if newPolicy.Status.SetDatapathProgrammedCondition(policy.Generation, nodeName, programErr) {
+			if updateErr := r.client.Status().Update(ctx, newPolicy); updateErr != nil {
+				if err == nil {
+					return reconcile.Result{}, fmt.Errorf("update egressclusterpolicy status: %w", updateErr)
+				}
+				log.Error(updateErr, "update egressclusterpolicy status")
+			}
+		}
+	}
 	if err != nil {
 		return reconcile.Result{Requeue: true}, err
 	}
@@ -1047,6 +1478,7 @@ func (r *policeReconciler) removeIPSet(log logr.Logger, name string) {
 		}
 		r.ipsetMap.Delete(name)
 	}
+	r.debug.DeleteIPSet(name)
 }
 
 func (r *policeReconciler) createIPSet(log logr.Logger, set SetName) error {
@@ -1076,7 +1508,7 @@ func (r *policeReconciler) createIPSet(log logr.Logger, set SetName) error {
 	return nil
 }
 
-func newPolicyController(mgr manager.Manager, log logr.Logger, cfg *config.Config) error {
+func newPolicyController(mgr manager.Manager, log logr.Logger, cfg *config.Config, status *datapathStatus, debug *datapathDebug) error {
 	iptablesCfg := cfg.FileConfig.IPTables
 	opt := iptables.Options{
 		HistoricChainPrefixes:    []string{"egw"},
@@ -1140,17 +1572,23 @@ func newPolicyController(mgr manager.Manager, log logr.Logger, cfg *config.Confi
 
 	e := exec.New()
 	r := &policeReconciler{
-		client:       mgr.GetClient(),
-		ipsetMap:     utils.NewSyncMap[string, *ipset.IPSet](),
-		log:          log,
-		ipset:        ipset.New(e),
-		cfg:          cfg,
-		mangleTables: mangleTables,
-		filterTables: filterTables,
-		natTables:    natTables,
-		ruleV4Map:    utils.NewSyncMap[string, iptables.Rule](),
-		ruleV6Map:    utils.NewSyncMap[string, iptables.Rule](),
-	}
+		client:         tracing.WrapClient(mgr.GetClient()),
+		ipsetMap:       utils.NewSyncMap[string, *ipset.IPSet](),
+		log:            log,
+		ipset:          ipset.New(e),
+		cfg:            cfg,
+		mangleTables:   mangleTables,
+		filterTables:   filterTables,
+		natTables:      natTables,
+		ruleV4Map:      utils.NewSyncMap[string, iptables.Rule](),
+		ruleV6Map:      utils.NewSyncMap[string, iptables.Rule](),
+		statefulChains: utils.NewSyncMap[egressv1.Policy, struct{}](),
+		counters:       counter.New(log.WithName("counter")),
+		status:         status,
+		debug:          debug,
+	}
+	debug.policy = r
+	ctrlmetrics.Registry.MustRegister(r.counters)
 
 	c, err := controller.New("policy", mgr, controller.Options{Reconciler: r})
 	if err != nil {
@@ -1204,14 +1642,14 @@ func buildIPSetNamesByPolicy(ns, name string, enableIPv4, enableIPv6 bool) SetNa
 	res := make([]SetName, 0)
 	if enableIPv4 {
 		res = append(res, []SetName{
-			{Name: formatIPSetName("egress-src-v4-", name), Stack: IPv4, Kind: IPSrc},
-			{Name: formatIPSetName("egress-dst-v4-", name), Stack: IPv4, Kind: IPDst},
+			{Name: ipset.FormatName("egress-src-v4-", name), Stack: IPv4, Kind: IPSrc},
+			{Name: ipset.FormatName("egress-dst-v4-", name), Stack: IPv4, Kind: IPDst},
 		}...)
 	}
 	if enableIPv6 {
 		res = append(res, []SetName{
-			{Name: formatIPSetName("egress-src-v6-", name), Stack: IPv6, Kind: IPSrc},
-			{Name: formatIPSetName("egress-dst-v6-", name), Stack: IPv6, Kind: IPDst},
+			{Name: ipset.FormatName("egress-src-v6-", name), Stack: IPv6, Kind: IPSrc},
+			{Name: ipset.FormatName("egress-dst-v6-", name), Stack: IPv6, Kind: IPDst},
 		}...)
 	}
 	return res
@@ -1235,12 +1673,6 @@ func (m SetNames) Map(f func(name SetName) error) error {
 	return nil
 }
 
-func formatIPSetName(prefix, name string) string {
-	hash := fmt.Sprintf("%x", sha1.Sum([]byte(name)))
-	i := 31 - len(prefix)
-	return prefix + hash[:i]
-}
-
 type IPKind int
 
 const (
@@ -139,6 +139,30 @@ func (r *RuleRoute) ensureRoute(link netlink.Link, ip *net.IP, family int, table
 	return nil
 }
 
+// Rules returns the rules whose mark is in the range of baseMark
+func (r *RuleRoute) Rules(family int, baseMark string) ([]netlink.Rule, error) {
+	start, end, err := markallocator.RangeSize(baseMark)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := netlink.RuleList(family)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]netlink.Rule, 0)
+	for _, rule := range rules {
+		if int(start) <= rule.Mark && int(end) >= rule.Mark {
+			res = append(res, rule)
+		}
+	}
+	return res, nil
+}
+
+// Routes returns the routes of the table
+func (r *RuleRoute) Routes(family int, table int) ([]netlink.Route, error) {
+	return netlink.RouteListFiltered(family, &netlink.Route{Table: table}, netlink.RT_FILTER_TABLE)
+}
+
 func (r *RuleRoute) EnsureRule(family int, table int, mark int, log logr.Logger) error {
 	log = log.WithValues("family", family)
 	log.V(1).Info("ensure rule")
@@ -0,0 +1,356 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package vlan
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/vishvananda/netlink"
+
+	"github.com/spidernet-io/egressgateway/pkg/lock"
+)
+
+// aliasPrefix marks the sub-interfaces created by the agent, the ones created by
+// the administrator are used but never deleted
+const aliasPrefix = "egressgateway:"
+
+// maxID is the largest VLAN ID, the tables and marks of the VLANs are in [base, base+maxID]
+const maxID = 4094
+
+type NetLink struct {
+	LinkByName   func(name string) (netlink.Link, error)
+	LinkList     func() ([]netlink.Link, error)
+	LinkAdd      func(link netlink.Link) error
+	LinkDel      func(link netlink.Link) error
+	LinkSetUp    func(link netlink.Link) error
+	LinkSetAlias func(link netlink.Link, name string) error
+	AddrReplace  func(link netlink.Link, addr *netlink.Addr) error
+	RouteReplace func(route *netlink.Route) error
+	RuleList     func(family int) ([]netlink.Rule, error)
+	RuleAdd      func(rule *netlink.Rule) error
+	RuleDel      func(rule *netlink.Rule) error
+}
+
+// VLAN is the sub-interface of an EgressGateway on this node.
+type VLAN struct {
+	Parent string
+	ID     int
+	// Addresses of the sub-interface on this node, in the CIDR format
+	Addresses   []string
+	IPv4Gateway net.IP
+	IPv6Gateway net.IP
+	// EIPs are the EIPs hosted by this node, the traffic from them uses the VLAN table
+	EIPs []net.IP
+}
+
+// families returns the IP families routed on the VLAN, a family is routed when the
+// VLAN has a gateway, an address or an EIP of the family.
+func (v *VLAN) families() []int {
+	v4, v6 := v.IPv4Gateway != nil, v.IPv6Gateway != nil
+	for _, item := range v.Addresses {
+		ip, _, err := net.ParseCIDR(item)
+		if err != nil {
+			continue
+		}
+		if ip.To4() != nil {
+			v4 = true
+		} else {
+			v6 = true
+		}
+	}
+	for _, eip := range v.EIPs {
+		if eip.To4() != nil {
+			v4 = true
+		} else {
+			v6 = true
+		}
+	}
+	var res []int
+	if v4 {
+		res = append(res, netlink.FAMILY_V4)
+	}
+	if v6 {
+		res = append(res, netlink.FAMILY_V6)
+	}
+	return res
+}
+
+// Name returns the name of the sub-interface, the "parent.id" form is used when it
+// fits in the limit of the interface names.
+func Name(parent string, id int) string {
+	name := fmt.Sprintf("%s.%d", parent, id)
+	if len(name) > 15 {
+		return fmt.Sprintf("egw.%d", id)
+	}
+	return name
+}
+
+// Manager provisions the VLAN sub-interfaces of the EgressGateways and the policy
+// routing of their EIP traffic. The traffic marked with mark+id in the mangle table,
+// or sent from a hosted EIP, is routed with the table tableBase+id.
+type Manager struct {
+	lock.Mutex
+	log       logr.Logger
+	nl        NetLink
+	tableBase int
+	mark      uint32
+
+	// desired is the VLAN of each EgressGateway
+	desired map[string]VLAN
+	// synced is set after the first sync, which removes the leftovers of the previous agent
+	synced bool
+}
+
+func New(log logr.Logger, tableBase int, mark uint32) *Manager {
+	return newManager(log, NetLink{
+		LinkByName:   netlink.LinkByName,
+		LinkList:     netlink.LinkList,
+		LinkAdd:      netlink.LinkAdd,
+		LinkDel:      netlink.LinkDel,
+		LinkSetUp:    netlink.LinkSetUp,
+		LinkSetAlias: netlink.LinkSetAlias,
+		AddrReplace:  netlink.AddrReplace,
+		RouteReplace: netlink.RouteReplace,
+		RuleList:     netlink.RuleList,
+		RuleAdd:      netlink.RuleAdd,
+		RuleDel:      netlink.RuleDel,
+	}, tableBase, mark)
+}
+
+func newManager(log logr.Logger, nl NetLink, tableBase int, mark uint32) *Manager {
+	return &Manager{
+		log:       log,
+		nl:        nl,
+		tableBase: tableBase,
+		mark:      mark,
+		desired:   make(map[string]VLAN),
+	}
+}
+
+// Mark returns the mark of the traffic routed out of the VLAN id.
+func (m *Manager) Mark(id int) uint32 {
+	return m.mark | uint32(id)
+}
+
+// Table returns the route table of the VLAN id.
+func (m *Manager) Table(id int) int {
+	return m.tableBase + id
+}
+
+// Set replaces the VLAN of the EgressGateway name, the VLAN is removed when v is nil.
+func (m *Manager) Set(name string, v *VLAN) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if v == nil {
+		if _, ok := m.desired[name]; !ok && m.synced {
+			return nil
+		}
+		delete(m.desired, name)
+	} else {
+		m.desired[name] = *v
+	}
+	if err := m.sync(); err != nil {
+		return err
+	}
+	m.synced = true
+	return nil
+}
+
+func (m *Manager) sync() error {
+	// the EgressGateways sharing a VLAN share its sub-interface and table
+	wanted := make(map[string]*VLAN)
+	names := make([]string, 0, len(m.desired))
+	for name := range m.desired {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v := m.desired[name]
+		link := Name(v.Parent, v.ID)
+		if cur, ok := wanted[link]; ok {
+			cur.EIPs = append(cur.EIPs, v.EIPs...)
+			continue
+		}
+		v.EIPs = append([]net.IP(nil), v.EIPs...)
+		wanted[link] = &v
+	}
+
+	var errs []error
+	for name, v := range wanted {
+		if err := m.ensureLink(name, v); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := m.purgeLinks(wanted); err != nil {
+		errs = append(errs, err)
+	}
+	if err := m.syncRules(wanted); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Manager) ensureLink(name string, v *VLAN) error {
+	link, err := m.nl.LinkByName(name)
+	if err != nil {
+		if !errors.As(err, &netlink.LinkNotFoundError{}) {
+			return fmt.Errorf("failed to get interface %s: %w", name, err)
+		}
+		parent, err := m.nl.LinkByName(v.Parent)
+		if err != nil {
+			return fmt.Errorf("failed to get the parent interface %s of VLAN %d: %w", v.Parent, v.ID, err)
+		}
+		sub := &netlink.Vlan{
+			LinkAttrs: netlink.LinkAttrs{Name: name, ParentIndex: parent.Attrs().Index},
+			VlanId:    v.ID,
+		}
+		if err := m.nl.LinkAdd(sub); err != nil {
+			return fmt.Errorf("failed to create the VLAN sub-interface %s: %w", name, err)
+		}
+		if link, err = m.nl.LinkByName(name); err != nil {
+			return fmt.Errorf("failed to get interface %s: %w", name, err)
+		}
+		if err := m.nl.LinkSetAlias(link, aliasPrefix+v.Parent); err != nil {
+			return fmt.Errorf("failed to set the alias of %s: %w", name, err)
+		}
+		m.log.Info("created the VLAN sub-interface", "interface", name, "parent", v.Parent, "vlan", v.ID)
+	}
+	if link.Attrs().Flags&net.FlagUp == 0 {
+		if err := m.nl.LinkSetUp(link); err != nil {
+			return fmt.Errorf("failed to set interface %s up: %w", name, err)
+		}
+	}
+
+	for _, item := range v.Addresses {
+		addr, err := netlink.ParseAddr(item)
+		if err != nil {
+			return fmt.Errorf("invalid address %s of VLAN %d: %w", item, v.ID, err)
+		}
+		if err := m.nl.AddrReplace(link, addr); err != nil {
+			return fmt.Errorf("failed to add address %s to %s: %w", item, name, err)
+		}
+	}
+
+	table := m.Table(v.ID)
+	for _, family := range v.families() {
+		gw := v.IPv4Gateway
+		dst := &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}
+		if family == netlink.FAMILY_V6 {
+			gw = v.IPv6Gateway
+			dst = &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
+		}
+		route := &netlink.Route{LinkIndex: link.Attrs().Index, Dst: dst, Gw: gw, Table: table, Family: family}
+		if gw == nil {
+			// the destinations are reached directly on the VLAN
+			route.Scope = netlink.SCOPE_LINK
+		}
+		if err := m.nl.RouteReplace(route); err != nil {
+			return fmt.Errorf("failed to set the default route of table %d: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// purgeLinks deletes the sub-interfaces created by the agent which are not wanted.
+func (m *Manager) purgeLinks(wanted map[string]*VLAN) error {
+	links, err := m.nl.LinkList()
+	if err != nil {
+		return fmt.Errorf("failed to list interfaces: %w", err)
+	}
+	var errs []error
+	for _, link := range links {
+		if _, ok := link.(*netlink.Vlan); !ok || !strings.HasPrefix(link.Attrs().Alias, aliasPrefix) {
+			continue
+		}
+		if _, ok := wanted[link.Attrs().Name]; ok {
+			continue
+		}
+		// the routes of the table are deleted with the interface
+		if err := m.nl.LinkDel(link); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete the VLAN sub-interface %s: %w", link.Attrs().Name, err))
+			continue
+		}
+		m.log.Info("deleted the VLAN sub-interface", "interface", link.Attrs().Name)
+	}
+	return errors.Join(errs...)
+}
+
+// desiredRules returns the policy rules of the wanted VLANs.
+func (m *Manager) desiredRules(wanted map[string]*VLAN) []netlink.Rule {
+	var rules []netlink.Rule
+	for _, v := range wanted {
+		for _, family := range v.families() {
+			rule := netlink.NewRule()
+			rule.Family = family
+			rule.Table = m.Table(v.ID)
+			rule.Mark = int(m.Mark(v.ID))
+			rules = append(rules, *rule)
+		}
+		for _, eip := range v.EIPs {
+			rule := netlink.NewRule()
+			rule.Family = netlink.FAMILY_V4
+			rule.Src = &net.IPNet{IP: eip.To4(), Mask: net.CIDRMask(32, 32)}
+			if eip.To4() == nil {
+				rule.Family = netlink.FAMILY_V6
+				rule.Src = &net.IPNet{IP: eip, Mask: net.CIDRMask(128, 128)}
+			}
+			rule.Table = m.Table(v.ID)
+			rules = append(rules, *rule)
+		}
+	}
+	return rules
+}
+
+func ruleKey(rule netlink.Rule) string {
+	src := ""
+	if rule.Src != nil {
+		src = rule.Src.String()
+	}
+	return fmt.Sprintf("%d/%d/%d/%s", rule.Family, rule.Table, rule.Mark, src)
+}
+
+// syncRules adds the wanted rules and deletes the other rules of the VLAN tables.
+func (m *Manager) syncRules(wanted map[string]*VLAN) error {
+	desired := make(map[string]netlink.Rule)
+	for _, rule := range m.desiredRules(wanted) {
+		desired[ruleKey(rule)] = rule
+	}
+
+	var errs []error
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		rules, err := m.nl.RuleList(family)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to list rules: %w", err))
+			continue
+		}
+		for _, rule := range rules {
+			if rule.Table < m.tableBase || rule.Table > m.tableBase+maxID {
+				continue
+			}
+			rule.Family = family
+			key := ruleKey(rule)
+			if _, ok := desired[key]; ok {
+				delete(desired, key)
+				continue
+			}
+			if err := m.nl.RuleDel(&rule); err != nil {
+				errs = append(errs, fmt.Errorf("failed to delete rule %s: %w", rule.String(), err))
+			}
+		}
+	}
+
+	for _, rule := range desired {
+		rule := rule
+		if err := m.nl.RuleAdd(&rule); err != nil {
+			errs = append(errs, fmt.Errorf("failed to add rule %s: %w", rule.String(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
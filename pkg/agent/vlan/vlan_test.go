@@ -0,0 +1,205 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package vlan
+
+import (
+	"net"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+)
+
+// fakeNetLink keeps the interfaces, addresses, routes and rules in memory
+type fakeNetLink struct {
+	links  map[string]netlink.Link
+	addrs  map[string][]string
+	routes map[int][]netlink.Route
+	rules  []netlink.Rule
+	index  int
+}
+
+func newFakeNetLink() *fakeNetLink {
+	f := &fakeNetLink{
+		links:  map[string]netlink.Link{},
+		addrs:  map[string][]string{},
+		routes: map[int][]netlink.Route{},
+		index:  1,
+	}
+	f.add(&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Flags: net.FlagUp}})
+	return f
+}
+
+func (f *fakeNetLink) add(link netlink.Link) {
+	f.index++
+	link.Attrs().Index = f.index
+	f.links[link.Attrs().Name] = link
+}
+
+func (f *fakeNetLink) netLink() NetLink {
+	return NetLink{
+		LinkByName: func(name string) (netlink.Link, error) {
+			link, ok := f.links[name]
+			if !ok {
+				return nil, netlink.LinkNotFoundError{}
+			}
+			return link, nil
+		},
+		LinkList: func() ([]netlink.Link, error) {
+			var res []netlink.Link
+			for _, link := range f.links {
+				res = append(res, link)
+			}
+			return res, nil
+		},
+		LinkAdd: func(link netlink.Link) error {
+			f.add(link)
+			return nil
+		},
+		LinkDel: func(link netlink.Link) error {
+			delete(f.links, link.Attrs().Name)
+			delete(f.addrs, link.Attrs().Name)
+			for table, routes := range f.routes {
+				if len(routes) > 0 && routes[0].LinkIndex == link.Attrs().Index {
+					delete(f.routes, table)
+				}
+			}
+			return nil
+		},
+		LinkSetUp: func(link netlink.Link) error {
+			link.Attrs().Flags |= net.FlagUp
+			return nil
+		},
+		LinkSetAlias: func(link netlink.Link, name string) error {
+			link.Attrs().Alias = name
+			return nil
+		},
+		AddrReplace: func(link netlink.Link, addr *netlink.Addr) error {
+			f.addrs[link.Attrs().Name] = append(f.addrs[link.Attrs().Name], addr.IPNet.String())
+			return nil
+		},
+		RouteReplace: func(route *netlink.Route) error {
+			routes := f.routes[route.Table]
+			for i := range routes {
+				if routes[i].Family == route.Family {
+					routes[i] = *route
+					return nil
+				}
+			}
+			f.routes[route.Table] = append(routes, *route)
+			return nil
+		},
+		RuleList: func(family int) ([]netlink.Rule, error) {
+			var res []netlink.Rule
+			for _, rule := range f.rules {
+				if rule.Family == family {
+					// the kernel does not return the family in the rule
+					rule.Family = 0
+					res = append(res, rule)
+				}
+			}
+			return res, nil
+		},
+		RuleAdd: func(rule *netlink.Rule) error {
+			f.rules = append(f.rules, *rule)
+			return nil
+		},
+		RuleDel: func(rule *netlink.Rule) error {
+			for i := range f.rules {
+				if ruleKey(f.rules[i]) == ruleKey(*rule) {
+					f.rules = append(f.rules[:i], f.rules[i+1:]...)
+					return nil
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func (f *fakeNetLink) ruleKeys() []string {
+	var res []string
+	for _, rule := range f.rules {
+		res = append(res, ruleKey(rule))
+	}
+	sort.Strings(res)
+	return res
+}
+
+func TestName(t *testing.T) {
+	assert.Equal(t, "eth0.300", Name("eth0", 300))
+	assert.Equal(t, "egw.300", Name("enp0s31f6np0", 300))
+}
+
+func TestManager(t *testing.T) {
+	fake := newFakeNetLink()
+	m := newManager(logger.NewLogger(logger.Config{}), fake.netLink(), 5000, 0x28000000)
+
+	v := &VLAN{
+		Parent:      "eth0",
+		ID:          300,
+		Addresses:   []string{"10.30.0.11/24"},
+		IPv4Gateway: net.ParseIP("10.30.0.1"),
+		EIPs:        []net.IP{net.ParseIP("10.30.0.100")},
+	}
+	assert.NoError(t, m.Set("egw1", v))
+
+	link, ok := fake.links["eth0.300"].(*netlink.Vlan)
+	assert.True(t, ok)
+	assert.Equal(t, 300, link.VlanId)
+	assert.Equal(t, fake.links["eth0"].Attrs().Index, link.ParentIndex)
+	assert.Equal(t, "egressgateway:eth0", link.Alias)
+	assert.NotZero(t, link.Flags&net.FlagUp)
+	assert.Equal(t, []string{"10.30.0.11/24"}, fake.addrs["eth0.300"])
+
+	// only the IPv4 family is routed
+	assert.Len(t, fake.routes[5300], 1)
+	assert.Equal(t, "10.30.0.1", fake.routes[5300][0].Gw.String())
+	assert.Equal(t, []string{
+		"2/5300/-1/10.30.0.100/32",
+		"2/5300/671088940/",
+	}, fake.ruleKeys())
+
+	// a second gateway on the same VLAN shares the sub-interface
+	assert.NoError(t, m.Set("egw2", &VLAN{Parent: "eth0", ID: 300, EIPs: []net.IP{net.ParseIP("fd00::100")}}))
+	assert.Len(t, fake.routes[5300], 2)
+	assert.Equal(t, []string{
+		"10/5300/-1/fd00::100/128",
+		"10/5300/671088940/",
+		"2/5300/-1/10.30.0.100/32",
+		"2/5300/671088940/",
+	}, fake.ruleKeys())
+
+	// the EIP moved to another node
+	v.EIPs = nil
+	assert.NoError(t, m.Set("egw1", v))
+	assert.Equal(t, []string{
+		"10/5300/-1/fd00::100/128",
+		"10/5300/671088940/",
+		"2/5300/671088940/",
+	}, fake.ruleKeys())
+
+	// the sub-interface is deleted with the last gateway
+	assert.NoError(t, m.Set("egw1", nil))
+	assert.NoError(t, m.Set("egw2", nil))
+	assert.NotContains(t, fake.links, "eth0.300")
+	assert.Empty(t, fake.rules)
+}
+
+func TestManagerKeepAdminInterface(t *testing.T) {
+	fake := newFakeNetLink()
+	fake.add(&netlink.Vlan{LinkAttrs: netlink.LinkAttrs{Name: "eth0.300", Flags: net.FlagUp}, VlanId: 300})
+	// a rule which is not in the VLAN tables
+	fake.rules = append(fake.rules, netlink.Rule{Family: netlink.FAMILY_V4, Table: 600, Mark: 39, Priority: -1, Mask: -1})
+	m := newManager(logger.NewLogger(logger.Config{}), fake.netLink(), 5000, 0x28000000)
+
+	assert.NoError(t, m.Set("egw1", &VLAN{Parent: "eth0", ID: 300, EIPs: []net.IP{net.ParseIP("10.30.0.100")}}))
+	assert.Equal(t, "", fake.links["eth0.300"].Attrs().Alias)
+
+	assert.NoError(t, m.Set("egw1", nil))
+	assert.Contains(t, fake.links, "eth0.300")
+	assert.Equal(t, []string{"2/600/39/"}, fake.ruleKeys())
+}
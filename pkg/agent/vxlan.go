@@ -51,6 +51,8 @@ type vxlanReconciler struct {
 	ruleRouteCache *utils.SyncMap[string, []net.IP]
 
 	updateTimer *time.Timer
+	// status publishes the state of the peers in the EgressAgentStatus
+	status *datapathStatus
 }
 
 type VTEP struct {
@@ -716,6 +718,7 @@ func (r *vxlanReconciler) keepVXLAN() {
 		err = r.vxlan.EnsureLink(name, vni, port, mac, 0, ipv4, ipv6, disableChecksumOffload)
 		if err != nil {
 			r.log.Error(err, "ensure vxlan link")
+			r.status.RecordError(egressv1.ComponentVXLAN, err)
 			reduce = false
 			time.Sleep(time.Second)
 			continue
@@ -726,6 +729,7 @@ func (r *vxlanReconciler) keepVXLAN() {
 		err = r.ensureRoute()
 		if err != nil {
 			r.log.Error(err, "ensure route")
+			r.status.RecordError(egressv1.ComponentFDB, err)
 			reduce = false
 			time.Sleep(time.Second)
 			continue
@@ -745,6 +749,7 @@ func (r *vxlanReconciler) keepVXLAN() {
 				err = r.ruleRoute.Ensure(r.cfg.FileConfig.VXLAN.Name, val.IPv4, val.IPv6, val.Mark, val.Mark)
 				if err != nil {
 					r.log.Error(err, "ensure vxlan link with error")
+					r.status.RecordError(egressv1.ComponentRoute, err)
 					reduce = false
 				}
 			}
@@ -753,6 +758,7 @@ func (r *vxlanReconciler) keepVXLAN() {
 		err = r.ruleRoute.PurgeStaleRules(markMap, r.cfg.FileConfig.Mark)
 		if err != nil {
 			r.log.Error(err, "purge stale rules error")
+			r.status.RecordError(egressv1.ComponentRoute, err)
 			reduce = false
 		}
 
@@ -772,6 +778,7 @@ func (r *vxlanReconciler) updateTunnelStatus(tunnel *egressv1.EgressTunnel) erro
 	defer cancel()
 
 	tunnel.Status.LastHeartbeatTime = metav1.Now()
+	tunnel.Status.SetReadyCondition(tunnel.Generation)
 	r.log.Info("update tunnel status",
 		"phase", tunnel.Status.Phase,
 		"tunnelIPv4", tunnel.Status.Tunnel.IPv4,
@@ -817,12 +824,23 @@ func (r *vxlanReconciler) ensureRoute() error {
 		}
 	}
 
-	for _, peer := range peerMap {
+	peers := make([]egressv1.TunnelPeer, 0, len(peerMap))
+	for node, peer := range peerMap {
+		item := egressv1.TunnelPeer{Node: node, Programmed: true}
 		err := r.vxlan.Add(peer)
 		if err != nil {
 			r.log.Error(err, "add peer route", "peer", peer)
+			r.status.RecordError(egressv1.ComponentFDB, fmt.Errorf("add peer %s: %v", node, err))
+			item.Programmed = false
+			item.Message = err.Error()
+		} else if err := vxlan.Reachable(peer.Parent); err != nil {
+			item.Message = err.Error()
+		} else {
+			item.Reachable = true
 		}
+		peers = append(peers, item)
 	}
+	r.status.SetPeers(peers)
 
 	return nil
 }
@@ -881,7 +899,7 @@ func parseMarkToInt(mark string) (int, error) {
 	return i32, nil
 }
 
-func newEgressTunnelController(mgr manager.Manager, cfg *config.Config, log logr.Logger) error {
+func newEgressTunnelController(mgr manager.Manager, cfg *config.Config, log logr.Logger, status *datapathStatus, debug *datapathDebug) error {
 	ruleRoute := route.NewRuleRoute(log)
 
 	r := &vxlanReconciler{
@@ -893,7 +911,9 @@ func newEgressTunnelController(mgr manager.Manager, cfg *config.Config, log logr
 		ruleRoute:      ruleRoute,
 		ruleRouteCache: utils.NewSyncMap[string, []net.IP](),
 		updateTimer:    time.NewTimer(time.Second * time.Duration(cfg.FileConfig.GatewayFailover.TunnelUpdatePeriod)),
+		status:         status,
 	}
+	debug.tunnel = r
 
 	netLink := vxlan.NetLink{
 		RouteListFiltered: netlink.RouteListFiltered,
@@ -179,6 +179,28 @@ func (dev *Device) ListNeigh() ([]netlink.Neigh, error) {
 	return existingNeigh, nil
 }
 
+// Neighbours returns the ARP/NDP entries and the FDB entries of the device
+func (dev *Device) Neighbours() (neigh []netlink.Neigh, fdb []netlink.Neigh, err error) {
+	dev.lock.RLock()
+	defer dev.lock.RUnlock()
+
+	if dev.notReady() {
+		return nil, nil, nil
+	}
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		items, err := netlink.NeighList(dev.link.Index, family)
+		if err != nil {
+			return nil, nil, err
+		}
+		neigh = append(neigh, items...)
+	}
+	fdb, err = netlink.NeighList(dev.link.Index, syscall.AF_BRIDGE)
+	if err != nil {
+		return nil, nil, err
+	}
+	return neigh, fdb, nil
+}
+
 func (dev *Device) Add(peer Peer) error {
 	dev.lock.RLock()
 	defer dev.lock.RUnlock()
@@ -252,6 +274,36 @@ func (dev *Device) Del(neigh netlink.Neigh) error {
 	return nil
 }
 
+// Reachable checks the underlay path to the parent of a peer, the parent must have
+// a route and the next hop of the route must not be a failed neighbor.
+func Reachable(parent net.IP) error {
+	routes, err := netlink.RouteGet(parent)
+	if err != nil {
+		return fmt.Errorf("no route to %s: %v", parent, err)
+	}
+	if len(routes) == 0 {
+		return fmt.Errorf("no route to %s", parent)
+	}
+	next := routes[0].Gw
+	if next == nil {
+		next = parent
+	}
+	family := netlink.FAMILY_V4
+	if next.To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+	neighs, err := netlink.NeighList(routes[0].LinkIndex, family)
+	if err != nil {
+		return err
+	}
+	for _, neigh := range neighs {
+		if neigh.IP.Equal(next) && neigh.State&(netlink.NUD_FAILED|netlink.NUD_INCOMPLETE) != 0 {
+			return fmt.Errorf("next hop %s of %s is unreachable", next, parent)
+		}
+	}
+	return nil
+}
+
 type conflictAttr struct {
 	name string
 	got  interface{}
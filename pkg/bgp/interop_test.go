@@ -0,0 +1,231 @@
+//go:build interop
+// +build interop
+
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// The interop test runs the speaker against an external GoBGP, gobgpd and gobgp
+// have to be in the PATH:
+//
+//	go install github.com/osrg/gobgp/v3/cmd/...@v3.20.0
+//	go test -tags interop ./pkg/bgp/
+
+package bgp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+)
+
+// gobgpPath is the part of a route received by GoBGP the test checks.
+type gobgpPath struct {
+	prefix      string
+	asPath      []uint32
+	nextHop     string
+	localPref   *uint32
+	communities []uint32
+}
+
+// gobgpAttr is a path attribute in the JSON output of the gobgp CLI.
+type gobgpAttr struct {
+	Type    uint8 `json:"type"`
+	ASPaths []struct {
+		ASNs []uint32 `json:"asns"`
+	} `json:"as_paths"`
+	NextHop     string   `json:"nexthop"`
+	Value       uint32   `json:"value"`
+	Communities []uint32 `json:"communities"`
+}
+
+// gobgpPeer is a passive gobgpd process.
+type gobgpPeer struct {
+	cmd     *exec.Cmd
+	apiPort int
+}
+
+func freePort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// startGoBGP starts a passive gobgpd listening on a free local port.
+func startGoBGP(t *testing.T, asn, peerASN uint32) (*gobgpPeer, int) {
+	for _, bin := range []string{"gobgpd", "gobgp"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s is not found: %v", bin, err)
+		}
+	}
+
+	port := freePort(t)
+	conf := fmt.Sprintf(`[global.config]
+  as = %d
+  router-id = "10.0.0.254"
+  port = %d
+  local-address-list = ["127.0.0.1"]
+
+[[neighbors]]
+  [neighbors.config]
+    neighbor-address = "127.0.0.1"
+    peer-as = %d
+  [neighbors.transport.config]
+    passive-mode = true
+`, asn, port, peerASN)
+	file := filepath.Join(t.TempDir(), "gobgpd.toml")
+	if err := os.WriteFile(file, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &gobgpPeer{apiPort: freePort(t)}
+	p.cmd = exec.Command("gobgpd", "-t", "toml", "-f", file,
+		"--api-hosts", "127.0.0.1:"+strconv.Itoa(p.apiPort), "--log-level", "error")
+	if err := p.cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Eventually(t, func() bool {
+		return exec.Command("gobgp", "-u", "127.0.0.1", "-p", strconv.Itoa(p.apiPort), "global").Run() == nil
+	}, 10*time.Second, 100*time.Millisecond)
+	return p, port
+}
+
+func (p *gobgpPeer) Stop() {
+	_ = p.cmd.Process.Kill()
+	_ = p.cmd.Wait()
+}
+
+// listGoBGP returns the IPv4 unicast routes in the global RIB of GoBGP.
+func listGoBGP(t *testing.T, p *gobgpPeer) []gobgpPath {
+	out, err := exec.Command("gobgp", "-u", "127.0.0.1", "-p", strconv.Itoa(p.apiPort),
+		"global", "rib", "-a", "ipv4", "-j").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rib := make(map[string][]struct {
+		Attrs []gobgpAttr `json:"attrs"`
+	})
+	if err := json.Unmarshal(out, &rib); err != nil {
+		t.Fatalf("failed to decode %q: %v", out, err)
+	}
+
+	var res []gobgpPath
+	for prefix, paths := range rib {
+		for _, p := range paths {
+			path := gobgpPath{prefix: prefix}
+			for _, attr := range p.Attrs {
+				switch attr.Type {
+				case attrASPath:
+					for _, param := range attr.ASPaths {
+						path.asPath = append(path.asPath, param.ASNs...)
+					}
+				case attrNextHop:
+					path.nextHop = attr.NextHop
+				case attrLocalPref:
+					pref := attr.Value
+					path.localPref = &pref
+				case attrCommunities:
+					path.communities = attr.Communities
+				}
+			}
+			res = append(res, path)
+		}
+	}
+	return res
+}
+
+// TestGoBGPInterop checks the announcements are accepted by GoBGP.
+func TestGoBGPInterop(t *testing.T) {
+	pref := uint32(150)
+	prefix := netip.MustParsePrefix("10.6.1.21/32")
+
+	cases := map[string]struct {
+		asn     uint32
+		peerASN uint32
+		exp     gobgpPath
+	}{
+		"ibgp": {
+			asn:     65000,
+			peerASN: 65000,
+			exp: gobgpPath{
+				prefix:      prefix.String(),
+				nextHop:     "127.0.0.1",
+				localPref:   &pref,
+				communities: []uint32{65000<<16 | 100},
+			},
+		},
+		"ebgp": {
+			asn:     65000,
+			peerASN: 65001,
+			exp: gobgpPath{
+				prefix:      prefix.String(),
+				asPath:      []uint32{65000},
+				nextHop:     "127.0.0.1",
+				communities: []uint32{65000<<16 | 100},
+			},
+		},
+		"ebgp four octet asn": {
+			asn:     4200000001,
+			peerASN: 65001,
+			exp: gobgpPath{
+				prefix:      prefix.String(),
+				asPath:      []uint32{4200000001},
+				nextHop:     "127.0.0.1",
+				communities: []uint32{65000<<16 | 100},
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			peer, port := startGoBGP(t, c.peerASN, c.asn)
+			defer peer.Stop()
+
+			s, err := New(logger.NewLogger(logger.Config{}), Config{
+				ASN:          c.asn,
+				RouterID:     netip.MustParseAddr("10.0.0.1"),
+				ConnectRetry: time.Second,
+				Peers:        []PeerConfig{{Address: netip.MustParseAddr("127.0.0.1"), Port: port, ASN: c.peerASN}},
+			})
+			if !assert.NoError(t, err) {
+				return
+			}
+			s.SetRoutes("egw1", []Route{{Prefix: prefix, Communities: []uint32{65000<<16 | 100}, LocalPref: &pref}})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go func() {
+				_ = s.Start(ctx)
+			}()
+
+			var paths []gobgpPath
+			assert.Eventually(t, func() bool {
+				paths = listGoBGP(t, peer)
+				return len(paths) == 1
+			}, 10*time.Second, 100*time.Millisecond)
+			if len(paths) == 1 {
+				assert.Equal(t, c.exp, paths[0])
+			}
+
+			// failover
+			s.DeleteRoutes("egw1")
+			assert.Eventually(t, func() bool {
+				return len(listGoBGP(t, peer)) == 0
+			}, 10*time.Second, 100*time.Millisecond)
+		})
+	}
+}
@@ -0,0 +1,468 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package bgp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// message types, RFC 4271 section 4.1
+const (
+	msgOpen         uint8 = 1
+	msgUpdate       uint8 = 2
+	msgNotification uint8 = 3
+	msgKeepalive    uint8 = 4
+)
+
+// path attribute types
+const (
+	attrOrigin        uint8 = 1
+	attrASPath        uint8 = 2
+	attrNextHop       uint8 = 3
+	attrLocalPref     uint8 = 5
+	attrCommunities   uint8 = 8
+	attrMPReachNLRI   uint8 = 14
+	attrMPUnreachNLRI uint8 = 15
+	attrAS4Path       uint8 = 17
+)
+
+// path attribute flags
+const (
+	flagOptional   uint8 = 0x80
+	flagTransitive uint8 = 0x40
+	flagExtended   uint8 = 0x10
+)
+
+// capability codes
+const (
+	capMultiProtocol uint8 = 1
+	capFourOctetASN  uint8 = 65
+)
+
+const (
+	afiIPv4       uint16 = 1
+	afiIPv6       uint16 = 2
+	safiUnicast   uint8  = 1
+	asTrans       uint16 = 23456
+	headerLen            = 19
+	maxMessageLen        = 4096
+	bgpVersion    uint8  = 4
+)
+
+// notification error codes
+const (
+	errOpenMessage      uint8 = 2
+	errHoldTimerExpired uint8 = 4
+	errCease            uint8 = 6
+)
+
+// open is a decoded OPEN message.
+type open struct {
+	asn      uint32
+	holdTime uint16
+	routerID netip.Addr
+	// fourOctetASN is true when the four-octet AS number capability is advertised
+	fourOctetASN bool
+}
+
+// update is a decoded UPDATE message, only the parts the speaker sends are decoded.
+type update struct {
+	withdrawn   []netip.Prefix
+	nlri        []netip.Prefix
+	asPath      []uint32
+	nextHop     netip.Addr
+	localPref   *uint32
+	communities []uint32
+}
+
+// notification is a decoded NOTIFICATION message.
+type notification struct {
+	code    uint8
+	subcode uint8
+}
+
+func (n notification) Error() string {
+	return fmt.Sprintf("bgp notification code %d subcode %d", n.code, n.subcode)
+}
+
+// ParseCommunity parses a community in the "asn:value" format.
+func ParseCommunity(s string) (uint32, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid community %q, the format is asn:value", s)
+	}
+	high, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid community %q: %w", s, err)
+	}
+	low, err := strconv.ParseUint(parts[1], 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid community %q: %w", s, err)
+	}
+	return uint32(high)<<16 | uint32(low), nil
+}
+
+func encodeMessage(typ uint8, body []byte) []byte {
+	msg := make([]byte, headerLen, headerLen+len(body))
+	for i := 0; i < 16; i++ {
+		msg[i] = 0xff
+	}
+	binary.BigEndian.PutUint16(msg[16:], uint16(headerLen+len(body)))
+	msg[18] = typ
+	return append(msg, body...)
+}
+
+// readMessage reads one message and returns its type and body.
+func readMessage(r io.Reader) (uint8, []byte, error) {
+	header := make([]byte, headerLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	for i := 0; i < 16; i++ {
+		if header[i] != 0xff {
+			return 0, nil, errors.New("bgp message marker is invalid")
+		}
+	}
+	length := int(binary.BigEndian.Uint16(header[16:]))
+	if length < headerLen || length > maxMessageLen {
+		return 0, nil, fmt.Errorf("bgp message length %d is invalid", length)
+	}
+	body := make([]byte, length-headerLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return header[18], body, nil
+}
+
+func encodeOpen(o open) []byte {
+	myAS := asTrans
+	if o.asn <= 0xffff {
+		myAS = uint16(o.asn)
+	}
+
+	var caps []byte
+	for _, afi := range []uint16{afiIPv4, afiIPv6} {
+		caps = append(caps, capMultiProtocol, 4, byte(afi>>8), byte(afi), 0, safiUnicast)
+	}
+	caps = append(caps, capFourOctetASN, 4)
+	caps = binary.BigEndian.AppendUint32(caps, o.asn)
+
+	body := []byte{bgpVersion}
+	body = binary.BigEndian.AppendUint16(body, myAS)
+	body = binary.BigEndian.AppendUint16(body, o.holdTime)
+	id := o.routerID.As4()
+	body = append(body, id[:]...)
+	// a single capabilities optional parameter
+	body = append(body, byte(len(caps)+2), 2, byte(len(caps)))
+	body = append(body, caps...)
+	return encodeMessage(msgOpen, body)
+}
+
+func decodeOpen(body []byte) (open, error) {
+	var o open
+	if len(body) < 10 {
+		return o, errors.New("bgp open message is too short")
+	}
+	if body[0] != bgpVersion {
+		return o, fmt.Errorf("unsupported bgp version %d", body[0])
+	}
+	o.asn = uint32(binary.BigEndian.Uint16(body[1:]))
+	o.holdTime = binary.BigEndian.Uint16(body[3:])
+	o.routerID = netip.AddrFrom4([4]byte(body[5:9]))
+
+	params := body[10:]
+	if len(params) != int(body[9]) {
+		return o, errors.New("bgp open optional parameters length is invalid")
+	}
+	for len(params) >= 2 {
+		typ, l := params[0], int(params[1])
+		if len(params) < 2+l {
+			return o, errors.New("bgp open optional parameter is truncated")
+		}
+		value := params[2 : 2+l]
+		params = params[2+l:]
+		if typ != 2 {
+			continue
+		}
+		for len(value) >= 2 {
+			code, cl := value[0], int(value[1])
+			if len(value) < 2+cl {
+				return o, errors.New("bgp capability is truncated")
+			}
+			if code == capFourOctetASN && cl == 4 {
+				o.fourOctetASN = true
+				o.asn = binary.BigEndian.Uint32(value[2:6])
+			}
+			value = value[2+cl:]
+		}
+	}
+	return o, nil
+}
+
+func encodeNotification(code, subcode uint8) []byte {
+	return encodeMessage(msgNotification, []byte{code, subcode})
+}
+
+func decodeNotification(body []byte) notification {
+	var n notification
+	if len(body) >= 2 {
+		n.code, n.subcode = body[0], body[1]
+	}
+	return n
+}
+
+func encodePrefix(p netip.Prefix) []byte {
+	bits := p.Bits()
+	addr := p.Addr().AsSlice()
+	return append([]byte{byte(bits)}, addr[:(bits+7)/8]...)
+}
+
+func decodePrefixes(b []byte, ipv6 bool) ([]netip.Prefix, error) {
+	var res []netip.Prefix
+	for len(b) > 0 {
+		bits := int(b[0])
+		n := (bits + 7) / 8
+		if len(b) < 1+n {
+			return nil, errors.New("bgp prefix is truncated")
+		}
+		var addr netip.Addr
+		if ipv6 {
+			var a [16]byte
+			copy(a[:], b[1:1+n])
+			addr = netip.AddrFrom16(a)
+		} else {
+			var a [4]byte
+			copy(a[:], b[1:1+n])
+			addr = netip.AddrFrom4(a)
+		}
+		p, err := addr.Prefix(bits)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, p)
+		b = b[1+n:]
+	}
+	return res, nil
+}
+
+func appendAttr(b []byte, flags, typ uint8, value []byte) []byte {
+	if len(value) > 0xff {
+		b = append(b, flags|flagExtended, typ)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(value)))
+	} else {
+		b = append(b, flags, typ, byte(len(value)))
+	}
+	return append(b, value...)
+}
+
+// encodeAnnounce builds the UPDATE message announcing a route. The AS path holds the
+// local AS for eBGP peers, it is empty for iBGP peers.
+func encodeAnnounce(route Route, asPath []uint32, fourOctetASN bool, nextHop netip.Addr) []byte {
+	var attrs []byte
+	attrs = appendAttr(attrs, flagTransitive, attrOrigin, []byte{0})
+
+	attrs = appendAttr(attrs, flagTransitive, attrASPath, encodeASPath(asPath, fourOctetASN))
+	// a 2-byte peer sees AS_TRANS for the 4-byte ASNs, the real path is carried in
+	// AS4_PATH, RFC 6793 section 4.2.2
+	if !fourOctetASN {
+		for _, asn := range asPath {
+			if asn > 0xffff {
+				attrs = appendAttr(attrs, flagOptional|flagTransitive, attrAS4Path, encodeASPath(asPath, true))
+				break
+			}
+		}
+	}
+
+	if route.Prefix.Addr().Is4() {
+		attrs = appendAttr(attrs, flagTransitive, attrNextHop, nextHop.AsSlice())
+	}
+	if route.LocalPref != nil {
+		attrs = appendAttr(attrs, flagTransitive, attrLocalPref, binary.BigEndian.AppendUint32(nil, *route.LocalPref))
+	}
+	if len(route.Communities) != 0 {
+		var value []byte
+		for _, c := range route.Communities {
+			value = binary.BigEndian.AppendUint32(value, c)
+		}
+		attrs = appendAttr(attrs, flagOptional|flagTransitive, attrCommunities, value)
+	}
+
+	var nlri []byte
+	if route.Prefix.Addr().Is4() {
+		nlri = encodePrefix(route.Prefix)
+	} else {
+		value := binary.BigEndian.AppendUint16(nil, afiIPv6)
+		value = append(value, safiUnicast, 16)
+		value = append(value, nextHop.AsSlice()...)
+		value = append(value, 0)
+		value = append(value, encodePrefix(route.Prefix)...)
+		attrs = appendAttr(attrs, flagOptional, attrMPReachNLRI, value)
+	}
+
+	body := binary.BigEndian.AppendUint16(nil, 0)
+	body = binary.BigEndian.AppendUint16(body, uint16(len(attrs)))
+	body = append(body, attrs...)
+	body = append(body, nlri...)
+	return encodeMessage(msgUpdate, body)
+}
+
+// encodeASPath encodes the AS path as a single AS_SEQUENCE segment.
+func encodeASPath(asPath []uint32, fourOctetASN bool) []byte {
+	if len(asPath) == 0 {
+		return nil
+	}
+	path := []byte{2, byte(len(asPath))}
+	for _, asn := range asPath {
+		if fourOctetASN {
+			path = binary.BigEndian.AppendUint32(path, asn)
+		} else if asn > 0xffff {
+			path = binary.BigEndian.AppendUint16(path, asTrans)
+		} else {
+			path = binary.BigEndian.AppendUint16(path, uint16(asn))
+		}
+	}
+	return path
+}
+
+func decodeASPath(value []byte, fourOctetASN bool) []uint32 {
+	size := 2
+	if fourOctetASN {
+		size = 4
+	}
+	var path []uint32
+	for len(value) >= 2 {
+		n := int(value[1])
+		value = value[2:]
+		for i := 0; i < n && len(value) >= size; i++ {
+			if size == 4 {
+				path = append(path, binary.BigEndian.Uint32(value))
+			} else {
+				path = append(path, uint32(binary.BigEndian.Uint16(value)))
+			}
+			value = value[size:]
+		}
+	}
+	return path
+}
+
+// encodeWithdraw builds the UPDATE message withdrawing a route.
+func encodeWithdraw(p netip.Prefix) []byte {
+	if p.Addr().Is4() {
+		withdrawn := encodePrefix(p)
+		body := binary.BigEndian.AppendUint16(nil, uint16(len(withdrawn)))
+		body = append(body, withdrawn...)
+		body = binary.BigEndian.AppendUint16(body, 0)
+		return encodeMessage(msgUpdate, body)
+	}
+
+	value := binary.BigEndian.AppendUint16(nil, afiIPv6)
+	value = append(value, safiUnicast)
+	value = append(value, encodePrefix(p)...)
+	attrs := appendAttr(nil, flagOptional, attrMPUnreachNLRI, value)
+	body := binary.BigEndian.AppendUint16(nil, 0)
+	body = binary.BigEndian.AppendUint16(body, uint16(len(attrs)))
+	body = append(body, attrs...)
+	return encodeMessage(msgUpdate, body)
+}
+
+func decodeUpdate(body []byte, fourOctetASN bool) (update, error) {
+	var u update
+	if len(body) < 4 {
+		return u, errors.New("bgp update message is too short")
+	}
+	wl := int(binary.BigEndian.Uint16(body))
+	if len(body) < 4+wl {
+		return u, errors.New("bgp update withdrawn routes are truncated")
+	}
+	var err error
+	if u.withdrawn, err = decodePrefixes(body[2:2+wl], false); err != nil {
+		return u, err
+	}
+	rest := body[2+wl:]
+	al := int(binary.BigEndian.Uint16(rest))
+	if len(rest) < 2+al {
+		return u, errors.New("bgp update path attributes are truncated")
+	}
+	attrs := rest[2 : 2+al]
+	if u.nlri, err = decodePrefixes(rest[2+al:], false); err != nil {
+		return u, err
+	}
+
+	var as4Path []uint32
+	for len(attrs) >= 3 {
+		flags, typ := attrs[0], attrs[1]
+		var l, off int
+		if flags&flagExtended != 0 {
+			if len(attrs) < 4 {
+				return u, errors.New("bgp path attribute is truncated")
+			}
+			l, off = int(binary.BigEndian.Uint16(attrs[2:])), 4
+		} else {
+			l, off = int(attrs[2]), 3
+		}
+		if len(attrs) < off+l {
+			return u, errors.New("bgp path attribute is truncated")
+		}
+		value := attrs[off : off+l]
+		attrs = attrs[off+l:]
+
+		switch typ {
+		case attrASPath:
+			u.asPath = decodeASPath(value, fourOctetASN)
+		case attrAS4Path:
+			if !fourOctetASN {
+				as4Path = decodeASPath(value, true)
+			}
+		case attrNextHop:
+			if addr, ok := netip.AddrFromSlice(value); ok {
+				u.nextHop = addr
+			}
+		case attrLocalPref:
+			if len(value) == 4 {
+				pref := binary.BigEndian.Uint32(value)
+				u.localPref = &pref
+			}
+		case attrCommunities:
+			for ; len(value) >= 4; value = value[4:] {
+				u.communities = append(u.communities, binary.BigEndian.Uint32(value))
+			}
+		case attrMPReachNLRI:
+			if len(value) < 5 || binary.BigEndian.Uint16(value) != afiIPv6 {
+				continue
+			}
+			nhl := int(value[3])
+			if len(value) < 5+nhl {
+				return u, errors.New("bgp mp_reach_nlri is truncated")
+			}
+			if addr, ok := netip.AddrFromSlice(value[4 : 4+nhl]); ok {
+				u.nextHop = addr
+			}
+			prefixes, err := decodePrefixes(value[5+nhl:], true)
+			if err != nil {
+				return u, err
+			}
+			u.nlri = append(u.nlri, prefixes...)
+		case attrMPUnreachNLRI:
+			if len(value) < 3 || binary.BigEndian.Uint16(value) != afiIPv6 {
+				continue
+			}
+			prefixes, err := decodePrefixes(value[3:], true)
+			if err != nil {
+				return u, err
+			}
+			u.withdrawn = append(u.withdrawn, prefixes...)
+		}
+	}
+	// merge AS4_PATH into AS_PATH, RFC 6793 section 4.2.3
+	if len(as4Path) != 0 && len(as4Path) <= len(u.asPath) {
+		copy(u.asPath[len(u.asPath)-len(as4Path):], as4Path)
+	}
+	return u, nil
+}
@@ -0,0 +1,188 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package bgp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpen(t *testing.T) {
+	cases := map[string]struct {
+		open open
+	}{
+		"two octet asn": {
+			open: open{asn: 65001, holdTime: 90, routerID: netip.MustParseAddr("10.0.0.1"), fourOctetASN: true},
+		},
+		"four octet asn": {
+			open: open{asn: 4200000001, holdTime: 30, routerID: netip.MustParseAddr("10.0.0.2"), fourOctetASN: true},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			typ, body, err := readMessage(bytes.NewReader(encodeOpen(c.open)))
+			assert.NoError(t, err)
+			assert.Equal(t, msgOpen, typ)
+			res, err := decodeOpen(body)
+			assert.NoError(t, err)
+			assert.Equal(t, c.open, res)
+		})
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	pref := uint32(200)
+	cases := map[string]struct {
+		msg          []byte
+		fourOctetASN bool
+		exp          update
+	}{
+		"announce ipv4": {
+			msg: encodeAnnounce(Route{
+				Prefix:      netip.MustParsePrefix("10.6.1.21/32"),
+				Communities: []uint32{65000<<16 | 100},
+				LocalPref:   &pref,
+			}, nil, true, netip.MustParseAddr("172.18.0.2")),
+			fourOctetASN: true,
+			exp: update{
+				nlri:        []netip.Prefix{netip.MustParsePrefix("10.6.1.21/32")},
+				nextHop:     netip.MustParseAddr("172.18.0.2"),
+				localPref:   &pref,
+				communities: []uint32{65000<<16 | 100},
+			},
+		},
+		"announce ipv4 ebgp two octet": {
+			msg: encodeAnnounce(Route{Prefix: netip.MustParsePrefix("10.6.1.21/32")},
+				[]uint32{65001}, false, netip.MustParseAddr("172.18.0.2")),
+			exp: update{
+				nlri:    []netip.Prefix{netip.MustParsePrefix("10.6.1.21/32")},
+				asPath:  []uint32{65001},
+				nextHop: netip.MustParseAddr("172.18.0.2"),
+			},
+		},
+		"announce ipv4 ebgp four octet asn to two octet peer": {
+			msg: encodeAnnounce(Route{Prefix: netip.MustParsePrefix("10.6.1.21/32")},
+				[]uint32{4200000001}, false, netip.MustParseAddr("172.18.0.2")),
+			exp: update{
+				nlri:    []netip.Prefix{netip.MustParsePrefix("10.6.1.21/32")},
+				asPath:  []uint32{4200000001},
+				nextHop: netip.MustParseAddr("172.18.0.2"),
+			},
+		},
+		"announce ipv6": {
+			msg: encodeAnnounce(Route{Prefix: netip.MustParsePrefix("fd00::21/128")},
+				[]uint32{65001}, true, netip.MustParseAddr("fc00::2")),
+			fourOctetASN: true,
+			exp: update{
+				nlri:    []netip.Prefix{netip.MustParsePrefix("fd00::21/128")},
+				asPath:  []uint32{65001},
+				nextHop: netip.MustParseAddr("fc00::2"),
+			},
+		},
+		"withdraw ipv4": {
+			msg: encodeWithdraw(netip.MustParsePrefix("10.6.1.21/32")),
+			exp: update{withdrawn: []netip.Prefix{netip.MustParsePrefix("10.6.1.21/32")}},
+		},
+		"withdraw ipv6": {
+			msg: encodeWithdraw(netip.MustParsePrefix("fd00::21/128")),
+			exp: update{withdrawn: []netip.Prefix{netip.MustParsePrefix("fd00::21/128")}},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			typ, body, err := readMessage(bytes.NewReader(c.msg))
+			assert.NoError(t, err)
+			assert.Equal(t, msgUpdate, typ)
+			res, err := decodeUpdate(body, c.fourOctetASN)
+			assert.NoError(t, err)
+			assert.Equal(t, c.exp, res)
+		})
+	}
+}
+
+// pathAttrs returns the value of each path attribute of an UPDATE message.
+func pathAttrs(t *testing.T, msg []byte) map[uint8][]byte {
+	_, body, err := readMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wl := int(binary.BigEndian.Uint16(body))
+	attrs := body[4+wl : 4+wl+int(binary.BigEndian.Uint16(body[2+wl:]))]
+	res := make(map[uint8][]byte)
+	for len(attrs) > 0 {
+		l, off := int(attrs[2]), 3
+		if attrs[0]&flagExtended != 0 {
+			l, off = int(binary.BigEndian.Uint16(attrs[2:])), 4
+		}
+		res[attrs[1]] = attrs[off : off+l]
+		attrs = attrs[off+l:]
+	}
+	return res
+}
+
+// TestAnnounceASPath checks the AS_PATH and AS4_PATH attributes on the wire,
+// a 2-byte peer only understands AS_TRANS in AS_PATH.
+func TestAnnounceASPath(t *testing.T) {
+	cases := map[string]struct {
+		asPath       []uint32
+		fourOctetASN bool
+		expASPath    []byte
+		expAS4Path   []byte
+	}{
+		"four octet peer": {
+			asPath:       []uint32{4200000001},
+			fourOctetASN: true,
+			expASPath:    []byte{2, 1, 0xfa, 0x56, 0xea, 0x01},
+		},
+		"two octet peer": {
+			asPath:    []uint32{65001},
+			expASPath: []byte{2, 1, 0xfd, 0xe9},
+		},
+		"two octet peer with four octet asn": {
+			asPath:     []uint32{4200000001},
+			expASPath:  []byte{2, 1, 0x5b, 0xa0},
+			expAS4Path: []byte{2, 1, 0xfa, 0x56, 0xea, 0x01},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			attrs := pathAttrs(t, encodeAnnounce(Route{Prefix: netip.MustParsePrefix("10.6.1.21/32")},
+				c.asPath, c.fourOctetASN, netip.MustParseAddr("172.18.0.2")))
+			assert.Equal(t, c.expASPath, attrs[attrASPath])
+			assert.Equal(t, c.expAS4Path, attrs[attrAS4Path])
+		})
+	}
+}
+
+func TestParseCommunity(t *testing.T) {
+	cases := map[string]struct {
+		in     string
+		exp    uint32
+		expErr bool
+	}{
+		"valid":        {in: "65000:100", exp: 65000<<16 | 100},
+		"no separator": {in: "65000", expErr: true},
+		"out of range": {in: "65536:1", expErr: true},
+		"not a number": {in: "a:1", expErr: true},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			res, err := ParseCommunity(c.in)
+			if c.expErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.exp, res)
+		})
+	}
+}
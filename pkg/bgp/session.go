@@ -0,0 +1,223 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package bgp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// session is the connection to one peer. The speaker always opens the connection,
+// incoming connections are not accepted.
+type session struct {
+	speaker *Speaker
+	peer    PeerConfig
+	log     logr.Logger
+	// updateCh is signaled when the routes of the speaker change
+	updateCh chan struct{}
+}
+
+func newSession(s *Speaker, peer PeerConfig) *session {
+	return &session{
+		speaker:  s,
+		peer:     peer,
+		log:      s.log.WithValues("peer", peer.String()),
+		updateCh: make(chan struct{}, 1),
+	}
+}
+
+func (s *session) notify() {
+	select {
+	case s.updateCh <- struct{}{}:
+	default:
+	}
+}
+
+func (s *session) run(ctx context.Context) {
+	for {
+		err := s.connect(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		s.log.Error(err, "bgp session is down, retry later", "retry", s.speaker.cfg.ConnectRetry.String())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.speaker.cfg.ConnectRetry):
+		}
+	}
+}
+
+// connect establishes the session and keeps the peer up to date until an error
+// happens. The routes are announced again on every new session, since the peer
+// drops them when the previous session goes down.
+func (s *session) connect(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: s.speaker.cfg.ConnectRetry}
+	conn, err := dialer.DialContext(ctx, "tcp", s.peer.String())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	local, ok := netip.AddrFromSlice(conn.LocalAddr().(*net.TCPAddr).IP)
+	if !ok {
+		return fmt.Errorf("invalid local address %v", conn.LocalAddr())
+	}
+	nextHop := local.Unmap()
+
+	holdTime := uint16(s.speaker.cfg.HoldTime / time.Second)
+	_, err = conn.Write(encodeOpen(open{asn: s.speaker.cfg.ASN, holdTime: holdTime, routerID: s.speaker.cfg.RouterID}))
+	if err != nil {
+		return err
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(s.speaker.cfg.HoldTime))
+	typ, body, err := readMessage(conn)
+	if err != nil {
+		return err
+	}
+	if typ == msgNotification {
+		return decodeNotification(body)
+	}
+	if typ != msgOpen {
+		return fmt.Errorf("expect an open message, got type %d", typ)
+	}
+	peerOpen, err := decodeOpen(body)
+	if err != nil {
+		_, _ = conn.Write(encodeNotification(errOpenMessage, 0))
+		return err
+	}
+	if peerOpen.asn != s.peer.ASN {
+		// bad peer AS
+		_, _ = conn.Write(encodeNotification(errOpenMessage, 2))
+		return fmt.Errorf("the peer ASN is %d, expect %d", peerOpen.asn, s.peer.ASN)
+	}
+	if peerOpen.holdTime != 0 && peerOpen.holdTime < holdTime {
+		holdTime = peerOpen.holdTime
+	}
+
+	if _, err := conn.Write(encodeMessage(msgKeepalive, nil)); err != nil {
+		return err
+	}
+
+	// done stops the reader when the connection is closed for any reason
+	done := make(chan struct{})
+	defer close(done)
+	recvCh := make(chan uint8)
+	errCh := make(chan error, 1)
+	go func() {
+		for {
+			_ = conn.SetReadDeadline(time.Time{})
+			typ, body, err := readMessage(conn)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			if typ == msgNotification {
+				errCh <- decodeNotification(body)
+				return
+			}
+			select {
+			case recvCh <- typ:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// a hold time of zero disables the keepalive and hold timers
+	hold := time.Duration(holdTime) * time.Second
+	var keepaliveC, holdC <-chan time.Time
+	var holdTimer *time.Timer
+	if hold != 0 {
+		keepalive := time.NewTicker(hold / 3)
+		defer keepalive.Stop()
+		keepaliveC = keepalive.C
+		holdTimer = time.NewTimer(hold)
+		defer holdTimer.Stop()
+		holdC = holdTimer.C
+	}
+
+	established := false
+	ibgp := s.peer.ASN == s.speaker.cfg.ASN
+	advertised := make(map[netip.Prefix]Route)
+	for {
+		select {
+		case <-ctx.Done():
+			_, _ = conn.Write(encodeNotification(errCease, 0))
+			return ctx.Err()
+		case err := <-errCh:
+			return err
+		case typ := <-recvCh:
+			if holdTimer != nil {
+				holdTimer.Reset(hold)
+			}
+			if typ == msgKeepalive && !established {
+				established = true
+				s.log.Info("bgp session is established", "holdTime", holdTime)
+				s.notify()
+			}
+		case <-holdC:
+			_, _ = conn.Write(encodeNotification(errHoldTimerExpired, 0))
+			return errors.New("bgp hold timer expired")
+		case <-keepaliveC:
+			if _, err := conn.Write(encodeMessage(msgKeepalive, nil)); err != nil {
+				return err
+			}
+		case <-s.updateCh:
+			if !established {
+				continue
+			}
+			if err := s.sync(conn, advertised, ibgp, peerOpen.fourOctetASN, nextHop); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sync sends the difference between the routes of the speaker and the advertised ones.
+func (s *session) sync(conn net.Conn, advertised map[netip.Prefix]Route, ibgp, fourOctetASN bool, nextHop netip.Addr) error {
+	routes := s.speaker.Routes()
+
+	for prefix := range advertised {
+		if _, ok := routes[prefix]; ok {
+			continue
+		}
+		if _, err := conn.Write(encodeWithdraw(prefix)); err != nil {
+			return err
+		}
+		delete(advertised, prefix)
+		s.log.V(1).Info("withdraw route", "prefix", prefix.String())
+	}
+
+	var asPath []uint32
+	if !ibgp {
+		asPath = []uint32{s.speaker.cfg.ASN}
+	}
+	for prefix, route := range routes {
+		// the next hop is the local address of the session, so only the routes
+		// of the same family as the session are announced
+		if prefix.Addr().Is4() != nextHop.Is4() {
+			continue
+		}
+		if old, ok := advertised[prefix]; ok && old.equal(route) {
+			continue
+		}
+		if !ibgp {
+			route.LocalPref = nil
+		}
+		if _, err := conn.Write(encodeAnnounce(route, asPath, fourOctetASN, nextHop)); err != nil {
+			return err
+		}
+		advertised[prefix] = routes[prefix]
+		s.log.V(1).Info("announce route", "prefix", prefix.String())
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package bgp is a minimal BGP-4 speaker that announces the EIPs hosted by a
+// node as host routes. It only originates routes, the routes sent by the peers
+// are ignored.
+package bgp
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	"github.com/spidernet-io/egressgateway/pkg/lock"
+)
+
+type Config struct {
+	// ASN is the local AS number
+	ASN      uint32
+	RouterID netip.Addr
+	// HoldTime is proposed to the peers, the keepalive interval is a third of the negotiated one
+	HoldTime time.Duration
+	// ConnectRetry is the interval between two connection attempts to a peer
+	ConnectRetry time.Duration
+	Peers        []PeerConfig
+}
+
+type PeerConfig struct {
+	Address netip.Addr
+	Port    int
+	ASN     uint32
+}
+
+func (p PeerConfig) String() string {
+	return netip.AddrPortFrom(p.Address, uint16(p.Port)).String()
+}
+
+// Route is a host route to an EIP with the attributes it is announced with.
+type Route struct {
+	Prefix      netip.Prefix
+	Communities []uint32
+	// LocalPref is only sent to iBGP peers
+	LocalPref *uint32
+}
+
+func (r Route) equal(o Route) bool {
+	if r.Prefix != o.Prefix || len(r.Communities) != len(o.Communities) {
+		return false
+	}
+	for i := range r.Communities {
+		if r.Communities[i] != o.Communities[i] {
+			return false
+		}
+	}
+	if r.LocalPref == nil || o.LocalPref == nil {
+		return r.LocalPref == o.LocalPref
+	}
+	return *r.LocalPref == *o.LocalPref
+}
+
+// Speaker keeps a session with every configured peer, and announces to them the
+// routes set by the owners, e.g. the EgressGateways.
+type Speaker struct {
+	log logr.Logger
+	cfg Config
+
+	lock.RWMutex
+	routes   map[string][]Route // owner -> routes
+	sessions []*session
+}
+
+// New returns a Speaker, the sessions are established by Start.
+func New(log logr.Logger, cfg Config) (*Speaker, error) {
+	if cfg.ASN == 0 {
+		return nil, fmt.Errorf("the local ASN of bgp is not set")
+	}
+	if !cfg.RouterID.Is4() {
+		return nil, fmt.Errorf("the bgp router id %v is not an IPv4 address", cfg.RouterID)
+	}
+	if cfg.HoldTime == 0 {
+		cfg.HoldTime = 90 * time.Second
+	}
+	if cfg.ConnectRetry == 0 {
+		cfg.ConnectRetry = 5 * time.Second
+	}
+
+	s := &Speaker{
+		log:    log,
+		cfg:    cfg,
+		routes: make(map[string][]Route),
+	}
+	for _, peer := range cfg.Peers {
+		if peer.Port == 0 {
+			peer.Port = 179
+		}
+		s.sessions = append(s.sessions, newSession(s, peer))
+	}
+	return s, nil
+}
+
+// Start runs the sessions until the context is done, it implements manager.Runnable.
+func (s *Speaker) Start(ctx context.Context) error {
+	s.log.Info("start bgp speaker", "asn", s.cfg.ASN, "routerID", s.cfg.RouterID.String())
+	var wg sync.WaitGroup
+	for _, item := range s.sessions {
+		wg.Add(1)
+		go func(item *session) {
+			defer wg.Done()
+			item.run(ctx)
+		}(item)
+	}
+	wg.Wait()
+	return nil
+}
+
+// SetRoutes replaces the routes of the owner, the peers are updated with the difference.
+func (s *Speaker) SetRoutes(owner string, routes []Route) {
+	s.Lock()
+	if len(routes) == 0 {
+		delete(s.routes, owner)
+	} else {
+		s.routes[owner] = routes
+	}
+	s.Unlock()
+
+	for _, item := range s.sessions {
+		item.notify()
+	}
+}
+
+// DeleteRoutes withdraws the routes of the owner.
+func (s *Speaker) DeleteRoutes(owner string) {
+	s.SetRoutes(owner, nil)
+}
+
+// Routes returns the routes announced to the peers, a prefix set by more than
+// one owner is announced with the attributes of the first owner by name.
+func (s *Speaker) Routes() map[netip.Prefix]Route {
+	s.RLock()
+	defer s.RUnlock()
+
+	owners := make([]string, 0, len(s.routes))
+	for owner := range s.routes {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+
+	res := make(map[netip.Prefix]Route)
+	for _, owner := range owners {
+		for _, route := range s.routes[owner] {
+			if _, ok := res[route.Prefix]; !ok {
+				res[route.Prefix] = route
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,162 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package bgp
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+)
+
+// testPeer is a passive BGP peer, it answers the OPEN of the speaker and
+// passes the received UPDATE messages to the test.
+type testPeer struct {
+	ln      net.Listener
+	asn     uint32
+	updates chan update
+}
+
+func newTestPeer(t *testing.T, asn uint32) *testPeer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testPeer{ln: ln, asn: asn, updates: make(chan update, 10)}
+	go p.serve(t)
+	return p
+}
+
+func (p *testPeer) port() int {
+	return p.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (p *testPeer) serve(t *testing.T) {
+	conn, err := p.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	typ, body, err := readMessage(conn)
+	if err != nil || typ != msgOpen {
+		t.Errorf("failed to read open: type %d, %v", typ, err)
+		return
+	}
+	o, err := decodeOpen(body)
+	if err != nil {
+		t.Errorf("failed to decode open: %v", err)
+		return
+	}
+	_, _ = conn.Write(encodeOpen(open{asn: p.asn, holdTime: 9, routerID: netip.MustParseAddr("10.0.0.254"), fourOctetASN: true}))
+	_, _ = conn.Write(encodeMessage(msgKeepalive, nil))
+
+	for {
+		typ, body, err := readMessage(conn)
+		if err != nil {
+			return
+		}
+		switch typ {
+		case msgKeepalive:
+			_, _ = conn.Write(encodeMessage(msgKeepalive, nil))
+		case msgUpdate:
+			u, err := decodeUpdate(body, o.fourOctetASN)
+			if err != nil {
+				t.Errorf("failed to decode update: %v", err)
+				return
+			}
+			p.updates <- u
+		}
+	}
+}
+
+func (p *testPeer) next(t *testing.T) update {
+	select {
+	case u := <-p.updates:
+		return u
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the update")
+	}
+	return update{}
+}
+
+func TestSpeaker(t *testing.T) {
+	pref := uint32(150)
+	prefix := netip.MustParsePrefix("10.6.1.21/32")
+	nextHop := netip.MustParseAddr("127.0.0.1")
+
+	cases := map[string]struct {
+		peerASN uint32
+		exp     update
+	}{
+		"ibgp": {
+			peerASN: 65000,
+			exp: update{
+				nlri:        []netip.Prefix{prefix},
+				nextHop:     nextHop,
+				localPref:   &pref,
+				communities: []uint32{65000<<16 | 100},
+			},
+		},
+		"ebgp": {
+			peerASN: 65001,
+			exp: update{
+				nlri:        []netip.Prefix{prefix},
+				asPath:      []uint32{65000},
+				nextHop:     nextHop,
+				communities: []uint32{65000<<16 | 100},
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			peer := newTestPeer(t, c.peerASN)
+			defer peer.ln.Close()
+
+			s, err := New(logger.NewLogger(logger.Config{}), Config{
+				ASN:      65000,
+				RouterID: netip.MustParseAddr("10.0.0.1"),
+				Peers:    []PeerConfig{{Address: nextHop, Port: peer.port(), ASN: c.peerASN}},
+			})
+			assert.NoError(t, err)
+
+			// the routes set before the session is established are sent once it is up
+			s.SetRoutes("egw1", []Route{{Prefix: prefix, Communities: []uint32{65000<<16 | 100}, LocalPref: &pref}})
+			// an IPv6 route is not sent on an IPv4 session
+			s.SetRoutes("egw2", []Route{{Prefix: netip.MustParsePrefix("fd00::21/128")}})
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go func() {
+				_ = s.Start(ctx)
+			}()
+
+			assert.Equal(t, c.exp, peer.next(t))
+
+			// failover
+			s.DeleteRoutes("egw1")
+			assert.Equal(t, update{withdrawn: []netip.Prefix{prefix}}, peer.next(t))
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	log := logger.NewLogger(logger.Config{})
+	_, err := New(log, Config{RouterID: netip.MustParseAddr("10.0.0.1")})
+	assert.Error(t, err)
+	_, err = New(log, Config{ASN: 65000, RouterID: netip.MustParseAddr("fd00::1")})
+	assert.Error(t, err)
+
+	s, err := New(log, Config{ASN: 65000, RouterID: netip.MustParseAddr("10.0.0.1"),
+		Peers: []PeerConfig{{Address: netip.MustParseAddr("10.0.0.2"), ASN: 65000}}})
+	assert.NoError(t, err)
+	assert.Equal(t, 90*time.Second, s.cfg.HoldTime)
+	assert.Equal(t, 179, s.sessions[0].peer.Port)
+}
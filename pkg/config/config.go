@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"net/url"
 	"os"
 	"regexp"
 	"strconv"
@@ -50,6 +51,7 @@ type EnvConfig struct {
 	LeaderElectionLostRestart bool          `mapstructure:"LEADER_ELECTION_LOST_RESTART"`
 	MetricsBindAddress        string        `mapstructure:"METRICS_BIND_ADDRESS"`
 	HealthProbeBindAddress    string        `mapstructure:"HEALTH_PROBE_BIND_ADDRESS"`
+	DebugAPIBindAddress       string        `mapstructure:"DEBUG_API_BIND_ADDRESS"`
 	GopsPort                  int           `mapstructure:"GOPS_PORT"`
 	WebhookPort               int           `mapstructure:"WEBHOOK_PORT"`
 	PyroscopeServerAddr       string        `mapstructure:"PYROSCOPE_SERVER_ADDR"`
@@ -66,24 +68,31 @@ type EnvConfig struct {
 }
 
 type FileConfig struct {
-	EnableIPv4                   bool            `yaml:"enableIPv4"`
-	EnableIPv6                   bool            `yaml:"enableIPv6"`
-	IPTables                     IPTables        `yaml:"iptables"`
-	DatapathMode                 string          `yaml:"datapathMode"`
-	TunnelIpv4Subnet             string          `yaml:"tunnelIpv4Subnet"`
-	TunnelIpv6Subnet             string          `yaml:"tunnelIpv6Subnet"`
-	TunnelIPv4Net                *net.IPNet      `json:"-"`
-	TunnelIPv6Net                *net.IPNet      `json:"-"`
-	TunnelDetectMethod           string          `yaml:"tunnelDetectMethod"`
-	VXLAN                        VXLAN           `yaml:"vxlan"`
-	MaxNumberEndpointPerSlice    int             `yaml:"maxNumberEndpointPerSlice"`
-	Mark                         string          `yaml:"mark"`
-	AnnouncedInterfacesToExclude []string        `yaml:"announcedInterfacesToExclude"`
-	AnnounceExcludeRegexp        *regexp.Regexp  `json:"-"`
-	EnableGatewayReplyRoute      bool            `yaml:"enableGatewayReplyRoute"`
-	GatewayReplyRouteTable       int             `yaml:"gatewayReplyRouteTable"`
-	GatewayReplyRouteMark        int             `yaml:"gatewayReplyRouteMark"`
-	GatewayFailover              GatewayFailover `yaml:"gatewayFailover"`
+	EnableIPv4                   bool                      `yaml:"enableIPv4"`
+	EnableIPv6                   bool                      `yaml:"enableIPv6"`
+	IPTables                     IPTables                  `yaml:"iptables"`
+	DatapathMode                 string                    `yaml:"datapathMode"`
+	TunnelIpv4Subnet             string                    `yaml:"tunnelIpv4Subnet"`
+	TunnelIpv6Subnet             string                    `yaml:"tunnelIpv6Subnet"`
+	TunnelIPv4Net                *net.IPNet                `json:"-"`
+	TunnelIPv6Net                *net.IPNet                `json:"-"`
+	TunnelDetectMethod           string                    `yaml:"tunnelDetectMethod"`
+	VXLAN                        VXLAN                     `yaml:"vxlan"`
+	MaxNumberEndpointPerSlice    int                       `yaml:"maxNumberEndpointPerSlice"`
+	Mark                         string                    `yaml:"mark"`
+	AnnouncedInterfacesToExclude []string                  `yaml:"announcedInterfacesToExclude"`
+	AnnounceExcludeRegexp        *regexp.Regexp            `json:"-"`
+	EnableGatewayReplyRoute      bool                      `yaml:"enableGatewayReplyRoute"`
+	GatewayReplyRouteTable       int                       `yaml:"gatewayReplyRouteTable"`
+	GatewayReplyRouteMark        int                       `yaml:"gatewayReplyRouteMark"`
+	GatewayFailover              GatewayFailover           `yaml:"gatewayFailover"`
+	EIPRebalance                 EIPRebalance              `yaml:"eipRebalance"`
+	BGP                          BGP                       `yaml:"bgp"`
+	DuplicateAddressDetection    DuplicateAddressDetection `yaml:"duplicateAddressDetection"`
+	EIPAddress                   EIPAddress                `yaml:"eipAddress"`
+	VLANRoute                    VLANRoute                 `yaml:"vlanRoute"`
+	FlowLog                      FlowLog                   `yaml:"flowLog"`
+	Tracing                      Tracing                   `yaml:"tracing"`
 }
 
 type GatewayFailover struct {
@@ -93,6 +102,98 @@ type GatewayFailover struct {
 	EipEvictionTimeout  int  `yaml:"eipEvictionTimeout"`
 }
 
+type EIPRebalance struct {
+	Enable              bool `yaml:"enable"`
+	Interval            int  `yaml:"interval"`
+	MaxMovesPerInterval int  `yaml:"maxMovesPerInterval"`
+}
+
+// DuplicateAddressDetection probes the EIPs with ARP/NDP before they are announced
+type DuplicateAddressDetection struct {
+	Enable              bool `yaml:"enable"`
+	ProbeCount          int  `yaml:"probeCount"`
+	ProbeIntervalMillis int  `yaml:"probeIntervalMillis"`
+	// ConflictExpiration is the time in seconds a conflicting EIP is not allocated
+	ConflictExpiration int `yaml:"conflictExpiration"`
+}
+
+// EIPAddress configures the EIPs hosted by the node as /32 or /128 addresses of an interface
+type EIPAddress struct {
+	Enable bool `yaml:"enable"`
+	// Interface is created as a dummy interface if it does not exist
+	Interface string `yaml:"interface"`
+	// StateFile records the addresses added by the agent, so that the addresses
+	// configured by the administrator are never deleted
+	StateFile string `yaml:"stateFile"`
+}
+
+// VLANRoute routes the EIP traffic out of the VLAN sub-interface of its EgressGateway,
+// the table and mark of a VLAN are the bases plus the VLAN ID
+type VLANRoute struct {
+	TableBase int    `yaml:"tableBase"`
+	Mark      string `yaml:"mark"`
+}
+
+// FlowLog writes a JSON record for each egress flow of the gateway node when the flow ends
+type FlowLog struct {
+	Enable bool `yaml:"enable"`
+	// Output is stdout or the path of a file the records are appended to
+	Output string `yaml:"output"`
+	// SampleRate logs one of SampleRate flows
+	SampleRate int `yaml:"sampleRate"`
+	// RateLimit is the maximum number of records per second, 0 is unlimited
+	RateLimit int   `yaml:"rateLimit"`
+	Burst     int   `yaml:"burst"`
+	IPFIX     IPFIX `yaml:"ipfix"`
+}
+
+// IPFIX exports the egress flows to an IPFIX collector over UDP, the flows are
+// selected, sampled and rate limited as the flow logs
+type IPFIX struct {
+	Enable bool `yaml:"enable"`
+	// Collector is the host:port of the collector
+	Collector string `yaml:"collector"`
+	// ActiveTimeout in seconds exports the counters of the long-lived flows periodically
+	ActiveTimeout int `yaml:"activeTimeout"`
+	// IdleTimeout in seconds ends the flows which have no packet
+	IdleTimeout int `yaml:"idleTimeout"`
+	// TemplateRefresh is the interval in seconds the templates are resent
+	TemplateRefresh     int    `yaml:"templateRefresh"`
+	ObservationDomainID uint32 `yaml:"observationDomainID"`
+	// EnterpriseNumber is the private enterprise number of the information elements
+	// carrying the policy, pod and gateway node
+	EnterpriseNumber uint32 `yaml:"enterpriseNumber"`
+}
+
+// Tracing exports the spans of the webhook, the controller and the agents to an
+// OTLP/HTTP receiver
+type Tracing struct {
+	Enable bool `yaml:"enable"`
+	// Endpoint is the base URL of the receiver, the spans are posted to its /v1/traces path
+	Endpoint string `yaml:"endpoint"`
+	// ExportInterval is the interval in seconds the spans are exported
+	ExportInterval int `yaml:"exportInterval"`
+	// CorrelationWindow is the time in seconds the reconciles of a policy join the trace
+	// of its last change
+	CorrelationWindow int `yaml:"correlationWindow"`
+}
+
+// BGP announces the EIPs as host routes to the peers instead of ARP/NDP
+type BGP struct {
+	Enable   bool   `yaml:"enable"`
+	LocalASN uint32 `yaml:"localASN"`
+	// RouterID defaults to the IPv4 InternalIP of the node
+	RouterID string    `yaml:"routerID"`
+	HoldTime int       `yaml:"holdTime"`
+	Peers    []BGPPeer `yaml:"peers"`
+}
+
+type BGPPeer struct {
+	Address string `yaml:"address"`
+	Port    int    `yaml:"port"`
+	ASN     uint32 `yaml:"asn"`
+}
+
 const TunnelInterfaceDefaultRoute = "defaultRouteInterface"
 const TunnelInterfaceSpecific = "interface="
 
@@ -112,6 +213,8 @@ type IPTables struct {
 	InitialPostWriteIntervalSecond int    `yaml:"initialPostWriteIntervalSecond"`
 	RestoreSupportsLock            bool   `yaml:"restoreSupportsLock"`
 	LockFilePath                   string `yaml:"lockFilePath"`
+	// Version is the version of iptables detected by the agent
+	Version string `yaml:"-"`
 }
 
 type AutoDetect struct {
@@ -163,6 +266,49 @@ func LoadConfig(isAgent bool) (*Config, error) {
 				TunnelUpdatePeriod:  5,
 				EipEvictionTimeout:  15,
 			},
+			EIPRebalance: EIPRebalance{
+				Enable:              false,
+				Interval:            300,
+				MaxMovesPerInterval: 1,
+			},
+			BGP: BGP{
+				Enable:   false,
+				HoldTime: 90,
+			},
+			DuplicateAddressDetection: DuplicateAddressDetection{
+				Enable:              true,
+				ProbeCount:          3,
+				ProbeIntervalMillis: 500,
+				ConflictExpiration:  600,
+			},
+			EIPAddress: EIPAddress{
+				Enable:    false,
+				Interface: "egress-eip",
+				StateFile: "/run/egressgateway/eip-addresses",
+			},
+			VLANRoute: VLANRoute{
+				TableBase: 5000,
+				Mark:      "0x28000000",
+			},
+			FlowLog: FlowLog{
+				Enable:     false,
+				Output:     "stdout",
+				SampleRate: 1,
+				RateLimit:  1000,
+				Burst:      2000,
+				IPFIX: IPFIX{
+					Enable:              false,
+					ActiveTimeout:       60,
+					IdleTimeout:         15,
+					TemplateRefresh:     600,
+					ObservationDomainID: 1,
+				},
+			},
+			Tracing: Tracing{
+				Enable:            false,
+				ExportInterval:    5,
+				CorrelationWindow: 300,
+			},
 		},
 	}
 
@@ -210,6 +356,9 @@ func LoadConfig(isAgent bool) (*Config, error) {
 	if config.FileConfig.IPTables.BackendMode == "auto" {
 		config.FileConfig.IPTables.BackendMode = ver.BackendMode
 	}
+	if isAgent {
+		config.FileConfig.IPTables.Version = ver.String()
+	}
 
 	config.Logger = logger.Config{
 		UseDevMode: config.UseDevMode,
@@ -255,5 +404,106 @@ func LoadConfig(isAgent bool) (*Config, error) {
 		}
 	}
 
+	if config.FileConfig.EIPRebalance.Enable {
+		if config.FileConfig.EIPRebalance.Interval <= 0 {
+			return nil, fmt.Errorf("eipRebalance interval should be greater than 0")
+		}
+		if config.FileConfig.EIPRebalance.MaxMovesPerInterval <= 0 {
+			return nil, fmt.Errorf("eipRebalance maxMovesPerInterval should be greater than 0")
+		}
+	}
+
+	if config.FileConfig.DuplicateAddressDetection.Enable {
+		if config.FileConfig.DuplicateAddressDetection.ProbeCount <= 0 {
+			return nil, fmt.Errorf("duplicateAddressDetection probeCount should be greater than 0")
+		}
+		if config.FileConfig.DuplicateAddressDetection.ProbeIntervalMillis <= 0 {
+			return nil, fmt.Errorf("duplicateAddressDetection probeIntervalMillis should be greater than 0")
+		}
+		if config.FileConfig.DuplicateAddressDetection.ConflictExpiration <= 0 {
+			return nil, fmt.Errorf("duplicateAddressDetection conflictExpiration should be greater than 0")
+		}
+	}
+
+	if config.FileConfig.EIPAddress.Enable {
+		if config.FileConfig.EIPAddress.Interface == "" || len(config.FileConfig.EIPAddress.Interface) > 15 {
+			return nil, fmt.Errorf("eipAddress interface %q is invalid", config.FileConfig.EIPAddress.Interface)
+		}
+		if config.FileConfig.EIPAddress.StateFile == "" {
+			return nil, fmt.Errorf("eipAddress stateFile should be set")
+		}
+	}
+
+	if config.FileConfig.VLANRoute.TableBase <= 0 {
+		return nil, fmt.Errorf("vlanRoute tableBase should be greater than 0")
+	}
+	vlanMark, err := strconv.ParseUint(strings.TrimPrefix(config.FileConfig.VLANRoute.Mark, "0x"), 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("vlanRoute mark %s is invalid: %w", config.FileConfig.VLANRoute.Mark, err)
+	}
+	if vlanMark&0xfff != 0 {
+		return nil, fmt.Errorf("the lowest 12 bits of vlanRoute mark %s are reserved for the VLAN ID", config.FileConfig.VLANRoute.Mark)
+	}
+
+	if config.FileConfig.FlowLog.Enable && config.FileConfig.FlowLog.Output == "" {
+		return nil, fmt.Errorf("flowLog output should be set")
+	}
+	if config.FileConfig.FlowLog.IPFIX.Enable {
+		ipfix := config.FileConfig.FlowLog.IPFIX
+		if _, _, err := net.SplitHostPort(ipfix.Collector); err != nil {
+			return nil, fmt.Errorf("flowLog ipfix collector %q is invalid: %w", ipfix.Collector, err)
+		}
+		if ipfix.ActiveTimeout <= 0 || ipfix.IdleTimeout <= 0 || ipfix.TemplateRefresh <= 0 {
+			return nil, fmt.Errorf("flowLog ipfix activeTimeout, idleTimeout and templateRefresh should be greater than 0")
+		}
+		if ipfix.EnterpriseNumber == 0 {
+			return nil, fmt.Errorf("flowLog ipfix enterpriseNumber should be set")
+		}
+	}
+	if config.FileConfig.FlowLog.Enable || config.FileConfig.FlowLog.IPFIX.Enable {
+		if config.FileConfig.FlowLog.SampleRate < 1 {
+			return nil, fmt.Errorf("flowLog sampleRate should be at least 1")
+		}
+		if config.FileConfig.FlowLog.RateLimit < 0 {
+			return nil, fmt.Errorf("flowLog rateLimit should not be negative")
+		}
+		if config.FileConfig.FlowLog.RateLimit > 0 && config.FileConfig.FlowLog.Burst < 1 {
+			return nil, fmt.Errorf("flowLog burst should be at least 1")
+		}
+	}
+
+	if config.FileConfig.Tracing.Enable {
+		tracing := config.FileConfig.Tracing
+		if u, err := url.Parse(tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("tracing endpoint %q should be a http or https URL", tracing.Endpoint)
+		}
+		if tracing.ExportInterval <= 0 || tracing.CorrelationWindow <= 0 {
+			return nil, fmt.Errorf("tracing exportInterval and correlationWindow should be greater than 0")
+		}
+	}
+
+	if config.FileConfig.BGP.Enable {
+		if config.FileConfig.BGP.LocalASN == 0 {
+			return nil, fmt.Errorf("bgp localASN should be set")
+		}
+		if config.FileConfig.BGP.HoldTime != 0 && config.FileConfig.BGP.HoldTime < 3 {
+			return nil, fmt.Errorf("bgp holdTime should be 0 or at least 3 seconds")
+		}
+		if config.FileConfig.BGP.RouterID != "" && net.ParseIP(config.FileConfig.BGP.RouterID).To4() == nil {
+			return nil, fmt.Errorf("bgp routerID %s is not an IPv4 address", config.FileConfig.BGP.RouterID)
+		}
+		if len(config.FileConfig.BGP.Peers) == 0 {
+			return nil, fmt.Errorf("bgp peers should be set")
+		}
+		for _, peer := range config.FileConfig.BGP.Peers {
+			if net.ParseIP(peer.Address) == nil {
+				return nil, fmt.Errorf("bgp peer address %s is invalid", peer.Address)
+			}
+			if peer.ASN == 0 {
+				return nil, fmt.Errorf("bgp peer %s asn should be set", peer.Address)
+			}
+		}
+	}
+
 	return config, nil
 }
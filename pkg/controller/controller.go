@@ -6,8 +6,10 @@ package controller
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
@@ -22,6 +24,7 @@ import (
 	"github.com/spidernet-io/egressgateway/pkg/logger"
 	"github.com/spidernet-io/egressgateway/pkg/profiling"
 	"github.com/spidernet-io/egressgateway/pkg/schema"
+	"github.com/spidernet-io/egressgateway/pkg/tracing"
 	"github.com/spidernet-io/egressgateway/pkg/types"
 )
 
@@ -62,7 +65,28 @@ func New(cfg *config.Config) (types.Service, error) {
 		return nil, err
 	}
 
-	metrics.RegisterMetricCollectors()
+	if cfg.FileConfig.Tracing.Enable {
+		provider, err := tracing.New(tracing.Options{
+			Endpoint:          cfg.FileConfig.Tracing.Endpoint,
+			ExportInterval:    time.Duration(cfg.FileConfig.Tracing.ExportInterval) * time.Second,
+			CorrelationWindow: time.Duration(cfg.FileConfig.Tracing.CorrelationWindow) * time.Second,
+			Service:           "egressgateway-controller",
+			Resource: []attribute.KeyValue{
+				attribute.String("k8s.pod.name", cfg.PodName),
+				attribute.String("k8s.namespace.name", cfg.PodNamespace),
+			},
+			Log: log.WithName("tracing"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := mgr.Add(provider); err != nil {
+			return nil, err
+		}
+		tracing.SetProvider(provider)
+	}
+
+	metrics.RegisterMetricCollectors(mgr.GetClient(), egressTunnelControllerMetricCollectors()...)
 
 	err = egressgateway.NewEgressGatewayController(mgr, log, cfg)
 	if err != nil {
@@ -79,10 +103,25 @@ func New(cfg *config.Config) (types.Service, error) {
 		return nil, fmt.Errorf("failed to create egress cluster policy controller: %w", err)
 	}
 
+	err = newEgressIPPoolController(mgr, log, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create egress ip pool controller: %w", err)
+	}
+
+	err = newEgressIPQuotaController(mgr, log, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create egress ip quota controller: %w", err)
+	}
+
 	err = newEgressTunnelController(mgr, log, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create egress tunnel controller: %w", err)
 	}
+
+	err = newEgressAgentStatusController(mgr, log, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create egress agent status controller: %w", err)
+	}
 	err = egressclusterinfo.NewEgressClusterInfoController(mgr, log)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create egress cluster info controller: %w", err)
@@ -0,0 +1,172 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+type agentStatusReconciler struct {
+	client client.Client
+	log    logr.Logger
+	config *config.Config
+}
+
+// Reconcile aggregates the EgressAgentStatus of the nodes in the datapath status of
+// the policies
+func (r *agentStatusReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	kind, newReq, err := utils.ParseKindWithReq(req)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	log := r.log.WithValues("name", newReq.Name, "namespace", newReq.Namespace, "kind", kind)
+	log.V(1).Info("reconciling")
+
+	agents := &v1beta1.EgressAgentStatusList{}
+	if err := r.client.List(ctx, agents); err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
+
+	var policies []client.Object
+	switch kind {
+	case "EgressPolicy":
+		policy := new(v1beta1.EgressPolicy)
+		if err := r.client.Get(ctx, newReq.NamespacedName, policy); err != nil {
+			return reconcile.Result{}, client.IgnoreNotFound(err)
+		}
+		policies = append(policies, policy)
+	case "EgressClusterPolicy":
+		policy := new(v1beta1.EgressClusterPolicy)
+		if err := r.client.Get(ctx, newReq.NamespacedName, policy); err != nil {
+			return reconcile.Result{}, client.IgnoreNotFound(err)
+		}
+		policies = append(policies, policy)
+	case "EgressAgentStatus":
+		// a node may have programmed any policy
+		egpList := &v1beta1.EgressPolicyList{}
+		if err := r.client.List(ctx, egpList); err != nil {
+			return reconcile.Result{Requeue: true}, err
+		}
+		for i := range egpList.Items {
+			policies = append(policies, &egpList.Items[i])
+		}
+		egcpList := &v1beta1.EgressClusterPolicyList{}
+		if err := r.client.List(ctx, egcpList); err != nil {
+			return reconcile.Result{Requeue: true}, err
+		}
+		for i := range egcpList.Items {
+			policies = append(policies, &egcpList.Items[i])
+		}
+	default:
+		return reconcile.Result{}, nil
+	}
+
+	var errs []error
+	for _, obj := range policies {
+		if err := r.updateDatapath(ctx, obj, agents.Items, log); err != nil && !errors.IsNotFound(err) {
+			errs = append(errs, err)
+		}
+	}
+	return reconcile.Result{}, utilerrors.NewAggregate(errs)
+}
+
+func (r *agentStatusReconciler) updateDatapath(ctx context.Context, obj client.Object, agents []v1beta1.EgressAgentStatus, log logr.Logger) error {
+	policy := v1beta1.Policy{Name: obj.GetName(), Namespace: obj.GetNamespace()}
+	datapath := aggregateDatapath(agents, policy, obj.GetGeneration())
+
+	var status *v1beta1.EgressPolicyStatus
+	switch obj := obj.(type) {
+	case *v1beta1.EgressPolicy:
+		status = &obj.Status
+	case *v1beta1.EgressClusterPolicy:
+		status = &obj.Status
+	}
+	if reflect.DeepEqual(status.Datapath, datapath) {
+		return nil
+	}
+
+	status.Datapath = datapath
+	log.V(1).Info("update policy datapath status", "policy", policy, "datapath", datapath)
+	if err := r.client.Status().Update(ctx, obj); err != nil {
+		return fmt.Errorf("failed to update the datapath status of policy %v: %w", policy, err)
+	}
+	return nil
+}
+
+// aggregateDatapath counts the nodes which programmed the given generation of a policy
+func aggregateDatapath(agents []v1beta1.EgressAgentStatus, policy v1beta1.Policy, generation int64) *v1beta1.PolicyDatapath {
+	datapath := &v1beta1.PolicyDatapath{TotalNodes: len(agents)}
+	for _, agent := range agents {
+		for _, item := range agent.Status.Policies {
+			if item.Name != policy.Name || item.Namespace != policy.Namespace {
+				continue
+			}
+			if !item.Programmed {
+				datapath.FailedNodes = append(datapath.FailedNodes, agent.Name)
+			} else if item.Generation >= generation {
+				datapath.ProgrammedNodes++
+			}
+			break
+		}
+	}
+	sort.Strings(datapath.FailedNodes)
+	return datapath
+}
+
+func newEgressAgentStatusController(mgr manager.Manager, log logr.Logger, cfg *config.Config) error {
+	if cfg == nil {
+		return fmt.Errorf("cfg can not be nil")
+	}
+
+	r := &agentStatusReconciler{
+		client: mgr.GetClient(),
+		log:    log,
+		config: cfg,
+	}
+
+	log.Info("new egress agent status controller")
+	c, err := controller.New("egressagentstatus", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	if err := c.Watch(source.Kind(mgr.GetCache(), &v1beta1.EgressAgentStatus{}),
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("EgressAgentStatus"))); err != nil {
+		return fmt.Errorf("failed to watch EgressAgentStatus: %w", err)
+	}
+
+	// the status updates of the policies are ignored
+	if err := c.Watch(source.Kind(mgr.GetCache(), &v1beta1.EgressPolicy{}),
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("EgressPolicy")),
+		predicate.GenerationChangedPredicate{}); err != nil {
+		return fmt.Errorf("failed to watch EgressPolicy: %w", err)
+	}
+
+	if err := c.Watch(source.Kind(mgr.GetCache(), &v1beta1.EgressClusterPolicy{}),
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("EgressClusterPolicy")),
+		predicate.GenerationChangedPredicate{}); err != nil {
+		return fmt.Errorf("failed to watch EgressClusterPolicy: %w", err)
+	}
+
+	return nil
+}
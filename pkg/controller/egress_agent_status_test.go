@@ -0,0 +1,115 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func TestAggregateDatapath(t *testing.T) {
+	agents := []egressv1.EgressAgentStatus{
+		{
+			ObjectMeta: v1.ObjectMeta{Name: "node1"},
+			Status: egressv1.AgentStatus{Policies: []egressv1.AppliedPolicy{
+				{Name: "policy1", Namespace: "default", Generation: 2, Programmed: true},
+			}},
+		},
+		{
+			// programmed from an old generation
+			ObjectMeta: v1.ObjectMeta{Name: "node2"},
+			Status: egressv1.AgentStatus{Policies: []egressv1.AppliedPolicy{
+				{Name: "policy1", Namespace: "default", Generation: 1, Programmed: true},
+			}},
+		},
+		{
+			ObjectMeta: v1.ObjectMeta{Name: "node4"},
+			Status: egressv1.AgentStatus{Policies: []egressv1.AppliedPolicy{
+				{Name: "policy1", Namespace: "default", Generation: 2, Error: "ipset error"},
+			}},
+		},
+		{
+			ObjectMeta: v1.ObjectMeta{Name: "node3"},
+			Status: egressv1.AgentStatus{Policies: []egressv1.AppliedPolicy{
+				{Name: "policy1", Namespace: "other", Generation: 2, Error: "ipset error"},
+				{Name: "policy1", Namespace: "default", Generation: 3, Error: "ipset error"},
+			}},
+		},
+	}
+
+	datapath := aggregateDatapath(agents, egressv1.Policy{Name: "policy1", Namespace: "default"}, 2)
+	assert.Equal(t, &egressv1.PolicyDatapath{
+		ProgrammedNodes: 1,
+		TotalNodes:      4,
+		FailedNodes:     []string{"node3", "node4"},
+	}, datapath)
+
+	datapath = aggregateDatapath(agents, egressv1.Policy{Name: "policy2"}, 1)
+	assert.Equal(t, &egressv1.PolicyDatapath{TotalNodes: 4}, datapath)
+}
+
+func TestAgentStatusReconcile(t *testing.T) {
+	policy := &egressv1.EgressPolicy{
+		ObjectMeta: v1.ObjectMeta{Name: "policy1", Namespace: "default", Generation: 1},
+	}
+	clusterPolicy := &egressv1.EgressClusterPolicy{
+		ObjectMeta: v1.ObjectMeta{Name: "policy1", Generation: 1},
+	}
+	initialObjects := []client.Object{
+		policy,
+		clusterPolicy,
+		&egressv1.EgressAgentStatus{
+			ObjectMeta: v1.ObjectMeta{Name: "node1"},
+			Status: egressv1.AgentStatus{Policies: []egressv1.AppliedPolicy{
+				{Name: "policy1", Namespace: "default", Generation: 1, Programmed: true},
+				{Name: "policy1", Generation: 1, Programmed: true},
+			}},
+		},
+		&egressv1.EgressAgentStatus{
+			ObjectMeta: v1.ObjectMeta{Name: "node2"},
+			Status: egressv1.AgentStatus{Policies: []egressv1.AppliedPolicy{
+				{Name: "policy1", Namespace: "default", Generation: 1, Programmed: true},
+			}},
+		},
+	}
+
+	builder := fake.NewClientBuilder()
+	builder.WithScheme(schema.GetScheme())
+	builder.WithObjects(initialObjects...)
+	builder.WithStatusSubresource(initialObjects...)
+
+	r := &agentStatusReconciler{
+		client: builder.Build(),
+		log:    logger.NewLogger(logger.Config{}),
+		config: &config.Config{},
+	}
+
+	ctx := context.Background()
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "EgressAgentStatus/", Name: "node1"}}
+	if _, err := r.Reconcile(ctx, req); !assert.NoError(t, err) {
+		return
+	}
+
+	if !assert.NoError(t, r.client.Get(ctx, client.ObjectKeyFromObject(policy), policy)) {
+		return
+	}
+	assert.Equal(t, &egressv1.PolicyDatapath{ProgrammedNodes: 2, TotalNodes: 2}, policy.Status.Datapath)
+
+	if !assert.NoError(t, r.client.Get(ctx, client.ObjectKeyFromObject(clusterPolicy), clusterPolicy)) {
+		return
+	}
+	assert.Equal(t, &egressv1.PolicyDatapath{ProgrammedNodes: 1, TotalNodes: 2}, clusterPolicy.Status.Datapath)
+}
@@ -12,6 +12,8 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
@@ -115,24 +117,42 @@ func (r *eciReconciler) Reconcile(ctx context.Context, req reconcile.Request) (r
 		return reconcile.Result{}, nil
 	}
 
-	if err != nil {
-		return reconcile.Result{Requeue: true}, err
-	}
-
+	// a failure is reported in the status before the request is retried
+	r.setReadyCondition(err)
 	if !reflect.DeepEqual(eciStatusCopy, r.eci.Status) {
-		err = r.client.Status().Update(ctx, r.eci)
-		if err != nil {
+		if updateErr := r.client.Status().Update(ctx, r.eci); updateErr != nil && err == nil {
 			//r.eci = eciCopy
-			if errors.IsConflict(err) {
+			if errors.IsConflict(updateErr) {
 				return reconcile.Result{Requeue: true}, nil
 			}
-			return reconcile.Result{Requeue: true}, err
+			return reconcile.Result{Requeue: true}, updateErr
 		}
 	}
+	if err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
 
 	return reconcile.Result{}, nil
 }
 
+// setReadyCondition sets the Ready condition of the EgressClusterInfo from the error
+// of the detection of the cluster CIDRs.
+func (r *eciReconciler) setReadyCondition(err error) {
+	cond := metav1.Condition{
+		Type:               egressv1beta1.ConditionReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: r.eci.Generation,
+		Reason:             egressv1beta1.ReasonDetected,
+		Message:            "The cluster CIDRs are detected",
+	}
+	if err != nil {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = egressv1beta1.ReasonDetectFailed
+		cond.Message = err.Error()
+	}
+	meta.SetStatusCondition(&r.eci.Status.Conditions, cond)
+}
+
 // reconcileEgressClusterInfo reconcile cr egressClusterInfo
 func (r *eciReconciler) reconcileEgressClusterInfo(ctx context.Context, req reconcile.Request, log logr.Logger) error {
 	log = log.WithValues("name", req.Name, "namespace", req.Namespace)
@@ -78,18 +78,22 @@ func (r *egcpReconciler) reconcileEGW(ctx context.Context, req reconcile.Request
 			newEGCP.Status.Node = ""
 
 			policy := v1beta1.Policy{Name: item.Name, Namespace: item.Namespace}
+			var policyEip *v1beta1.Eips
 			eipStatus, isExist := egressgateway.GetEIPStatusByPolicy(policy, *egw)
 			if isExist {
-				for _, eip := range eipStatus.Eips {
+				for i, eip := range eipStatus.Eips {
 					for _, p := range eip.Policies {
 						if p == policy {
 							newEGCP.Status.Eip.Ipv4 = eip.IPv4
 							newEGCP.Status.Eip.Ipv6 = eip.IPv6
 							newEGCP.Status.Node = eipStatus.Name
+							policyEip = &eipStatus.Eips[i]
 						}
 					}
 				}
 			}
+			egressgateway.SetEIPSharedCondition(&newEGCP.Status, policyEip, item.Generation)
+			newEGCP.Status.SetEIPAllocatedCondition(item.Generation)
 
 			log.V(1).Info("update egressclusterpolicy status", "status", newEGCP.Status)
 			err = r.client.Status().Update(ctx, newEGCP)
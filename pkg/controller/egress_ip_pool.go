@@ -0,0 +1,134 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/egressgateway"
+	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+type eipPoolReconciler struct {
+	client client.Client
+	log    logr.Logger
+	config *config.Config
+}
+
+func (r *eipPoolReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	kind, newReq, err := utils.ParseKindWithReq(req)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	log := r.log.WithValues("name", newReq.Name, "kind", kind)
+	log.V(1).Info("reconciling")
+	switch kind {
+	case "EgressIPPool":
+		return r.reconcilePool(ctx, newReq.Name, log)
+	case "EgressGateway":
+		// the gateway may have been removed from the pool, so refresh all pools
+		poolList := &v1beta1.EgressIPPoolList{}
+		if err := r.client.List(ctx, poolList); err != nil {
+			return reconcile.Result{Requeue: true}, err
+		}
+		for _, item := range poolList.Items {
+			res, err := r.reconcilePool(ctx, item.Name, log)
+			if err != nil || res.Requeue {
+				return res, err
+			}
+		}
+		return reconcile.Result{}, nil
+	default:
+		return reconcile.Result{}, nil
+	}
+}
+
+// reconcilePool reconcile EgressIPPool
+// goal:
+// - update the allocations and the IP usage of EgressIPPool
+func (r *eipPoolReconciler) reconcilePool(ctx context.Context, name string, log logr.Logger) (reconcile.Result, error) {
+	pool := new(v1beta1.EgressIPPool)
+	err := r.client.Get(ctx, client.ObjectKey{Name: name}, pool)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{Requeue: true}, err
+	}
+	if !pool.GetDeletionTimestamp().IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	gateways, err := egressgateway.ListPoolGateways(ctx, r.client, pool.Name)
+	if err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
+	eipPool, err := egressgateway.NewEIPPool(pool.Name, pool.Spec, gateways)
+	if err != nil {
+		log.Error(err, "parse EgressIPPool")
+		return reconcile.Result{}, nil
+	}
+
+	status := v1beta1.EgressIPPoolStatus{Allocations: eipPool.Allocations()}
+	status.IPUsage.IPv4Free, status.IPUsage.IPv6Free, status.IPUsage.IPv4Total, status.IPUsage.IPv6Total = eipPool.Count()
+	status.Conditions = pool.Status.DeepCopy().Conditions
+	status.SetExhaustedCondition(pool.Generation)
+	if reflect.DeepEqual(status, pool.Status) {
+		return reconcile.Result{}, nil
+	}
+
+	pool.Status = status
+	log.V(1).Info("update egressippool status", "status", pool.Status)
+	err = r.client.Status().Update(ctx, pool)
+	if err != nil {
+		log.Error(err, "update egressippool status", "status", pool.Status)
+		return reconcile.Result{Requeue: true}, err
+	}
+
+	return reconcile.Result{}, nil
+}
+
+func newEgressIPPoolController(mgr manager.Manager, log logr.Logger, cfg *config.Config) error {
+	if cfg == nil {
+		return fmt.Errorf("cfg can not be nil")
+	}
+
+	r := &eipPoolReconciler{
+		client: mgr.GetClient(),
+		log:    log,
+		config: cfg,
+	}
+
+	log.Info("new egress ip pool controller")
+	c, err := controller.New("egressippool", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	if err := c.Watch(source.Kind(mgr.GetCache(), &v1beta1.EgressIPPool{}),
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("EgressIPPool"))); err != nil {
+		return fmt.Errorf("failed to watch EgressIPPool: %w", err)
+	}
+
+	if err := c.Watch(source.Kind(mgr.GetCache(), &v1beta1.EgressGateway{}),
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("EgressGateway"))); err != nil {
+		return fmt.Errorf("failed to watch EgressGateway: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/egressgateway"
+	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/utils"
+)
+
+type eipQuotaReconciler struct {
+	client client.Client
+	log    logr.Logger
+	config *config.Config
+}
+
+func (r *eipQuotaReconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	kind, newReq, err := utils.ParseKindWithReq(req)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	log := r.log.WithValues("name", newReq.Name, "namespace", newReq.Namespace, "kind", kind)
+	log.V(1).Info("reconciling")
+	switch kind {
+	case "EgressIPQuota":
+		return r.reconcileQuota(ctx, newReq, log)
+	case "EgressGateway":
+		// the usage of every namespace may have changed
+		return r.reconcileQuotas(ctx, "", log)
+	case "EgressPolicy":
+		return r.reconcileQuotas(ctx, newReq.Namespace, log)
+	default:
+		return reconcile.Result{}, nil
+	}
+}
+
+func (r *eipQuotaReconciler) reconcileQuotas(ctx context.Context, namespace string, log logr.Logger) (reconcile.Result, error) {
+	quotaList := &v1beta1.EgressIPQuotaList{}
+	if err := r.client.List(ctx, quotaList, client.InNamespace(namespace)); err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
+	for _, item := range quotaList.Items {
+		req := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&item)}
+		res, err := r.reconcileQuota(ctx, req, log)
+		if err != nil || res.Requeue {
+			return res, err
+		}
+	}
+	return reconcile.Result{}, nil
+}
+
+// reconcileQuota reconcile EgressIPQuota
+// goal:
+// - update the usage of the namespace in EgressIPQuota status
+func (r *eipQuotaReconciler) reconcileQuota(ctx context.Context, req reconcile.Request, log logr.Logger) (reconcile.Result, error) {
+	quota := new(v1beta1.EgressIPQuota)
+	err := r.client.Get(ctx, req.NamespacedName, quota)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{Requeue: true}, err
+	}
+	if !quota.GetDeletionTimestamp().IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	egwList := &v1beta1.EgressGatewayList{}
+	if err := r.client.List(ctx, egwList); err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
+
+	var usages []v1beta1.EgressIPQuotaUsage
+	for _, egw := range egwList.Items {
+		if !quota.Spec.AppliesTo(egw.Name) {
+			continue
+		}
+		usage, err := egressgateway.GetNamespaceUsage(ctx, r.client, quota.Namespace, &egw)
+		if err != nil {
+			return reconcile.Result{Requeue: true}, err
+		}
+		// a quota for every gateway only reports the gateways the namespace uses
+		if len(quota.Spec.EgressGatewayName) == 0 && len(usage.Policies) == 0 && len(usage.EIPs) == 0 {
+			continue
+		}
+		usages = append(usages, v1beta1.EgressIPQuotaUsage{
+			EgressGateway: egw.Name,
+			EIPs:          len(usage.EIPs),
+			Policies:      len(usage.Policies),
+		})
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].EgressGateway < usages[j].EgressGateway
+	})
+
+	status := v1beta1.EgressIPQuotaStatus{Usage: usages, Conditions: quota.Status.DeepCopy().Conditions}
+	status.SetExceededCondition(quota.Generation, quota.Spec)
+	if reflect.DeepEqual(status, quota.Status) {
+		return reconcile.Result{}, nil
+	}
+
+	quota.Status = status
+	log.V(1).Info("update egressipquota status", "status", quota.Status)
+	err = r.client.Status().Update(ctx, quota)
+	if err != nil {
+		log.Error(err, "update egressipquota status", "status", quota.Status)
+		return reconcile.Result{Requeue: true}, err
+	}
+
+	return reconcile.Result{}, nil
+}
+
+func newEgressIPQuotaController(mgr manager.Manager, log logr.Logger, cfg *config.Config) error {
+	if cfg == nil {
+		return fmt.Errorf("cfg can not be nil")
+	}
+
+	r := &eipQuotaReconciler{
+		client: mgr.GetClient(),
+		log:    log,
+		config: cfg,
+	}
+
+	log.Info("new egress ip quota controller")
+	c, err := controller.New("egressipquota", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	if err := c.Watch(source.Kind(mgr.GetCache(), &v1beta1.EgressIPQuota{}),
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("EgressIPQuota"))); err != nil {
+		return fmt.Errorf("failed to watch EgressIPQuota: %w", err)
+	}
+
+	if err := c.Watch(source.Kind(mgr.GetCache(), &v1beta1.EgressGateway{}),
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("EgressGateway"))); err != nil {
+		return fmt.Errorf("failed to watch EgressGateway: %w", err)
+	}
+
+	if err := c.Watch(source.Kind(mgr.GetCache(), &v1beta1.EgressPolicy{}),
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("EgressPolicy"))); err != nil {
+		return fmt.Errorf("failed to watch EgressPolicy: %w", err)
+	}
+
+	return nil
+}
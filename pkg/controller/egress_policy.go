@@ -78,18 +78,29 @@ func (r *egpReconciler) reconcileEGW(ctx context.Context, req reconcile.Request,
 			newEGP.Status.Node = ""
 
 			policy := v1beta1.Policy{Name: item.Name, Namespace: item.Namespace}
+			var policyEip *v1beta1.Eips
 			eipStatus, isExist := egressgateway.GetEIPStatusByPolicy(policy, *egw)
 			if isExist {
-				for _, eip := range eipStatus.Eips {
+				for i, eip := range eipStatus.Eips {
 					for _, p := range eip.Policies {
 						if p == policy {
 							newEGP.Status.Eip.Ipv4 = eip.IPv4
 							newEGP.Status.Eip.Ipv6 = eip.IPv6
 							newEGP.Status.Node = eipStatus.Name
+							policyEip = &eipStatus.Eips[i]
 						}
 					}
 				}
+				// without a gateway node the mapping is kept, the pods get the same EIPs back later
+				newEGP.Status.PodEips = nil
+				for _, eip := range eipStatus.StatefulEips {
+					if eip.Policy == policy {
+						newEGP.Status.PodEips = append(newEGP.Status.PodEips, eip.PodEip)
+					}
+				}
 			}
+			egressgateway.SetEIPSharedCondition(&newEGP.Status, policyEip, item.Generation)
+			newEGP.Status.SetEIPAllocatedCondition(item.Generation)
 
 			log.V(1).Info("update egresspolicy status", "status", newEGP.Status)
 			err = r.client.Status().Update(ctx, newEGP)
@@ -524,6 +524,7 @@ func (r *egReconciler) updateEgressTunnel(node egressv1.EgressTunnel) error {
 		node.Status.Phase = egressv1.EgressTunnelPending
 	}
 
+	node.Status.SetReadyCondition(node.Generation)
 	err := r.client.Status().Update(context.Background(), &node)
 	if err != nil {
 		return fmt.Errorf("rebuild failed to update egress tunnel: %v", err)
@@ -625,6 +626,7 @@ func (r *egReconciler) tunnelListCheck(ctx context.Context) error {
 				continue
 			}
 			tunnel.Status.Phase = egressv1.EgressTunnelHeartbeatTimeout
+			tunnel.Status.SetReadyCondition(tunnel.Generation)
 			r.log.Info("update tunnel status to HeartbeatTimeout", "tunnel", tunnel.Name)
 			err := r.client.Status().Update(ctx, tunnel)
 			if err != nil {
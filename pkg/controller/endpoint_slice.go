@@ -196,6 +196,15 @@ func (r *endpointReconciler) Reconcile(ctx context.Context, req reconcile.Reques
 		}
 	}
 
+	// the status is only written on a change, the update of the policy triggers
+	// one more reconcile which finds nothing to change
+	newPolicy := policy.DeepCopy()
+	if newPolicy.Status.SetEndpointsSyncedCondition(policy.Generation, utilerrors.NewAggregate(errs)) {
+		if err := r.client.Status().Update(ctx, newPolicy); err != nil {
+			errs = append(errs, fmt.Errorf("failed to update status of policy %v: %v", policy.Name, err))
+		}
+	}
+
 	return reconcile.Result{}, utilerrors.NewAggregate(errs)
 }
 
@@ -12,6 +12,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -48,6 +49,7 @@ func TestReconcilerEgressEndpointSlice(t *testing.T) {
 			builder := fake.NewClientBuilder()
 			builder.WithScheme(schema.GetScheme())
 			builder.WithObjects(c.initialObjects...)
+			builder.WithStatusSubresource(c.initialObjects...)
 			cli := builder.Build()
 			reconciler := endpointReconciler{
 				client: cli,
@@ -71,6 +73,9 @@ func TestReconcilerEgressEndpointSlice(t *testing.T) {
 				if err != nil {
 					t.Fatal(err)
 				}
+				if !req.expErr {
+					assert.True(t, meta.IsStatusConditionTrue(policy.Status.Conditions, v1beta1.PolicyConditionEndpointsSynced))
+				}
 
 				epList, err := listEndpointSlices(ctx, cli, policy.Namespace, policy.Name)
 				if err != nil {
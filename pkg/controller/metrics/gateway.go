@@ -0,0 +1,96 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+var (
+	descGatewayIPs = prometheus.NewDesc(
+		"egress_gateway_ips",
+		"Number of the EIPs of the EgressGateway, mirroring status.ipUsage",
+		[]string{"gateway", "family", "state"}, nil,
+	)
+	descGatewayNodes = prometheus.NewDesc(
+		"egress_gateway_nodes",
+		"Number of the gateway nodes of the EgressGateway, by the status of their EgressTunnel",
+		[]string{"gateway", "status"}, nil,
+	)
+	descPolicyNode = prometheus.NewDesc(
+		"egress_gateway_policy_node",
+		"The gateway node and EIPs a policy is placed on, the value is always 1",
+		[]string{"gateway", "policy", "node", "ipv4", "ipv6"}, nil,
+	)
+)
+
+// scrapeTimeout bounds the listing of the EgressGateways on each scrape
+const scrapeTimeout = 5 * time.Second
+
+// gatewayCollector exports the status of the EgressGateways when it is scraped, so
+// the series of a deleted gateway or a moved policy disappear with it.
+type gatewayCollector struct {
+	reader client.Reader
+}
+
+func newGatewayCollector(reader client.Reader) *gatewayCollector {
+	return &gatewayCollector{reader: reader}
+}
+
+func (c *gatewayCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- descGatewayIPs
+	ch <- descGatewayNodes
+	ch <- descPolicyNode
+}
+
+func (c *gatewayCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+	defer cancel()
+
+	list := new(egressv1.EgressGatewayList)
+	if err := c.reader.List(ctx, list); err != nil {
+		ch <- prometheus.NewInvalidMetric(descGatewayIPs, err)
+		return
+	}
+	for _, item := range list.Items {
+		collectGateway(ch, &item)
+	}
+}
+
+func collectGateway(ch chan<- prometheus.Metric, egw *egressv1.EgressGateway) {
+	usage := egw.Status.IPUsage
+	for _, item := range []struct {
+		family, state string
+		value         int
+	}{
+		{"ipv4", "free", usage.IPv4Free},
+		{"ipv4", "total", usage.IPv4Total},
+		{"ipv6", "free", usage.IPv6Free},
+		{"ipv6", "total", usage.IPv6Total},
+	} {
+		ch <- prometheus.MustNewConstMetric(descGatewayIPs, prometheus.GaugeValue,
+			float64(item.value), egw.Name, item.family, item.state)
+	}
+
+	nodes := make(map[string]int)
+	for _, node := range egw.Status.NodeList {
+		nodes[node.Status]++
+		for _, eip := range node.Eips {
+			for _, policy := range eip.Policies {
+				ch <- prometheus.MustNewConstMetric(descPolicyNode, prometheus.GaugeValue, 1,
+					egw.Name, PolicyLabel(policy), node.Name, eip.IPv4, eip.IPv6)
+			}
+		}
+	}
+	for status, count := range nodes {
+		ch <- prometheus.MustNewConstMetric(descGatewayNodes, prometheus.GaugeValue,
+			float64(count), egw.Name, status)
+	}
+}
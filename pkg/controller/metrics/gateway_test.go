@@ -0,0 +1,76 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+// gather returns the samples of the registry as "name{label=value,...} value"
+func gather(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+	families, err := reg.Gather()
+	assert.NoError(t, err)
+	res := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			var labels []string
+			for _, label := range metric.GetLabel() {
+				labels = append(labels, label.GetName()+"="+label.GetValue())
+			}
+			key := family.GetName() + "{" + strings.Join(labels, ",") + "}"
+			res[key] = metric.GetGauge().GetValue()
+		}
+	}
+	return res
+}
+
+func TestGatewayCollector(t *testing.T) {
+	egw := &egressv1.EgressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "egw1"},
+		Status: egressv1.EgressGatewayStatus{
+			IPUsage: egressv1.IPUsage{IPv4Free: 8, IPv4Total: 10},
+			NodeList: []egressv1.EgressIPStatus{
+				{
+					Name:   "node1",
+					Status: string(egressv1.EgressTunnelReady),
+					Eips: []egressv1.Eips{{
+						IPv4:     "10.6.1.21",
+						Policies: []egressv1.Policy{{Name: "p1", Namespace: "default"}, {Name: "cp1"}},
+					}},
+				},
+				{Name: "node2", Status: string(egressv1.EgressTunnelHeartbeatTimeout)},
+			},
+		},
+	}
+	cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(egw).Build()
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(newGatewayCollector(cli))
+	samples := gather(t, reg)
+
+	assert.Equal(t, map[string]float64{
+		"egress_gateway_ips{family=ipv4,gateway=egw1,state=free}":                                    8,
+		"egress_gateway_ips{family=ipv4,gateway=egw1,state=total}":                                   10,
+		"egress_gateway_ips{family=ipv6,gateway=egw1,state=free}":                                    0,
+		"egress_gateway_ips{family=ipv6,gateway=egw1,state=total}":                                   0,
+		"egress_gateway_nodes{gateway=egw1,status=HeartbeatTimeout}":                                 1,
+		"egress_gateway_nodes{gateway=egw1,status=Ready}":                                            1,
+		"egress_gateway_policy_node{gateway=egw1,ipv4=10.6.1.21,ipv6=,node=node1,policy=cp1}":        1,
+		"egress_gateway_policy_node{gateway=egw1,ipv4=10.6.1.21,ipv6=,node=node1,policy=default/p1}": 1,
+	}, samples)
+
+	// the series of the deleted gateway disappear
+	assert.NoError(t, cli.Delete(context.Background(), egw))
+	assert.Empty(t, gather(t, reg))
+}
@@ -5,11 +5,102 @@ package metrics
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// The results of a policy reallocation, see ObserveReallocation
+const (
+	// ReallocationAssigned is a policy which had no gateway node
+	ReallocationAssigned = "assigned"
+	// ReallocationKept is a policy which stays on its gateway node
+	ReallocationKept = "kept"
+	// ReallocationMoved is a policy which moved to another gateway node
+	ReallocationMoved = "moved"
+	// ReallocationShared is a policy which shares the EIP of another policy
+	ReallocationShared = "shared"
+	// ReallocationNoFreeEIP is a policy which got no EIP
+	ReallocationNoFreeEIP = "no_free_eip"
+	// ReallocationNoNode is a policy whose gateway has no node
+	ReallocationNoNode = "no_node"
+	// ReallocationFailed is a policy which failed to be reallocated
+	ReallocationFailed = "failed"
 )
 
-func RegisterMetricCollectors() {
-	var metricCollectors []prometheus.Collector
+var (
+	countReallocations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "egress_gateway_policy_reallocations_total",
+		Help: "Total number of gateway node and EIP allocations of the policies, by result",
+	}, []string{"gateway", "policy", "result"})
+
+	histogramFailoverSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "egress_gateway_failover_duration_seconds",
+		Help:    "Time from the last heartbeat of a failed gateway node to the reassignment of its policies",
+		Buckets: []float64{1, 2, 5, 10, 20, 30, 60, 120, 300},
+	}, []string{"gateway", "policy"})
+
+	countWebhookRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "egress_webhook_rejections_total",
+		Help: "Total number of requests denied by the validating and mutating webhooks",
+	}, []string{"kind", "operation", "gateway"})
+
+	countReconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "egress_gateway_reconcile_errors_total",
+		Help: "Total number of failed reconciles of the EgressGateway controller, by the kind of the object",
+	}, []string{"kind", "gateway", "policy"})
+)
+
+// PolicyLabel returns the value of the policy label, namespace/name for an
+// EgressPolicy and name for an EgressClusterPolicy.
+func PolicyLabel(policy egressv1.Policy) string {
+	if policy.Namespace == "" {
+		return policy.Name
+	}
+	return policy.Namespace + "/" + policy.Name
+}
+
+// ObserveReallocation counts a reallocation of the policy with the result.
+func ObserveReallocation(gateway string, policy egressv1.Policy, result string) {
+	countReallocations.WithLabelValues(gateway, PolicyLabel(policy), result).Inc()
+}
+
+// ObserveFailover records the failover time of the policy in seconds.
+func ObserveFailover(gateway string, policy egressv1.Policy, seconds float64) {
+	histogramFailoverSeconds.WithLabelValues(gateway, PolicyLabel(policy)).Observe(seconds)
+}
+
+// ObserveWebhookRejection counts a denied admission request. The name of the denied
+// object is not a label, the objects which are never created would leak series.
+func ObserveWebhookRejection(kind, operation, gateway string) {
+	countWebhookRejections.WithLabelValues(kind, operation, gateway).Inc()
+}
+
+// DeletePolicy drops the series of the deleted policy.
+func DeletePolicy(policy egressv1.Policy) {
+	labels := prometheus.Labels{"policy": PolicyLabel(policy)}
+	countReallocations.DeletePartialMatch(labels)
+	histogramFailoverSeconds.DeletePartialMatch(labels)
+	countReconcileErrors.DeletePartialMatch(labels)
+}
+
+// ObserveReconcileError counts a failed reconcile of an object of kind.
+func ObserveReconcileError(kind, gateway, policy string) {
+	countReconcileErrors.WithLabelValues(kind, gateway, policy).Inc()
+}
+
+// RegisterMetricCollectors registers the controller metrics and the collectors of the
+// other controllers, the gateway state is read from reader when it is scraped.
+func RegisterMetricCollectors(reader client.Reader, collectors ...prometheus.Collector) {
+	metricCollectors := []prometheus.Collector{
+		countReallocations,
+		histogramFailoverSeconds,
+		countWebhookRejections,
+		countReconcileErrors,
+		newGatewayCollector(reader),
+	}
+	metricCollectors = append(metricCollectors, collectors...)
 	for _, collector := range metricCollectors {
 		metrics.Registry.MustRegister(collector)
 	}
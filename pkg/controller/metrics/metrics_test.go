@@ -0,0 +1,37 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+func TestDeletePolicy(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(countReallocations, histogramFailoverSeconds, countReconcileErrors)
+
+	p1 := egressv1.Policy{Name: "p1", Namespace: "default"}
+	p2 := egressv1.Policy{Name: "p2"}
+	for _, policy := range []egressv1.Policy{p1, p2} {
+		ObserveReallocation("egw1", policy, ReallocationAssigned)
+		ObserveFailover("egw1", policy, 3)
+		ObserveReconcileError("EgressPolicy", "", PolicyLabel(policy))
+	}
+
+	DeletePolicy(p1)
+	var keys []string
+	for key := range gather(t, reg) {
+		keys = append(keys, key)
+	}
+	assert.ElementsMatch(t, []string{
+		"egress_gateway_policy_reallocations_total{gateway=egw1,policy=p2,result=assigned}",
+		"egress_gateway_failover_duration_seconds{gateway=egw1,policy=p2}",
+		"egress_gateway_reconcile_errors_total{gateway=,kind=EgressPolicy,policy=p2}",
+	}, keys)
+}
@@ -0,0 +1,47 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/spidernet-io/egressgateway/pkg/controller/metrics"
+)
+
+// withRejectionMetrics counts the requests denied by handler
+func withRejectionMetrics(handler admission.HandlerFunc) admission.HandlerFunc {
+	return func(ctx context.Context, req webhook.AdmissionRequest) webhook.AdmissionResponse {
+		resp := handler(ctx, req)
+		if !resp.Allowed {
+			metrics.ObserveWebhookRejection(req.Kind.Kind, string(req.Operation), rejectionGateway(req))
+		}
+		return resp
+	}
+}
+
+// rejectionGateway returns the gateway of the object in the request
+func rejectionGateway(req webhook.AdmissionRequest) string {
+	switch req.Kind.Kind {
+	case EgressGateway:
+		return req.Name
+	case EgressPolicy, EgressClusterPolicy:
+		raw := req.Object.Raw
+		if len(raw) == 0 {
+			raw = req.OldObject.Raw
+		}
+		obj := struct {
+			Spec struct {
+				EgressGatewayName string `json:"egressGatewayName"`
+			} `json:"spec"`
+		}{}
+		// the gateway label is left empty when the object can not be decoded
+		_ = json.Unmarshal(raw, &obj)
+		return obj.Spec.EgressGatewayName
+	}
+	return ""
+}
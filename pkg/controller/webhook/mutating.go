@@ -14,7 +14,6 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
-	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	"github.com/spidernet-io/egressgateway/pkg/config"
 	"github.com/spidernet-io/egressgateway/pkg/egressgateway"
@@ -24,7 +23,7 @@ import (
 // MutateHook MutateHook
 func MutateHook(client client.Client, cfg *config.Config) *webhook.Admission {
 	return &webhook.Admission{
-		Handler: admission.HandlerFunc(func(ctx context.Context, req webhook.AdmissionRequest) webhook.AdmissionResponse {
+		Handler: withRejectionMetrics(withTracing("webhook mutate", false, func(ctx context.Context, req webhook.AdmissionRequest) webhook.AdmissionResponse {
 
 			switch req.Kind.Kind {
 			case EgressGateway:
@@ -36,7 +35,7 @@ func MutateHook(client client.Client, cfg *config.Config) *webhook.Admission {
 			}
 
 			return webhook.Allowed("checked")
-		}),
+		})),
 	}
 }
 
@@ -63,7 +62,8 @@ func mutateHookEgressPolicy(ctx context.Context, req webhook.AdmissionRequest, c
 					return webhook.Denied(err.Error())
 				}
 				if p != nil {
-					patchList = append(patchList, *p)
+					patchList = append(patchList, *p,
+						annotationPatch(&policy.Annotations, egressv1.AnnotationDefaultGateway, egressv1.DefaultGatewayCluster))
 				}
 			}
 		} else {
@@ -71,7 +71,7 @@ func mutateHookEgressPolicy(ctx context.Context, req webhook.AdmissionRequest, c
 				Operation: "add",
 				Path:      "/spec/egressGatewayName",
 				Value:     egw,
-			})
+			}, annotationPatch(&policy.Annotations, egressv1.AnnotationDefaultGateway, egressv1.DefaultGatewayNamespace))
 		}
 	}
 
@@ -83,6 +83,8 @@ func mutateHookEgressPolicy(ctx context.Context, req webhook.AdmissionRequest, c
 		})
 	}
 
+	patchList = append(patchList, traceContextPatches(ctx, req, &policy.Annotations)...)
+
 	if len(patchList) > 0 {
 		return webhook.Patched("patched", patchList...)
 	}
@@ -133,7 +135,8 @@ func mutateHookEgressClusterPolicy(ctx context.Context, req webhook.AdmissionReq
 			return webhook.Denied(err.Error())
 		}
 		if p != nil {
-			patchList = append(patchList, *p)
+			patchList = append(patchList, *p,
+				annotationPatch(&policy.Annotations, egressv1.AnnotationDefaultGateway, egressv1.DefaultGatewayCluster))
 		}
 	}
 
@@ -145,6 +148,8 @@ func mutateHookEgressClusterPolicy(ctx context.Context, req webhook.AdmissionReq
 		})
 	}
 
+	patchList = append(patchList, traceContextPatches(ctx, req, &policy.Annotations)...)
+
 	if len(patchList) > 0 {
 		return webhook.Patched("patched", patchList...)
 	}
@@ -0,0 +1,117 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gomodules.xyz/jsonpatch/v2"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/spidernet-io/egressgateway/pkg/tracing"
+)
+
+// withTracing records a span of each admission request. The mutating webhook starts
+// the trace of a change, the validating webhook joins the trace written in the
+// annotation of the object by the mutating one.
+func withTracing(name string, join bool, handler admission.HandlerFunc) admission.HandlerFunc {
+	return func(ctx context.Context, req webhook.AdmissionRequest) webhook.AdmissionResponse {
+		if !tracing.Enabled() {
+			return handler(ctx, req)
+		}
+		obj := new(metav1.PartialObjectMetadata)
+		if join && len(req.Object.Raw) > 0 {
+			// the span starts its own trace when the object can not be decoded
+			if err := json.Unmarshal(req.Object.Raw, obj); err == nil {
+				ctx = tracing.ContextFromObject(ctx, obj)
+			}
+		}
+		attrs := []attribute.KeyValue{
+			attribute.String("k8s.kind", req.Kind.Kind),
+			attribute.String("k8s.name", req.Name),
+			attribute.String("operation", string(req.Operation)),
+		}
+		if req.Namespace != "" {
+			attrs = append(attrs, attribute.String("k8s.namespace", req.Namespace))
+		}
+		ctx, span := tracing.Start(ctx, name, attrs...)
+		defer span.End()
+
+		resp := handler(ctx, req)
+		if !resp.Allowed {
+			tracing.RecordError(span, fmt.Errorf("denied: %s", resp.Result.Message))
+		}
+		return resp
+	}
+}
+
+// traceContextPatches returns the patches writing the trace context of the span of ctx
+// in the annotations of a policy which is created or whose spec changes, so that the
+// reconciles of the change join the trace of the webhook. They are nil when tracing
+// is disabled.
+func traceContextPatches(ctx context.Context, req webhook.AdmissionRequest, annotations *map[string]string) []jsonpatch.JsonPatchOperation {
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		return nil
+	}
+	if req.Operation == admissionv1.Update && !specChanged(req) {
+		return nil
+	}
+	values := tracing.Annotations(ctx)
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	// the add operations replace the annotations of the previous change
+	var patches []jsonpatch.JsonPatchOperation
+	for _, key := range keys {
+		patches = append(patches, annotationPatch(annotations, key, values[key]))
+	}
+	return patches
+}
+
+// annotationPatch returns the patch setting the annotation key, the annotations map is
+// created when *annotations is nil and *annotations is updated, so that the following
+// patches of the request add their keys to it.
+func annotationPatch(annotations *map[string]string, key, value string) jsonpatch.JsonPatchOperation {
+	if *annotations == nil {
+		*annotations = map[string]string{key: value}
+		return jsonpatch.JsonPatchOperation{
+			Operation: "add",
+			Path:      "/metadata/annotations",
+			Value:     map[string]string{key: value},
+		}
+	}
+	(*annotations)[key] = value
+	return jsonpatch.JsonPatchOperation{
+		Operation: "add",
+		Path:      "/metadata/annotations/" + strings.ReplaceAll(key, "/", "~1"),
+		Value:     value,
+	}
+}
+
+// specChanged reports whether the update request changes the spec of the object
+func specChanged(req webhook.AdmissionRequest) bool {
+	obj := struct {
+		Spec interface{} `json:"spec"`
+	}{}
+	old := obj
+	if err := json.Unmarshal(req.Object.Raw, &obj); err != nil {
+		return true
+	}
+	if err := json.Unmarshal(req.OldObject.Raw, &old); err != nil {
+		return true
+	}
+	return !reflect.DeepEqual(obj.Spec, old.Spec)
+}
@@ -0,0 +1,84 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	"github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/tracing"
+)
+
+func TestTraceContextPatches(t *testing.T) {
+	spec := func(ip string) runtime.RawExtension {
+		raw, _ := json.Marshal(&v1beta1.EgressPolicy{Spec: v1beta1.EgressPolicySpec{EgressIP: v1beta1.EgressIP{IPv4: ip}}})
+		return runtime.RawExtension{Raw: raw}
+	}
+	create := webhook.AdmissionRequest{}
+	create.Operation = admissionv1.Create
+	create.Object = spec("10.6.1.21")
+
+	update := webhook.AdmissionRequest{}
+	update.Operation = admissionv1.Update
+	update.Object = spec("10.6.1.21")
+	update.OldObject = spec("10.6.1.21")
+
+	changed := update
+	changed.OldObject = spec("10.6.1.22")
+
+	// tracing disabled
+	var annotations map[string]string
+	assert.Nil(t, traceContextPatches(context.Background(), create, &annotations))
+
+	provider, err := tracing.New(tracing.Options{Endpoint: "http://localhost:4318", ExportInterval: time.Second, Log: logr.Discard()})
+	if !assert.NoError(t, err) {
+		return
+	}
+	tracing.SetProvider(provider)
+	defer tracing.SetProvider(nil)
+	ctx, _ := tracing.Start(context.Background(), "webhook mutate")
+	values := tracing.Annotations(ctx)
+
+	patches := traceContextPatches(ctx, create, &annotations)
+	if assert.Len(t, patches, 2) {
+		assert.Equal(t, "/metadata/annotations", patches[0].Path)
+		assert.Equal(t, map[string]string{v1beta1.AnnotationTraceParent: values[v1beta1.AnnotationTraceParent]}, patches[0].Value)
+		assert.Equal(t, "/metadata/annotations/egressgateway.spidernet.io~1tracestate", patches[1].Path)
+	}
+	assert.Equal(t, values, annotations)
+
+	annotations = map[string]string{"app": "test"}
+	patches = traceContextPatches(ctx, changed, &annotations)
+	if assert.Len(t, patches, 2) {
+		assert.Equal(t, "/metadata/annotations/egressgateway.spidernet.io~1traceparent", patches[0].Path)
+		assert.Equal(t, values[v1beta1.AnnotationTraceParent], patches[0].Value)
+	}
+
+	// the updates keeping the spec keep the trace of the last change
+	annotations = map[string]string{v1beta1.AnnotationTraceParent: "old"}
+	assert.Nil(t, traceContextPatches(ctx, update, &annotations))
+}
+
+func TestAnnotationPatch(t *testing.T) {
+	var annotations map[string]string
+	first := annotationPatch(&annotations, v1beta1.AnnotationDefaultGateway, v1beta1.DefaultGatewayCluster)
+	second := annotationPatch(&annotations, v1beta1.AnnotationTraceParent, "value")
+
+	// the second patch adds its key to the map created by the first one
+	assert.Equal(t, "/metadata/annotations", first.Path)
+	assert.Equal(t, "/metadata/annotations/egressgateway.spidernet.io~1traceparent", second.Path)
+	assert.Equal(t, map[string]string{
+		v1beta1.AnnotationDefaultGateway: v1beta1.DefaultGatewayCluster,
+		v1beta1.AnnotationTraceParent:    "value",
+	}, annotations)
+}
@@ -14,7 +14,6 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
-	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	"github.com/spidernet-io/egressgateway/pkg/config"
 	"github.com/spidernet-io/egressgateway/pkg/constant"
@@ -28,12 +27,13 @@ const (
 	EgressGateway       = "EgressGateway"
 	EgressPolicy        = "EgressPolicy"
 	EgressClusterPolicy = "EgressClusterPolicy"
+	EgressIPPool        = "EgressIPPool"
 )
 
 // ValidateHook ValidateHook
 func ValidateHook(client client.Client, cfg *config.Config) *webhook.Admission {
 	return &webhook.Admission{
-		Handler: admission.HandlerFunc(func(ctx context.Context, req webhook.AdmissionRequest) webhook.AdmissionResponse {
+		Handler: withRejectionMetrics(withTracing("webhook validate", true, func(ctx context.Context, req webhook.AdmissionRequest) webhook.AdmissionResponse {
 
 			switch req.Kind.Kind {
 			case EgressClusterInfo:
@@ -47,10 +47,12 @@ func ValidateHook(client client.Client, cfg *config.Config) *webhook.Admission {
 				return validateEgressClusterPolicy(ctx, client, req, cfg)
 			case EgressPolicy:
 				return validateEgressPolicy(ctx, client, req, cfg)
+			case EgressIPPool:
+				return validateEgressIPPool(ctx, client, req, cfg)
 			}
 
 			return webhook.Allowed("checked")
-		}),
+		})),
 	}
 }
 
@@ -93,6 +95,19 @@ func validateEgressPolicy(ctx context.Context, client client.Client, req webhook
 		}
 	}
 
+	if err := checkAllocatorPolicy(egp.Spec.EgressIP.AllocatorPolicy); err != nil {
+		return webhook.Denied(err.Error())
+	}
+
+	if egp.Spec.EgressIP.AllocatorPolicy == egressv1.EipAllocatorSticky {
+		if egp.Spec.EgressIP.UseNodeIP {
+			return webhook.Denied("the sticky allocatorPolicy cannot be used with useNodeIP")
+		}
+		if len(egp.Spec.AppliedTo.PodSubnet) != 0 {
+			return webhook.Denied("the sticky allocatorPolicy selects StatefulSet pods by spec.appliedTo.podSelector, it cannot be used with podSubnet")
+		}
+	}
+
 	if req.Operation == v1.Update {
 		oldEgp := new(egressv1.EgressPolicy)
 		err := json.Unmarshal(req.OldObject.Raw, oldEgp)
@@ -136,12 +151,17 @@ func validateEgressPolicy(ctx context.Context, client client.Client, req webhook
 		}
 
 		// denied when the `Spec.EgressIP.IPv4` or `Spec.EgressIP.IPv6` are not within the ip ranges defined in the ippools of the egressgateway
-		if ok, err := checkEIPIncluded(client, ctx, egp.Spec.EgressIP.IPv4, egp.Spec.EgressIP.IPv6, egp.Spec.EgressGatewayName); !ok {
+		if ok, err := checkEIPIncluded(client, ctx, egp.Spec.EgressIP.IPv4, egp.Spec.EgressIP.IPv6, egp.Spec.EgressGatewayName,
+			egressv1.Policy{Name: egp.Name, Namespace: egp.Namespace}); !ok {
 			if err != nil {
 				return webhook.Denied(err.Error())
 			}
 			return webhook.Denied("the Spec.EgressIP.IPv4 or Spec.EgressIP.IPv6 is not within the ip ranges defined in the ippools of the egressgateway")
 		}
+
+		if err := checkEIPQuota(ctx, client, egp); err != nil {
+			return webhook.Denied(err.Error())
+		}
 	}
 
 	return validateSubnet(egp.Spec.DestSubnet)
@@ -186,6 +206,14 @@ func validateEgressClusterPolicy(ctx context.Context, client client.Client, req
 		}
 	}
 
+	if err := checkAllocatorPolicy(policy.Spec.EgressIP.AllocatorPolicy); err != nil {
+		return webhook.Denied(err.Error())
+	}
+
+	if policy.Spec.EgressIP.AllocatorPolicy == egressv1.EipAllocatorSticky {
+		return webhook.Denied("the sticky allocatorPolicy is only supported by EgressPolicy")
+	}
+
 	if req.Operation == v1.Update {
 		oldPolicy := new(egressv1.EgressClusterPolicy)
 		err := json.Unmarshal(req.OldObject.Raw, oldPolicy)
@@ -230,7 +258,8 @@ func validateEgressClusterPolicy(ctx context.Context, client client.Client, req
 		}
 
 		// denied when the `Spec.EgressIP.IPv4` or `Spec.EgressIP.IPv6` are not within the ip ranges defined in the ippools of the egressgateway
-		if ok, err := checkEIPIncluded(client, ctx, policy.Spec.EgressIP.IPv4, policy.Spec.EgressIP.IPv6, policy.Spec.EgressGatewayName); !ok {
+		if ok, err := checkEIPIncluded(client, ctx, policy.Spec.EgressIP.IPv4, policy.Spec.EgressIP.IPv6, policy.Spec.EgressGatewayName,
+			egressv1.Policy{Name: policy.Name}); !ok {
 			if err != nil {
 				return webhook.Denied(err.Error())
 			}
@@ -241,6 +270,17 @@ func validateEgressClusterPolicy(ctx context.Context, client client.Client, req
 	return validateSubnet(policy.Spec.DestSubnet)
 }
 
+// checkAllocatorPolicy denies an unknown allocatorPolicy, the field is immutable so that
+// a typo could not be fixed later. An empty allocatorPolicy is the default one.
+func checkAllocatorPolicy(allocatorPolicy string) error {
+	switch allocatorPolicy {
+	case "", egressv1.EipAllocatorDefault, egressv1.EipAllocatorRR, egressv1.EipAllocatorShared, egressv1.EipAllocatorSticky:
+		return nil
+	}
+	return fmt.Errorf("invalid spec.egressIP.allocatorPolicy %q, it should be one of %s, %s, %s and %s", allocatorPolicy,
+		egressv1.EipAllocatorDefault, egressv1.EipAllocatorRR, egressv1.EipAllocatorShared, egressv1.EipAllocatorSticky)
+}
+
 // checkEGWIppools when creating the policy with the value of the field .Spec.EgressIP.UseNodeIP set to be false, the ippools of the gateway should not be empty
 func checkEGWIppools(client client.Client, cfg *config.Config, ctx context.Context, name, allocatorPolicy string) error {
 
@@ -251,7 +291,7 @@ func checkEGWIppools(client client.Client, cfg *config.Config, ctx context.Conte
 		return fmt.Errorf("failed to obtain the EgressGateway: %v", err)
 	}
 
-	if len(egw.Spec.Ippools.IPv4) == 0 && len(egw.Spec.Ippools.IPv6) == 0 {
+	if len(egw.Spec.Ippools.IPv4) == 0 && len(egw.Spec.Ippools.IPv6) == 0 && len(egw.Spec.Ippools.EgressIPPool) == 0 {
 		return fmt.Errorf("referenced egw(%v) spec.Ippools cannot be empty", egw.Name)
 	}
 
@@ -301,8 +341,76 @@ func checkEIP(client client.Client, ctx context.Context, ipv4, ipv6, egwName str
 	return true, nil
 }
 
-// checkEIPIncluded check if the `Spec.EgressIP.IPv4` or `Spec.EgressIP.IPv6` are within the ip ranges defined in the ippools of the egressgateway
-func checkEIPIncluded(client client.Client, ctx context.Context, ipv4, ipv6, egwName string) (bool, error) {
+// checkEIPQuota denied when the policy makes its namespace exceed an EgressIPQuota of the referenced gateway
+func checkEIPQuota(ctx context.Context, cli client.Client, egp *egressv1.EgressPolicy) error {
+	quotaList := new(egressv1.EgressIPQuotaList)
+	err := cli.List(ctx, quotaList, client.InNamespace(egp.Namespace))
+	if err != nil {
+		return fmt.Errorf("failed to list EgressIPQuota: %v", err)
+	}
+	if len(quotaList.Items) == 0 {
+		return nil
+	}
+
+	egw := new(egressv1.EgressGateway)
+	err = cli.Get(ctx, types.NamespacedName{Name: egp.Spec.EgressGatewayName}, egw)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get the EgressGateway: %v", err)
+	}
+
+	usage, err := egressgateway.GetNamespaceUsage(ctx, cli, egp.Namespace, egw)
+	if err != nil {
+		return fmt.Errorf("failed to count the usage of namespace %v: %v", egp.Namespace, err)
+	}
+
+	policies := len(usage.Policies)
+	if _, ok := usage.Policies[egp.Name]; !ok {
+		policies++
+	}
+
+	eips := len(usage.EIPs)
+	if needNewEIP(egp, egw, usage) {
+		eips++
+	}
+
+	for _, quota := range quotaList.Items {
+		if !quota.Spec.AppliesTo(egw.Name) {
+			continue
+		}
+		if quota.Spec.MaxPolicies != nil && policies > *quota.Spec.MaxPolicies {
+			return fmt.Errorf("exceeded EgressIPQuota %v: namespace %v can have at most %v policies on EgressGateway %v",
+				quota.Name, egp.Namespace, *quota.Spec.MaxPolicies, egw.Name)
+		}
+		if quota.Spec.MaxEIPs != nil && eips > *quota.Spec.MaxEIPs {
+			return fmt.Errorf("exceeded EgressIPQuota %v: namespace %v can use at most %v EIPs on EgressGateway %v",
+				quota.Name, egp.Namespace, *quota.Spec.MaxEIPs, egw.Name)
+		}
+	}
+
+	return nil
+}
+
+// needNewEIP reports whether the policy would add an EIP to those already used by its namespace
+func needNewEIP(egp *egressv1.EgressPolicy, egw *egressv1.EgressGateway, usage egressgateway.NamespaceUsage) bool {
+	if egp.Spec.EgressIP.UseNodeIP {
+		return false
+	}
+	if len(egp.Spec.EgressIP.IPv4) != 0 || len(egp.Spec.EgressIP.IPv6) != 0 {
+		return !usage.HasEIP(egp.Spec.EgressIP.IPv4, egp.Spec.EgressIP.IPv6, egw)
+	}
+	if egp.Spec.EgressIP.AllocatorPolicy == egressv1.EipAllocatorRR || egp.Spec.EgressIP.AllocatorPolicy == egressv1.EipAllocatorShared ||
+		egp.Spec.EgressIP.AllocatorPolicy == egressv1.EipAllocatorSticky {
+		return true
+	}
+	return !usage.HasEIP(egw.Spec.Ippools.Ipv4DefaultEIP, egw.Spec.Ippools.Ipv6DefaultEIP, egw)
+}
+
+// checkEIPIncluded check if the `Spec.EgressIP.IPv4` or `Spec.EgressIP.IPv6` are within the ip ranges defined in the ippools of the egressgateway,
+// and whether the policy is allowed to use them
+func checkEIPIncluded(client client.Client, ctx context.Context, ipv4, ipv6, egwName string, policy egressv1.Policy) (bool, error) {
 	eipIPV4 := ipv4
 	eipIPV6 := ipv6
 
@@ -318,19 +426,20 @@ func checkEIPIncluded(client client.Client, ctx context.Context, ipv4, ipv6, egw
 		}
 	}
 
-	if len(egw.Spec.Ippools.IPv4) != 0 || len(egw.Spec.Ippools.IPv6) != 0 {
-		ips := append(egw.Spec.Ippools.IPv4, egw.Spec.Ippools.IPv6...)
-		if len(ipv4) != 0 {
-			ok, err := ip.CheckIPIncluded(ipv4, ips)
-			if !ok {
-				return ok, err
-			}
+	if len(egw.Spec.Ippools.IPv4) == 0 && len(egw.Spec.Ippools.IPv6) == 0 && len(egw.Spec.Ippools.EgressIPPool) == 0 {
+		return true, nil
+	}
+
+	pool, err := egressgateway.GetEIPPool(ctx, client, egw)
+	if err != nil {
+		return false, err
+	}
+	for _, eip := range []string{eipIPV4, eipIPV6} {
+		if len(eip) == 0 {
+			continue
 		}
-		if len(ipv6) != 0 {
-			ok, err := ip.CheckIPIncluded(ipv6, ips)
-			if !ok {
-				return ok, err
-			}
+		if err := pool.CheckUsable(egw.Name, eip, policy); err != nil {
+			return false, err
 		}
 	}
 	return true, nil
@@ -372,6 +481,81 @@ func countGatewayAvailableIP(egw *egressv1.EgressGateway) (int, int, error) {
 	return len(freeIpv4s), len(freeIpv6s), nil
 }
 
+func validateEgressIPPool(ctx context.Context, client client.Client, req webhook.AdmissionRequest, cfg *config.Config) webhook.AdmissionResponse {
+	if req.Operation == v1.Delete {
+		gateways, err := egressgateway.ListPoolGateways(ctx, client, req.Name)
+		if err != nil {
+			return webhook.Denied(fmt.Sprintf("failed to list EgressGateway: %v", err))
+		}
+		if len(gateways) != 0 {
+			return webhook.Denied(fmt.Sprintf("EgressIPPool %v is referenced by EgressGateway %v", req.Name, gateways[0].Name))
+		}
+		return webhook.Allowed("checked")
+	}
+
+	pool := new(egressv1.EgressIPPool)
+	err := json.Unmarshal(req.Object.Raw, pool)
+	if err != nil {
+		return webhook.Denied(fmt.Sprintf("json unmarshal EgressIPPool with error: %v", err))
+	}
+
+	if !cfg.FileConfig.EnableIPv6 && (len(pool.Spec.IPv6) != 0 || len(pool.Spec.ExcludeIPv6) != 0) {
+		return webhook.Denied("Please do not configure spec.ipv6, as the current installation settings have not enabled IPv6")
+	}
+	if !cfg.FileConfig.EnableIPv4 && (len(pool.Spec.IPv4) != 0 || len(pool.Spec.ExcludeIPv4) != 0) {
+		return webhook.Denied("Please do not configure spec.ipv4, as the current installation settings have not enabled IPv4")
+	}
+
+	eipPool, err := egressgateway.NewEIPPool(pool.Name, pool.Spec, nil)
+	if err != nil {
+		return webhook.Denied(fmt.Sprintf("invalid EgressIPPool: %v", err))
+	}
+
+	reserved := make(map[string]struct{})
+	for _, r := range pool.Spec.Reservations {
+		if len(r.IPv4) == 0 && len(r.IPv6) == 0 {
+			return webhook.Denied("reservation requires at least one of ipv4 or ipv6")
+		}
+		if (len(r.Namespace) == 0) == (r.Policy == nil) {
+			return webhook.Denied("reservation requires exactly one of namespace or policy")
+		}
+		if len(r.IPv4) != 0 && !isIPv4(r.IPv4) {
+			return webhook.Denied(fmt.Sprintf("invalid reservation ipv4 %v", r.IPv4))
+		}
+		if len(r.IPv6) != 0 && !isIPv6(r.IPv6) {
+			return webhook.Denied(fmt.Sprintf("invalid reservation ipv6 %v", r.IPv6))
+		}
+		for _, eip := range []string{r.IPv4, r.IPv6} {
+			if len(eip) == 0 {
+				continue
+			}
+			if !eipPool.Contains(eip) {
+				return webhook.Denied(fmt.Sprintf("reserved IP %v is not within the pool or is excluded", eip))
+			}
+			if _, ok := reserved[net.ParseIP(eip).String()]; ok {
+				return webhook.Denied(fmt.Sprintf("IP %v is reserved more than once", eip))
+			}
+			reserved[net.ParseIP(eip).String()] = struct{}{}
+		}
+	}
+
+	if req.Operation == v1.Update {
+		oldPool := new(egressv1.EgressIPPool)
+		err := json.Unmarshal(req.OldObject.Raw, oldPool)
+		if err != nil {
+			return webhook.Denied(fmt.Sprintf("json unmarshal EgressIPPool with error: %v", err))
+		}
+		// the IPs in use cannot be removed or excluded
+		for _, item := range oldPool.Status.Allocations {
+			if !eipPool.Contains(item.IP) {
+				return webhook.Denied(fmt.Sprintf("%v has been allocated by EgressGateway %v and cannot be deleted", item.IP, item.EgressGateway))
+			}
+		}
+	}
+
+	return webhook.Allowed("checked")
+}
+
 func validateSubnet(subnet []string) webhook.AdmissionResponse {
 	invalidList := make([]string, 0)
 	for _, subnet := range subnet {
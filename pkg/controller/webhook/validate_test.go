@@ -296,6 +296,97 @@ func TestValidateEgressPolicy(t *testing.T) {
 			},
 			expAllow: false,
 		},
+		"case9 the policy's eip is reserved for another namespace in the EgressIPPool": {
+			existingResources: []client.Object{
+				&v1beta1.EgressIPPool{
+					ObjectMeta: metav1.ObjectMeta{Name: "pool"},
+					Spec: v1beta1.EgressIPPoolSpec{
+						IPv4: []string{"172.18.1.2-172.18.1.5"},
+						Reservations: []v1beta1.EgressIPReservation{
+							{IPv4: "172.18.1.2", Namespace: "other"},
+						},
+					},
+				},
+				&v1beta1.EgressGateway{
+					ObjectMeta: metav1.ObjectMeta{Name: "test"},
+					Spec: v1beta1.EgressGatewaySpec{
+						Ippools: v1beta1.Ippools{EgressIPPool: "pool"},
+					},
+				},
+			},
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP: v1beta1.EgressIP{
+					IPv4: "172.18.1.2",
+				},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			expAllow: false,
+		},
+		"case10 the policy's eip is reserved for the policy in the EgressIPPool": {
+			existingResources: []client.Object{
+				&v1beta1.EgressIPPool{
+					ObjectMeta: metav1.ObjectMeta{Name: "pool"},
+					Spec: v1beta1.EgressIPPoolSpec{
+						IPv4: []string{"172.18.1.2-172.18.1.5"},
+						Reservations: []v1beta1.EgressIPReservation{
+							{IPv4: "172.18.1.2", Policy: &v1beta1.Policy{Name: "policy"}},
+						},
+					},
+				},
+				&v1beta1.EgressGateway{
+					ObjectMeta: metav1.ObjectMeta{Name: "test"},
+					Spec: v1beta1.EgressGatewaySpec{
+						Ippools: v1beta1.Ippools{EgressIPPool: "pool"},
+					},
+				},
+			},
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP: v1beta1.EgressIP{
+					IPv4: "172.18.1.2",
+				},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			expAllow: true,
+		},
+		"case11 sticky policy selects pods by podSubnet": {
+			existingResources: nil,
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP: v1beta1.EgressIP{
+					AllocatorPolicy: v1beta1.EipAllocatorSticky,
+				},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSubnet: []string{"10.6.1.0/24"},
+				},
+			},
+			expAllow: false,
+		},
+		"case12 unknown allocatorPolicy": {
+			existingResources: nil,
+			spec: v1beta1.EgressPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP: v1beta1.EgressIP{
+					AllocatorPolicy: "stiky",
+				},
+				AppliedTo: v1beta1.AppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			expAllow:      false,
+			expErrMessage: `invalid spec.egressIP.allocatorPolicy "stiky", it should be one of default, rr, shared and sticky`,
+		},
 	}
 	for name, c := range cases {
 		t.Run(name, func(t *testing.T) {
@@ -753,6 +844,20 @@ func TestValidateEgressClusterPolicy(t *testing.T) {
 			},
 			expAllow: false,
 		},
+		"case7 unknown allocatorPolicy": {
+			spec: v1beta1.EgressClusterPolicySpec{
+				EgressGatewayName: "test",
+				EgressIP: v1beta1.EgressIP{
+					AllocatorPolicy: "random",
+				},
+				AppliedTo: v1beta1.ClusterAppliedTo{
+					PodSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{"app": "test"},
+					},
+				},
+			},
+			expAllow: false,
+		},
 	}
 	for name, c := range cases {
 		t.Run(name, func(t *testing.T) {
@@ -1056,3 +1161,112 @@ func TestCountAvailableIP(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateEgressPolicyQuota(t *testing.T) {
+	ctx := context.Background()
+	one := 1
+
+	egw := &v1beta1.EgressGateway{
+		ObjectMeta: metav1.ObjectMeta{Name: "test"},
+		Spec: v1beta1.EgressGatewaySpec{
+			Ippools: v1beta1.Ippools{
+				IPv4:           []string{"172.18.1.2-172.18.1.5"},
+				Ipv4DefaultEIP: "172.18.1.2",
+			},
+		},
+		Status: v1beta1.EgressGatewayStatus{
+			NodeList: []v1beta1.EgressIPStatus{
+				{Name: "node1", Eips: []v1beta1.Eips{
+					{IPv4: "172.18.1.2", Policies: []v1beta1.Policy{{Name: "p1", Namespace: "ns1"}}},
+				}},
+			},
+		},
+	}
+	existing := &v1beta1.EgressPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "p1", Namespace: "ns1"},
+		Spec:       v1beta1.EgressPolicySpec{EgressGatewayName: "test"},
+	}
+
+	cases := map[string]struct {
+		quota    v1beta1.EgressIPQuotaSpec
+		egressIP v1beta1.EgressIP
+		expAllow bool
+	}{
+		"default eip already used by the namespace": {
+			quota:    v1beta1.EgressIPQuotaSpec{EgressGatewayName: "test", MaxEIPs: &one},
+			egressIP: v1beta1.EgressIP{},
+			expAllow: true,
+		},
+		"rr allocates a new eip": {
+			quota:    v1beta1.EgressIPQuotaSpec{MaxEIPs: &one},
+			egressIP: v1beta1.EgressIP{AllocatorPolicy: v1beta1.EipAllocatorRR},
+			expAllow: false,
+		},
+		"node ip does not use eip": {
+			quota:    v1beta1.EgressIPQuotaSpec{MaxEIPs: &one},
+			egressIP: v1beta1.EgressIP{UseNodeIP: true},
+			expAllow: true,
+		},
+		"too many policies": {
+			quota:    v1beta1.EgressIPQuotaSpec{MaxPolicies: &one},
+			egressIP: v1beta1.EgressIP{UseNodeIP: true},
+			expAllow: false,
+		},
+		"quota of another gateway": {
+			quota:    v1beta1.EgressIPQuotaSpec{EgressGatewayName: "other", MaxPolicies: &one},
+			egressIP: v1beta1.EgressIP{UseNodeIP: true},
+			expAllow: true,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			quota := &v1beta1.EgressIPQuota{
+				ObjectMeta: metav1.ObjectMeta{Name: "quota", Namespace: "ns1"},
+				Spec:       c.quota,
+			}
+			policy := &v1beta1.EgressPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "p2", Namespace: "ns1"},
+				Spec: v1beta1.EgressPolicySpec{
+					EgressGatewayName: "test",
+					EgressIP:          c.egressIP,
+					AppliedTo: v1beta1.AppliedTo{
+						PodSelector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{"app": "test"},
+						},
+					},
+				},
+			}
+
+			marshalledRequestObject, err := json.Marshal(policy)
+			assert.NoError(t, err)
+
+			builder := fake.NewClientBuilder()
+			builder.WithScheme(schema.GetScheme())
+			builder.WithObjects(egw.DeepCopy(), existing.DeepCopy(), quota)
+			cli := builder.Build()
+			conf := &config.Config{
+				FileConfig: config.FileConfig{
+					EnableIPv4: true,
+				},
+			}
+
+			validator := ValidateHook(cli, conf)
+			resp := validator.Handle(ctx, admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					Name:      policy.Name,
+					Namespace: policy.Namespace,
+					Kind: metav1.GroupVersionKind{
+						Kind: "EgressPolicy",
+					},
+					Operation: admissionv1.Create,
+					Object: runtime.RawExtension{
+						Raw: marshalledRequestObject,
+					},
+				},
+			})
+
+			assert.Equal(t, c.expAllow, resp.Allowed, resp.Result.Message)
+		})
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressctl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/datapath"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// datapathPath is the path of the datapath debug handler of the agent
+const datapathPath = "/debug/datapath"
+
+// ProxyFunc gets the path from the port of a pod, e.g. through the API server
+type ProxyFunc func(ctx context.Context, namespace, pod string, port int, path string) ([]byte, error)
+
+// WithProxy sets the function used to reach the agents
+func (i *Inspector) WithProxy(proxy ProxyFunc) *Inspector {
+	i.proxy = proxy
+	return i
+}
+
+// DatapathOptions selects the agent of the node and the output
+type DatapathOptions struct {
+	// AgentSelector is the label selector of the agent pods
+	AgentSelector string
+	// Port is the metrics port of the agent, the debug handlers are served on it
+	Port int
+	// Output is "json" for the raw state, the summary and the drift are printed otherwise
+	Output string
+}
+
+// Datapath prints the desired and the actual datapath the agent of the node reports,
+// with the drift between them.
+func (i *Inspector) Datapath(ctx context.Context, node string, opts DatapathOptions) error {
+	if i.proxy == nil {
+		return fmt.Errorf("the agents can not be reached")
+	}
+	selector, err := labels.Parse(opts.AgentSelector)
+	if err != nil {
+		return fmt.Errorf("invalid agent selector %q: %w", opts.AgentSelector, err)
+	}
+	pods := new(corev1.PodList)
+	if err := i.client.List(ctx, pods, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return fmt.Errorf("failed to list the agent pods: %w", err)
+	}
+	var agent *corev1.Pod
+	for n, item := range pods.Items {
+		if item.Spec.NodeName == node && item.Status.Phase == corev1.PodRunning {
+			agent = &pods.Items[n]
+			break
+		}
+	}
+	if agent == nil {
+		return fmt.Errorf("no running agent on node %s", node)
+	}
+
+	raw, err := i.proxy(ctx, agent.Namespace, agent.Name, opts.Port, datapathPath)
+	if err != nil {
+		return fmt.Errorf("failed to get %s from agent %s/%s: %w", datapathPath, agent.Namespace, agent.Name, err)
+	}
+	if opts.Output == "json" {
+		_, err := i.out.Write(raw)
+		return err
+	}
+	state := new(datapath.State)
+	if err := json.Unmarshal(raw, state); err != nil {
+		return fmt.Errorf("failed to decode the datapath of agent %s/%s: %w", agent.Namespace, agent.Name, err)
+	}
+	return i.printDatapath(state)
+}
+
+func (i *Inspector) printDatapath(state *datapath.State) error {
+	fmt.Fprintf(i.out, "Node %s\n\n", state.Node)
+
+	w := newTabWriter(i.out)
+	fmt.Fprintln(w, "COMPONENT\tOBJECT\tDESIRED\tACTUAL\tERROR")
+	for _, table := range state.IPTables {
+		desired, actual := 0, 0
+		for _, chain := range table.Desired {
+			desired += len(chain.Hashes)
+		}
+		for _, hashes := range table.Actual {
+			actual += len(hashes)
+		}
+		fmt.Fprintf(w, "%s\tipv%d/%s\t%d\t%d\t%s\n", egressv1.ComponentIPTables, table.IPVersion, table.Name, desired, actual, joinOrDash(table.Error))
+	}
+	entries := func(component string, item datapath.Entries) {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n", component, item.Name, len(item.Desired), len(item.Actual), joinOrDash(item.Error))
+	}
+	for _, set := range state.IPSets {
+		entries(egressv1.ComponentIPSet, set)
+	}
+	state.Rules.Name = "rules"
+	state.Routes.Name = "routes"
+	state.Neighbours.Name = "neighbours"
+	state.FDB.Name = "fdb"
+	entries(egressv1.ComponentRoute, state.Rules)
+	entries(egressv1.ComponentRoute, state.Routes)
+	entries(egressv1.ComponentVXLAN, state.Neighbours)
+	entries(egressv1.ComponentFDB, state.FDB)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(state.Drift) == 0 {
+		fmt.Fprintf(i.out, "\nNo drift\n")
+		return nil
+	}
+	fmt.Fprintln(i.out)
+	w = newTabWriter(i.out)
+	fmt.Fprintln(w, "COMPONENT\tOBJECT\tDRIFT\tENTRY")
+	for _, item := range state.Drift {
+		for _, entry := range item.Missing {
+			fmt.Fprintf(w, "%s\t%s\tmissing\t%s\n", item.Component, item.Object, entry)
+		}
+		for _, entry := range item.Unexpected {
+			fmt.Fprintf(w, "%s\t%s\tunexpected\t%s\n", item.Component, item.Object, entry)
+		}
+		if item.OutOfOrder {
+			fmt.Fprintf(w, "%s\t%s\tout of order\t-\n", item.Component, item.Object)
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,161 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package egressctl implements the subcommands of the kubectl-egress plugin, they
+// read the egress resources and print what the controller and the agents did with
+// them.
+package egressctl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// Inspector prints the state of the egress resources to out
+type Inspector struct {
+	client client.Client
+	out    io.Writer
+	// proxy reaches the debug handlers of the agents
+	proxy ProxyFunc
+}
+
+func New(cli client.Client, out io.Writer) *Inspector {
+	return &Inspector{client: cli, out: out}
+}
+
+// match is a policy which selects a pod
+type match struct {
+	policy   egressv1.Policy
+	kind     string
+	spec     egressv1.EgressPolicySpec
+	status   egressv1.EgressPolicyStatus
+	endpoint egressv1.EgressEndpoint
+}
+
+func (m match) name() string {
+	if m.policy.Namespace == "" {
+		return m.policy.Name
+	}
+	return m.policy.Namespace + "/" + m.policy.Name
+}
+
+// eip returns the EIP the pod uses with the policy
+func (m match) eip() egressv1.Eip {
+	for _, item := range m.status.PodEips {
+		if item.Pod == m.endpoint.Pod {
+			return egressv1.Eip{Ipv4: item.IPv4, Ipv6: item.IPv6}
+		}
+	}
+	return m.status.Eip
+}
+
+// lookup returns the policies which select the pod, from the endpoint slices of the
+// policies. They are sorted by name, the namespaced policies first.
+func (i *Inspector) lookup(ctx context.Context, namespace, pod string) ([]match, error) {
+	var res []match
+
+	slices := new(egressv1.EgressEndpointSliceList)
+	if err := i.client.List(ctx, slices, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("failed to list EgressEndpointSlices: %w", err)
+	}
+	for _, slice := range slices.Items {
+		ep, ok := findEndpoint(slice.Endpoints, namespace, pod)
+		if !ok {
+			continue
+		}
+		name := slice.Labels[egressv1.LabelPolicyName]
+		policy := new(egressv1.EgressPolicy)
+		if err := i.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, policy); err != nil {
+			return nil, fmt.Errorf("failed to get EgressPolicy %s/%s: %w", namespace, name, err)
+		}
+		res = append(res, match{
+			policy:   egressv1.Policy{Name: name, Namespace: namespace},
+			kind:     "EgressPolicy",
+			spec:     policy.Spec,
+			status:   policy.Status,
+			endpoint: ep,
+		})
+	}
+
+	clusterSlices := new(egressv1.EgressClusterEndpointSliceList)
+	if err := i.client.List(ctx, clusterSlices); err != nil {
+		return nil, fmt.Errorf("failed to list EgressClusterEndpointSlices: %w", err)
+	}
+	for _, slice := range clusterSlices.Items {
+		ep, ok := findEndpoint(slice.Endpoints, namespace, pod)
+		if !ok {
+			continue
+		}
+		name := slice.Labels[egressv1.LabelPolicyName]
+		policy := new(egressv1.EgressClusterPolicy)
+		if err := i.client.Get(ctx, types.NamespacedName{Name: name}, policy); err != nil {
+			return nil, fmt.Errorf("failed to get EgressClusterPolicy %s: %w", name, err)
+		}
+		res = append(res, match{
+			policy: egressv1.Policy{Name: name},
+			kind:   "EgressClusterPolicy",
+			spec: egressv1.EgressPolicySpec{
+				EgressGatewayName: policy.Spec.EgressGatewayName,
+				EgressIP:          policy.Spec.EgressIP,
+				DestSubnet:        policy.Spec.DestSubnet,
+				Priority:          policy.Spec.Priority,
+			},
+			status:   policy.Status,
+			endpoint: ep,
+		})
+	}
+
+	sort.SliceStable(res, func(a, b int) bool {
+		if (res[a].policy.Namespace == "") != (res[b].policy.Namespace == "") {
+			return res[a].policy.Namespace != ""
+		}
+		return res[a].policy.Name < res[b].policy.Name
+	})
+	return res, nil
+}
+
+func findEndpoint(endpoints []egressv1.EgressEndpoint, namespace, pod string) (egressv1.EgressEndpoint, bool) {
+	for _, ep := range endpoints {
+		if ep.Namespace == namespace && ep.Pod == pod {
+			return ep, true
+		}
+	}
+	return egressv1.EgressEndpoint{}, false
+}
+
+func newTabWriter(out io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+}
+
+// conditionStatus returns the status of a condition, "-" when it is not reported
+func conditionStatus(conditions []metav1.Condition, t string) string {
+	cond := meta.FindStatusCondition(conditions, t)
+	if cond == nil {
+		return "-"
+	}
+	return string(cond.Status)
+}
+
+func joinOrDash(items ...string) string {
+	res := make([]string, 0, len(items))
+	for _, item := range items {
+		if item != "" {
+			res = append(res, item)
+		}
+	}
+	if len(res) == 0 {
+		return "-"
+	}
+	return strings.Join(res, ",")
+}
@@ -0,0 +1,251 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressctl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/spidernet-io/egressgateway/pkg/agent/datapath"
+	"github.com/spidernet-io/egressgateway/pkg/ipset"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func initialObjects() []client.Object {
+	ready := []metav1.Condition{{Type: egressv1.ConditionReady, Status: metav1.ConditionTrue}}
+	return []client.Object{
+		&egressv1.EgressGateway{
+			ObjectMeta: metav1.ObjectMeta{Name: "egw1"},
+			Status: egressv1.EgressGatewayStatus{
+				NodeList: []egressv1.EgressIPStatus{
+					{
+						Name:   "node2",
+						Status: string(egressv1.EgressTunnelReady),
+						Eips: []egressv1.Eips{{
+							IPv4:     "10.6.1.60",
+							Policies: []egressv1.Policy{{Name: "policy1", Namespace: "default"}},
+						}},
+					},
+					{Name: "node3", Status: string(egressv1.EgressTunnelHeartbeatTimeout)},
+				},
+				IPUsage:    egressv1.IPUsage{IPv4Total: 10, IPv4Free: 9},
+				Conditions: ready,
+			},
+		},
+		&egressv1.EgressIPPool{
+			ObjectMeta: metav1.ObjectMeta{Name: "pool1"},
+			Status: egressv1.EgressIPPoolStatus{
+				IPUsage:     egressv1.IPUsage{IPv4Total: 10, IPv4Free: 9},
+				Allocations: []egressv1.EgressIPAllocation{{IP: "10.6.1.60", EgressGateway: "egw1", Node: "node2"}},
+			},
+		},
+		&egressv1.EgressPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "policy1", Namespace: "default"},
+			Spec: egressv1.EgressPolicySpec{
+				EgressGatewayName: "egw1",
+				DestSubnet:        []string{"1.1.1.0/24"},
+			},
+			Status: egressv1.EgressPolicyStatus{
+				Eip:        egressv1.Eip{Ipv4: "10.6.1.60"},
+				Node:       "node2",
+				Conditions: ready,
+			},
+		},
+		&egressv1.EgressEndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "policy1-abcde",
+				Namespace: "default",
+				Labels:    map[string]string{egressv1.LabelPolicyName: "policy1"},
+			},
+			Endpoints: []egressv1.EgressEndpoint{
+				{Namespace: "default", Pod: "pod1", IPv4: []string{"10.200.0.5"}, Node: "node1"},
+				{Namespace: "default", Pod: "pod2", IPv4: []string{"10.200.0.9"}, Node: "node2"},
+			},
+		},
+		&egressv1.EgressTunnel{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+			Status: egressv1.EgressTunnelStatus{
+				Tunnel: egressv1.Tunnel{IPv4: "192.200.0.1", MAC: "66:50:85:cb:b2:bf", Parent: egressv1.Parent{Name: "eth0"}},
+				Phase:  egressv1.EgressTunnelReady,
+				Mark:   "0x26000001",
+			},
+		},
+		&egressv1.EgressTunnel{
+			ObjectMeta: metav1.ObjectMeta{Name: "node2"},
+			Status: egressv1.EgressTunnelStatus{
+				Tunnel: egressv1.Tunnel{IPv4: "192.200.0.2", MAC: "66:50:85:cb:b2:c0", Parent: egressv1.Parent{Name: "eth0"}},
+				Phase:  egressv1.EgressTunnelReady,
+				Mark:   "0x26000002",
+			},
+		},
+		&egressv1.EgressAgentStatus{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+			Status: egressv1.AgentStatus{
+				Peers: []egressv1.TunnelPeer{
+					{Node: "node2", Programmed: true, Reachable: true},
+					{Node: "node3", Programmed: true, Message: "neighbor 10.6.1.23 is FAILED"},
+				},
+				Conditions: ready,
+			},
+		},
+	}
+}
+
+func newInspector() (*Inspector, *bytes.Buffer) {
+	builder := fake.NewClientBuilder()
+	builder.WithScheme(schema.GetScheme())
+	builder.WithObjects(initialObjects()...)
+	out := new(bytes.Buffer)
+	return New(builder.Build(), out), out
+}
+
+func TestStatus(t *testing.T) {
+	i, out := newInspector()
+	if !assert.NoError(t, i.Status(context.Background())) {
+		return
+	}
+	assert.Contains(t, out.String(), "egw1     True   -         1/2    9/10       0/0        1         false")
+	assert.Contains(t, out.String(), "pool1   -          9/10       0/0        1")
+	assert.Contains(t, out.String(), "node2  egw1     Ready             10.6.1.60  1")
+	assert.Contains(t, out.String(), "node3  egw1     HeartbeatTimeout  -          0")
+}
+
+func TestWho(t *testing.T) {
+	i, out := newInspector()
+	if !assert.NoError(t, i.Who(context.Background(), "default", "pod1")) {
+		return
+	}
+	assert.Contains(t, out.String(), "Pod default/pod1 on node node1, IP 10.200.0.5")
+	assert.Contains(t, out.String(), "default/policy1  EgressPolicy  egw1     10.6.1.60  node2         True")
+
+	err := i.Who(context.Background(), "default", "pod3")
+	assert.EqualError(t, err, "pod default/pod3 is not selected by any policy")
+}
+
+func TestTrace(t *testing.T) {
+	srcSet := ipset.FormatName("egress-src-v4-", "default-policy1")
+	dstSet := ipset.FormatName("egress-dst-v4-", "default-policy1")
+
+	cases := map[string]struct {
+		pod      string
+		dest     string
+		expected []string
+		missing  []string
+	}{
+		"forwarded to the gateway node": {
+			pod:  "pod1",
+			dest: "1.1.1.1",
+			expected: []string{
+				"Matched EgressPolicy default/policy1, gateway egw1",
+				"src in " + srcSet + ", dst in " + dstSet + ": set mark 0x26000002",
+				"route the marked traffic through the egress tunnel to node2 (tunnel IP 192.200.0.2)",
+				"SNAT to 10.6.1.60",
+			},
+		},
+		"on the gateway node": {
+			pod:      "pod2",
+			dest:     "1.1.1.1",
+			expected: []string{"EGRESSGATEWAY-SNAT-EIP", "the traffic leaves node2 with source 10.6.1.60"},
+			missing:  []string{"EGRESSGATEWAY-MARK-REQUEST"},
+		},
+		"destination not matched": {
+			pod:      "pod1",
+			dest:     "8.8.8.8",
+			expected: []string{"No policy of the pod matches the destination"},
+			missing:  []string{"EGRESSGATEWAY-SNAT-EIP"},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			i, out := newInspector()
+			if !assert.NoError(t, i.Trace(context.Background(), "default", c.pod, c.dest)) {
+				return
+			}
+			for _, s := range c.expected {
+				assert.Contains(t, out.String(), s)
+			}
+			for _, s := range c.missing {
+				assert.NotContains(t, out.String(), s)
+			}
+		})
+	}
+
+	i, _ := newInspector()
+	assert.Error(t, i.Trace(context.Background(), "default", "pod1", "invalid"))
+}
+
+func TestNodes(t *testing.T) {
+	i, out := newInspector()
+	if !assert.NoError(t, i.Nodes(context.Background())) {
+		return
+	}
+	assert.Contains(t, out.String(), "node1  Ready  192.200.0.1  -            66:50:85:cb:b2:bf  eth0    0x26000001  1/2              True")
+	assert.Contains(t, out.String(), "node2  Ready  192.200.0.2  -            66:50:85:cb:b2:c0  eth0    0x26000002  -                -")
+	assert.Contains(t, out.String(), "node1  node3             true        neighbor 10.6.1.23 is FAILED")
+}
+
+func TestDatapath(t *testing.T) {
+	state := datapath.State{
+		Node: "node1",
+		IPSets: []datapath.Entries{
+			{Name: "egress-src-v4-a", Desired: []string{"10.21.0.5"}, Actual: []string{"10.21.0.5"}},
+		},
+		Neighbours: datapath.Entries{Desired: []string{"192.200.0.2 lladdr 66:50:85:cb:b2:c0"}},
+	}
+	state.SetDrift()
+	raw, err := json.Marshal(state)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	i, out := newInspector()
+	err = i.client.Create(context.Background(), &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "agent-x", Namespace: "kube-system",
+			Labels: map[string]string{"app.kubernetes.io/component": "egressgateway-agent"},
+		},
+		Spec:   corev1.PodSpec{NodeName: "node1"},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	opts := DatapathOptions{AgentSelector: "app.kubernetes.io/component=egressgateway-agent", Port: 5811}
+
+	// the agents can not be reached without proxy
+	assert.Error(t, i.Datapath(context.Background(), "node1", opts))
+
+	var got string
+	i.WithProxy(func(ctx context.Context, namespace, pod string, port int, path string) ([]byte, error) {
+		got = fmt.Sprintf("%s/%s:%d%s", namespace, pod, port, path)
+		return raw, nil
+	})
+	if !assert.NoError(t, i.Datapath(context.Background(), "node1", opts)) {
+		return
+	}
+	assert.Equal(t, "kube-system/agent-x:5811/debug/datapath", got)
+	assert.Contains(t, out.String(), "ipset      egress-src-v4-a  1        1       -")
+	assert.Contains(t, out.String(), "vxlan      neighbours       1        0       -")
+	assert.Contains(t, out.String(), "vxlan      neighbours  missing  192.200.0.2 lladdr 66:50:85:cb:b2:c0")
+
+	out.Reset()
+	opts.Output = "json"
+	if !assert.NoError(t, i.Datapath(context.Background(), "node1", opts)) {
+		return
+	}
+	assert.JSONEq(t, string(raw), out.String())
+
+	assert.Error(t, i.Datapath(context.Background(), "node2", opts))
+}
@@ -0,0 +1,62 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressctl
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// Nodes prints the tunnel of the nodes and the reachability of their peers
+func (i *Inspector) Nodes(ctx context.Context) error {
+	tunnels := new(egressv1.EgressTunnelList)
+	if err := i.client.List(ctx, tunnels); err != nil {
+		return fmt.Errorf("failed to list EgressTunnels: %w", err)
+	}
+	agents := new(egressv1.EgressAgentStatusList)
+	if err := i.client.List(ctx, agents); err != nil {
+		return fmt.Errorf("failed to list EgressAgentStatuses: %w", err)
+	}
+	sort.Slice(tunnels.Items, func(a, b int) bool { return tunnels.Items[a].Name < tunnels.Items[b].Name })
+	agentMap := make(map[string]egressv1.EgressAgentStatus, len(agents.Items))
+	for _, item := range agents.Items {
+		agentMap[item.Name] = item
+	}
+
+	w := newTabWriter(i.out)
+	fmt.Fprintln(w, "NODE\tPHASE\tTUNNEL IPV4\tTUNNEL IPV6\tMAC\tPARENT\tMARK\tPEERS REACHABLE\tAGENT READY")
+	var unreachable []string
+	for _, item := range tunnels.Items {
+		tunnel := item.Status.Tunnel
+		peers, agentReady := "-", "-"
+		if agent, ok := agentMap[item.Name]; ok {
+			reachable := 0
+			for _, peer := range agent.Status.Peers {
+				if peer.Reachable {
+					reachable++
+					continue
+				}
+				unreachable = append(unreachable, fmt.Sprintf("%s\t%s\t%t\t%s",
+					item.Name, peer.Node, peer.Programmed, joinOrDash(peer.Message)))
+			}
+			peers = fmt.Sprintf("%d/%d", reachable, len(agent.Status.Peers))
+			agentReady = conditionStatus(agent.Status.Conditions, egressv1.ConditionReady)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", item.Name, item.Status.Phase,
+			joinOrDash(tunnel.IPv4), joinOrDash(tunnel.IPv6), joinOrDash(tunnel.MAC),
+			joinOrDash(tunnel.Parent.Name), joinOrDash(item.Status.Mark), peers, agentReady)
+	}
+
+	if len(unreachable) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "NODE\tUNREACHABLE PEER\tPROGRAMMED\tMESSAGE")
+		for _, line := range unreachable {
+			fmt.Fprintln(w, line)
+		}
+	}
+	return w.Flush()
+}
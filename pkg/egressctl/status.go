@@ -0,0 +1,74 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressctl
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// Status prints the gateways, the ippools and the gateway nodes
+func (i *Inspector) Status(ctx context.Context) error {
+	gateways := new(egressv1.EgressGatewayList)
+	if err := i.client.List(ctx, gateways); err != nil {
+		return fmt.Errorf("failed to list EgressGateways: %w", err)
+	}
+	pools := new(egressv1.EgressIPPoolList)
+	if err := i.client.List(ctx, pools); err != nil {
+		return fmt.Errorf("failed to list EgressIPPools: %w", err)
+	}
+	sort.Slice(gateways.Items, func(a, b int) bool { return gateways.Items[a].Name < gateways.Items[b].Name })
+	sort.Slice(pools.Items, func(a, b int) bool { return pools.Items[a].Name < pools.Items[b].Name })
+
+	w := newTabWriter(i.out)
+	fmt.Fprintln(w, "GATEWAY\tREADY\tDEGRADED\tNODES\tIPV4 FREE\tIPV6 FREE\tPOLICIES\tCLUSTER DEFAULT")
+	for _, item := range gateways.Items {
+		ready, policies := 0, 0
+		for _, node := range item.Status.NodeList {
+			if node.Status == string(egressv1.EgressTunnelReady) {
+				ready++
+			}
+			for _, eip := range node.Eips {
+				policies += len(eip.Policies)
+			}
+		}
+		usage := item.Status.IPUsage
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d/%d\t%d/%d\t%d/%d\t%d\t%t\n", item.Name,
+			conditionStatus(item.Status.Conditions, egressv1.ConditionReady),
+			conditionStatus(item.Status.Conditions, egressv1.ConditionDegraded),
+			ready, len(item.Status.NodeList),
+			usage.IPv4Free, usage.IPv4Total, usage.IPv6Free, usage.IPv6Total,
+			policies, item.Spec.ClusterDefault)
+	}
+
+	if len(pools.Items) > 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "IPPOOL\tEXHAUSTED\tIPV4 FREE\tIPV6 FREE\tALLOCATIONS")
+		for _, item := range pools.Items {
+			usage := item.Status.IPUsage
+			fmt.Fprintf(w, "%s\t%s\t%d/%d\t%d/%d\t%d\n", item.Name,
+				conditionStatus(item.Status.Conditions, egressv1.PoolConditionExhausted),
+				usage.IPv4Free, usage.IPv4Total, usage.IPv6Free, usage.IPv6Total,
+				len(item.Status.Allocations))
+		}
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "NODE\tGATEWAY\tSTATUS\tEIPS\tPOLICIES")
+	for _, item := range gateways.Items {
+		for _, node := range item.Status.NodeList {
+			eips, policies := make([]string, 0, len(node.Eips)), 0
+			for _, eip := range node.Eips {
+				eips = append(eips, joinOrDash(eip.IPv4, eip.IPv6))
+				policies += len(eip.Policies)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", node.Name, item.Name, node.Status,
+				joinOrDash(eips...), policies)
+		}
+	}
+	return w.Flush()
+}
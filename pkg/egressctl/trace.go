@@ -0,0 +1,219 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressctl
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/spidernet-io/egressgateway/pkg/ipset"
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// clusterInfoName is the name of the EgressClusterInfo created by the controller
+const clusterInfoName = "default"
+
+// step is a hop of the traced path, table and chain are empty for the routing steps
+type step struct {
+	node   string
+	table  string
+	chain  string
+	action string
+}
+
+// Trace prints the path the traffic of the pod to dest is expected to take, with the
+// iptables rules the agents program for it.
+func (i *Inspector) Trace(ctx context.Context, namespace, pod, dest string) error {
+	dst := net.ParseIP(dest)
+	if dst == nil {
+		return fmt.Errorf("invalid destination IP %q", dest)
+	}
+	version := "v4"
+	if dst.To4() == nil {
+		version = "v6"
+	}
+
+	matches, err := i.lookup(ctx, namespace, pod)
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("pod %s/%s is not selected by any policy", namespace, pod)
+	}
+	internal, err := i.internalCIDRs(ctx)
+	if err != nil {
+		return err
+	}
+
+	var selected []match
+	for _, m := range matches {
+		if len(m.spec.DestSubnet) > 0 && containsIP(m.spec.DestSubnet, dst) ||
+			len(m.spec.DestSubnet) == 0 && !containsIP(internal, dst) {
+			selected = append(selected, m)
+		}
+	}
+
+	ep := matches[0].endpoint
+	src := ep.IPv4
+	if version == "v6" {
+		src = ep.IPv6
+	}
+	fmt.Fprintf(i.out, "Pod %s/%s (%s) on node %s to %s\n", namespace, pod, joinOrDash(src...), ep.Node, dst)
+	if len(selected) == 0 {
+		fmt.Fprintf(i.out, "No policy of the pod matches the destination, the traffic does not go through an egress gateway\n")
+		return nil
+	}
+	m := selected[0]
+	fmt.Fprintf(i.out, "Matched %s %s, gateway %s\n", m.kind, m.name(), m.spec.EgressGatewayName)
+	if len(selected) > 1 {
+		fmt.Fprintf(i.out, "Warning: %d policies match the destination, the traffic uses the EIP of one of them\n", len(selected))
+	}
+	if m.status.Node == "" {
+		fmt.Fprintf(i.out, "The policy has no gateway node, the traffic does not go through an egress gateway\n")
+		return nil
+	}
+	fmt.Fprintln(i.out)
+
+	steps, err := i.trace(ctx, m, version)
+	if err != nil {
+		return err
+	}
+	w := newTabWriter(i.out)
+	fmt.Fprintln(w, "STEP\tNODE\tTABLE\tCHAIN\tACTION")
+	for n, s := range steps {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", n+1, s.node, joinOrDash(s.table), joinOrDash(s.chain), s.action)
+	}
+	return w.Flush()
+}
+
+// trace returns the steps of the traffic of the endpoint of m, version is v4 or v6
+func (i *Inspector) trace(ctx context.Context, m match, version string) ([]step, error) {
+	policyName := m.policy.Name
+	if m.policy.Namespace != "" {
+		policyName = m.policy.Namespace + "-" + m.policy.Name
+	}
+	srcSet := ipset.FormatName("egress-src-"+version+"-", policyName)
+	dstSet := ipset.FormatName("egress-dst-"+version+"-", policyName)
+	criteria := fmt.Sprintf("src in %s, dst in %s", srcSet, dstSet)
+	if len(m.spec.DestSubnet) == 0 {
+		criteria = fmt.Sprintf("src in %s, dst not in egress-cluster-cidr-ip%s", srcSet, version)
+	}
+
+	eip := m.eip().Ipv4
+	if version == "v6" {
+		eip = m.eip().Ipv6
+	}
+	gatewayNode := m.status.Node
+	var steps []step
+
+	if m.endpoint.Node != gatewayNode {
+		tunnel := new(egressv1.EgressTunnel)
+		err := i.client.Get(ctx, types.NamespacedName{Name: gatewayNode}, tunnel)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get EgressTunnel %s: %w", gatewayNode, err)
+		}
+		tunnelIP := tunnel.Status.Tunnel.IPv4
+		if version == "v6" {
+			tunnelIP = tunnel.Status.Tunnel.IPv6
+		}
+		steps = append(steps,
+			step{
+				node: m.endpoint.Node, table: "mangle", chain: "EGRESSGATEWAY-MARK-REQUEST",
+				action: fmt.Sprintf("%s: set mark %s", criteria, joinOrDash(tunnel.Status.Mark)),
+			},
+			step{
+				node:   m.endpoint.Node,
+				action: fmt.Sprintf("route the marked traffic through the egress tunnel to %s (tunnel IP %s)", gatewayNode, joinOrDash(tunnelIP)),
+			},
+		)
+	}
+
+	gateway := new(egressv1.EgressGateway)
+	err := i.client.Get(ctx, types.NamespacedName{Name: m.spec.EgressGatewayName}, gateway)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get EgressGateway %s: %w", m.spec.EgressGatewayName, err)
+	}
+	if vlan := gateway.Spec.VLAN; vlan != nil {
+		steps = append(steps, step{
+			node: gatewayNode, table: "mangle", chain: "EGRESSGATEWAY-VLAN-ROUTING",
+			action: fmt.Sprintf("%s: route out of VLAN %d on %s", criteria, vlan.ID, vlan.Parent),
+		})
+	}
+
+	// the pods of a sticky policy have their own SNAT rule
+	snat := criteria
+	for _, item := range m.status.PodEips {
+		if item.Pod != m.endpoint.Pod {
+			continue
+		}
+		src := m.endpoint.IPv4
+		if version == "v6" {
+			src = m.endpoint.IPv6
+		}
+		snat = fmt.Sprintf("src %s of pod %s", joinOrDash(src...), item.Pod)
+	}
+	to := joinOrDash(eip)
+	if m.spec.EgressIP.UseNodeIP {
+		to = "the node IP"
+	}
+	steps = append(steps,
+		step{
+			node: gatewayNode, table: "nat", chain: "EGRESSGATEWAY-SNAT-EIP",
+			action: fmt.Sprintf("%s: SNAT to %s", snat, to),
+		},
+		step{
+			node:   gatewayNode,
+			action: fmt.Sprintf("the traffic leaves %s with source %s", gatewayNode, to),
+		},
+	)
+	return steps, nil
+}
+
+// internalCIDRs returns the addresses of the cluster, the traffic to them does not
+// go through the policies without destSubnet.
+func (i *Inspector) internalCIDRs(ctx context.Context) ([]string, error) {
+	info := new(egressv1.EgressClusterInfo)
+	err := i.client.Get(ctx, types.NamespacedName{Name: clusterInfoName}, info)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get EgressClusterInfo: %w", err)
+	}
+
+	var res []string
+	for _, pair := range info.Status.NodeIP {
+		res = append(res, pair.IPv4...)
+		res = append(res, pair.IPv6...)
+	}
+	for _, pair := range info.Status.PodCIDR {
+		res = append(res, pair.IPv4...)
+		res = append(res, pair.IPv6...)
+	}
+	if info.Status.ClusterIP != nil {
+		res = append(res, info.Status.ClusterIP.IPv4...)
+		res = append(res, info.Status.ClusterIP.IPv6...)
+	}
+	return append(res, info.Status.ExtraCidr...), nil
+}
+
+// containsIP reports whether ip is one of the IPs or in one of the CIDRs of list
+func containsIP(list []string, ip net.IP) bool {
+	for _, item := range list {
+		if _, cidr, err := net.ParseCIDR(item); err == nil {
+			if cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if other := net.ParseIP(item); other != nil && other.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressctl
+
+import (
+	"context"
+	"fmt"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// Who prints the policies which select the pod, with their EIP and gateway node
+func (i *Inspector) Who(ctx context.Context, namespace, pod string) error {
+	matches, err := i.lookup(ctx, namespace, pod)
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("pod %s/%s is not selected by any policy", namespace, pod)
+	}
+
+	ep := matches[0].endpoint
+	fmt.Fprintf(i.out, "Pod %s/%s on node %s, IP %s\n\n", namespace, pod, ep.Node,
+		joinOrDash(append(append([]string{}, ep.IPv4...), ep.IPv6...)...))
+
+	w := newTabWriter(i.out)
+	fmt.Fprintln(w, "POLICY\tKIND\tGATEWAY\tEIP\tGATEWAY NODE\tREADY")
+	for _, m := range matches {
+		eip := joinOrDash(m.eip().Ipv4, m.eip().Ipv6)
+		if m.spec.EgressIP.UseNodeIP {
+			eip = "node IP"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", m.name(), m.kind, m.spec.EgressGatewayName,
+			eip, joinOrDash(m.status.Node),
+			conditionStatus(m.status.Conditions, egressv1.ConditionReady))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(matches) > 1 {
+		fmt.Fprintf(i.out, "\nWarning: %d policies select the pod, the destinations they share use the EIP of one of them\n", len(matches))
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// SetEIPSharedCondition sets the EIPShared condition of a policy status from the EIP
+// allocated to the policy, the condition is removed when no EIP is allocated.
+func SetEIPSharedCondition(status *egress.EgressPolicyStatus, eip *egress.Eips, generation int64) {
+	if eip == nil || (len(eip.IPv4) == 0 && len(eip.IPv6) == 0) {
+		meta.RemoveStatusCondition(&status.Conditions, egress.PolicyConditionEIPShared)
+		return
+	}
+
+	cond := metav1.Condition{
+		Type:               egress.PolicyConditionEIPShared,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             egress.ReasonEIPExclusive,
+		Message:            "The EIP is only used by this policy",
+	}
+	if others := len(eip.Policies) - 1; others > 0 {
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = egress.ReasonEIPShared
+		cond.Message = fmt.Sprintf("The EIP is shared with %d other policies", others)
+	}
+	meta.SetStatusCondition(&status.Conditions, cond)
+}
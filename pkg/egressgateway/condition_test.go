@@ -0,0 +1,250 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/api/meta"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+func TestAllocatorSharedEIP(t *testing.T) {
+	ready := string(egress.EgressTunnelReady)
+	egw := &egress.EgressGateway{
+		ObjectMeta: v1.ObjectMeta{Name: "egw1"},
+		Status: egress.EgressGatewayStatus{Conflicts: []egress.EIPConflict{
+			{IP: "10.6.1.25", MAC: "aa:bb:cc:dd:ee:ff", Node: "node1", LastDetectedTime: v1.Now()},
+		}},
+	}
+	pool, err := NewEIPPool("pool", egress.EgressIPPoolSpec{
+		IPv4: []string{"10.6.1.20-10.6.1.25"},
+		Reservations: []egress.EgressIPReservation{
+			{IPv4: "10.6.1.24", Namespace: "ns1"},
+		},
+	}, []egress.EgressGateway{*egw})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	cases := map[string]struct {
+		nodeName string
+		nodeMap  map[string]egress.EgressIPStatus
+		expNode  string
+		expIPv4  string
+		expFound bool
+	}{
+		"fewest policies on the selected node": {
+			nodeName: "node1",
+			nodeMap: map[string]egress.EgressIPStatus{
+				"node1": {Name: "node1", Status: ready, Eips: []egress.Eips{
+					{IPv4: "10.6.1.21", Policies: policies("p1", "p2")},
+					{IPv4: "10.6.1.22", Policies: policies("p3")},
+				}},
+				"node2": {Name: "node2", Status: ready, Eips: []egress.Eips{
+					{IPv4: "10.6.1.23", Policies: policies("p4")},
+				}},
+			},
+			expNode:  "node1",
+			expIPv4:  "10.6.1.22",
+			expFound: true,
+		},
+		"selected node without eip": {
+			nodeName: "node1",
+			nodeMap: map[string]egress.EgressIPStatus{
+				"node1": {Name: "node1", Status: ready},
+				"node2": {Name: "node2", Status: ready, Eips: []egress.Eips{
+					{IPv4: "10.6.1.22", Policies: policies("p1", "p2")},
+				}},
+				"node3": {Name: "node3", Status: ready, Eips: []egress.Eips{
+					{IPv4: "10.6.1.23", Policies: policies("p3")},
+				}},
+				"node4": {Name: "node4", Status: string(egress.EgressTunnelNodeNotReady), Eips: []egress.Eips{
+					{IPv4: "10.6.1.24"},
+				}},
+			},
+			expNode:  "node3",
+			expIPv4:  "10.6.1.23",
+			expFound: true,
+		},
+		"eip reserved for another namespace": {
+			nodeName: "node1",
+			nodeMap: map[string]egress.EgressIPStatus{
+				"node1": {Name: "node1", Status: ready, Eips: []egress.Eips{
+					{IPv4: "10.6.1.21", Policies: policies("p1", "p2")},
+					{IPv4: "10.6.1.24", Policies: []egress.Policy{{Name: "p3", Namespace: "ns1"}}},
+				}},
+			},
+			expNode:  "node1",
+			expIPv4:  "10.6.1.21",
+			expFound: true,
+		},
+		"only reserved and conflicting eips": {
+			nodeName: "node1",
+			nodeMap: map[string]egress.EgressIPStatus{
+				"node1": {Name: "node1", Status: ready, Eips: []egress.Eips{
+					{IPv4: "10.6.1.24", Policies: []egress.Policy{{Name: "p3", Namespace: "ns1"}}},
+					{IPv4: "10.6.1.25", Policies: policies("p1")},
+				}},
+			},
+		},
+		"node ip is not shared": {
+			nodeName: "node1",
+			nodeMap: map[string]egress.EgressIPStatus{
+				"node1": {Name: "node1", Status: ready, Eips: []egress.Eips{
+					{Policies: policies("p1")},
+				}},
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			node, eip, found := allocatorSharedEIP(c.nodeName, c.nodeMap, sharableEIP(pool, egw, egress.Policy{Name: "p9", Namespace: "default"}))
+			assert.Equal(t, c.expFound, found)
+			assert.Equal(t, c.expNode, node)
+			assert.Equal(t, c.expIPv4, eip.IPv4)
+		})
+	}
+}
+
+func TestSetEIPSharedCondition(t *testing.T) {
+	status := &egress.EgressPolicyStatus{}
+
+	SetEIPSharedCondition(status, &egress.Eips{IPv4: "10.6.1.21", Policies: policies("p1")}, 1)
+	cond := meta.FindStatusCondition(status.Conditions, egress.PolicyConditionEIPShared)
+	assert.NotNil(t, cond)
+	assert.Equal(t, v1.ConditionFalse, cond.Status)
+	assert.Equal(t, egress.ReasonEIPExclusive, cond.Reason)
+
+	SetEIPSharedCondition(status, &egress.Eips{IPv4: "10.6.1.21", Policies: policies("p1", "p2")}, 2)
+	cond = meta.FindStatusCondition(status.Conditions, egress.PolicyConditionEIPShared)
+	assert.NotNil(t, cond)
+	assert.Equal(t, v1.ConditionTrue, cond.Status)
+	assert.Equal(t, egress.ReasonEIPShared, cond.Reason)
+	assert.Equal(t, int64(2), cond.ObservedGeneration)
+
+	SetEIPSharedCondition(status, nil, 2)
+	assert.Empty(t, status.Conditions)
+}
+
+func TestPolicyReadyCondition(t *testing.T) {
+	status := &egress.EgressPolicyStatus{}
+	ready := func() *v1.Condition { return meta.FindStatusCondition(status.Conditions, egress.ConditionReady) }
+
+	status.SetEIPAllocatedCondition(1)
+	assert.Equal(t, v1.ConditionFalse, ready().Status)
+	assert.Equal(t, egress.ReasonNotAllocated, ready().Reason)
+
+	status.Node, status.Eip.Ipv4 = "node1", "10.6.1.21"
+	assert.True(t, status.SetEIPAllocatedCondition(1))
+	assert.Equal(t, v1.ConditionUnknown, ready().Status)
+	assert.Equal(t, egress.ReasonPending, ready().Reason)
+
+	status.SetEndpointsSyncedCondition(1, nil)
+	status.SetDatapathProgrammedCondition(1, "node1", nil)
+	assert.Equal(t, v1.ConditionTrue, ready().Status)
+	assert.False(t, status.SetEIPAllocatedCondition(1))
+	assert.False(t, status.SetDatapathProgrammedCondition(1, "node1", nil))
+
+	status.SetDatapathProgrammedCondition(2, "node1", errors.New("iptables failed"))
+	assert.Equal(t, v1.ConditionFalse, ready().Status)
+	assert.Equal(t, egress.ReasonProgramFailed, ready().Reason)
+	assert.Equal(t, int64(2), ready().ObservedGeneration)
+
+	// the datapath waits for the agent of the new node
+	status.SetDatapathProgrammedCondition(2, "node1", nil)
+	status.Node = "node2"
+	assert.True(t, status.SetEIPAllocatedCondition(2))
+	assert.Equal(t, v1.ConditionUnknown, ready().Status)
+	assert.Equal(t, egress.ReasonPending, ready().Reason)
+}
+
+func TestGatewayConditions(t *testing.T) {
+	ready := string(egress.EgressTunnelReady)
+
+	cases := map[string]struct {
+		status      egress.EgressGatewayStatus
+		expReady    v1.ConditionStatus
+		expReason   string
+		expDegraded string
+	}{
+		"no gateway node": {
+			expReady:    v1.ConditionFalse,
+			expReason:   egress.ReasonNoGatewayNode,
+			expDegraded: egress.ReasonAsExpected,
+		},
+		"no ready gateway node": {
+			status: egress.EgressGatewayStatus{NodeList: []egress.EgressIPStatus{
+				{Name: "node1", Status: string(egress.EgressTunnelHeartbeatTimeout)},
+			}},
+			expReady:    v1.ConditionFalse,
+			expReason:   egress.ReasonNoReadyGatewayNode,
+			expDegraded: egress.ReasonGatewayNodeNotReady,
+		},
+		"one of two gateway nodes is ready": {
+			status: egress.EgressGatewayStatus{NodeList: []egress.EgressIPStatus{
+				{Name: "node1", Status: ready},
+				{Name: "node2", Status: string(egress.EgressTunnelNodeNotReady)},
+			}},
+			expReady:    v1.ConditionTrue,
+			expReason:   egress.ReasonGatewayNodeReady,
+			expDegraded: egress.ReasonGatewayNodeNotReady,
+		},
+		"eip conflict": {
+			status: egress.EgressGatewayStatus{
+				NodeList:  []egress.EgressIPStatus{{Name: "node1", Status: ready}},
+				Conflicts: []egress.EIPConflict{{IP: "10.6.1.21", Node: "node1"}},
+			},
+			expReady:    v1.ConditionTrue,
+			expReason:   egress.ReasonGatewayNodeReady,
+			expDegraded: egress.ReasonEIPConflict,
+		},
+		"eip exhausted": {
+			status: egress.EgressGatewayStatus{
+				NodeList: []egress.EgressIPStatus{{Name: "node1", Status: ready}},
+				IPUsage:  egress.IPUsage{IPv4Total: 2},
+			},
+			expReady:    v1.ConditionTrue,
+			expReason:   egress.ReasonGatewayNodeReady,
+			expDegraded: egress.ReasonEIPExhausted,
+		},
+		"all gateway nodes are ready": {
+			status: egress.EgressGatewayStatus{
+				NodeList: []egress.EgressIPStatus{{Name: "node1", Status: ready}},
+				IPUsage:  egress.IPUsage{IPv4Total: 2, IPv4Free: 1},
+			},
+			expReady:    v1.ConditionTrue,
+			expReason:   egress.ReasonGatewayNodeReady,
+			expDegraded: egress.ReasonAsExpected,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			status := c.status
+			assert.True(t, status.SetConditions(3))
+			assert.False(t, status.SetConditions(3))
+
+			cond := meta.FindStatusCondition(status.Conditions, egress.ConditionReady)
+			if !assert.NotNil(t, cond) {
+				return
+			}
+			assert.Equal(t, c.expReady, cond.Status)
+			assert.Equal(t, c.expReason, cond.Reason)
+			assert.Equal(t, int64(3), cond.ObservedGeneration)
+
+			cond = meta.FindStatusCondition(status.Conditions, egress.ConditionDegraded)
+			if !assert.NotNil(t, cond) {
+				return
+			}
+			assert.Equal(t, c.expDegraded, cond.Reason)
+			assert.Equal(t, c.expDegraded != egress.ReasonAsExpected, cond.Status == v1.ConditionTrue)
+		})
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/types"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// pruneConflicts removes the conflicts older than expiration from the status of egw,
+// the EIPs can be allocated again, and are probed again by the agents. It returns
+// whether the status changed, and the time until the next conflict expires.
+func pruneConflicts(egw *egress.EgressGateway, expiration time.Duration, now time.Time) (bool, time.Duration) {
+	var next time.Duration
+	var res []egress.EIPConflict
+	for _, item := range egw.Status.Conflicts {
+		left := item.LastDetectedTime.Add(expiration).Sub(now)
+		if left <= 0 {
+			continue
+		}
+		if next == 0 || left < next {
+			next = left
+		}
+		res = append(res, item)
+	}
+	changed := len(res) != len(egw.Status.Conflicts)
+	egw.Status.Conflicts = res
+	return changed, next
+}
+
+// isConflicted reports whether the EIP is used by another host, the conflicts
+// in the status of egw are all active since the expired ones are pruned.
+func isConflicted(egw *egress.EgressGateway, eip egress.Eips) bool {
+	for _, item := range []string{eip.IPv4, eip.IPv6} {
+		if len(item) == 0 {
+			continue
+		}
+		if _, ok := egw.Status.GetConflict(item, time.Time{}); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// steerConflicts moves the policies off the EIPs which conflict with another host.
+// A policy which specifies the EIP in its spec keeps it, the other ones get a new
+// EIP by reAllocatorPolicy. It returns whether nodeMap changed.
+func (r egnReconciler) steerConflicts(ctx context.Context, log logr.Logger, egw *egress.EgressGateway, nodeMap map[string]egress.EgressIPStatus) (bool, error) {
+	if len(egw.Status.Conflicts) == 0 {
+		return false, nil
+	}
+
+	var moved []egress.Policy
+	for name, node := range nodeMap {
+		var eips []egress.Eips
+		for _, eip := range node.Eips {
+			if !isConflicted(egw, eip) {
+				eips = append(eips, eip)
+				continue
+			}
+			var kept []egress.Policy
+			for _, policy := range eip.Policies {
+				pinned, err := r.policySpecifiesEIP(ctx, policy)
+				if err != nil {
+					return false, err
+				}
+				if pinned {
+					kept = append(kept, policy)
+					continue
+				}
+				moved = append(moved, policy)
+			}
+			if len(kept) != 0 {
+				eip.Policies = kept
+				eips = append(eips, eip)
+			}
+		}
+		node.Eips = eips
+		nodeMap[name] = node
+	}
+
+	for _, policy := range moved {
+		// the EIPs allocated to the policies moved before are taken into account
+		egw.Status.NodeList = egw.Status.NodeList[:0]
+		for _, node := range nodeMap {
+			egw.Status.NodeList = append(egw.Status.NodeList, node)
+		}
+		log.Info("the EIP of the policy conflicts with another host, allocate a new one", "policy", policy)
+		if err := r.reAllocatorPolicy(ctx, log, policy, egw, nodeMap); err != nil {
+			return false, err
+		}
+	}
+	return len(moved) != 0, nil
+}
+
+// policySpecifiesEIP reports whether the policy sets its EIP in the spec.
+func (r egnReconciler) policySpecifiesEIP(ctx context.Context, policy egress.Policy) (bool, error) {
+	if len(policy.Namespace) == 0 {
+		egcp := new(egress.EgressClusterPolicy)
+		if err := r.client.Get(ctx, types.NamespacedName{Name: policy.Name}, egcp); err != nil {
+			return false, err
+		}
+		return len(egcp.Spec.EgressIP.IPv4) != 0 || len(egcp.Spec.EgressIP.IPv6) != 0, nil
+	}
+	egp := new(egress.EgressPolicy)
+	if err := r.client.Get(ctx, types.NamespacedName{Namespace: policy.Namespace, Name: policy.Name}, egp); err != nil {
+		return false, err
+	}
+	return len(egp.Spec.EgressIP.IPv4) != 0 || len(egp.Spec.EgressIP.IPv6) != 0, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func TestPruneConflicts(t *testing.T) {
+	now := time.Now()
+	egw := &egress.EgressGateway{Status: egress.EgressGatewayStatus{Conflicts: []egress.EIPConflict{
+		{IP: "10.6.1.20", LastDetectedTime: v1.NewTime(now.Add(-11 * time.Minute))},
+		{IP: "10.6.1.21", LastDetectedTime: v1.NewTime(now.Add(-5 * time.Minute))},
+		{IP: "10.6.1.22", LastDetectedTime: v1.NewTime(now.Add(-2 * time.Minute))},
+	}}}
+
+	changed, next := pruneConflicts(egw, 10*time.Minute, now)
+	assert.True(t, changed)
+	assert.Equal(t, 5*time.Minute, next)
+	assert.Len(t, egw.Status.Conflicts, 2)
+
+	changed, _ = pruneConflicts(egw, 10*time.Minute, now)
+	assert.False(t, changed)
+
+	changed, next = pruneConflicts(egw, time.Minute, now)
+	assert.True(t, changed)
+	assert.Zero(t, next)
+	assert.Empty(t, egw.Status.Conflicts)
+}
+
+func TestSteerConflicts(t *testing.T) {
+	ready := string(egress.EgressTunnelReady)
+	moved := egress.Policy{Name: "moved", Namespace: "default"}
+	pinned := egress.Policy{Name: "pinned", Namespace: "default"}
+	other := egress.Policy{Name: "other", Namespace: "default"}
+
+	egw := &egress.EgressGateway{
+		ObjectMeta: v1.ObjectMeta{Name: "egw1"},
+		Spec: egress.EgressGatewaySpec{
+			Ippools: egress.Ippools{IPv4: []string{"10.6.1.20-10.6.1.23"}},
+		},
+		Status: egress.EgressGatewayStatus{
+			Conflicts: []egress.EIPConflict{{IP: "10.6.1.20", MAC: "02:00:00:00:00:01", Node: "node1"}},
+		},
+	}
+	policy := func(p egress.Policy, specIP, statusIP string) *egress.EgressPolicy {
+		return &egress.EgressPolicy{
+			ObjectMeta: v1.ObjectMeta{Name: p.Name, Namespace: p.Namespace},
+			Spec: egress.EgressPolicySpec{
+				EgressGatewayName: "egw1",
+				EgressIP:          egress.EgressIP{IPv4: specIP, AllocatorPolicy: egress.EipAllocatorShared},
+			},
+			Status: egress.EgressPolicyStatus{Eip: egress.Eip{Ipv4: statusIP}},
+		}
+	}
+	nodeMap := map[string]egress.EgressIPStatus{
+		"node1": {Name: "node1", Status: ready, Eips: []egress.Eips{
+			{IPv4: "10.6.1.20", Policies: []egress.Policy{moved, pinned}},
+			{IPv4: "10.6.1.21", Policies: []egress.Policy{other}},
+		}},
+	}
+	egw.Status.NodeList = []egress.EgressIPStatus{nodeMap["node1"]}
+
+	cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(
+		egw.DeepCopy(),
+		policy(moved, "", "10.6.1.20"),
+		policy(pinned, "10.6.1.20", "10.6.1.20"),
+		policy(other, "", "10.6.1.21"),
+	).Build()
+	r := egnReconciler{client: cli, log: logger.NewLogger(logger.Config{}), recorder: record.NewFakeRecorder(10)}
+
+	changed, err := r.steerConflicts(context.Background(), r.log, egw, nodeMap)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	res := map[string][]egress.Policy{}
+	for _, eip := range nodeMap["node1"].Eips {
+		res[eip.IPv4] = append(res[eip.IPv4], eip.Policies...)
+	}
+	// the pinned policy keeps the conflicting EIP, the other one gets a free EIP
+	assert.Equal(t, []egress.Policy{pinned}, res["10.6.1.20"])
+	assert.Equal(t, []egress.Policy{other}, res["10.6.1.21"])
+	var newIP string
+	for ip, policies := range res {
+		if len(policies) == 1 && policies[0] == moved {
+			newIP = ip
+		}
+	}
+	assert.Contains(t, []string{"10.6.1.22", "10.6.1.23"}, newIP)
+
+	// nothing to move anymore
+	changed, err = r.steerConflicts(context.Background(), r.log, egw, nodeMap)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+}
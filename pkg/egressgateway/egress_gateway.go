@@ -7,15 +7,16 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
-	"net"
 	"time"
 
 	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -25,16 +26,18 @@ import (
 
 	"github.com/spidernet-io/egressgateway/pkg/config"
 	"github.com/spidernet-io/egressgateway/pkg/constant"
+	"github.com/spidernet-io/egressgateway/pkg/controller/metrics"
 	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/tracing"
 	"github.com/spidernet-io/egressgateway/pkg/utils"
-	"github.com/spidernet-io/egressgateway/pkg/utils/ip"
 	"github.com/spidernet-io/egressgateway/pkg/utils/slice"
 )
 
 type egnReconciler struct {
-	client client.Client
-	log    logr.Logger
-	config *config.Config
+	client   client.Client
+	log      logr.Logger
+	config   *config.Config
+	recorder record.EventRecorder
 }
 
 type policyInfo struct {
@@ -54,21 +57,59 @@ func (r egnReconciler) Reconcile(ctx context.Context, req reconcile.Request) (re
 	}
 
 	log := r.log.WithValues("kind", kind)
+	ctx = withReallocations(ctx)
 
+	var res reconcile.Result
 	switch kind {
 	case "EgressGateway":
-		return r.reconcileEGW(ctx, newReq, log)
+		res, err = r.reconcileEGW(ctx, newReq, log)
 	case "EgressClusterPolicy":
 		fallthrough
 	case "EgressPolicy":
-		return r.reconcileEGP(ctx, newReq, log)
+		ctx, span := tracing.Start(r.policyContext(ctx, kind, newReq), "controller reconcile policy",
+			tracing.ObjectAttributes(kind, &metav1.ObjectMeta{Name: newReq.Name, Namespace: newReq.Namespace})...)
+		if span.IsRecording() {
+			log = log.WithValues("traceID", span.SpanContext().TraceID().String())
+		}
+		res, err = r.reconcileEGP(ctx, newReq, log)
+		tracing.RecordError(span, err)
+		span.End()
 	case "Node":
-		return r.reconcileNode(ctx, newReq, log)
+		res, err = r.reconcileNode(ctx, newReq, log)
 	case "EgressTunnel":
-		return r.reconcileEGT(ctx, newReq, log)
+		res, err = r.reconcileEGT(ctx, newReq, log)
+	case "EgressIPPool":
+		res, err = r.reconcileEIPPool(ctx, newReq, log)
 	default:
 		return reconcile.Result{}, nil
 	}
+	if err != nil {
+		gateway, policy := "", ""
+		switch kind {
+		case "EgressGateway":
+			gateway = newReq.Name
+		case "EgressPolicy", "EgressClusterPolicy":
+			policy = metrics.PolicyLabel(egress.Policy{Name: newReq.Name, Namespace: newReq.Namespace})
+		}
+		metrics.ObserveReconcileError(kind, gateway, policy)
+	}
+	return res, err
+}
+
+// policyContext returns the context of the reconcile of a policy, its spans join the
+// trace of the last change of the policy
+func (r egnReconciler) policyContext(ctx context.Context, kind string, req reconcile.Request) context.Context {
+	if !tracing.Enabled() {
+		return ctx
+	}
+	var obj client.Object = new(egress.EgressPolicy)
+	if kind == "EgressClusterPolicy" {
+		obj = new(egress.EgressClusterPolicy)
+	}
+	if err := r.client.Get(ctx, req.NamespacedName, obj); err != nil {
+		return ctx
+	}
+	return tracing.ContextFromObject(ctx, obj)
 }
 
 // reconcileNode reconcile node
@@ -119,7 +160,7 @@ func (r egnReconciler) reconcileNode(ctx context.Context, req reconcile.Request,
 					egw.Status.NodeList = append(egw.Status.NodeList, egress.EgressIPStatus{Name: node.Name, Status: string(egress.EgressTunnelFailed)})
 				}
 
-				ipv4sFree, ipv6sFree, ipv4sTotal, ipv6sTotal, err := countGatewayIP(&egw)
+				ipv4sFree, ipv6sFree, ipv4sTotal, ipv6sTotal, err := countGatewayIP(ctx, r.client, &egw)
 				if err != nil {
 					r.log.Error(err, "count egress gateway ippools", "nodeList", egw.Status.NodeList)
 					return reconcile.Result{Requeue: true}, nil
@@ -129,8 +170,9 @@ func (r egnReconciler) reconcileNode(ctx context.Context, req reconcile.Request,
 				egw.Status.IPUsage.IPv6Free = ipv6sFree
 				egw.Status.IPUsage.IPv6Total = ipv6sTotal
 
+				egw.Status.SetConditions(egw.Generation)
 				r.log.V(1).Info("update egress gateway status", "status", egw.Status)
-				err = r.client.Status().Update(ctx, &egw)
+				err = r.updateGatewayStatus(ctx, &egw)
 				if err != nil {
 					r.log.Error(err, "update egress gateway status", "status", egw.Status)
 					return reconcile.Result{Requeue: true}, nil
@@ -246,6 +288,9 @@ func (r egnReconciler) reconcileEGW(ctx context.Context, req reconcile.Request,
 
 	log.Info("deleted gateway nodes", "delNodeMap", delNodeMap)
 
+	// reassigned is set when EIPs move to another gateway node
+	reassigned := false
+
 	if len(delNodeMap) != 0 {
 		// Select a gateway node for the policy again
 		var reSetPolicies []egress.Policy
@@ -266,6 +311,21 @@ func (r egnReconciler) reconcileEGW(ctx context.Context, req reconcile.Request,
 		}
 
 		isUpdate = true
+		reassigned = true
+	}
+
+	var requeueAfter time.Duration
+	if r.config.FileConfig.DuplicateAddressDetection.Enable {
+		expiration := time.Duration(r.config.FileConfig.DuplicateAddressDetection.ConflictExpiration) * time.Second
+		var pruned bool
+		pruned, requeueAfter = pruneConflicts(egw, expiration, time.Now())
+		steered, err := r.steerConflicts(ctx, log, egw, perNodeMap)
+		if err != nil {
+			log.Error(err, "failed to move the policies off the conflicting EIPs")
+			return reconcile.Result{Requeue: true}, err
+		}
+		isUpdate = isUpdate || pruned || steered
+		reassigned = reassigned || steered
 	}
 
 	// When the first gateway node of an egw recovers, you need to rebind the policy that references the egw
@@ -321,7 +381,7 @@ func (r egnReconciler) reconcileEGW(ctx context.Context, req reconcile.Request,
 		}
 		egw.Status.NodeList = perNodeList
 
-		ipv4sFree, ipv6sFree, ipv4sTotal, ipv6sTotal, err := countGatewayIP(egw)
+		ipv4sFree, ipv6sFree, ipv4sTotal, ipv6sTotal, err := countGatewayIP(ctx, r.client, egw)
 		if err != nil {
 			r.log.Error(err, "count egress gateway ippools", "nodeList", egw.Status.NodeList)
 			return reconcile.Result{Requeue: true}, nil
@@ -331,15 +391,23 @@ func (r egnReconciler) reconcileEGW(ctx context.Context, req reconcile.Request,
 		egw.Status.IPUsage.IPv6Free = ipv6sFree
 		egw.Status.IPUsage.IPv6Total = ipv6sTotal
 
+		egw.Status.SetConditions(egw.Generation)
 		log.V(1).Info("update egress gateway status", "status", egw.Status)
-		err = r.client.Status().Update(ctx, egw)
+		err = r.updateGatewayStatus(ctx, egw)
 		if err != nil {
 			log.Error(err, "update egress gateway status", "status", egw.Status)
 			return reconcile.Result{Requeue: true}, err
 		}
 	}
 
-	return reconcile.Result{}, nil
+	if reassigned {
+		if err := r.requestReannounce(ctx, egw); err != nil {
+			log.Error(err, "failed to request the re-announcement of the EIPs")
+			return reconcile.Result{Requeue: true}, err
+		}
+	}
+
+	return reconcile.Result{RequeueAfter: requeueAfter}, nil
 }
 
 // reconcileEG reconcile egress tunnel
@@ -373,8 +441,10 @@ func (r egnReconciler) reconcileEGT(ctx context.Context, req reconcile.Request,
 			perNodeMap := make(map[string]egress.EgressIPStatus)
 			egw := item.DeepCopy()
 
+			reassigned := false
 			// If the node is not in success state, the policy on the node is reassigned
 			if egt.Status.Phase != egress.EgressTunnelReady {
+				reassigned = len(policies) > 0
 				for _, node := range egw.Status.NodeList {
 					if node.Name != egt.Name {
 						perNodeMap[node.Name] = node
@@ -456,7 +526,7 @@ func (r egnReconciler) reconcileEGT(ctx context.Context, req reconcile.Request,
 			}
 
 			egw.Status.NodeList = perNodeList
-			ipv4sFree, ipv6sFree, ipv4sTotal, ipv6sTotal, err := countGatewayIP(egw)
+			ipv4sFree, ipv6sFree, ipv4sTotal, ipv6sTotal, err := countGatewayIP(ctx, r.client, egw)
 			if err != nil {
 				r.log.Error(err, "count egress gateway ippools", "nodeList", egw.Status.NodeList)
 				return reconcile.Result{Requeue: true}, nil
@@ -466,12 +536,53 @@ func (r egnReconciler) reconcileEGT(ctx context.Context, req reconcile.Request,
 			egw.Status.IPUsage.IPv6Free = ipv6sFree
 			egw.Status.IPUsage.IPv6Total = ipv6sTotal
 
+			egw.Status.SetConditions(egw.Generation)
 			log.V(1).Info("update egress gateway status", "status", egw.Status)
-			err = r.client.Status().Update(ctx, egw)
+			err = r.updateGatewayStatus(ctx, egw)
 			if err != nil {
 				log.Error(err, "update egress gateway status", "status", egw.Status)
 				return reconcile.Result{Requeue: true}, err
 			}
+
+			if reassigned {
+				observeFailover(egt, egw, policies, time.Now())
+				if err := r.requestReannounce(ctx, egw); err != nil {
+					log.Error(err, "failed to request the re-announcement of the EIPs")
+					return reconcile.Result{Requeue: true}, err
+				}
+			}
+		}
+	}
+
+	return reconcile.Result{}, nil
+}
+
+// reconcileEIPPool refresh the IP usage of the gateways that reference the EgressIPPool
+func (r egnReconciler) reconcileEIPPool(ctx context.Context, req reconcile.Request, log logr.Logger) (reconcile.Result, error) {
+	gateways, err := ListPoolGateways(ctx, r.client, req.Name)
+	if err != nil {
+		return reconcile.Result{Requeue: true}, err
+	}
+
+	for _, item := range gateways {
+		egw := item.DeepCopy()
+		ipv4sFree, ipv6sFree, ipv4sTotal, ipv6sTotal, err := countGatewayIP(ctx, r.client, egw)
+		if err != nil {
+			log.Error(err, "count egress gateway ippools", "egressGateway", egw.Name)
+			return reconcile.Result{Requeue: true}, nil
+		}
+		usage := egress.IPUsage{IPv4Free: ipv4sFree, IPv4Total: ipv4sTotal, IPv6Free: ipv6sFree, IPv6Total: ipv6sTotal}
+		if egw.Status.IPUsage == usage {
+			continue
+		}
+		egw.Status.IPUsage = usage
+
+		egw.Status.SetConditions(egw.Generation)
+		log.V(1).Info("update egress gateway status", "status", egw.Status)
+		err = r.updateGatewayStatus(ctx, egw)
+		if err != nil {
+			log.Error(err, "update egress gateway status", "status", egw.Status)
+			return reconcile.Result{Requeue: true}, err
 		}
 	}
 
@@ -556,14 +667,21 @@ func (r egnReconciler) reconcileEGP(ctx context.Context, req reconcile.Request,
 			return reconcile.Result{Requeue: true}, nil
 		}
 		for _, egw := range egwList.Items {
-			_, isExist := GetEIPStatusByPolicy(policy, egw)
+			eipStatus, isExist := GetEIPStatusByPolicy(policy, egw)
 			if isExist {
+				// the EIP is released when the policy is its last user
+				var released *egress.Eips
+				for _, eip := range eipStatus.Eips {
+					if len(eip.Policies) == 1 && eip.Policies[0] == policy && (eip.IPv4 != "" || eip.IPv6 != "") {
+						released = &egress.Eips{IPv4: eip.IPv4, IPv6: eip.IPv6}
+					}
+				}
 				log.Info("delete policy", "policy", policy, "egw", egw.Name)
 				// Delete the policy from the EgressGateway. If the referenced EIP is not used by any other policy,
 				// the system reclaims the EIP.
 				DeletePolicyFromEG(log, policy, &egw)
 
-				ipv4sFree, ipv6sFree, ipv4sTotal, ipv6sTotal, err := countGatewayIP(&egw)
+				ipv4sFree, ipv6sFree, ipv4sTotal, ipv6sTotal, err := countGatewayIP(ctx, r.client, &egw)
 				if err != nil {
 					r.log.Error(err, "count egress gateway ippools", "nodeList", egw.Status.NodeList)
 					return reconcile.Result{Requeue: true}, nil
@@ -573,15 +691,21 @@ func (r egnReconciler) reconcileEGP(ctx context.Context, req reconcile.Request,
 				egw.Status.IPUsage.IPv6Free = ipv6sFree
 				egw.Status.IPUsage.IPv6Total = ipv6sTotal
 
+				egw.Status.SetConditions(egw.Generation)
 				log.V(1).Info("update egress gateway status", "status", egw.Status)
-				err = r.client.Status().Update(ctx, &egw)
+				err = r.updateGatewayStatus(ctx, &egw)
 				if err != nil {
 					log.Error(err, "update egress gateway status", "status", egw.Status)
 					return reconcile.Result{Requeue: true}, err
 				}
+				if released != nil {
+					r.recordRelease(ctx, &egw, policy, eipStatus.Name, *released)
+				}
+				metrics.DeletePolicy(policy)
 				return reconcile.Result{}, nil
 			}
 		}
+		metrics.DeletePolicy(policy)
 		return reconcile.Result{}, nil
 	}
 
@@ -664,9 +788,13 @@ func (r egnReconciler) reconcileEGP(ctx context.Context, req reconcile.Request,
 						policyStatus.Eip.Ipv4 = eip.IPv4
 						policyStatus.Eip.Ipv6 = eip.IPv6
 						policyStatus.Node = eipStatus.Name
+						policyStatus.PodEips = egp.Status.PodEips
 
 						if len(policy.Namespace) == 0 {
 							if len(egcp.Status.Node) == 0 {
+								policyStatus.Conditions = egcp.Status.Conditions
+								policyStatus.Datapath = egcp.Status.Datapath
+								policyStatus.SetEIPAllocatedCondition(egcp.Generation)
 								egcp.Status = policyStatus
 								log.V(1).Info("update egressclusterpolicy status", "status", egcp.Status)
 								err = r.client.Status().Update(ctx, egcp)
@@ -677,6 +805,9 @@ func (r egnReconciler) reconcileEGP(ctx context.Context, req reconcile.Request,
 							}
 						} else {
 							if len(egp.Status.Node) == 0 {
+								policyStatus.Conditions = egp.Status.Conditions
+								policyStatus.Datapath = egp.Status.Datapath
+								policyStatus.SetEIPAllocatedCondition(egp.Generation)
 								egp.Status = policyStatus
 								log.V(1).Info("update egresspolicy status", "status", egp.Status)
 								err = r.client.Status().Update(ctx, egp)
@@ -688,7 +819,11 @@ func (r egnReconciler) reconcileEGP(ctx context.Context, req reconcile.Request,
 						}
 					}
 
-					isUpdate = true
+					// the sticky policies are reconciled on each change of their pods, the
+					// gateway status is only written when their stateful EIPs change
+					if isReAllocatorPolicy || len(policy.Namespace) == 0 || egp.Spec.EgressIP.AllocatorPolicy != egress.EipAllocatorSticky {
+						isUpdate = true
+					}
 					goto update
 				}
 			}
@@ -697,8 +832,30 @@ func (r egnReconciler) reconcileEGP(ctx context.Context, req reconcile.Request,
 	}
 
 update:
+	if len(policy.Namespace) != 0 && egp.Spec.EgressIP.AllocatorPolicy == egress.EipAllocatorSticky {
+		nodeName := ""
+		if eipStatus, ok := GetEIPStatusByPolicy(policy, *egw); ok {
+			nodeName = eipStatus.Name
+		}
+		nodeMap := make(map[string]egress.EgressIPStatus)
+		for _, node := range egw.Status.NodeList {
+			nodeMap[node.Name] = node
+		}
+		changed, err := r.syncStatefulEips(ctx, log, egp, egw, nodeName, nodeMap)
+		if err != nil {
+			log.Error(err, "failed to allocate EIPs for stateful pods", "policy", policy)
+			return reconcile.Result{Requeue: true}, err
+		}
+		if changed {
+			for i, node := range egw.Status.NodeList {
+				egw.Status.NodeList[i] = nodeMap[node.Name]
+			}
+			isUpdate = true
+		}
+	}
+
 	if isUpdate {
-		ipv4sFree, ipv6sFree, ipv4sTotal, ipv6sTotal, err := countGatewayIP(egw)
+		ipv4sFree, ipv6sFree, ipv4sTotal, ipv6sTotal, err := countGatewayIP(ctx, r.client, egw)
 		if err != nil {
 			r.log.Error(err, "count egress gateway ippools", "nodeList", egw.Status.NodeList)
 			return reconcile.Result{Requeue: true}, nil
@@ -707,8 +864,9 @@ update:
 		egw.Status.IPUsage.IPv4Total = ipv4sTotal
 		egw.Status.IPUsage.IPv6Free = ipv6sFree
 		egw.Status.IPUsage.IPv6Total = ipv6sTotal
+		egw.Status.SetConditions(egw.Generation)
 		r.log.V(1).Info("update egress gateway status", "status", egw.Status)
-		err = r.client.Status().Update(ctx, egw)
+		err = r.updateGatewayStatus(ctx, egw)
 		if err != nil {
 			r.log.Error(err, "update egress gateway status", "status", egw.Status)
 			return reconcile.Result{Requeue: true}, err
@@ -761,7 +919,7 @@ func (r egnReconciler) deleteNodeFromEG(ctx context.Context, log logr.Logger, no
 		}
 
 		egw.Status.NodeList = perNodeList
-		ipv4sFree, ipv6sFree, ipv4sTotal, ipv6sTotal, err := countGatewayIP(&egw)
+		ipv4sFree, ipv6sFree, ipv4sTotal, ipv6sTotal, err := countGatewayIP(ctx, r.client, &egw)
 		if err != nil {
 			r.log.Error(err, "count egress gateway ippools", "nodeList", egw.Status.NodeList)
 			return err
@@ -771,8 +929,9 @@ func (r egnReconciler) deleteNodeFromEG(ctx context.Context, log logr.Logger, no
 		egw.Status.IPUsage.IPv6Free = ipv6sFree
 		egw.Status.IPUsage.IPv6Total = ipv6sTotal
 
+		egw.Status.SetConditions(egw.Generation)
 		r.log.V(1).Info("update egress gateway status", "status", egw.Status)
-		err = r.client.Status().Update(ctx, &egw)
+		err = r.updateGatewayStatus(ctx, &egw)
 		if err != nil {
 			r.log.Error(err, "update egress gateway status", "status", egw.Status)
 			return err
@@ -782,15 +941,82 @@ func (r egnReconciler) deleteNodeFromEG(ctx context.Context, log logr.Logger, no
 	return nil
 }
 
-func (r egnReconciler) reAllocatorPolicy(ctx context.Context, log logr.Logger, policy egress.Policy, egw *egress.EgressGateway, nodeMap map[string]egress.EgressIPStatus) error {
+// observeFailover records the time from the last heartbeat of the failed node to
+// the reassignment of each of its policies which moved to another node.
+func observeFailover(egt *egress.EgressTunnel, egw *egress.EgressGateway, policies []egress.Policy, now time.Time) {
+	if egt.Status.LastHeartbeatTime.IsZero() {
+		return
+	}
+	seconds := now.Sub(egt.Status.LastHeartbeatTime.Time).Seconds()
+	for _, policy := range policies {
+		status, ok := GetEIPStatusByPolicy(policy, *egw)
+		if !ok || status.Name == egt.Name {
+			continue
+		}
+		metrics.ObserveFailover(egw.Name, policy, seconds)
+	}
+}
+
+// requestReannounce sets AnnotationReannounce of the gateway to a new value, the agents
+// send the takeover burst of their EIPs again once they have seen the new status.
+func (r egnReconciler) requestReannounce(ctx context.Context, egw *egress.EgressGateway) error {
+	return requestReannounce(ctx, r.client, egw)
+}
+
+func requestReannounce(ctx context.Context, cli client.Client, egw *egress.EgressGateway) error {
+	patch := client.MergeFrom(egw.DeepCopy())
+	if egw.Annotations == nil {
+		egw.Annotations = make(map[string]string)
+	}
+	egw.Annotations[egress.AnnotationReannounce] = time.Now().UTC().Format(time.RFC3339Nano)
+	return cli.Patch(ctx, egw, patch)
+}
+
+func (r egnReconciler) reAllocatorPolicy(ctx context.Context, log logr.Logger, policy egress.Policy, egw *egress.EgressGateway, nodeMap map[string]egress.EgressIPStatus) (err error) {
 	var perNode string
 	var ipv4, ipv6 string
-	var err error
 	pi := policyInfo{}
 	pi.policy = policy
+	egp := &egress.EgressPolicy{}
+	pinned := false
+
+	oldNode := ""
+	if status, ok := GetEIPStatusByPolicy(policy, *egw); ok {
+		oldNode = status.Name
+	}
+	shared := false
+	result := ""
+	ctx, span := tracing.StartChild(ctx, "reAllocatorPolicy", attribute.String("egressGateway", egw.Name))
+	// the result is set by the deferred function below, which runs first
+	defer func() {
+		span.SetAttributes(attribute.String("node", perNode), attribute.String("result", result))
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+	defer func() {
+		switch {
+		case err == nil && result == "":
+			result = metrics.ReallocationAssigned
+			if shared {
+				result = metrics.ReallocationShared
+			} else if oldNode == perNode {
+				result = metrics.ReallocationKept
+			} else if oldNode != "" {
+				result = metrics.ReallocationMoved
+			}
+		case err != nil:
+			result = metrics.ReallocationFailed
+			if _, ok := err.(noFreeEIPError); ok {
+				result = metrics.ReallocationNoFreeEIP
+			}
+		}
+		r.addReallocation(ctx, egw, reallocation{gateway: egw.Name, policy: policy, result: result,
+			oldNode: oldNode, node: perNode, eip: egress.Eips{IPv4: ipv4, IPv6: ipv6}, err: err})
+	}()
 
 	if len(nodeMap) == 0 {
 		r.log.Info("egw: ", egw.Name, " does not have a matching node")
+		result = metrics.ReallocationNoNode
 		return nil
 	}
 
@@ -813,11 +1039,11 @@ func (r egnReconciler) reAllocatorPolicy(ctx context.Context, log logr.Logger, p
 			pi.ipv6 = egcp.Status.Eip.Ipv6
 		}
 
+		pinned = len(egcp.Spec.EgressIP.IPv4) != 0 || len(egcp.Spec.EgressIP.IPv6) != 0
 		pi.isUseNodeIP = egcp.Spec.EgressIP.UseNodeIP
 		pi.egw = egcp.Spec.EgressGatewayName
 		pi.allocatorPolicy = egcp.Spec.EgressIP.AllocatorPolicy
 	} else {
-		egp := &egress.EgressPolicy{}
 		err := r.client.Get(ctx, types.NamespacedName{Namespace: pi.policy.Namespace, Name: pi.policy.Name}, egp)
 		if err != nil {
 			return err
@@ -835,11 +1061,18 @@ func (r egnReconciler) reAllocatorPolicy(ctx context.Context, log logr.Logger, p
 			pi.ipv6 = egp.Status.Eip.Ipv6
 		}
 
+		pinned = len(egp.Spec.EgressIP.IPv4) != 0 || len(egp.Spec.EgressIP.IPv6) != 0
 		pi.isUseNodeIP = egp.Spec.EgressIP.UseNodeIP
 		pi.egw = egp.Spec.EgressGatewayName
 		pi.allocatorPolicy = egp.Spec.EgressIP.AllocatorPolicy
 	}
 
+	// the EIP in the status of the policy is given up when another host uses it,
+	// the spec always wins
+	if !pinned && isConflicted(egw, egress.Eips{IPv4: pi.ipv4, IPv6: pi.ipv6}) {
+		pi.ipv4, pi.ipv6 = "", ""
+	}
+
 	ipv4 = pi.ipv4
 	if len(ipv4) != 0 {
 		perNode = GetNodeByIP(ipv4, *egw)
@@ -848,25 +1081,49 @@ func (r egnReconciler) reAllocatorPolicy(ctx context.Context, log logr.Logger, p
 		}
 
 		if len(perNode) == 0 {
-			perNode, err = r.allocatorNode("rr", nodeMap)
+			perNode, err = r.allocatorNode(ctx, "rr", nodeMap)
 			if err != nil {
 				return err
 			}
 		}
 
-		ipv4, ipv6, err = r.allocatorEIP("", perNode, pi, *egw)
+		ipv4, ipv6, err = r.allocatorEIP(ctx, "", perNode, pi, *egw)
 		if err != nil {
 			return err
 		}
 	} else {
 		allocatorPolicy := pi.allocatorPolicy
-		if allocatorPolicy == egress.EipAllocatorRR {
-			perNode, err = r.allocatorNode("rr", nodeMap)
+		if allocatorPolicy == egress.EipAllocatorRR || allocatorPolicy == egress.EipAllocatorSticky {
+			perNode, err = r.allocatorNode(ctx, "rr", nodeMap)
 			if err != nil {
 				return err
 			}
 
-			ipv4, ipv6, err = r.allocatorEIP("", perNode, pi, *egw)
+			ipv4, ipv6, err = r.allocatorEIP(ctx, "", perNode, pi, *egw)
+			if err != nil {
+				return err
+			}
+		} else if allocatorPolicy == egress.EipAllocatorShared {
+			perNode, err = r.allocatorNode(ctx, "rr", nodeMap)
+			if err != nil {
+				return err
+			}
+
+			ipv4, ipv6, err = r.allocatorEIP(ctx, "", perNode, pi, *egw)
+			if _, ok := err.(noFreeEIPError); ok {
+				pool, poolErr := GetEIPPool(ctx, r.client, egw)
+				if poolErr != nil {
+					return poolErr
+				}
+				sharedNode, sharedEip, found := allocatorSharedEIP(perNode, nodeMap, sharableEIP(pool, egw, pi.policy))
+				if !found {
+					return err
+				}
+				log.Info("no free EIP, share an allocated EIP", "policy", pi.policy, "node", sharedNode,
+					"ipv4", sharedEip.IPv4, "ipv6", sharedEip.IPv6, "sharedWith", len(sharedEip.Policies))
+				perNode, ipv4, ipv6, err = sharedNode, sharedEip.IPv4, sharedEip.IPv6, nil
+				shared = true
+			}
 			if err != nil {
 				return err
 			}
@@ -880,7 +1137,7 @@ func (r egnReconciler) reAllocatorPolicy(ctx context.Context, log logr.Logger, p
 			}
 
 			if len(perNode) == 0 {
-				perNode, err = r.allocatorNode("rr", nodeMap)
+				perNode, err = r.allocatorNode(ctx, "rr", nodeMap)
 				if err != nil {
 					return err
 				}
@@ -895,17 +1152,29 @@ func (r egnReconciler) reAllocatorPolicy(ctx context.Context, log logr.Logger, p
 		return err
 	}
 
+	// the EIPs of the stateful pods follow the policy to its new gateway node
+	if len(pi.policy.Namespace) != 0 && pi.allocatorPolicy == egress.EipAllocatorSticky {
+		if _, err := r.syncStatefulEips(ctx, log, egp, egw, perNode, nodeMap); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func (r egnReconciler) allocatorNode(selNodePolicy string, nodeMap map[string]egress.EgressIPStatus) (string, error) {
+func (r egnReconciler) allocatorNode(ctx context.Context, selNodePolicy string, nodeMap map[string]egress.EgressIPStatus) (perNode string, err error) {
+	_, span := tracing.StartChild(ctx, "allocatorNode", attribute.Int("nodes", len(nodeMap)))
+	defer func() {
+		span.SetAttributes(attribute.String("node", perNode))
+		tracing.RecordError(span, err)
+		span.End()
+	}()
 
 	if len(nodeMap) == 0 {
 		err := fmt.Errorf("nodeList is empty")
 		return "", err
 	}
 
-	var perNode string
 	perNodePolicyNum := 0
 	i := 0
 	for _, node := range nodeMap {
@@ -931,7 +1200,13 @@ func (r egnReconciler) allocatorNode(selNodePolicy string, nodeMap map[string]eg
 	return perNode, nil
 }
 
-func (r egnReconciler) allocatorEIP(selEipLolicy string, nodeName string, pi policyInfo, egw egress.EgressGateway) (string, string, error) {
+func (r egnReconciler) allocatorEIP(ctx context.Context, selEipLolicy string, nodeName string, pi policyInfo, egw egress.EgressGateway) (ipv4, ipv6 string, err error) {
+	ctx, span := tracing.StartChild(ctx, "allocatorEIP", attribute.String("node", nodeName))
+	defer func() {
+		span.SetAttributes(attribute.String("ipv4", ipv4), attribute.String("ipv6", ipv6))
+		tracing.RecordError(span, err)
+		span.End()
+	}()
 
 	if pi.isUseNodeIP || len(nodeName) == 0 {
 		return "", "", nil
@@ -940,113 +1215,106 @@ func (r egnReconciler) allocatorEIP(selEipLolicy string, nodeName string, pi pol
 	var perIpv6 string
 	rander := rand.New(rand.NewSource(time.Now().UnixNano()))
 
-	if len(egw.Spec.Ippools.IPv4) > 0 {
-		var useIpv4s []net.IP
+	pool, err := GetEIPPool(ctx, r.client, &egw)
+	if err != nil {
+		return "", "", err
+	}
 
-		ipv4Ranges, _ := ip.MergeIPRanges(constant.IPv4, egw.Spec.Ippools.IPv4)
+	if pool.HasIPv4() {
 		perIpv4 = pi.ipv4
 		if len(perIpv4) != 0 {
-			result, err := ip.IsIPIncludedRange(constant.IPv4, perIpv4, ipv4Ranges)
-			if err != nil {
+			if err := pool.CheckUsable(egw.Name, perIpv4, pi.policy); err != nil {
 				return "", "", err
 			}
-			if !result {
-				return "", "", fmt.Errorf("%v is not within the EIP range of EgressGateway %v", perIpv4, egw.Name)
-			}
 		} else {
-			for _, node := range egw.Status.NodeList {
-				for _, eip := range node.Eips {
-					if len(eip.IPv4) != 0 {
-						useIpv4s = append(useIpv4s, net.ParseIP(eip.IPv4))
-					}
-				}
-			}
-
-			ipv4s, _ := ip.ParseIPRanges(constant.IPv4, ipv4Ranges)
-			freeIpv4s := ip.IPsDiffSet(ipv4s, useIpv4s, false)
-
-			if len(freeIpv4s) == 0 {
-				return "", "", fmt.Errorf("No Egress IPV4 is available; policy=%v egw=%v", pi.policy, egw.Name)
-
-				// save it for later policy
-				// var useIpv4sByNode []net.IP
-				// for _, node := range egw.Status.NodeList {
-				// 	if node.Name == nodeName {
-				// 		for _, eip := range node.Eips {
-				// 			if len(eip.IPv4) != 0 {
-				// 				useIpv4sByNode = append(useIpv4sByNode, net.ParseIP(eip.IPv4))
-				// 			}
-				// 		}
-				// 	}
-				// }
-
-				// if len(useIpv4sByNode) == 0 {
-				// 	return "", "", fmt.Errorf("No EIP meeting requirements is found on node %v; EG %v", nodeName, egw.Name)
-				// }
-
-				// perIpv4 = useIpv4sByNode[rander.Intn(len(useIpv4sByNode))].String()
-			} else {
-				perIpv4 = freeIpv4s[rander.Intn(len(freeIpv4s))].String()
+			perIpv4, err = pool.Allocate(constant.IPv4, pi.policy, rander)
+			if err != nil {
+				return "", "", noFreeEIPError{version: "IPV4", policy: pi.policy, egw: egw.Name}
 			}
 		}
 	}
 
-	if len(egw.Spec.Ippools.IPv6) > 0 {
+	if pool.HasIPv6() {
 		if len(perIpv4) != 0 && len(GetEipByIPV4(perIpv4, egw).IPv6) != 0 {
 			return perIpv4, GetEipByIPV4(perIpv4, egw).IPv6, nil
 		}
 
-		var useIpv6s []net.IP
-
-		ipv6Ranges, _ := ip.MergeIPRanges(constant.IPv6, egw.Spec.Ippools.IPv6)
-
 		perIpv6 = pi.ipv6
 		if len(perIpv6) != 0 {
-			result, err := ip.IsIPIncludedRange(constant.IPv6, perIpv6, ipv6Ranges)
-			if err != nil {
+			if err := pool.CheckUsable(egw.Name, perIpv6, pi.policy); err != nil {
 				return "", "", err
 			}
-			if !result {
-				return "", "", fmt.Errorf("%v is not within the EIP range of EgressGateway %v", perIpv6, egw.Name)
-			}
 		} else {
-			for _, node := range egw.Status.NodeList {
-				for _, eip := range node.Eips {
-					if len(eip.IPv6) != 0 {
-						useIpv6s = append(useIpv6s, net.ParseIP(eip.IPv6))
-					}
-				}
+			perIpv6, err = pool.Allocate(constant.IPv6, pi.policy, rander)
+			if err != nil {
+				return "", "", noFreeEIPError{version: "IPV6", policy: pi.policy, egw: egw.Name}
 			}
+		}
+	}
 
-			ipv6s, _ := ip.ParseIPRanges(constant.IPv6, ipv6Ranges)
-			freeIpv6s := ip.IPsDiffSet(ipv6s, useIpv6s, false)
-
-			if len(freeIpv6s) == 0 {
-				return "", "", fmt.Errorf("No Egress IPV6 is available; policy=%v egw=%v", pi.policy, egw.Name)
-
-				// save it for later policy
-				// var useIpv6sByNode []net.IP
-				// for _, node := range egw.Status.NodeList {
-				// 	if node.Name == nodeName {
-				// 		for _, eip := range node.Eips {
-				// 			if len(eip.IPv6) != 0 {
-				// 				useIpv6sByNode = append(useIpv6sByNode, net.ParseIP(eip.IPv6))
-				// 			}
-				// 		}
-				// 	}
-				// }
-
-				// if len(useIpv6sByNode) == 0 {
-				// 	return "", "", fmt.Errorf("No EIP meeting requirements is found on node %v; EG %v", nodeName, egw.Name)
-				// }
-				// perIpv6 = useIpv6sByNode[rander.Intn(len(useIpv6sByNode))].String()
-			} else {
-				perIpv6 = freeIpv6s[rander.Intn(len(freeIpv6s))].String()
+	return perIpv4, perIpv6, nil
+}
+
+// noFreeEIPError is returned by allocatorEIP when every EIP of the pool is allocated
+type noFreeEIPError struct {
+	version string
+	policy  egress.Policy
+	egw     string
+}
+
+func (e noFreeEIPError) Error() string {
+	return fmt.Sprintf("No Egress %v is available; policy=%v egw=%v", e.version, e.policy, e.egw)
+}
+
+// allocatorSharedEIP selects an allocated EIP to be shared by one more policy. The EIP
+// used by the fewest policies on nodeName is preferred, if nodeName has no EIP the one
+// used by the fewest policies on any ready node is selected, together with its node.
+// Only the EIPs accepted by usable are selected.
+func allocatorSharedEIP(nodeName string, nodeMap map[string]egress.EgressIPStatus, usable func(egress.Eips) bool) (string, egress.Eips, bool) {
+	var perNode string
+	var perEip egress.Eips
+	found := false
+
+	pick := func(node egress.EgressIPStatus) {
+		for _, eip := range node.Eips {
+			if (len(eip.IPv4) == 0 && len(eip.IPv6) == 0) || !usable(eip) {
+				continue
+			}
+			if !found || len(eip.Policies) < len(perEip.Policies) ||
+				(len(eip.Policies) == len(perEip.Policies) && eipKey(eip) < eipKey(perEip)) {
+				perNode, perEip, found = node.Name, eip, true
 			}
 		}
 	}
 
-	return perIpv4, perIpv6, nil
+	if node, ok := nodeMap[nodeName]; ok && node.Status == string(egress.EgressTunnelReady) {
+		pick(node)
+	}
+	if !found {
+		for _, node := range nodeMap {
+			if node.Status == string(egress.EgressTunnelReady) {
+				pick(node)
+			}
+		}
+	}
+
+	return perNode, perEip, found
+}
+
+// sharableEIP returns whether the policy can share an allocated EIP of egw, the EIPs
+// reserved for other policies and the EIPs conflicting with another host are not shared
+func sharableEIP(pool *EIPPool, egw *egress.EgressGateway, policy egress.Policy) func(egress.Eips) bool {
+	return func(eip egress.Eips) bool {
+		if isConflicted(egw, eip) {
+			return false
+		}
+		for _, ip := range []string{eip.IPv4, eip.IPv6} {
+			if len(ip) != 0 && pool.CheckUsable(egw.Name, ip, policy) != nil {
+				return false
+			}
+		}
+		return true
+	}
 }
 
 func NewEgressGatewayController(mgr manager.Manager, log logr.Logger, cfg *config.Config) error {
@@ -1054,9 +1322,10 @@ func NewEgressGatewayController(mgr manager.Manager, log logr.Logger, cfg *confi
 		return fmt.Errorf("cfg can not be nil")
 	}
 	r := &egnReconciler{
-		client: mgr.GetClient(),
-		log:    log,
-		config: cfg,
+		client:   tracing.WrapClient(mgr.GetClient()),
+		log:      log,
+		config:   cfg,
+		recorder: mgr.GetEventRecorderFor("egress-gateway"),
 	}
 
 	c, err := controller.New("egressGateway", mgr,
@@ -1090,6 +1359,23 @@ func NewEgressGatewayController(mgr manager.Manager, log logr.Logger, cfg *confi
 		return fmt.Errorf("failed to watch EgressTunnel: %w", err)
 	}
 
+	if err = c.Watch(source.Kind(mgr.GetCache(), &egress.EgressIPPool{}),
+		handler.EnqueueRequestsFromMapFunc(utils.KindToMapFlat("EgressIPPool"))); err != nil {
+		return fmt.Errorf("failed to watch EgressIPPool: %w", err)
+	}
+
+	// new StatefulSet pods of a sticky policy need their own EIP
+	if err = c.Watch(source.Kind(mgr.GetCache(), &egress.EgressEndpointSlice{}),
+		handler.EnqueueRequestsFromMapFunc(enqueueEndpointSlicePolicy(mgr.GetClient()))); err != nil {
+		return fmt.Errorf("failed to watch EgressEndpointSlice: %w", err)
+	}
+
+	if cfg.FileConfig.EIPRebalance.Enable {
+		if err = newRebalancer(mgr, log, cfg); err != nil {
+			return fmt.Errorf("failed to add eip rebalancer: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -1211,6 +1497,17 @@ func GetEIPStatusByPolicy(policy egress.Policy, egw egress.EgressGateway) (egres
 func DeletePolicyFromEG(log logr.Logger, policy egress.Policy, egw *egress.EgressGateway) {
 	var policies []egress.Policy
 	var eips []egress.Eips
+
+	// Release the EIPs of the stateful pods of the policy
+	for i, node := range egw.Status.NodeList {
+		var statefulEips []egress.StatefulEip
+		for _, eip := range node.StatefulEips {
+			if eip.Policy != policy {
+				statefulEips = append(statefulEips, eip)
+			}
+		}
+		egw.Status.NodeList[i].StatefulEips = statefulEips
+	}
 	for i, node := range egw.Status.NodeList {
 		for j, eip := range node.Eips {
 			for k, item := range eip.Policies {
@@ -1257,32 +1554,13 @@ func egwIpsCount(status egress.EgressGatewayStatus) (int, int) {
 	return ipv4Usage, ipv6Usage
 }
 
-func countGatewayIP(egw *egress.EgressGateway) (ipv4sFree, ipv6sFree, ipv4sTotal, ipv6sTotal int, err error) {
-	ipv4s, err := ip.ConvertCidrOrIPrangeToIPs(egw.Spec.Ippools.IPv4, constant.IPv4)
+func countGatewayIP(ctx context.Context, cli client.Reader, egw *egress.EgressGateway) (ipv4sFree, ipv6sFree, ipv4sTotal, ipv6sTotal int, err error) {
+	pool, err := GetEIPPool(ctx, cli, egw)
 	if err != nil {
 		return 0, 0, 0, 0, err
 	}
-	ipv6s, err := ip.ConvertCidrOrIPrangeToIPs(egw.Spec.Ippools.IPv6, constant.IPv6)
-	if err != nil {
-		return 0, 0, 0, 0, err
-	}
-	useIpv4s := make([]net.IP, 0)
-	useIpv6s := make([]net.IP, 0)
-	for _, node := range egw.Status.NodeList {
-		for _, eip := range node.Eips {
-			if len(eip.IPv4) != 0 {
-				useIpv4s = append(useIpv4s, net.ParseIP(eip.IPv4))
-			}
-			if len(eip.IPv6) != 0 {
-				useIpv6s = append(useIpv6s, net.ParseIP(eip.IPv6))
-			}
-		}
-	}
-
-	ipv4sFree = len(ipv4s) - len(useIpv4s)
-	ipv6sFree = len(ipv6s) - len(useIpv6s)
 
-	ipv4sTotal, ipv6sTotal, err = len(ipv4s), len(ipv6s), nil
+	ipv4sFree, ipv6sFree, ipv4sTotal, ipv6sTotal = pool.Count()
 	return
 }
 
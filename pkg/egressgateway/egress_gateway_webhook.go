@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"math/rand"
 	"net"
+	"regexp"
 	"time"
 
 	"github.com/spidernet-io/egressgateway/pkg/utils/ip"
@@ -20,6 +21,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
+	"github.com/spidernet-io/egressgateway/pkg/bgp"
 	"github.com/spidernet-io/egressgateway/pkg/config"
 	"github.com/spidernet-io/egressgateway/pkg/constant"
 	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
@@ -65,6 +67,33 @@ func (egw *EgressGatewayWebhook) EgressGatewayValidate(ctx context.Context, req
 		}
 	}
 
+	if newEg.Spec.BGP != nil {
+		for _, item := range newEg.Spec.BGP.Communities {
+			if _, err := bgp.ParseCommunity(item); err != nil {
+				return webhook.Denied(fmt.Sprintf("invalid spec.bgp.communities: %v", err))
+			}
+		}
+	}
+
+	if sel := newEg.Spec.AnnounceInterfaces; sel != nil {
+		for _, item := range sel.Regexps {
+			if _, err := regexp.Compile(item); err != nil {
+				return webhook.Denied(fmt.Sprintf("invalid spec.announceInterfaces.regexps: %v", err))
+			}
+		}
+		for _, item := range sel.Subnets {
+			if _, _, err := net.ParseCIDR(item); err != nil {
+				return webhook.Denied(fmt.Sprintf("invalid spec.announceInterfaces.subnets: %v", err))
+			}
+		}
+	}
+
+	if newEg.Spec.VLAN != nil {
+		if err := validateVLAN(newEg.Spec.VLAN); err != nil {
+			return webhook.Denied(fmt.Sprintf("invalid spec.vlan: %v", err))
+		}
+	}
+
 	if newEg.Spec.ClusterDefault {
 		egwList := new(egress.EgressGatewayList)
 		err := egw.Client.List(ctx, egwList)
@@ -78,6 +107,10 @@ func (egw *EgressGatewayWebhook) EgressGatewayValidate(ctx context.Context, req
 		}
 	}
 
+	if len(newEg.Spec.Ippools.EgressIPPool) != 0 {
+		return egw.validateEgressIPPoolRef(ctx, req, newEg)
+	}
+
 	// Checking the number of IPV4 and IPV6 addresses
 	var ipv4s, ipv6s []net.IP
 	ipv4Ranges, err := ip.MergeIPRanges(constant.IPv4, newEg.Spec.Ippools.IPv4)
@@ -174,6 +207,43 @@ func (egw *EgressGatewayWebhook) EgressGatewayValidate(ctx context.Context, req
 	return webhook.Allowed("checked")
 }
 
+// validateEgressIPPoolRef validates the EgressGateway that allocates EIPs from an EgressIPPool
+func (egw *EgressGatewayWebhook) validateEgressIPPoolRef(ctx context.Context, req webhook.AdmissionRequest, newEg *egress.EgressGateway) webhook.AdmissionResponse {
+	if len(newEg.Spec.Ippools.IPv4) != 0 || len(newEg.Spec.Ippools.IPv6) != 0 {
+		return webhook.Denied("spec.ippools.egressIPPool cannot be used with spec.ippools.ipv4 or spec.ippools.ipv6 at the same time")
+	}
+
+	if req.Operation == v1.Update {
+		oldEg := new(egress.EgressGateway)
+		err := json.Unmarshal(req.OldObject.Raw, oldEg)
+		if err != nil {
+			return webhook.Denied(fmt.Sprintf("json unmarshal EgressGateway with error: %v", err))
+		}
+		if oldEg.Spec.Ippools.EgressIPPool != newEg.Spec.Ippools.EgressIPPool {
+			ipv4Usage, ipv6Usage := egwIpsCount(oldEg.Status)
+			if ipv4Usage != 0 || ipv6Usage != 0 {
+				return webhook.Denied("the 'spec.ippools.egressIPPool' field cannot be modified when EIPs have been allocated")
+			}
+		}
+	}
+
+	pool, err := GetEIPPool(ctx, egw.Client, newEg)
+	if err != nil {
+		return webhook.Denied(err.Error())
+	}
+
+	for _, eip := range []string{newEg.Spec.Ippools.Ipv4DefaultEIP, newEg.Spec.Ippools.Ipv6DefaultEIP} {
+		if len(eip) == 0 {
+			continue
+		}
+		if err := pool.CheckUsable(newEg.Name, eip, egress.Policy{}); err != nil {
+			return webhook.Denied(fmt.Sprintf("invalid default EIP: %v", err))
+		}
+	}
+
+	return webhook.Allowed("checked")
+}
+
 func (egw *EgressGatewayWebhook) EgressGatewayMutate(ctx context.Context, req webhook.AdmissionRequest) webhook.AdmissionResponse {
 	rander := rand.New(rand.NewSource(time.Now().UnixNano()))
 	eg := new(egress.EgressGateway)
@@ -185,6 +255,32 @@ func (egw *EgressGatewayWebhook) EgressGatewayMutate(ctx context.Context, req we
 	reviewResponse := webhook.AdmissionResponse{}
 	var patchList []patchOperation
 
+	// patch egress gateway default eip from the referenced pool
+	if len(eg.Spec.Ippools.EgressIPPool) != 0 {
+		pool, err := GetEIPPool(ctx, egw.Client, eg)
+		if err != nil {
+			return webhook.Denied(err.Error())
+		}
+		if egw.Config.FileConfig.EnableIPv4 && len(eg.Spec.Ippools.Ipv4DefaultEIP) == 0 && pool.HasIPv4() {
+			if eip, err := pool.Allocate(constant.IPv4, egress.Policy{}, rander); err == nil {
+				patchList = append(patchList, patchOperation{
+					Op:    "add",
+					Path:  "/spec/ippools/ipv4DefaultEIP",
+					Value: eip,
+				})
+			}
+		}
+		if egw.Config.FileConfig.EnableIPv6 && len(eg.Spec.Ippools.Ipv6DefaultEIP) == 0 && pool.HasIPv6() {
+			if eip, err := pool.Allocate(constant.IPv6, egress.Policy{}, rander); err == nil {
+				patchList = append(patchList, patchOperation{
+					Op:    "add",
+					Path:  "/spec/ippools/ipv6DefaultEIP",
+					Value: eip,
+				})
+			}
+		}
+	}
+
 	// patch egress gateway default eip
 	if egw.Config.FileConfig.EnableIPv4 {
 		if len(eg.Spec.Ippools.Ipv4DefaultEIP) == 0 && len(eg.Spec.Ippools.IPv4) != 0 {
@@ -257,3 +353,56 @@ func getEgressGatewayFinalizerPatch(req webhook.AdmissionRequest, finalizer []st
 	}
 	return nil
 }
+
+// validateVLAN checks the addresses and gateways of the VLAN are of the right family
+func validateVLAN(v *egress.EgressGatewayVLAN) error {
+	if v.Parent == "" {
+		return fmt.Errorf("parent is not set")
+	}
+	if v.ID < 1 || v.ID > 4094 {
+		return fmt.Errorf("id %d is not in [1, 4094]", v.ID)
+	}
+	checkIP := func(field, value string, v4 bool) error {
+		if value == "" {
+			return nil
+		}
+		ip := net.ParseIP(value)
+		if ip == nil || (ip.To4() != nil) != v4 {
+			return fmt.Errorf("%s %q is not a valid address", field, value)
+		}
+		return nil
+	}
+	checkCIDR := func(field, value string, v4 bool) error {
+		if value == "" {
+			return nil
+		}
+		ip, _, err := net.ParseCIDR(value)
+		if err != nil || (ip.To4() != nil) != v4 {
+			return fmt.Errorf("%s %q is not a valid address in CIDR format", field, value)
+		}
+		return nil
+	}
+	if err := checkIP("ipv4Gateway", v.IPv4Gateway, true); err != nil {
+		return err
+	}
+	if err := checkIP("ipv6Gateway", v.IPv6Gateway, false); err != nil {
+		return err
+	}
+	nodes := make(map[string]struct{})
+	for _, item := range v.Addresses {
+		if item.Node == "" {
+			return fmt.Errorf("node of addresses is not set")
+		}
+		if _, ok := nodes[item.Node]; ok {
+			return fmt.Errorf("node %s has more than one entry in addresses", item.Node)
+		}
+		nodes[item.Node] = struct{}{}
+		if err := checkCIDR("ipv4", item.IPv4, true); err != nil {
+			return err
+		}
+		if err := checkCIDR("ipv6", item.IPv6, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
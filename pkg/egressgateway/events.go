@@ -0,0 +1,183 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/spidernet-io/egressgateway/pkg/controller/metrics"
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// The reasons of the Events of the EIP lifecycle, emitted on the EgressGateway and on
+// the policy. The reasons are stable, alerts may select on them.
+const (
+	// ReasonEIPAllocated is the reason of the Event emitted when a policy gets its first
+	// gateway node and EIP
+	ReasonEIPAllocated = "EIPAllocated"
+	// ReasonEIPMoved is the reason of the Event emitted when a policy moves to another
+	// gateway node, e.g. on a failover
+	ReasonEIPMoved = "EIPMoved"
+	// ReasonEIPShared is the reason of the Event emitted when a policy shares an EIP
+	// of another policy because the pool has no free EIP
+	ReasonEIPShared = "EIPShared"
+	// ReasonEIPExhausted is the reason of the Warning Event emitted when no EIP of the
+	// pool can be allocated to a policy
+	ReasonEIPExhausted = "EIPExhausted"
+	// ReasonEIPReleased is the reason of the Event emitted when the EIP of a deleted
+	// policy is released to the pool
+	ReasonEIPReleased = "EIPReleased"
+	// ReasonNoGatewayNode is the reason of the Warning Event emitted when the gateway
+	// has no node a policy can be assigned to
+	ReasonNoGatewayNode = "NoGatewayNode"
+	// ReasonAllocationFailed is the reason of the Warning Event emitted when the
+	// allocation of a policy fails for another reason
+	ReasonAllocationFailed = "AllocationFailed"
+	// ReasonEIPQuotaExceeded is the reason of the Warning Event emitted when stateful pods of
+	// a sticky policy get no EIP of their own because of the EgressIPQuota of the namespace
+	ReasonEIPQuotaExceeded = "EIPQuotaExceeded"
+	// ReasonGatewayDefaulted is the reason of the Event emitted when a policy created
+	// without EgressGatewayName is allocated on the default gateway set by the webhook
+	ReasonGatewayDefaulted = "GatewayDefaulted"
+)
+
+// reallocation is the result of a reAllocatorPolicy call, see recordReallocation
+type reallocation struct {
+	gateway string
+	policy  egress.Policy
+	result  string
+	oldNode string
+	node    string
+	eip     egress.Eips
+	err     error
+}
+
+type reallocationsKey struct{}
+
+// withReallocations returns a context which collects the reallocations made with it,
+// they are recorded by updateGatewayStatus once the status of the gateway is written.
+func withReallocations(ctx context.Context) context.Context {
+	return context.WithValue(ctx, reallocationsKey{}, &[]reallocation{})
+}
+
+// addReallocation observes and records the reallocation. A failed reallocation changes no status, it is
+// recorded at once. The other ones are only recorded after the gateway status is written,
+// if the context collects them, so that no Event is emitted for a status which is not
+// written.
+func (r egnReconciler) addReallocation(ctx context.Context, egw *egress.EgressGateway, item reallocation) {
+	pending, ok := ctx.Value(reallocationsKey{}).(*[]reallocation)
+	switch item.result {
+	case metrics.ReallocationNoFreeEIP, metrics.ReallocationNoNode, metrics.ReallocationFailed:
+		ok = false
+	}
+	if !ok {
+		r.observeReallocation(ctx, egw, item)
+		return
+	}
+	*pending = append(*pending, item)
+}
+
+// updateGatewayStatus writes the status of the gateway, the reallocations collected for
+// the gateway are observed and recorded if it succeeds, and dropped otherwise.
+func (r egnReconciler) updateGatewayStatus(ctx context.Context, egw *egress.EgressGateway) error {
+	err := r.client.Status().Update(ctx, egw)
+	pending, ok := ctx.Value(reallocationsKey{}).(*[]reallocation)
+	if !ok {
+		return err
+	}
+	rest := (*pending)[:0]
+	var done []reallocation
+	for _, item := range *pending {
+		if item.gateway != egw.Name {
+			rest = append(rest, item)
+			continue
+		}
+		done = append(done, item)
+	}
+	*pending = rest
+	if err != nil {
+		return err
+	}
+	for _, item := range done {
+		r.observeReallocation(ctx, egw, item)
+	}
+	return nil
+}
+
+func (r egnReconciler) observeReallocation(ctx context.Context, egw *egress.EgressGateway, item reallocation) {
+	metrics.ObserveReallocation(egw.Name, item.policy, item.result)
+	r.recordReallocation(ctx, egw, item.policy, item.result, item.oldNode, item.node, item.eip, item.err)
+}
+
+// recordReallocation emits the Events of a reallocation of the policy with the result
+// of metrics.ObserveReallocation, a policy kept on its node emits no Event.
+func (r egnReconciler) recordReallocation(ctx context.Context, egw *egress.EgressGateway, policy egress.Policy,
+	result, oldNode, node string, eip egress.Eips, err error) {
+	var eventType, reason, msg string
+	name := metrics.PolicyLabel(policy)
+	switch result {
+	case metrics.ReallocationAssigned:
+		eventType, reason = corev1.EventTypeNormal, ReasonEIPAllocated
+		msg = fmt.Sprintf("EIP (ipv4=%q, ipv6=%q) on node %s allocated to policy %s", eip.IPv4, eip.IPv6, node, name)
+	case metrics.ReallocationMoved:
+		eventType, reason = corev1.EventTypeNormal, ReasonEIPMoved
+		msg = fmt.Sprintf("policy %s moved from node %s to node %s with EIP (ipv4=%q, ipv6=%q)", name, oldNode, node, eip.IPv4, eip.IPv6)
+	case metrics.ReallocationShared:
+		eventType, reason = corev1.EventTypeNormal, ReasonEIPShared
+		msg = fmt.Sprintf("no free EIP, policy %s shares EIP (ipv4=%q, ipv6=%q) on node %s", name, eip.IPv4, eip.IPv6, node)
+	case metrics.ReallocationNoFreeEIP:
+		eventType, reason = corev1.EventTypeWarning, ReasonEIPExhausted
+		msg = fmt.Sprintf("no free EIP for policy %s: %v", name, err)
+	case metrics.ReallocationNoNode:
+		eventType, reason = corev1.EventTypeWarning, ReasonNoGatewayNode
+		msg = fmt.Sprintf("no gateway node for policy %s", name)
+	case metrics.ReallocationFailed:
+		eventType, reason = corev1.EventTypeWarning, ReasonAllocationFailed
+		msg = fmt.Sprintf("failed to allocate policy %s: %v", name, err)
+	default:
+		return
+	}
+	r.recorder.Event(egw, eventType, reason, msg)
+
+	obj, ok := r.getPolicyObject(ctx, policy)
+	if !ok {
+		return
+	}
+	r.recorder.Event(obj, eventType, reason, msg)
+	if oldNode == "" && err == nil && result != metrics.ReallocationNoNode {
+		if source, ok := obj.GetAnnotations()[egress.AnnotationDefaultGateway]; ok {
+			r.recorder.Eventf(obj, corev1.EventTypeNormal, ReasonGatewayDefaulted,
+				"EgressGateway %s set by the webhook from the %s default", egw.Name, source)
+		}
+	}
+}
+
+// recordRelease emits the Events of the release of the EIP of a deleted policy, the
+// policy only gets the Event while it is being deleted.
+func (r egnReconciler) recordRelease(ctx context.Context, egw *egress.EgressGateway, policy egress.Policy, node string, eip egress.Eips) {
+	msg := fmt.Sprintf("EIP (ipv4=%q, ipv6=%q) on node %s released by deleted policy %s",
+		eip.IPv4, eip.IPv6, node, metrics.PolicyLabel(policy))
+	r.recorder.Event(egw, corev1.EventTypeNormal, ReasonEIPReleased, msg)
+	if obj, ok := r.getPolicyObject(ctx, policy); ok {
+		r.recorder.Event(obj, corev1.EventTypeNormal, ReasonEIPReleased, msg)
+	}
+}
+
+func (r egnReconciler) getPolicyObject(ctx context.Context, policy egress.Policy) (client.Object, bool) {
+	var obj client.Object = &egress.EgressPolicy{}
+	if len(policy.Namespace) == 0 {
+		obj = &egress.EgressClusterPolicy{}
+	}
+	err := r.client.Get(ctx, types.NamespacedName{Namespace: policy.Namespace, Name: policy.Name}, obj)
+	if err != nil {
+		r.log.V(1).Info("get policy to record event", "policy", policy, "error", err)
+		return nil, false
+	}
+	return obj, true
+}
@@ -0,0 +1,174 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+// eventReasons returns the reasons of the recorded events, in order
+func eventReasons(recorder *record.FakeRecorder) []string {
+	var reasons []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			// the events of the fake recorder are "<type> <reason> <message>"
+			reasons = append(reasons, strings.Fields(event)[1])
+		default:
+			return reasons
+		}
+	}
+}
+
+func TestReAllocatorPolicyEvents(t *testing.T) {
+	ready := string(egress.EgressTunnelReady)
+	policy := egress.Policy{Name: "p1", Namespace: "default"}
+	egw := &egress.EgressGateway{
+		ObjectMeta: v1.ObjectMeta{Name: "egw1"},
+		Spec: egress.EgressGatewaySpec{
+			Ippools: egress.Ippools{IPv4: []string{"10.6.1.20-10.6.1.21"}},
+		},
+	}
+	egp := &egress.EgressPolicy{
+		ObjectMeta: v1.ObjectMeta{Name: "p1", Namespace: "default",
+			Annotations: map[string]string{egress.AnnotationDefaultGateway: egress.DefaultGatewayNamespace}},
+		Spec: egress.EgressPolicySpec{
+			EgressGatewayName: "egw1",
+			EgressIP:          egress.EgressIP{AllocatorPolicy: egress.EipAllocatorRR},
+		},
+	}
+
+	cases := map[string]struct {
+		nodeMap map[string]egress.EgressIPStatus
+		status  []egress.EgressIPStatus
+		expErr  bool
+		expect  []string
+	}{
+		"allocate on the default gateway": {
+			nodeMap: map[string]egress.EgressIPStatus{"node1": {Name: "node1", Status: ready}},
+			expect:  []string{ReasonEIPAllocated, ReasonEIPAllocated, ReasonGatewayDefaulted},
+		},
+		"move to another node": {
+			nodeMap: map[string]egress.EgressIPStatus{"node2": {Name: "node2", Status: ready}},
+			status: []egress.EgressIPStatus{{Name: "node1", Eips: []egress.Eips{
+				{IPv4: "10.6.1.20", Policies: []egress.Policy{policy}},
+			}}},
+			expect: []string{ReasonEIPMoved, ReasonEIPMoved},
+		},
+		"pool exhausted": {
+			nodeMap: map[string]egress.EgressIPStatus{"node1": {Name: "node1", Status: ready, Eips: []egress.Eips{
+				{IPv4: "10.6.1.20", Policies: []egress.Policy{{Name: "p2", Namespace: "default"}}},
+				{IPv4: "10.6.1.21", Policies: []egress.Policy{{Name: "p3", Namespace: "default"}}},
+			}}},
+			expErr: true,
+			expect: []string{ReasonEIPExhausted, ReasonEIPExhausted},
+		},
+		"no gateway node": {
+			nodeMap: map[string]egress.EgressIPStatus{},
+			expect:  []string{ReasonNoGatewayNode, ReasonNoGatewayNode},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			gateway := egw.DeepCopy()
+			gateway.Status.NodeList = c.status
+			if c.status == nil {
+				for _, node := range c.nodeMap {
+					gateway.Status.NodeList = append(gateway.Status.NodeList, node)
+				}
+			}
+			objs := []client.Object{gateway.DeepCopy(), egp.DeepCopy()}
+			cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(objs...).WithStatusSubresource(objs...).Build()
+			recorder := record.NewFakeRecorder(10)
+			r := egnReconciler{client: cli, log: logger.NewLogger(logger.Config{}), recorder: recorder}
+
+			ctx := withReallocations(context.Background())
+			assert.NoError(t, cli.Get(ctx, types.NamespacedName{Name: gateway.Name}, gateway))
+			err := r.reAllocatorPolicy(ctx, r.log, policy, gateway, c.nodeMap)
+			if c.expErr {
+				assert.Error(t, err)
+				// the failures are recorded at once
+				assert.Equal(t, c.expect, eventReasons(recorder))
+				return
+			}
+			assert.NoError(t, err)
+			if c.expect[0] != ReasonNoGatewayNode {
+				// the transitions are recorded after the gateway status is written
+				assert.Empty(t, eventReasons(recorder))
+				assert.NoError(t, r.updateGatewayStatus(ctx, gateway))
+			}
+			assert.Equal(t, c.expect, eventReasons(recorder))
+		})
+	}
+}
+
+func TestReAllocatorPolicyEventsUpdateFailed(t *testing.T) {
+	policy := egress.Policy{Name: "p1", Namespace: "default"}
+	egw := &egress.EgressGateway{
+		ObjectMeta: v1.ObjectMeta{Name: "egw1"},
+		Spec: egress.EgressGatewaySpec{
+			Ippools: egress.Ippools{IPv4: []string{"10.6.1.20-10.6.1.21"}},
+		},
+	}
+	egp := &egress.EgressPolicy{
+		ObjectMeta: v1.ObjectMeta{Name: "p1", Namespace: "default"},
+		Spec:       egress.EgressPolicySpec{EgressGatewayName: "egw1"},
+	}
+	// the gateway is not found, its status can not be written
+	cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(egp).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := egnReconciler{client: cli, log: logger.NewLogger(logger.Config{}), recorder: recorder}
+
+	ctx := withReallocations(context.Background())
+	nodeMap := map[string]egress.EgressIPStatus{"node1": {Name: "node1", Status: string(egress.EgressTunnelReady)}}
+	assert.NoError(t, r.reAllocatorPolicy(ctx, r.log, policy, egw, nodeMap))
+	assert.Error(t, r.updateGatewayStatus(ctx, egw))
+	assert.Empty(t, eventReasons(recorder))
+	assert.Empty(t, *ctx.Value(reallocationsKey{}).(*[]reallocation))
+}
+
+func TestDeletePolicyEvents(t *testing.T) {
+	policy := egress.Policy{Name: "p1", Namespace: "default"}
+	egw := &egress.EgressGateway{
+		ObjectMeta: v1.ObjectMeta{Name: "egw1"},
+		Spec: egress.EgressGatewaySpec{
+			Ippools: egress.Ippools{IPv4: []string{"10.6.1.20-10.6.1.21"}},
+		},
+		Status: egress.EgressGatewayStatus{NodeList: []egress.EgressIPStatus{{Name: "node1", Eips: []egress.Eips{
+			{IPv4: "10.6.1.20", Policies: []egress.Policy{policy}},
+			{IPv4: "10.6.1.21", Policies: []egress.Policy{{Name: "p2", Namespace: "default"}, {Name: "p3", Namespace: "default"}}},
+		}}}},
+	}
+	objs := []client.Object{egw}
+	cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(objs...).WithStatusSubresource(objs...).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := egnReconciler{client: cli, log: logger.NewLogger(logger.Config{}), recorder: recorder}
+
+	// the EIP of the deleted policy is released
+	req := reconcile.Request{NamespacedName: types.NamespacedName{Name: "p1", Namespace: "default"}}
+	_, err := r.reconcileEGP(context.Background(), req, r.log)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ReasonEIPReleased}, eventReasons(recorder))
+
+	// the shared EIP is kept for the other policy
+	req.Name = "p2"
+	_, err = r.reconcileEGP(context.Background(), req, r.log)
+	assert.NoError(t, err)
+	assert.Empty(t, eventReasons(recorder))
+}
@@ -0,0 +1,323 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/spidernet-io/egressgateway/pkg/constant"
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/utils/ip"
+)
+
+// EIPPool is the set of EIPs an EgressGateway allocates from. It is built from
+// the EgressIPPool referenced by the gateway, or from the inline spec.ippools
+// of the gateway when no pool is referenced.
+type EIPPool struct {
+	// Name is the name of the EgressIPPool, empty for inline ippools
+	Name string
+
+	ipv4         []net.IP
+	ipv6         []net.IP
+	excluded     map[string]struct{}
+	reservations map[string]egress.EgressIPReservation
+	// conflicted are the IPs found in use by another host, they are not allocated
+	conflicted map[string]struct{}
+	// used maps every allocated IP to the gateway that holds it
+	used     map[string]string
+	gateways []egress.EgressGateway
+}
+
+// GetEIPPool builds the EIPPool of egw. The gateways that share the pool are
+// listed from the cluster, the given egw replaces its stored copy so that
+// allocations not yet written to the status are taken into account.
+func GetEIPPool(ctx context.Context, cli client.Reader, egw *egress.EgressGateway) (*EIPPool, error) {
+	poolName := egw.Spec.Ippools.EgressIPPool
+	if len(poolName) == 0 {
+		spec := egress.EgressIPPoolSpec{IPv4: egw.Spec.Ippools.IPv4, IPv6: egw.Spec.Ippools.IPv6}
+		return NewEIPPool("", spec, []egress.EgressGateway{*egw})
+	}
+
+	pool := new(egress.EgressIPPool)
+	if err := cli.Get(ctx, types.NamespacedName{Name: poolName}, pool); err != nil {
+		return nil, fmt.Errorf("failed to get EgressIPPool %v of EgressGateway %v: %w", poolName, egw.Name, err)
+	}
+
+	gateways, err := ListPoolGateways(ctx, cli, poolName)
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for i := range gateways {
+		if gateways[i].Name == egw.Name {
+			gateways[i] = *egw
+			found = true
+		}
+	}
+	if !found {
+		gateways = append(gateways, *egw)
+	}
+
+	return NewEIPPool(poolName, pool.Spec, gateways)
+}
+
+// ListPoolGateways lists the EgressGateways that reference the EgressIPPool.
+func ListPoolGateways(ctx context.Context, cli client.Reader, poolName string) ([]egress.EgressGateway, error) {
+	egwList := &egress.EgressGatewayList{}
+	if err := cli.List(ctx, egwList); err != nil {
+		return nil, err
+	}
+
+	var res []egress.EgressGateway
+	for _, item := range egwList.Items {
+		if item.Spec.Ippools.EgressIPPool == poolName {
+			res = append(res, item)
+		}
+	}
+	return res, nil
+}
+
+func NewEIPPool(name string, spec egress.EgressIPPoolSpec, gateways []egress.EgressGateway) (*EIPPool, error) {
+	p := &EIPPool{
+		Name:         name,
+		excluded:     make(map[string]struct{}),
+		reservations: make(map[string]egress.EgressIPReservation),
+		used:         make(map[string]string),
+		conflicted:   make(map[string]struct{}),
+		gateways:     gateways,
+	}
+
+	ipv4s, err := ip.ConvertCidrOrIPrangeToIPs(spec.IPv4, constant.IPv4)
+	if err != nil {
+		return nil, err
+	}
+	ipv6s, err := ip.ConvertCidrOrIPrangeToIPs(spec.IPv6, constant.IPv6)
+	if err != nil {
+		return nil, err
+	}
+	excludeIPv4s, err := ip.ConvertCidrOrIPrangeToIPs(spec.ExcludeIPv4, constant.IPv4)
+	if err != nil {
+		return nil, err
+	}
+	excludeIPv6s, err := ip.ConvertCidrOrIPrangeToIPs(spec.ExcludeIPv6, constant.IPv6)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range append(excludeIPv4s, excludeIPv6s...) {
+		p.excluded[item.String()] = struct{}{}
+	}
+	p.ipv4 = ip.IPsDiffSet(ipv4s, excludeIPv4s, true)
+	p.ipv6 = ip.IPsDiffSet(ipv6s, excludeIPv6s, true)
+
+	for _, r := range spec.Reservations {
+		if len(r.IPv4) != 0 {
+			p.reservations[normalizeIP(r.IPv4)] = r
+		}
+		if len(r.IPv6) != 0 {
+			p.reservations[normalizeIP(r.IPv6)] = r
+		}
+	}
+
+	for _, egw := range gateways {
+		// the default EIPs belong to their gateway before any policy uses them
+		p.markUsed(egw.Name, egw.Spec.Ippools.Ipv4DefaultEIP, egw.Spec.Ippools.Ipv6DefaultEIP)
+		for _, node := range egw.Status.NodeList {
+			for _, eip := range node.Eips {
+				p.markUsed(egw.Name, eip.IPv4, eip.IPv6)
+			}
+			for _, eip := range node.StatefulEips {
+				p.markUsed(egw.Name, eip.IPv4, eip.IPv6)
+			}
+		}
+		for _, item := range egw.Status.Conflicts {
+			p.conflicted[normalizeIP(item.IP)] = struct{}{}
+		}
+	}
+
+	return p, nil
+}
+
+func (p *EIPPool) markUsed(egwName string, ips ...string) {
+	for _, item := range ips {
+		if len(item) != 0 {
+			p.used[normalizeIP(item)] = egwName
+		}
+	}
+}
+
+// HasIPv4 reports whether the pool has IPv4 addresses.
+func (p *EIPPool) HasIPv4() bool {
+	return len(p.ipv4) > 0
+}
+
+// HasIPv6 reports whether the pool has IPv6 addresses.
+func (p *EIPPool) HasIPv6() bool {
+	return len(p.ipv6) > 0
+}
+
+// Contains reports whether ipStr belongs to the pool and is not excluded.
+func (p *EIPPool) Contains(ipStr string) bool {
+	target := net.ParseIP(ipStr)
+	if target == nil {
+		return false
+	}
+	ips := p.ipv6
+	if target.To4() != nil {
+		ips = p.ipv4
+	}
+	for _, item := range ips {
+		if item.Equal(target) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckUsable returns an error if the policy of gateway egwName cannot use ipStr,
+// because it is not in the pool, reserved for someone else, or already allocated
+// by another gateway sharing the pool.
+func (p *EIPPool) CheckUsable(egwName, ipStr string, policy egress.Policy) error {
+	if !p.Contains(ipStr) {
+		if _, ok := p.excluded[normalizeIP(ipStr)]; ok {
+			return fmt.Errorf("%v is excluded from the EIP range of EgressGateway %v", ipStr, egwName)
+		}
+		return fmt.Errorf("%v is not within the EIP range of EgressGateway %v", ipStr, egwName)
+	}
+	if r, ok := p.reservations[normalizeIP(ipStr)]; ok && !r.Allows(policy) {
+		return fmt.Errorf("%v of EgressIPPool %v is reserved, policy %v cannot use it", ipStr, p.Name, policy)
+	}
+	if owner, ok := p.used[normalizeIP(ipStr)]; ok && owner != egwName {
+		return fmt.Errorf("%v of EgressIPPool %v is allocated by EgressGateway %v", ipStr, p.Name, owner)
+	}
+	return nil
+}
+
+// Allocate picks a free IP of the given version for the policy. IPs reserved
+// for the policy, or its namespace, are preferred over the unreserved ones,
+// IPs reserved for others are never picked.
+func (p *EIPPool) Allocate(version constant.IPVersion, policy egress.Policy, rander *rand.Rand) (string, error) {
+	ips := p.ipv4
+	if version == constant.IPv6 {
+		ips = p.ipv6
+	}
+
+	var reserved, free []net.IP
+	for _, item := range ips {
+		if !p.isFree(item) {
+			continue
+		}
+		if r, ok := p.reservations[item.String()]; ok {
+			if r.Allows(policy) {
+				reserved = append(reserved, item)
+			}
+			continue
+		}
+		free = append(free, item)
+	}
+
+	if len(reserved) != 0 {
+		return reserved[rander.Intn(len(reserved))].String(), nil
+	}
+	if len(free) != 0 {
+		return free[rander.Intn(len(free))].String(), nil
+	}
+	return "", fmt.Errorf("no free %v EIP in the pool", version)
+}
+
+// Count returns the free and total IPs of the pool, exclusions are not counted.
+// The allocated and the conflicted IPs are not free.
+func (p *EIPPool) Count() (ipv4sFree, ipv6sFree, ipv4sTotal, ipv6sTotal int) {
+	ipv4sTotal, ipv6sTotal = len(p.ipv4), len(p.ipv6)
+	for _, item := range p.ipv4 {
+		if p.isFree(item) {
+			ipv4sFree++
+		}
+	}
+	for _, item := range p.ipv6 {
+		if p.isFree(item) {
+			ipv6sFree++
+		}
+	}
+	return
+}
+
+func (p *EIPPool) isFree(item net.IP) bool {
+	if _, ok := p.used[item.String()]; ok {
+		return false
+	}
+	_, ok := p.conflicted[item.String()]
+	return !ok
+}
+
+// Allocations lists every allocated IP of the pool with its owner, sorted by IP.
+// A default EIP no policy uses yet is listed without node.
+func (p *EIPPool) Allocations() []egress.EgressIPAllocation {
+	var res []egress.EgressIPAllocation
+	for _, egw := range p.gateways {
+		for _, item := range []string{egw.Spec.Ippools.Ipv4DefaultEIP, egw.Spec.Ippools.Ipv6DefaultEIP} {
+			if len(item) != 0 && !statusHasIP(egw.Status, item) {
+				res = append(res, egress.EgressIPAllocation{IP: item, EgressGateway: egw.Name})
+			}
+		}
+		for _, node := range egw.Status.NodeList {
+			for _, eip := range node.Eips {
+				for _, item := range []string{eip.IPv4, eip.IPv6} {
+					if len(item) == 0 {
+						continue
+					}
+					res = append(res, egress.EgressIPAllocation{
+						IP:            item,
+						EgressGateway: egw.Name,
+						Node:          node.Name,
+						Policies:      eip.Policies,
+					})
+				}
+			}
+			for _, eip := range node.StatefulEips {
+				for _, item := range []string{eip.IPv4, eip.IPv6} {
+					if len(item) == 0 {
+						continue
+					}
+					res = append(res, egress.EgressIPAllocation{
+						IP:            item,
+						EgressGateway: egw.Name,
+						Node:          node.Name,
+						Policies:      []egress.Policy{eip.Policy},
+					})
+				}
+			}
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return ip.Cmp(net.ParseIP(res[i].IP), net.ParseIP(res[j].IP)) < 0
+	})
+	return res
+}
+
+func statusHasIP(status egress.EgressGatewayStatus, ipStr string) bool {
+	target := normalizeIP(ipStr)
+	for _, node := range status.NodeList {
+		for _, eip := range node.Eips {
+			if normalizeIP(eip.IPv4) == target || normalizeIP(eip.IPv6) == target {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func normalizeIP(ipStr string) string {
+	if parsed := net.ParseIP(ipStr); parsed != nil {
+		return parsed.String()
+	}
+	return ipStr
+}
@@ -0,0 +1,101 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/spidernet-io/egressgateway/pkg/constant"
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+func TestEIPPool(t *testing.T) {
+	spec := egress.EgressIPPoolSpec{
+		IPv4:        []string{"10.6.1.20-10.6.1.24"},
+		ExcludeIPv4: []string{"10.6.1.20"},
+		Reservations: []egress.EgressIPReservation{
+			{IPv4: "10.6.1.21", Namespace: "ns1"},
+			{IPv4: "10.6.1.22", Policy: &egress.Policy{Name: "p2", Namespace: "ns2"}},
+		},
+	}
+	gateways := []egress.EgressGateway{
+		{
+			ObjectMeta: v1.ObjectMeta{Name: "egw1"},
+			Status: egress.EgressGatewayStatus{NodeList: []egress.EgressIPStatus{
+				{Name: "node1", Eips: []egress.Eips{{IPv4: "10.6.1.23", Policies: policies("p1")}}},
+			}},
+		},
+		{ObjectMeta: v1.ObjectMeta{Name: "egw2"}},
+	}
+
+	pool, err := NewEIPPool("pool", spec, gateways)
+	assert.NoError(t, err)
+
+	ipv4Free, ipv6Free, ipv4Total, ipv6Total := pool.Count()
+	assert.Equal(t, 3, ipv4Free)
+	assert.Equal(t, 4, ipv4Total)
+	assert.Equal(t, 0, ipv6Free)
+	assert.Equal(t, 0, ipv6Total)
+
+	assert.False(t, pool.Contains("10.6.1.20"))
+	assert.Error(t, pool.CheckUsable("egw2", "10.6.1.20", egress.Policy{Name: "p1", Namespace: "ns1"}))
+	assert.NoError(t, pool.CheckUsable("egw2", "10.6.1.21", egress.Policy{Name: "p1", Namespace: "ns1"}))
+	assert.Error(t, pool.CheckUsable("egw2", "10.6.1.21", egress.Policy{Name: "p1", Namespace: "ns2"}))
+	assert.Error(t, pool.CheckUsable("egw2", "10.6.1.22", egress.Policy{Name: "p1", Namespace: "ns2"}))
+	assert.NoError(t, pool.CheckUsable("egw2", "10.6.1.22", egress.Policy{Name: "p2", Namespace: "ns2"}))
+	// allocated by the other gateway sharing the pool
+	assert.Error(t, pool.CheckUsable("egw2", "10.6.1.23", egress.Policy{Name: "p3", Namespace: "ns3"}))
+	assert.NoError(t, pool.CheckUsable("egw1", "10.6.1.23", egress.Policy{Name: "p3", Namespace: "ns3"}))
+
+	rander := rand.New(rand.NewSource(1))
+	eip, err := pool.Allocate(constant.IPv4, egress.Policy{Name: "p1", Namespace: "ns1"}, rander)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.6.1.21", eip)
+
+	eip, err = pool.Allocate(constant.IPv4, egress.Policy{Name: "p3", Namespace: "ns3"}, rander)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.6.1.24", eip)
+
+	_, err = pool.Allocate(constant.IPv6, egress.Policy{Name: "p3", Namespace: "ns3"}, rander)
+	assert.Error(t, err)
+
+	assert.Equal(t, []egress.EgressIPAllocation{
+		{IP: "10.6.1.23", EgressGateway: "egw1", Node: "node1", Policies: policies("p1")},
+	}, pool.Allocations())
+}
+
+func TestEIPPoolDefaultEIP(t *testing.T) {
+	spec := egress.EgressIPPoolSpec{IPv4: []string{"10.6.1.20-10.6.1.22"}}
+	gateways := []egress.EgressGateway{
+		{
+			ObjectMeta: v1.ObjectMeta{Name: "egw1"},
+			Spec:       egress.EgressGatewaySpec{Ippools: egress.Ippools{Ipv4DefaultEIP: "10.6.1.20"}},
+			Status:     egress.EgressGatewayStatus{Conflicts: []egress.EIPConflict{{IP: "10.6.1.21"}}},
+		},
+		{ObjectMeta: v1.ObjectMeta{Name: "egw2"}},
+	}
+
+	pool, err := NewEIPPool("pool", spec, gateways)
+	assert.NoError(t, err)
+
+	// the default EIP of egw1 and the conflicted IP are not free
+	ipv4Free, _, ipv4Total, _ := pool.Count()
+	assert.Equal(t, 1, ipv4Free)
+	assert.Equal(t, 3, ipv4Total)
+	assert.Error(t, pool.CheckUsable("egw2", "10.6.1.20", egress.Policy{}))
+	assert.NoError(t, pool.CheckUsable("egw1", "10.6.1.20", egress.Policy{}))
+
+	// the default EIP of egw2 is allocated against the pool-wide allocations
+	eip, err := pool.Allocate(constant.IPv4, egress.Policy{}, rand.New(rand.NewSource(1)))
+	assert.NoError(t, err)
+	assert.Equal(t, "10.6.1.22", eip)
+
+	assert.Equal(t, []egress.EgressIPAllocation{
+		{IP: "10.6.1.20", EgressGateway: "egw1"},
+	}, pool.Allocations())
+}
@@ -0,0 +1,95 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"context"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// NamespaceUsage is what the EgressPolicies of a namespace consume on a gateway.
+type NamespaceUsage struct {
+	// EIPs is keyed by "ipv4/ipv6", a dual stack EIP counts once
+	EIPs     map[string]struct{}
+	Policies map[string]struct{}
+}
+
+// HasEIP reports whether the namespace already uses the EIP with the given IPv4 or IPv6.
+func (u NamespaceUsage) HasEIP(ipv4, ipv6 string, egw *egress.EgressGateway) bool {
+	for _, node := range egw.Status.NodeList {
+		for _, eip := range node.Eips {
+			if (len(ipv4) != 0 && eip.IPv4 == ipv4) || (len(ipv6) != 0 && eip.IPv6 == ipv6) {
+				if _, ok := u.EIPs[eipKey(eip)]; ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// GetNamespaceUsage counts the policies of the namespace that reference egw,
+// and the distinct EIPs allocated to them.
+func GetNamespaceUsage(ctx context.Context, cli client.Reader, namespace string, egw *egress.EgressGateway) (NamespaceUsage, error) {
+	usage := NamespaceUsage{
+		EIPs:     make(map[string]struct{}),
+		Policies: make(map[string]struct{}),
+	}
+
+	egpList := &egress.EgressPolicyList{}
+	if err := cli.List(ctx, egpList, client.InNamespace(namespace)); err != nil {
+		return usage, err
+	}
+	for _, item := range egpList.Items {
+		if item.Spec.EgressGatewayName == egw.Name {
+			usage.Policies[item.Name] = struct{}{}
+		}
+	}
+
+	for _, node := range egw.Status.NodeList {
+		for _, eip := range node.Eips {
+			if len(eip.IPv4) == 0 && len(eip.IPv6) == 0 {
+				continue
+			}
+			for _, p := range eip.Policies {
+				if p.Namespace == namespace {
+					usage.EIPs[eipKey(eip)] = struct{}{}
+					break
+				}
+			}
+		}
+		for _, eip := range node.StatefulEips {
+			if eip.Policy.Namespace == namespace {
+				usage.EIPs[eipKey(egress.Eips{IPv4: eip.IPv4, IPv6: eip.IPv6})] = struct{}{}
+			}
+		}
+	}
+
+	return usage, nil
+}
+
+// NamespaceEIPLimit returns the lowest maxEIPs of the EgressIPQuotas of the namespace that
+// apply to the gateway, and the name of that quota. ok is false when no quota limits the EIPs.
+func NamespaceEIPLimit(ctx context.Context, cli client.Reader, namespace, egwName string) (limit int, quota string, ok bool, err error) {
+	quotaList := &egress.EgressIPQuotaList{}
+	if err := cli.List(ctx, quotaList, client.InNamespace(namespace)); err != nil {
+		return 0, "", false, err
+	}
+	for _, item := range quotaList.Items {
+		if !item.Spec.AppliesTo(egwName) || item.Spec.MaxEIPs == nil {
+			continue
+		}
+		if !ok || *item.Spec.MaxEIPs < limit {
+			limit, quota, ok = *item.Spec.MaxEIPs, item.Name, true
+		}
+	}
+	return limit, quota, ok, nil
+}
+
+func eipKey(eip egress.Eips) string {
+	return eip.IPv4 + "/" + eip.IPv6
+}
@@ -0,0 +1,293 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	"github.com/spidernet-io/egressgateway/pkg/config"
+	"github.com/spidernet-io/egressgateway/pkg/controller/metrics"
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+const ReasonEIPRebalanced = "EIPRebalanced"
+
+// eipMove describes moving one EIP, together with all the policies that use it,
+// from one gateway node to another.
+type eipMove struct {
+	eip  egress.Eips
+	from string
+	to   string
+}
+
+// rebalancer periodically redistributes the EIPs of each EgressGateway across its
+// ready gateway nodes, so that policies do not stay piled on the nodes that
+// survived a failover.
+type rebalancer struct {
+	client   client.Client
+	log      logr.Logger
+	recorder record.EventRecorder
+	interval time.Duration
+	maxMoves int
+}
+
+func (r *rebalancer) Start(ctx context.Context) error {
+	r.log.Info("start eip rebalancer", "interval", r.interval, "maxMovesPerInterval", r.maxMoves)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := r.rebalance(ctx); err != nil {
+				r.log.Error(err, "rebalance egress gateways")
+			}
+		}
+	}
+}
+
+func (r *rebalancer) rebalance(ctx context.Context) error {
+	pinned, err := r.listPinnedPolicies(ctx)
+	if err != nil {
+		return err
+	}
+	isPinned := func(policy egress.Policy) bool {
+		_, ok := pinned[policy]
+		return ok
+	}
+
+	egwList := &egress.EgressGatewayList{}
+	if err := r.client.List(ctx, egwList); err != nil {
+		return err
+	}
+
+	for _, item := range egwList.Items {
+		if !item.GetDeletionTimestamp().IsZero() {
+			continue
+		}
+		egw := item.DeepCopy()
+		// the EIPs of stateful pods stay with their policy, so such policies are not moved
+		sticky := make(map[egress.Policy]struct{})
+		for _, node := range egw.Status.NodeList {
+			for _, eip := range node.StatefulEips {
+				sticky[eip.Policy] = struct{}{}
+			}
+		}
+		moves := planRebalance(egw.Status.NodeList, func(policy egress.Policy) bool {
+			_, ok := sticky[policy]
+			return ok || isPinned(policy)
+		}, r.maxMoves)
+		if len(moves) == 0 {
+			continue
+		}
+
+		egw.Status.NodeList = applyRebalance(egw.Status.NodeList, moves)
+		r.log.V(1).Info("update egress gateway status", "status", egw.Status)
+		// a conflict means the reconciler changed the gateway in the meantime,
+		// the next interval works on the new state
+		if err := r.client.Status().Update(ctx, egw); err != nil {
+			r.log.Error(err, "update egress gateway status", "egressGateway", egw.Name)
+			continue
+		}
+
+		for _, move := range moves {
+			r.log.Info("rebalanced eip", "egressGateway", egw.Name,
+				"ipv4", move.eip.IPv4, "ipv6", move.eip.IPv6,
+				"from", move.from, "to", move.to, "policies", move.eip.Policies)
+			r.recordMove(ctx, egw, move)
+		}
+		// the EIPs moved to a new node, its agent sends the takeover burst once it
+		// has seen the new status
+		if err := requestReannounce(ctx, r.client, egw); err != nil {
+			r.log.Error(err, "failed to request the re-announcement of the EIPs", "egressGateway", egw.Name)
+		}
+	}
+
+	return nil
+}
+
+// recordMove emits the Events and counts the reallocations of a move written to the status.
+func (r *rebalancer) recordMove(ctx context.Context, egw *egress.EgressGateway, move eipMove) {
+	msg := fmt.Sprintf("EIP (ipv4=%q, ipv6=%q) moved from node %s to node %s by rebalancer",
+		move.eip.IPv4, move.eip.IPv6, move.from, move.to)
+	r.recorder.Event(egw, corev1.EventTypeNormal, ReasonEIPRebalanced, msg)
+
+	for _, p := range move.eip.Policies {
+		metrics.ObserveReallocation(egw.Name, p, metrics.ReallocationMoved)
+
+		var obj client.Object
+		if len(p.Namespace) == 0 {
+			obj = &egress.EgressClusterPolicy{}
+		} else {
+			obj = &egress.EgressPolicy{}
+		}
+		err := r.client.Get(ctx, types.NamespacedName{Namespace: p.Namespace, Name: p.Name}, obj)
+		if err != nil {
+			r.log.Error(err, "get policy to record rebalance event", "policy", p)
+			continue
+		}
+		r.recorder.Event(obj, corev1.EventTypeNormal, ReasonEIPRebalanced, msg)
+	}
+}
+
+func (r *rebalancer) listPinnedPolicies(ctx context.Context) (map[egress.Policy]struct{}, error) {
+	pinned := make(map[egress.Policy]struct{})
+
+	egpList := &egress.EgressPolicyList{}
+	if err := r.client.List(ctx, egpList); err != nil {
+		return nil, err
+	}
+	for _, item := range egpList.Items {
+		if item.Annotations[egress.AnnotationPolicyPinned] == "true" {
+			pinned[egress.Policy{Name: item.Name, Namespace: item.Namespace}] = struct{}{}
+		}
+	}
+
+	egcpList := &egress.EgressClusterPolicyList{}
+	if err := r.client.List(ctx, egcpList); err != nil {
+		return nil, err
+	}
+	for _, item := range egcpList.Items {
+		if item.Annotations[egress.AnnotationPolicyPinned] == "true" {
+			pinned[egress.Policy{Name: item.Name}] = struct{}{}
+		}
+	}
+
+	return pinned, nil
+}
+
+// planRebalance returns at most maxMoves EIP moves that reduce the difference
+// between the most and the least loaded ready nodes. The load of a node is the
+// number of policies on it, which is also what allocatorNode balances on. EIPs
+// that carry a pinned policy, and node IP policies, are never moved.
+func planRebalance(nodeList []egress.EgressIPStatus, isPinned func(egress.Policy) bool, maxMoves int) []eipMove {
+	var moves []eipMove
+
+	current := applyRebalance(nodeList, nil)
+	for len(moves) < maxMoves {
+		var ready []egress.EgressIPStatus
+		for _, node := range current {
+			if node.Status == string(egress.EgressTunnelReady) {
+				ready = append(ready, node)
+			}
+		}
+		if len(ready) < 2 {
+			break
+		}
+
+		sort.Slice(ready, func(i, j int) bool {
+			li, lj := nodePolicyNum(ready[i]), nodePolicyNum(ready[j])
+			if li != lj {
+				return li > lj
+			}
+			return ready[i].Name < ready[j].Name
+		})
+		busiest, idlest := ready[0], ready[len(ready)-1]
+		diff := nodePolicyNum(busiest) - nodePolicyNum(idlest)
+		if diff <= 1 {
+			break
+		}
+
+		// Moving an EIP that carries n policies only helps while n < diff, and
+		// the closer n is to diff/2, the more even the two nodes become.
+		best := -1
+		for i, eip := range busiest.Eips {
+			n := len(eip.Policies)
+			if n == 0 || n >= diff || !movable(eip, isPinned) {
+				continue
+			}
+			if best == -1 || abs(2*n-diff) < abs(2*len(busiest.Eips[best].Policies)-diff) {
+				best = i
+			}
+		}
+		if best == -1 {
+			break
+		}
+
+		move := eipMove{eip: busiest.Eips[best], from: busiest.Name, to: idlest.Name}
+		moves = append(moves, move)
+		current = applyRebalance(current, []eipMove{move})
+	}
+
+	return moves
+}
+
+// applyRebalance returns a copy of nodeList with the moves applied.
+func applyRebalance(nodeList []egress.EgressIPStatus, moves []eipMove) []egress.EgressIPStatus {
+	res := make([]egress.EgressIPStatus, 0, len(nodeList))
+	for _, node := range nodeList {
+		res = append(res, *node.DeepCopy())
+	}
+
+	for _, move := range moves {
+		for i := range res {
+			switch res[i].Name {
+			case move.from:
+				var eips []egress.Eips
+				for _, eip := range res[i].Eips {
+					if eip.IPv4 == move.eip.IPv4 && eip.IPv6 == move.eip.IPv6 {
+						continue
+					}
+					eips = append(eips, eip)
+				}
+				res[i].Eips = eips
+			case move.to:
+				res[i].Eips = append(res[i].Eips, *move.eip.DeepCopy())
+			}
+		}
+	}
+
+	return res
+}
+
+func movable(eip egress.Eips, isPinned func(egress.Policy) bool) bool {
+	// the policies use the node IP, there is no EIP to move
+	if len(eip.IPv4) == 0 && len(eip.IPv6) == 0 {
+		return false
+	}
+	for _, p := range eip.Policies {
+		if isPinned(p) {
+			return false
+		}
+	}
+	return true
+}
+
+func nodePolicyNum(node egress.EgressIPStatus) int {
+	num := 0
+	for _, eip := range node.Eips {
+		num += len(eip.Policies)
+	}
+	return num
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func newRebalancer(mgr manager.Manager, log logr.Logger, cfg *config.Config) error {
+	r := &rebalancer{
+		client:   mgr.GetClient(),
+		log:      log.WithName("rebalancer"),
+		recorder: mgr.GetEventRecorderFor("egress-gateway"),
+		interval: time.Duration(cfg.FileConfig.EIPRebalance.Interval) * time.Second,
+		maxMoves: cfg.FileConfig.EIPRebalance.MaxMovesPerInterval,
+	}
+	return mgr.Add(r)
+}
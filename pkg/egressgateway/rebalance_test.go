@@ -0,0 +1,179 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func policies(names ...string) []egress.Policy {
+	res := make([]egress.Policy, 0, len(names))
+	for _, name := range names {
+		res = append(res, egress.Policy{Name: name, Namespace: "default"})
+	}
+	return res
+}
+
+func TestPlanRebalance(t *testing.T) {
+	ready := string(egress.EgressTunnelReady)
+	notPinned := func(egress.Policy) bool { return false }
+
+	cases := map[string]struct {
+		nodeList []egress.EgressIPStatus
+		isPinned func(egress.Policy) bool
+		maxMoves int
+		expMoves []eipMove
+	}{
+		"balanced": {
+			nodeList: []egress.EgressIPStatus{
+				{Name: "node1", Status: ready, Eips: []egress.Eips{{IPv4: "10.6.1.21", Policies: policies("p1")}}},
+				{Name: "node2", Status: ready, Eips: []egress.Eips{{IPv4: "10.6.1.22", Policies: policies("p2")}}},
+			},
+			isPinned: notPinned,
+			maxMoves: 1,
+		},
+		"move to recovered node": {
+			nodeList: []egress.EgressIPStatus{
+				{Name: "node1", Status: ready, Eips: []egress.Eips{
+					{IPv4: "10.6.1.21", Policies: policies("p1")},
+					{IPv4: "10.6.1.22", Policies: policies("p2")},
+					{IPv4: "10.6.1.23", Policies: policies("p3")},
+				}},
+				{Name: "node2", Status: ready},
+			},
+			isPinned: notPinned,
+			maxMoves: 1,
+			expMoves: []eipMove{
+				{eip: egress.Eips{IPv4: "10.6.1.21", Policies: policies("p1")}, from: "node1", to: "node2"},
+			},
+		},
+		"bounded by max moves": {
+			nodeList: []egress.EgressIPStatus{
+				{Name: "node1", Status: ready, Eips: []egress.Eips{
+					{IPv4: "10.6.1.21", Policies: policies("p1")},
+					{IPv4: "10.6.1.22", Policies: policies("p2")},
+					{IPv4: "10.6.1.23", Policies: policies("p3")},
+					{IPv4: "10.6.1.24", Policies: policies("p4")},
+				}},
+				{Name: "node2", Status: ready},
+			},
+			isPinned: notPinned,
+			maxMoves: 5,
+			expMoves: []eipMove{
+				{eip: egress.Eips{IPv4: "10.6.1.21", Policies: policies("p1")}, from: "node1", to: "node2"},
+				{eip: egress.Eips{IPv4: "10.6.1.22", Policies: policies("p2")}, from: "node1", to: "node2"},
+			},
+		},
+		"skip pinned and node ip": {
+			nodeList: []egress.EgressIPStatus{
+				{Name: "node1", Status: ready, Eips: []egress.Eips{
+					{IPv4: "10.6.1.21", Policies: policies("p1")},
+					{Policies: policies("p2")},
+					{IPv4: "10.6.1.23", Policies: policies("p3")},
+				}},
+				{Name: "node2", Status: ready},
+			},
+			isPinned: func(p egress.Policy) bool { return p.Name == "p1" },
+			maxMoves: 1,
+			expMoves: []eipMove{
+				{eip: egress.Eips{IPv4: "10.6.1.23", Policies: policies("p3")}, from: "node1", to: "node2"},
+			},
+		},
+		"ignore not ready node": {
+			nodeList: []egress.EgressIPStatus{
+				{Name: "node1", Status: ready, Eips: []egress.Eips{
+					{IPv4: "10.6.1.21", Policies: policies("p1")},
+					{IPv4: "10.6.1.22", Policies: policies("p2")},
+				}},
+				{Name: "node2", Status: string(egress.EgressTunnelHeartbeatTimeout)},
+			},
+			isPinned: notPinned,
+			maxMoves: 1,
+		},
+		"shared eip too large to move": {
+			nodeList: []egress.EgressIPStatus{
+				{Name: "node1", Status: ready, Eips: []egress.Eips{
+					{IPv4: "10.6.1.21", Policies: policies("p1", "p2")},
+				}},
+				{Name: "node2", Status: ready},
+			},
+			isPinned: notPinned,
+			maxMoves: 1,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			moves := planRebalance(c.nodeList, c.isPinned, c.maxMoves)
+			assert.Equal(t, c.expMoves, moves)
+		})
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	ready := string(egress.EgressTunnelReady)
+	egw := &egress.EgressGateway{
+		ObjectMeta: v1.ObjectMeta{Name: "egw1"},
+		Status: egress.EgressGatewayStatus{
+			NodeList: []egress.EgressIPStatus{
+				{Name: "node1", Status: ready, Eips: []egress.Eips{
+					{IPv4: "10.6.1.21", Policies: policies("p1")},
+					{IPv4: "10.6.1.22", Policies: policies("p2")},
+				}},
+				{Name: "node2", Status: ready},
+			},
+		},
+	}
+	initialObjects := []client.Object{
+		egw,
+		&egress.EgressPolicy{ObjectMeta: v1.ObjectMeta{Name: "p1", Namespace: "default",
+			Annotations: map[string]string{egress.AnnotationPolicyPinned: "true"}}},
+		&egress.EgressPolicy{ObjectMeta: v1.ObjectMeta{Name: "p2", Namespace: "default"}},
+	}
+
+	builder := fake.NewClientBuilder()
+	builder.WithScheme(schema.GetScheme())
+	builder.WithObjects(initialObjects...)
+	builder.WithStatusSubresource(initialObjects...)
+	cli := builder.Build()
+
+	recorder := record.NewFakeRecorder(10)
+	r := &rebalancer{
+		client:   cli,
+		log:      logger.NewLogger(logger.Config{}),
+		recorder: recorder,
+		maxMoves: 1,
+	}
+
+	err := r.rebalance(context.Background())
+	assert.NoError(t, err)
+
+	res := &egress.EgressGateway{}
+	err = cli.Get(context.Background(), types.NamespacedName{Name: "egw1"}, res)
+	assert.NoError(t, err)
+
+	for _, node := range res.Status.NodeList {
+		assert.Len(t, node.Eips, 1)
+		if node.Name == "node2" {
+			assert.Equal(t, "10.6.1.22", node.Eips[0].IPv4)
+		}
+	}
+	// the agents re-announce the moved EIPs
+	assert.NotEmpty(t, res.Annotations[egress.AnnotationReannounce])
+
+	// one event on the gateway and one on the moved policy
+	assert.Len(t, recorder.Events, 2)
+}
@@ -0,0 +1,288 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"context"
+	"math/rand"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/spidernet-io/egressgateway/pkg/constant"
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// syncStatefulEips places the EIPs of the StatefulSet pods selected by a sticky policy on
+// nodeName, the gateway node of the policy. A pod keeps the EIP found in the gateway status,
+// or else in the policy status, so the mapping survives pod rescheduling and the failover of
+// the gateway node. A pod gets no EIP of its own when the EgressIPQuota of the namespace is
+// reached, it uses the EIP of the policy. It reports whether nodeMap has been changed.
+func (r egnReconciler) syncStatefulEips(ctx context.Context, log logr.Logger, egp *egress.EgressPolicy,
+	egw *egress.EgressGateway, nodeName string, nodeMap map[string]egress.EgressIPStatus) (bool, error) {
+	policy := egress.Policy{Name: egp.Name, Namespace: egp.Namespace}
+
+	// take the EIPs of the policy out of every node, they are put back on nodeName
+	var old []egress.StatefulEip
+	moved := false
+	known := make(map[string]egress.PodEip)
+	for name, node := range nodeMap {
+		var others []egress.StatefulEip
+		for _, item := range node.StatefulEips {
+			if item.Policy == policy {
+				old = append(old, item)
+				known[item.Pod] = item.PodEip
+				moved = moved || name != nodeName
+			} else {
+				others = append(others, item)
+			}
+		}
+		node.StatefulEips = others
+		nodeMap[name] = node
+	}
+	for _, item := range egp.Status.PodEips {
+		if _, ok := known[item.Pod]; !ok {
+			known[item.Pod] = item
+		}
+	}
+
+	node, ok := nodeMap[nodeName]
+	if !ok {
+		return len(old) != 0, nil
+	}
+
+	pods, err := listStatefulPods(ctx, r.client, egp)
+	if err != nil {
+		return false, err
+	}
+	desired := make(map[string]struct{})
+	for _, pod := range pods {
+		desired[pod] = struct{}{}
+	}
+	for pod := range known {
+		if _, ok := desired[pod]; ok {
+			continue
+		}
+		// the pod may be recreated, release its EIP only when the ordinal is scaled down
+		exist, err := statefulPodExpected(ctx, r.client, egp.Namespace, pod)
+		if err != nil {
+			return false, err
+		}
+		if exist {
+			desired[pod] = struct{}{}
+		}
+	}
+
+	perEgw := egw.DeepCopy()
+	perEgw.Status.NodeList = nil
+	for _, item := range nodeMap {
+		perEgw.Status.NodeList = append(perEgw.Status.NodeList, item)
+	}
+	pool, err := GetEIPPool(ctx, r.client, perEgw)
+	if err != nil {
+		return false, err
+	}
+
+	podList := make([]string, 0, len(desired))
+	for pod := range desired {
+		podList = append(podList, pod)
+	}
+	sort.Strings(podList)
+
+	res := make(map[string]egress.PodEip)
+	// keep the known EIPs before allocating, so that they are not given to a new pod
+	for _, pod := range podList {
+		item, ok := known[pod]
+		if !ok {
+			continue
+		}
+		if err := checkPodEipUsable(pool, egw.Name, item, policy); err != nil {
+			log.Info("the EIP of the stateful pod is not usable, allocate a new one", "pod", pod, "reason", err.Error())
+			continue
+		}
+		pool.markUsed(egw.Name, item.IPv4, item.IPv6)
+		res[pod] = item
+	}
+
+	// every EIP of a pod counts against the EgressIPQuota of the namespace
+	limit, quota, limited, err := NamespaceEIPLimit(ctx, r.client, egp.Namespace, egw.Name)
+	if err != nil {
+		return false, err
+	}
+	usage, err := GetNamespaceUsage(ctx, r.client, egp.Namespace, perEgw)
+	if err != nil {
+		return false, err
+	}
+	for _, item := range res {
+		usage.EIPs[eipKey(egress.Eips{IPv4: item.IPv4, IPv6: item.IPv6})] = struct{}{}
+	}
+
+	var overQuota []string
+	rander := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for _, pod := range podList {
+		if _, ok := res[pod]; ok {
+			continue
+		}
+		if limited && len(usage.EIPs) >= limit {
+			overQuota = append(overQuota, pod)
+			continue
+		}
+		item := egress.PodEip{Pod: pod}
+		if pool.HasIPv4() {
+			if item.IPv4, err = pool.Allocate(constant.IPv4, policy, rander); err != nil {
+				return false, noFreeEIPError{version: "IPV4", policy: policy, egw: egw.Name}
+			}
+		}
+		if pool.HasIPv6() {
+			if item.IPv6, err = pool.Allocate(constant.IPv6, policy, rander); err != nil {
+				return false, noFreeEIPError{version: "IPV6", policy: policy, egw: egw.Name}
+			}
+		}
+		pool.markUsed(egw.Name, item.IPv4, item.IPv6)
+		usage.EIPs[eipKey(egress.Eips{IPv4: item.IPv4, IPv6: item.IPv6})] = struct{}{}
+		log.Info("allocate EIP for stateful pod", "policy", policy, "pod", pod, "ipv4", item.IPv4, "ipv6", item.IPv6)
+		res[pod] = item
+	}
+	if len(overQuota) != 0 {
+		log.Info("EgressIPQuota exceeded, stateful pods use the EIP of the policy", "policy", policy,
+			"quota", quota, "maxEIPs", limit, "pods", overQuota)
+		r.recorder.Eventf(egp, corev1.EventTypeWarning, ReasonEIPQuotaExceeded,
+			"EgressIPQuota %s allows %d EIPs on EgressGateway %s, stateful pods %s use the EIP of the policy",
+			quota, limit, egw.Name, strings.Join(overQuota, ", "))
+	}
+
+	var cur []egress.StatefulEip
+	for _, pod := range podList {
+		if _, ok := res[pod]; ok {
+			cur = append(cur, egress.StatefulEip{PodEip: res[pod], Policy: policy})
+		}
+	}
+	node.StatefulEips = append(node.StatefulEips, cur...)
+	nodeMap[nodeName] = node
+
+	sort.Slice(old, func(i, j int) bool {
+		return old[i].Pod < old[j].Pod
+	})
+	return moved || !reflect.DeepEqual(old, cur), nil
+}
+
+func checkPodEipUsable(pool *EIPPool, egwName string, item egress.PodEip, policy egress.Policy) error {
+	if len(item.IPv4) == 0 && pool.HasIPv4() {
+		return noFreeEIPError{version: "IPV4", policy: policy, egw: egwName}
+	}
+	if len(item.IPv6) == 0 && pool.HasIPv6() {
+		return noFreeEIPError{version: "IPV6", policy: policy, egw: egwName}
+	}
+	for _, ip := range []string{item.IPv4, item.IPv6} {
+		if len(ip) == 0 {
+			continue
+		}
+		if err := pool.CheckUsable(egwName, ip, policy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listStatefulPods lists the pods selected by the policy that belong to a StatefulSet,
+// the name of such a pod is its stable identity.
+func listStatefulPods(ctx context.Context, cli client.Reader, egp *egress.EgressPolicy) ([]string, error) {
+	if egp.Spec.AppliedTo.PodSelector == nil {
+		return nil, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(egp.Spec.AppliedTo.PodSelector)
+	if err != nil {
+		return nil, err
+	}
+	podList := &corev1.PodList{}
+	err = cli.List(ctx, podList, client.InNamespace(egp.Namespace), client.MatchingLabelsSelector{Selector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	var res []string
+	for _, pod := range podList.Items {
+		owner := metav1.GetControllerOf(&pod)
+		if owner == nil || owner.Kind != "StatefulSet" {
+			continue
+		}
+		if _, _, ok := parseStatefulPod(pod.Name); ok && strings.HasPrefix(pod.Name, owner.Name+"-") {
+			res = append(res, pod.Name)
+		}
+	}
+	return res, nil
+}
+
+// statefulPodExpected reports whether the StatefulSet of the pod still wants a pod with its ordinal.
+func statefulPodExpected(ctx context.Context, cli client.Reader, namespace, pod string) (bool, error) {
+	name, ordinal, ok := parseStatefulPod(pod)
+	if !ok {
+		return false, nil
+	}
+	sts := new(appsv1.StatefulSet)
+	err := cli.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, sts)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	start, replicas := 0, 1
+	if sts.Spec.Ordinals != nil {
+		start = int(sts.Spec.Ordinals.Start)
+	}
+	if sts.Spec.Replicas != nil {
+		replicas = int(*sts.Spec.Replicas)
+	}
+	return ordinal >= start && ordinal < start+replicas, nil
+}
+
+// parseStatefulPod splits the name of a StatefulSet pod into the StatefulSet name and the ordinal.
+func parseStatefulPod(pod string) (string, int, bool) {
+	i := strings.LastIndex(pod, "-")
+	if i <= 0 {
+		return "", 0, false
+	}
+	ordinal, err := strconv.Atoi(pod[i+1:])
+	if err != nil || ordinal < 0 {
+		return "", 0, false
+	}
+	return pod[:i], ordinal, true
+}
+
+// enqueueEndpointSlicePolicy maps an EgressEndpointSlice to the EgressPolicy it belongs to,
+// only the sticky policies have EIPs following their pods.
+func enqueueEndpointSlicePolicy(cli client.Reader) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []reconcile.Request {
+		policyName, ok := obj.GetLabels()[egress.LabelPolicyName]
+		if !ok {
+			return nil
+		}
+		egp := new(egress.EgressPolicy)
+		if err := cli.Get(ctx, types.NamespacedName{Namespace: obj.GetNamespace(), Name: policyName}, egp); err != nil {
+			return nil
+		}
+		if egp.Spec.EgressIP.AllocatorPolicy != egress.EipAllocatorSticky {
+			return nil
+		}
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{
+			Namespace: path.Join("EgressPolicy", obj.GetNamespace()),
+			Name:      policyName,
+		}}}
+	}
+}
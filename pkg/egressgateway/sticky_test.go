@@ -0,0 +1,260 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package egressgateway
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	egress "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+	"github.com/spidernet-io/egressgateway/pkg/schema"
+)
+
+func statefulPod(name, sts string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: v1.ObjectMeta{
+		Name:      name,
+		Namespace: "default",
+		Labels:    map[string]string{"app": "db"},
+		OwnerReferences: []v1.OwnerReference{{
+			APIVersion: "apps/v1",
+			Kind:       "StatefulSet",
+			Name:       sts,
+			UID:        "uid",
+			Controller: pointer.Bool(true),
+		}},
+	}}
+}
+
+func TestSyncStatefulEips(t *testing.T) {
+	ready := string(egress.EgressTunnelReady)
+	policy := egress.Policy{Name: "p1", Namespace: "default"}
+	egw := &egress.EgressGateway{
+		ObjectMeta: v1.ObjectMeta{Name: "egw1"},
+		Spec: egress.EgressGatewaySpec{
+			Ippools: egress.Ippools{IPv4: []string{"10.6.1.20-10.6.1.25"}},
+		},
+	}
+	egp := &egress.EgressPolicy{
+		ObjectMeta: v1.ObjectMeta{Name: "p1", Namespace: "default"},
+		Spec: egress.EgressPolicySpec{
+			EgressGatewayName: "egw1",
+			EgressIP:          egress.EgressIP{AllocatorPolicy: egress.EipAllocatorSticky},
+			AppliedTo: egress.AppliedTo{
+				PodSelector: &v1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			},
+		},
+	}
+	deployPod := &corev1.Pod{ObjectMeta: v1.ObjectMeta{
+		Name: "api-7d9f-2", Namespace: "default", Labels: map[string]string{"app": "db"},
+	}}
+
+	cases := map[string]struct {
+		objs     []client.Object
+		podEips  []egress.PodEip
+		nodeMap  map[string]egress.EgressIPStatus
+		nodeName string
+		expPods  []string
+		expKept  map[string]string
+	}{
+		"allocate for new pods": {
+			objs: []client.Object{statefulPod("db-0", "db"), statefulPod("db-1", "db"), deployPod},
+			nodeMap: map[string]egress.EgressIPStatus{
+				"node1": {Name: "node1", Status: ready, Eips: []egress.Eips{{IPv4: "10.6.1.20", Policies: []egress.Policy{policy}}}},
+			},
+			nodeName: "node1",
+			expPods:  []string{"db-0", "db-1"},
+		},
+		"follow the policy to the new node": {
+			objs: []client.Object{statefulPod("db-0", "db")},
+			nodeMap: map[string]egress.EgressIPStatus{
+				"node1": {Name: "node1", Status: string(egress.EgressTunnelNodeNotReady), StatefulEips: []egress.StatefulEip{
+					{PodEip: egress.PodEip{Pod: "db-0", IPv4: "10.6.1.23"}, Policy: policy},
+				}},
+				"node2": {Name: "node2", Status: ready, Eips: []egress.Eips{{IPv4: "10.6.1.20", Policies: []egress.Policy{policy}}}},
+			},
+			nodeName: "node2",
+			expPods:  []string{"db-0"},
+			expKept:  map[string]string{"db-0": "10.6.1.23"},
+		},
+		"restore from the policy status": {
+			objs: []client.Object{statefulPod("db-0", "db"),
+				&appsv1.StatefulSet{ObjectMeta: v1.ObjectMeta{Name: "db", Namespace: "default"},
+					Spec: appsv1.StatefulSetSpec{Replicas: pointer.Int32(2)}}},
+			podEips: []egress.PodEip{{Pod: "db-0", IPv4: "10.6.1.24"}, {Pod: "db-1", IPv4: "10.6.1.25"}},
+			nodeMap: map[string]egress.EgressIPStatus{
+				"node2": {Name: "node2", Status: ready, Eips: []egress.Eips{{IPv4: "10.6.1.20", Policies: []egress.Policy{policy}}}},
+			},
+			nodeName: "node2",
+			// db-1 is being recreated, it keeps its EIP
+			expPods: []string{"db-0", "db-1"},
+			expKept: map[string]string{"db-0": "10.6.1.24", "db-1": "10.6.1.25"},
+		},
+		"release scaled down ordinal": {
+			objs: []client.Object{statefulPod("db-0", "db"),
+				&appsv1.StatefulSet{ObjectMeta: v1.ObjectMeta{Name: "db", Namespace: "default"},
+					Spec: appsv1.StatefulSetSpec{Replicas: pointer.Int32(1)}}},
+			nodeMap: map[string]egress.EgressIPStatus{
+				"node1": {Name: "node1", Status: ready, Eips: []egress.Eips{{IPv4: "10.6.1.20", Policies: []egress.Policy{policy}}},
+					StatefulEips: []egress.StatefulEip{
+						{PodEip: egress.PodEip{Pod: "db-0", IPv4: "10.6.1.24"}, Policy: policy},
+						{PodEip: egress.PodEip{Pod: "db-1", IPv4: "10.6.1.25"}, Policy: policy},
+					}},
+			},
+			nodeName: "node1",
+			expPods:  []string{"db-0"},
+			expKept:  map[string]string{"db-0": "10.6.1.24"},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			policyObj := egp.DeepCopy()
+			policyObj.Status.PodEips = c.podEips
+			objs := append([]client.Object{egw.DeepCopy(), policyObj}, c.objs...)
+			cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(objs...).Build()
+			r := egnReconciler{client: cli, log: logger.NewLogger(logger.Config{})}
+
+			changed, err := r.syncStatefulEips(context.Background(), r.log, policyObj, egw, c.nodeName, c.nodeMap)
+			assert.NoError(t, err)
+			assert.True(t, changed)
+
+			for name, node := range c.nodeMap {
+				if name != c.nodeName {
+					assert.Empty(t, node.StatefulEips)
+				}
+			}
+
+			used := map[string]struct{}{"10.6.1.20": {}}
+			var pods []string
+			for _, eip := range c.nodeMap[c.nodeName].StatefulEips {
+				pods = append(pods, eip.Pod)
+				assert.Equal(t, policy, eip.Policy)
+				assert.NotEmpty(t, eip.IPv4)
+				_, dup := used[eip.IPv4]
+				assert.False(t, dup, "EIP %v is allocated twice", eip.IPv4)
+				used[eip.IPv4] = struct{}{}
+				if ip, ok := c.expKept[eip.Pod]; ok {
+					assert.Equal(t, ip, eip.IPv4)
+				}
+			}
+			assert.Equal(t, c.expPods, pods)
+
+			// a second sync keeps the mapping
+			changed, err = r.syncStatefulEips(context.Background(), r.log, policyObj, egw, c.nodeName, c.nodeMap)
+			assert.NoError(t, err)
+			assert.False(t, changed)
+		})
+	}
+}
+
+func TestParseStatefulPod(t *testing.T) {
+	cases := map[string]struct {
+		pod        string
+		expName    string
+		expOrdinal int
+		expOK      bool
+	}{
+		"ordinal":      {pod: "db-0", expName: "db", expOrdinal: 0, expOK: true},
+		"dashed name":  {pod: "my-db-12", expName: "my-db", expOrdinal: 12, expOK: true},
+		"no ordinal":   {pod: "db-abc"},
+		"no separator": {pod: "db"},
+		"empty sts":    {pod: "-1"},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			sts, ordinal, ok := parseStatefulPod(c.pod)
+			assert.Equal(t, c.expOK, ok)
+			assert.Equal(t, c.expName, sts)
+			assert.Equal(t, c.expOrdinal, ordinal)
+		})
+	}
+}
+
+func TestSyncStatefulEipsQuota(t *testing.T) {
+	policy := egress.Policy{Name: "p1", Namespace: "default"}
+	egw := &egress.EgressGateway{
+		ObjectMeta: v1.ObjectMeta{Name: "egw1"},
+		Spec: egress.EgressGatewaySpec{
+			Ippools: egress.Ippools{IPv4: []string{"10.6.1.20-10.6.1.25"}},
+		},
+	}
+	egp := &egress.EgressPolicy{
+		ObjectMeta: v1.ObjectMeta{Name: "p1", Namespace: "default"},
+		Spec: egress.EgressPolicySpec{
+			EgressGatewayName: "egw1",
+			EgressIP:          egress.EgressIP{AllocatorPolicy: egress.EipAllocatorSticky},
+			AppliedTo: egress.AppliedTo{
+				PodSelector: &v1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+			},
+		},
+	}
+	// the EIP of the policy and the EIP of one pod reach the quota
+	quota := &egress.EgressIPQuota{
+		ObjectMeta: v1.ObjectMeta{Name: "quota", Namespace: "default"},
+		Spec:       egress.EgressIPQuotaSpec{MaxEIPs: pointer.Int(2)},
+	}
+	nodeMap := map[string]egress.EgressIPStatus{
+		"node1": {Name: "node1", Status: string(egress.EgressTunnelReady),
+			Eips: []egress.Eips{{IPv4: "10.6.1.20", Policies: []egress.Policy{policy}}}},
+	}
+
+	cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(egw, egp, quota,
+		statefulPod("db-0", "db"), statefulPod("db-1", "db"), statefulPod("db-2", "db")).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := egnReconciler{client: cli, log: logger.NewLogger(logger.Config{}), recorder: recorder}
+
+	changed, err := r.syncStatefulEips(context.Background(), r.log, egp, egw, "node1", nodeMap)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	if assert.Len(t, nodeMap["node1"].StatefulEips, 1) {
+		assert.Equal(t, "db-0", nodeMap["node1"].StatefulEips[0].Pod)
+	}
+	if assert.Len(t, recorder.Events, 1) {
+		event := <-recorder.Events
+		assert.Contains(t, event, ReasonEIPQuotaExceeded)
+		assert.Contains(t, event, "db-1, db-2")
+	}
+}
+
+func TestEnqueueEndpointSlicePolicy(t *testing.T) {
+	sticky := &egress.EgressPolicy{
+		ObjectMeta: v1.ObjectMeta{Name: "p1", Namespace: "default"},
+		Spec:       egress.EgressPolicySpec{EgressIP: egress.EgressIP{AllocatorPolicy: egress.EipAllocatorSticky}},
+	}
+	plain := &egress.EgressPolicy{
+		ObjectMeta: v1.ObjectMeta{Name: "p2", Namespace: "default"},
+		Spec:       egress.EgressPolicySpec{EgressIP: egress.EgressIP{AllocatorPolicy: egress.EipAllocatorDefault}},
+	}
+	cli := fake.NewClientBuilder().WithScheme(schema.GetScheme()).WithObjects(sticky, plain).Build()
+	mapFunc := enqueueEndpointSlicePolicy(cli)
+
+	slice := func(policy string) *egress.EgressEndpointSlice {
+		res := &egress.EgressEndpointSlice{ObjectMeta: v1.ObjectMeta{Name: "s1", Namespace: "default"}}
+		if policy != "" {
+			res.Labels = map[string]string{egress.LabelPolicyName: policy}
+		}
+		return res
+	}
+
+	res := mapFunc(context.Background(), slice("p1"))
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, "EgressPolicy/default", res[0].Namespace)
+		assert.Equal(t, "p1", res[0].Name)
+	}
+	// only the sticky policies are reconciled on the endpoint slice changes
+	assert.Empty(t, mapFunc(context.Background(), slice("p2")))
+	assert.Empty(t, mapFunc(context.Background(), slice("p3")))
+	assert.Empty(t, mapFunc(context.Background(), slice("")))
+}
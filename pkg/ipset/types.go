@@ -4,6 +4,11 @@
 
 package ipset
 
+import (
+	"crypto/sha1"
+	"fmt"
+)
+
 // Type represents the ipset type
 type Type string
 
@@ -56,3 +61,11 @@ var ValidIPSetTypes = []Type{
 	HashIP,
 	HashNet,
 }
+
+// FormatName returns the name of a set from a prefix and the hash of name, it
+// fits the 31 characters limit of the set names.
+func FormatName(prefix, name string) string {
+	hash := fmt.Sprintf("%x", sha1.Sum([]byte(name)))
+	i := 31 - len(prefix)
+	return prefix + hash[:i]
+}
@@ -0,0 +1,96 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package iptables
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+// counterRegexp matches an iptables-save -c output line for an append operation,
+// capturing the packet and byte counters.
+var counterRegexp = regexp.MustCompile(`^\[(\d+):(\d+)\] -A (\S+)`)
+
+// RuleCounter is the packet and byte counter of a rule written by the Table.
+type RuleCounter struct {
+	Chain   string
+	Packets uint64
+	Bytes   uint64
+}
+
+// RuleHashes returns the hashes of the rules of the chain in the desired state,
+// they identify the rules in the result of ReadCounters after Apply.
+func (t *Table) RuleHashes(chainName string) []string {
+	chain, ok := t.chainNameToChain[chainName]
+	if !ok {
+		return nil
+	}
+	return calculateRuleHashes(chainName, chain.Rules, t.opt)
+}
+
+// ReadCounters returns the counters of the rules written by the Table, indexed by
+// rule hash. The counters restart from zero when a rule is rewritten, because its
+// hash changes with the rule and the rules before it.
+func (t *Table) ReadCounters() (map[string]RuleCounter, error) {
+	cmd := t.newCmd(t.iptablesSaveCmd, "-c", "-t", t.Name)
+	countNumSaveCalls.Inc()
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the stdout of %s: %w", t.iptablesSaveCmd, err)
+	}
+	if err := cmd.Start(); err != nil {
+		_ = stdout.Close()
+		countNumSaveErrors.Inc()
+		return nil, fmt.Errorf("failed to start %s: %w", t.iptablesSaveCmd, err)
+	}
+	counters, err := t.readCountersFrom(stdout)
+	if err != nil {
+		_ = cmd.Kill()
+	}
+	if waitErr := cmd.Wait(); waitErr != nil && err == nil {
+		err = waitErr
+	}
+	if err != nil {
+		countNumSaveErrors.Inc()
+		return nil, err
+	}
+	return counters, nil
+}
+
+func (t *Table) readCountersFrom(r io.Reader) (map[string]RuleCounter, error) {
+	counters := make(map[string]RuleCounter)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		captures := counterRegexp.FindSubmatch(line)
+		if captures == nil {
+			continue
+		}
+		hash := t.hashCommentRegexp.FindSubmatch(line)
+		if hash == nil {
+			continue
+		}
+		packets, err := strconv.ParseUint(string(captures[1]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid packet counter in %q: %w", line, err)
+		}
+		bytes, err := strconv.ParseUint(string(captures[2]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid byte counter in %q: %w", line, err)
+		}
+		counters[string(hash[1])] = RuleCounter{
+			Chain:   string(captures[3]),
+			Packets: packets,
+			Bytes:   bytes,
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return counters, nil
+}
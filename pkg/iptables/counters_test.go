@@ -0,0 +1,31 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package iptables
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadCountersFrom(t *testing.T) {
+	table := &Table{hashCommentRegexp: regexp.MustCompile(`--comment "?egw:([a-zA-Z0-9_-]+)"?`)}
+	input := `# Generated by iptables-save
+*nat
+:PREROUTING ACCEPT [10:600]
+:EGRESSGATEWAY-SNAT-EIP - [0:0]
+[3:180] -A EGRESSGATEWAY-SNAT-EIP -m comment --comment "egw:hash1" -m comment --comment "snat policy p1" -j SNAT --to-source 10.6.1.21
+[0:0] -A EGRESSGATEWAY-SNAT-EIP -m comment --comment "egw:hash2" -j SNAT --to-source 10.6.1.22
+[7:420] -A POSTROUTING -j MASQUERADE
+COMMIT
+`
+	counters, err := table.readCountersFrom(strings.NewReader(input))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]RuleCounter{
+		"hash1": {Chain: "EGRESSGATEWAY-SNAT-EIP", Packets: 3, Bytes: 180},
+		"hash2": {Chain: "EGRESSGATEWAY-SNAT-EIP"},
+	}, counters)
+}
@@ -0,0 +1,144 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package iptables
+
+import (
+	"sort"
+)
+
+// ChainState is the desired state of the rules the Table writes in a chain, the
+// chains of the Table and the rules it inserts in or appends to the other chains.
+type ChainState struct {
+	Chain string `json:"chain"`
+	// Rules are the rules rendered as in the output of iptables-save
+	Rules []string `json:"rules"`
+	// Hashes identify the rules, they are written in the comment of the rules
+	Hashes []string `json:"hashes"`
+}
+
+// ChainDrift is the difference between the desired and the actual rules of a chain.
+type ChainDrift struct {
+	Chain string `json:"chain"`
+	// Missing are the desired rules which are not in the dataplane
+	Missing []string `json:"missing,omitempty"`
+	// Unexpected are the hashes of the rules written by the Table which are not desired
+	Unexpected []string `json:"unexpected,omitempty"`
+	// OutOfOrder is true when the desired rules are in the dataplane in another order
+	OutOfOrder bool `json:"outOfOrder,omitempty"`
+}
+
+// DesiredState returns the rules the Table keeps in the dataplane, sorted by chain.
+// The chains which are not referenced are not written, so they are left out. Like the
+// other methods updating the Table, it must not be called concurrently with them.
+func (t *Table) DesiredState() []ChainState {
+	states := make(map[string]*ChainState)
+	add := func(chainName string, rules []Rule, hashes []string) {
+		state, ok := states[chainName]
+		if !ok {
+			state = &ChainState{Chain: chainName}
+			states[chainName] = state
+		}
+		for i, rule := range rules {
+			state.Rules = append(state.Rules, rule.RenderAppend(chainName, t.commentFrag(hashes[i]), t.opt))
+			state.Hashes = append(state.Hashes, hashes[i])
+		}
+	}
+
+	for name, chain := range t.chainNameToChain {
+		if _, present := t.desiredStateOfChain(name); !present {
+			continue
+		}
+		add(name, chain.Rules, chain.RuleHashes(t.opt))
+	}
+	for name, rules := range t.chainToInsertedRules {
+		add(name, rules, calculateRuleHashes(name, rules, t.opt))
+	}
+	for name, rules := range t.chainToAppendedRules {
+		add(name, rules, calculateRuleHashes(name+"*appends*", rules, t.opt))
+	}
+
+	res := make([]ChainState, 0, len(states))
+	for _, state := range states {
+		res = append(res, *state)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Chain < res[j].Chain })
+	return res
+}
+
+// ActualState reads the hashes of the rules written by the Table in the dataplane,
+// indexed by chain. It runs iptables-save and does not touch the desired state, so
+// it can be called concurrently with the other methods.
+func (t *Table) ActualState() (map[string][]string, error) {
+	hashes, _, err := t.attemptToGetHashesAndRulesFromDataplane()
+	if err != nil {
+		countNumSaveErrors.Inc()
+		return nil, err
+	}
+	res := make(map[string][]string)
+	for chain, items := range hashes {
+		for _, hash := range items {
+			if hash != "" {
+				res[chain] = append(res[chain], hash)
+			}
+		}
+	}
+	return res, nil
+}
+
+// Drift compares the desired state of the chains with the hashes in the dataplane,
+// only the chains which differ are returned.
+func Drift(desired []ChainState, actual map[string][]string) []ChainDrift {
+	res := make([]ChainDrift, 0)
+	seen := make(map[string]struct{}, len(desired))
+	for _, state := range desired {
+		seen[state.Chain] = struct{}{}
+		drift := ChainDrift{Chain: state.Chain}
+
+		got := make(map[string]struct{}, len(actual[state.Chain]))
+		for _, hash := range actual[state.Chain] {
+			got[hash] = struct{}{}
+		}
+		want := make(map[string]struct{}, len(state.Hashes))
+		for i, hash := range state.Hashes {
+			want[hash] = struct{}{}
+			if _, ok := got[hash]; !ok {
+				drift.Missing = append(drift.Missing, state.Rules[i])
+			}
+		}
+		// the hashes of the kept rules must be in the desired order
+		kept := make([]string, 0, len(actual[state.Chain]))
+		for _, hash := range actual[state.Chain] {
+			// a duplicated rule is unexpected too
+			if _, ok := want[hash]; !ok {
+				drift.Unexpected = append(drift.Unexpected, hash)
+				continue
+			}
+			delete(want, hash)
+			kept = append(kept, hash)
+		}
+		if len(drift.Missing) == 0 && len(drift.Unexpected) == 0 {
+			for i := range kept {
+				if kept[i] != state.Hashes[i] {
+					drift.OutOfOrder = true
+					break
+				}
+			}
+		}
+		if len(drift.Missing) > 0 || len(drift.Unexpected) > 0 || drift.OutOfOrder {
+			res = append(res, drift)
+		}
+	}
+
+	chains := make([]string, 0)
+	for chain := range actual {
+		if _, ok := seen[chain]; !ok {
+			chains = append(chains, chain)
+		}
+	}
+	sort.Strings(chains)
+	for _, chain := range chains {
+		res = append(res, ChainDrift{Chain: chain, Unexpected: actual[chain]})
+	}
+	return res
+}
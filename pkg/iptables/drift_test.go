@@ -0,0 +1,35 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package iptables
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrift(t *testing.T) {
+	desired := []ChainState{
+		{Chain: "EGRESSGATEWAY-MARK-REQUEST", Rules: []string{"-A rule1", "-A rule2"}, Hashes: []string{"hash1", "hash2"}},
+		{Chain: "EGRESSGATEWAY-SNAT-EIP", Rules: []string{"-A rule3"}, Hashes: []string{"hash3"}},
+		{Chain: "EGRESSGATEWAY-REPLY-ROUTING", Rules: []string{"-A rule4", "-A rule5"}, Hashes: []string{"hash4", "hash5"}},
+		{Chain: "PREROUTING", Rules: []string{"-A rule6"}, Hashes: []string{"hash6"}},
+	}
+	actual := map[string][]string{
+		"EGRESSGATEWAY-MARK-REQUEST":  {"hash1"},
+		"EGRESSGATEWAY-SNAT-EIP":      {"hash3", "hash3", "stale"},
+		"EGRESSGATEWAY-REPLY-ROUTING": {"hash5", "hash4"},
+		"PREROUTING":                  {"hash6"},
+		"EGRESSGATEWAY-OLD":           {"old"},
+	}
+
+	assert.Equal(t, []ChainDrift{
+		{Chain: "EGRESSGATEWAY-MARK-REQUEST", Missing: []string{"-A rule2"}},
+		{Chain: "EGRESSGATEWAY-SNAT-EIP", Unexpected: []string{"hash3", "stale"}},
+		{Chain: "EGRESSGATEWAY-REPLY-ROUTING", OutOfOrder: true},
+		{Chain: "EGRESSGATEWAY-OLD", Unexpected: []string{"old"}},
+	}, Drift(desired, actual))
+
+	assert.Empty(t, Drift(desired[3:], map[string][]string{"PREROUTING": {"hash6"}}))
+}
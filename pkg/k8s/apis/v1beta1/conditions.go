@@ -0,0 +1,243 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package v1beta1
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The conditions shared by the egress CRDs, the other conditions are declared with
+// their CRD. The conditions carry the generation of the object they were computed
+// from as observedGeneration.
+const (
+	// ConditionReady is True when the object works as specified
+	ConditionReady = "Ready"
+	// ConditionDegraded is True when the object works with less redundancy or capacity than specified
+	ConditionDegraded = "Degraded"
+
+	// ReasonAsExpected is the reason of a Degraded condition which is False
+	ReasonAsExpected = "AsExpected"
+)
+
+// policyReadyConditions are the conditions a policy needs to be Ready, in the order they are met
+var policyReadyConditions = []string{
+	PolicyConditionEIPAllocated,
+	PolicyConditionEndpointsSynced,
+	PolicyConditionDatapathProgrammed,
+}
+
+// SetEIPAllocatedCondition sets the EIPAllocated condition from the node and the EIP
+// of the status. The DatapathProgrammed condition is reset when the policy moves to
+// another node, until the agent of the node programs it. It reports whether the
+// conditions changed.
+func (status *EgressPolicyStatus) SetEIPAllocatedCondition(generation int64) bool {
+	cond := metav1.Condition{
+		Type:               PolicyConditionEIPAllocated,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             ReasonNotAllocated,
+		Message:            "No gateway node is available for the policy",
+	}
+	if status.Node != "" {
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = ReasonAllocated
+		cond.Message = fmt.Sprintf("The policy is placed on node %s", status.Node)
+		if eips := joinEIP(status.Eip); eips != "" {
+			cond.Message = fmt.Sprintf("EIP %s is allocated on node %s", eips, status.Node)
+		}
+	}
+
+	changed := false
+	old := meta.FindStatusCondition(status.Conditions, PolicyConditionEIPAllocated)
+	if old == nil || old.Message != cond.Message {
+		changed = meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:               PolicyConditionDatapathProgrammed,
+			Status:             metav1.ConditionUnknown,
+			ObservedGeneration: generation,
+			Reason:             ReasonPending,
+			Message:            "Waiting for the agent of the gateway node",
+		})
+	}
+	changed = meta.SetStatusCondition(&status.Conditions, cond) || changed
+	return status.setReadyCondition(generation) || changed
+}
+
+// SetEndpointsSyncedCondition sets the EndpointsSynced condition from the error of the
+// endpoint slice sync. It reports whether the conditions changed.
+func (status *EgressPolicyStatus) SetEndpointsSyncedCondition(generation int64, err error) bool {
+	cond := metav1.Condition{
+		Type:               PolicyConditionEndpointsSynced,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             ReasonSynced,
+		Message:            "The endpoint slices match the selected pods",
+	}
+	if err != nil {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = ReasonSyncFailed
+		cond.Message = err.Error()
+	}
+	changed := meta.SetStatusCondition(&status.Conditions, cond)
+	return status.setReadyCondition(generation) || changed
+}
+
+// SetDatapathProgrammedCondition sets the DatapathProgrammed condition from the error
+// of the agent of the gateway node. It reports whether the conditions changed.
+func (status *EgressPolicyStatus) SetDatapathProgrammedCondition(generation int64, node string, err error) bool {
+	cond := metav1.Condition{
+		Type:               PolicyConditionDatapathProgrammed,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             ReasonProgrammed,
+		Message:            fmt.Sprintf("The rules are applied on node %s", node),
+	}
+	if err != nil {
+		cond.Status = metav1.ConditionFalse
+		cond.Reason = ReasonProgramFailed
+		cond.Message = fmt.Sprintf("Node %s: %v", node, err)
+	}
+	changed := meta.SetStatusCondition(&status.Conditions, cond)
+	return status.setReadyCondition(generation) || changed
+}
+
+// setReadyCondition sets Ready to True when all the policyReadyConditions are True,
+// otherwise the first condition which is not True gives the reason.
+func (status *EgressPolicyStatus) setReadyCondition(generation int64) bool {
+	cond := metav1.Condition{
+		Type:               ConditionReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             ConditionReady,
+		Message:            "The traffic of the selected pods leaves through the EIP",
+	}
+	for _, t := range policyReadyConditions {
+		c := meta.FindStatusCondition(status.Conditions, t)
+		if c == nil {
+			cond.Status = metav1.ConditionUnknown
+			cond.Reason = ReasonPending
+			cond.Message = fmt.Sprintf("Condition %s is not reported yet", t)
+			break
+		}
+		if c.Status != metav1.ConditionTrue {
+			cond.Status = c.Status
+			cond.Reason = c.Reason
+			cond.Message = c.Message
+			break
+		}
+	}
+	return meta.SetStatusCondition(&status.Conditions, cond)
+}
+
+func joinEIP(eip Eip) string {
+	switch {
+	case eip.Ipv4 != "" && eip.Ipv6 != "":
+		return eip.Ipv4 + "," + eip.Ipv6
+	case eip.Ipv4 != "":
+		return eip.Ipv4
+	default:
+		return eip.Ipv6
+	}
+}
+
+// SetConditions sets the Ready and Degraded conditions from the gateway nodes, the
+// conflicts and the IP usage. It reports whether the conditions changed.
+func (status *EgressGatewayStatus) SetConditions(generation int64) bool {
+	ready := 0
+	for _, node := range status.NodeList {
+		if node.Status == string(EgressTunnelReady) {
+			ready++
+		}
+	}
+
+	readyCond := metav1.Condition{
+		Type:               ConditionReady,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+		Reason:             ReasonGatewayNodeReady,
+		Message:            fmt.Sprintf("%d of %d gateway nodes are ready", ready, len(status.NodeList)),
+	}
+	switch {
+	case len(status.NodeList) == 0:
+		readyCond.Status = metav1.ConditionFalse
+		readyCond.Reason = ReasonNoGatewayNode
+		readyCond.Message = "No node matches the node selector"
+	case ready == 0:
+		readyCond.Status = metav1.ConditionFalse
+		readyCond.Reason = ReasonNoReadyGatewayNode
+	}
+
+	degradedCond := metav1.Condition{
+		Type:               ConditionDegraded,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: generation,
+	}
+	usage := status.IPUsage
+	switch {
+	case ready < len(status.NodeList):
+		degradedCond.Reason = ReasonGatewayNodeNotReady
+		degradedCond.Message = readyCond.Message
+	case len(status.Conflicts) > 0:
+		degradedCond.Reason = ReasonEIPConflict
+		degradedCond.Message = fmt.Sprintf("%d EIPs are used by other hosts", len(status.Conflicts))
+	case (usage.IPv4Total > 0 && usage.IPv4Free == 0) || (usage.IPv6Total > 0 && usage.IPv6Free == 0):
+		degradedCond.Reason = ReasonEIPExhausted
+		degradedCond.Message = "No free EIP is left in the ippools"
+	default:
+		degradedCond.Status = metav1.ConditionFalse
+		degradedCond.Reason = ReasonAsExpected
+		degradedCond.Message = "All gateway nodes are ready"
+	}
+
+	changed := meta.SetStatusCondition(&status.Conditions, readyCond)
+	return meta.SetStatusCondition(&status.Conditions, degradedCond) || changed
+}
+
+// SetExhaustedCondition sets the Exhausted condition from the IP usage. It reports
+// whether the condition changed.
+func (status *EgressIPPoolStatus) SetExhaustedCondition(generation int64) bool {
+	cond := metav1.Condition{
+		Type:               PoolConditionExhausted,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             ReasonFreeIP,
+		Message: fmt.Sprintf("%d of %d IPv4 and %d of %d IPv6 addresses are free",
+			status.IPUsage.IPv4Free, status.IPUsage.IPv4Total, status.IPUsage.IPv6Free, status.IPUsage.IPv6Total),
+	}
+	if (status.IPUsage.IPv4Total > 0 && status.IPUsage.IPv4Free == 0) ||
+		(status.IPUsage.IPv6Total > 0 && status.IPUsage.IPv6Free == 0) {
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = ReasonNoFreeIP
+	}
+	return meta.SetStatusCondition(&status.Conditions, cond)
+}
+
+// SetExceededCondition sets the Exceeded condition from the usage of the namespace
+// and the limits of spec. It reports whether the condition changed.
+func (status *EgressIPQuotaStatus) SetExceededCondition(generation int64, spec EgressIPQuotaSpec) bool {
+	cond := metav1.Condition{
+		Type:               QuotaConditionExceeded,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             ReasonWithinQuota,
+		Message:            "The usage is within the limits",
+	}
+	for _, usage := range status.Usage {
+		if spec.MaxEIPs != nil && usage.EIPs > *spec.MaxEIPs {
+			cond.Status = metav1.ConditionTrue
+			cond.Reason = ReasonOverQuota
+			cond.Message = fmt.Sprintf("%d EIPs are used on gateway %s, the limit is %d", usage.EIPs, usage.EgressGateway, *spec.MaxEIPs)
+			break
+		}
+		if spec.MaxPolicies != nil && usage.Policies > *spec.MaxPolicies {
+			cond.Status = metav1.ConditionTrue
+			cond.Reason = ReasonOverQuota
+			cond.Message = fmt.Sprintf("%d policies use gateway %s, the limit is %d", usage.Policies, usage.EgressGateway, *spec.MaxPolicies)
+			break
+		}
+	}
+	return meta.SetStatusCondition(&status.Conditions, cond)
+}
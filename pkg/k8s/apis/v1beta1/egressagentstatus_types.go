@@ -0,0 +1,115 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EgressAgentStatusList is a list of EgressAgentStatus
+// +kubebuilder:object:root=true
+type EgressAgentStatusList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []EgressAgentStatus `json:"items"`
+}
+
+// EgressAgentStatus is the datapath status reported by the agent of a node, it is
+// named after the node and removed with the node
+// +kubebuilder:resource:categories={egressagentstatus},path="egressagentstatuses",singular="egressagentstatus",scope="Cluster",shortName={eas}
+// +kubebuilder:printcolumn:JSONPath=".status.datapathGeneration",description="datapathGeneration",name="generation",type=integer
+// +kubebuilder:printcolumn:JSONPath=".status.iptables.backend",description="iptablesBackend",name="iptables",type=string
+// +kubebuilder:printcolumn:JSONPath=".status.lastSyncTime",description="lastSyncTime",name="lastSync",type=date
+// +kubebuilder:printcolumn:JSONPath=".status.conditions[?(@.type==\"Ready\")].status",description="ready",name="ready",type=string
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+type EgressAgentStatus struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+
+	Status AgentStatus `json:"status,omitempty"`
+}
+
+type AgentStatus struct {
+	// DatapathGeneration is increased each time the agent programs the datapath of
+	// all the policies successfully
+	// +kubebuilder:validation:Optional
+	DatapathGeneration int64 `json:"datapathGeneration,omitempty"`
+	// LastSyncTime is the time of the last successful programming of all the policies
+	// +kubebuilder:validation:Optional
+	LastSyncTime metav1.Time `json:"lastSyncTime,omitempty"`
+	// +kubebuilder:validation:Optional
+	IPTables IPTablesInfo `json:"iptables,omitempty"`
+	// Policies are the policies programmed on the node
+	// +kubebuilder:validation:Optional
+	Policies []AppliedPolicy `json:"policies,omitempty"`
+	// Errors are the last errors of the datapath, the newest first
+	// +kubebuilder:validation:Optional
+	Errors []DatapathError `json:"errors,omitempty"`
+	// Peers are the tunnel peers of the node
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=node
+	Peers []TunnelPeer `json:"peers,omitempty"`
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+type IPTablesInfo struct {
+	// +kubebuilder:validation:Optional
+	Backend string `json:"backend,omitempty"`
+	// +kubebuilder:validation:Optional
+	Version string `json:"version,omitempty"`
+}
+
+type AppliedPolicy struct {
+	Name string `json:"name"`
+	// Namespace is empty for the EgressClusterPolicies
+	// +kubebuilder:validation:Optional
+	Namespace string `json:"namespace,omitempty"`
+	// Generation is the generation of the policy the datapath was programmed from
+	// +kubebuilder:validation:Optional
+	Generation int64 `json:"generation,omitempty"`
+	// Gateway is true when the node is the gateway node of the policy
+	// +kubebuilder:validation:Optional
+	Gateway    bool `json:"gateway,omitempty"`
+	Programmed bool `json:"programmed"`
+	// +kubebuilder:validation:Optional
+	Error string `json:"error,omitempty"`
+}
+
+type DatapathError struct {
+	// +kubebuilder:validation:Enum=ipset;iptables;route;fdb;vxlan
+	Component string `json:"component"`
+	Message   string `json:"message"`
+	// +kubebuilder:validation:Optional
+	Time metav1.Time `json:"time,omitempty"`
+}
+
+type TunnelPeer struct {
+	Node string `json:"node"`
+	// Programmed is true when the FDB and neighbor entries of the peer are set
+	Programmed bool `json:"programmed"`
+	// Reachable is true when the underlay address of the peer has a route whose next
+	// hop is not a failed neighbor
+	Reachable bool `json:"reachable"`
+	// +kubebuilder:validation:Optional
+	Message string `json:"message,omitempty"`
+}
+
+// The components of the datapath errors
+const (
+	ComponentIPSet    = "ipset"
+	ComponentIPTables = "iptables"
+	ComponentRoute    = "route"
+	ComponentFDB      = "fdb"
+	ComponentVXLAN    = "vxlan"
+)
+
+func init() {
+	SchemeBuilder.Register(&EgressAgentStatus{}, &EgressAgentStatusList{})
+}
@@ -47,8 +47,18 @@ type EgressClusterInfoStatus struct {
 	PodCIDR map[string]IPListPair `json:"podCIDR,omitempty"`
 	// +kubebuilder:validation:Optional
 	ExtraCidr []string `json:"extraCidr,omitempty"`
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+// The reasons of the Ready condition of the EgressClusterInfo
+const (
+	ReasonDetected     = "Detected"
+	ReasonDetectFailed = "DetectFailed"
+)
+
 type AutoDetect struct {
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:default:="auto"
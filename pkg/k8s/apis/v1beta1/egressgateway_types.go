@@ -4,6 +4,9 @@
 package v1beta1
 
 import (
+	"net"
+	"time"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -26,6 +29,7 @@ type EgressGatewayList struct {
 // +kubebuilder:printcolumn:JSONPath=".status.ipUsage.ipv4Free",description="ipv4Free",name="ipv4Free",type=integer
 // +kubebuilder:printcolumn:JSONPath=".status.ipUsage.ipv6Total",description="ipv6Total",name="ipv6Total",type=integer
 // +kubebuilder:printcolumn:JSONPath=".status.ipUsage.ipv6Free",description="ipv6Free",name="ipv6Free",type=integer
+// +kubebuilder:printcolumn:JSONPath=".status.conditions[?(@.type==\"Ready\")].status",description="ready",name="ready",type=string
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 type EgressGateway struct {
@@ -43,6 +47,93 @@ type EgressGatewaySpec struct {
 	Ippools Ippools `json:"ippools,omitempty"`
 	// +kubebuilder:validation:Required
 	NodeSelector NodeSelector `json:"nodeSelector,omitempty"`
+	// BGP sets the attributes of the EIP routes announced by the agents when the BGP mode is enabled
+	// +kubebuilder:validation:Optional
+	BGP *EgressGatewayBGP `json:"bgp,omitempty"`
+	// AnnounceInterfaces selects the interfaces of the gateway nodes on which the EIPs are
+	// answered with ARP/NDP, all interfaces are used when it is not set
+	// +kubebuilder:validation:Optional
+	AnnounceInterfaces *AnnounceInterfaces `json:"announceInterfaces,omitempty"`
+	// Announcement is the burst of gratuitous ARP/unsolicited NA sent by a gateway node
+	// when it takes over the EIPs, 5 announcements 1100ms apart when it is not set
+	// +kubebuilder:validation:Optional
+	Announcement *AnnouncementProfile `json:"announcement,omitempty"`
+	// VLAN is the underlay VLAN the EIPs live on, the agents create its sub-interface
+	// on the gateway nodes and route the EIP traffic out of it
+	// +kubebuilder:validation:Optional
+	VLAN *EgressGatewayVLAN `json:"vlan,omitempty"`
+}
+
+type EgressGatewayVLAN struct {
+	// Parent is the interface of the gateway nodes the VLAN is trunked to, e.g. "eth0"
+	// +kubebuilder:validation:Required
+	Parent string `json:"parent"`
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=4094
+	ID int32 `json:"id"`
+	// Addresses of the sub-interface on the gateway nodes, the sub-interface has no address
+	// when the node is not listed
+	// +kubebuilder:validation:Optional
+	Addresses []EgressGatewayVLANAddress `json:"addresses,omitempty"`
+	// IPv4Gateway is the next hop of the EIP traffic on the VLAN, the destinations are
+	// reached directly on the VLAN when it is not set
+	// +kubebuilder:validation:Optional
+	IPv4Gateway string `json:"ipv4Gateway,omitempty"`
+	// +kubebuilder:validation:Optional
+	IPv6Gateway string `json:"ipv6Gateway,omitempty"`
+}
+
+type EgressGatewayVLANAddress struct {
+	// +kubebuilder:validation:Required
+	Node string `json:"node"`
+	// IPv4 in the CIDR format, e.g. "10.30.0.11/24"
+	// +kubebuilder:validation:Optional
+	IPv4 string `json:"ipv4,omitempty"`
+	// +kubebuilder:validation:Optional
+	IPv6 string `json:"ipv6,omitempty"`
+}
+
+type AnnouncementProfile struct {
+	// Count is the number of announcements sent for an EIP
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	Count *int32 `json:"count,omitempty"`
+	// IntervalMillis is the interval between two announcements
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=100
+	// +kubebuilder:validation:Maximum=60000
+	IntervalMillis *int32 `json:"intervalMillis,omitempty"`
+	// Override sets the override flag of the unsolicited neighbor advertisements, the
+	// neighbors replace their cache entries of the EIP, defaults to true
+	// +kubebuilder:validation:Optional
+	Override *bool `json:"override,omitempty"`
+	// Router sets the router flag of the unsolicited neighbor advertisements, defaults to false
+	// +kubebuilder:validation:Optional
+	Router *bool `json:"router,omitempty"`
+}
+
+// AnnounceInterfaces selects an interface when any of its fields matches
+type AnnounceInterfaces struct {
+	// Names of the interfaces, e.g. "eth1" or the VLAN interface "eth1.100"
+	// +kubebuilder:validation:Optional
+	Names []string `json:"names,omitempty"`
+	// Regexps match the names of the interfaces
+	// +kubebuilder:validation:Optional
+	Regexps []string `json:"regexps,omitempty"`
+	// Subnets select the interfaces which have an address in one of the CIDRs
+	// +kubebuilder:validation:Optional
+	Subnets []string `json:"subnets,omitempty"`
+}
+
+type EgressGatewayBGP struct {
+	// Communities in the "asn:value" format
+	// +kubebuilder:validation:Optional
+	Communities []string `json:"communities,omitempty"`
+	// LocalPref is only sent to iBGP peers
+	// +kubebuilder:validation:Optional
+	LocalPref *uint32 `json:"localPref,omitempty"`
 }
 
 type Ippools struct {
@@ -54,6 +145,10 @@ type Ippools struct {
 	Ipv4DefaultEIP string `json:"ipv4DefaultEIP,omitempty"`
 	// +kubebuilder:validation:Optional
 	Ipv6DefaultEIP string `json:"ipv6DefaultEIP,omitempty"`
+	// EgressIPPool is the name of the EgressIPPool the gateway allocates EIPs from,
+	// it cannot be used together with IPv4 and IPv6.
+	// +kubebuilder:validation:Optional
+	EgressIPPool string `json:"egressIPPool,omitempty"`
 }
 
 type NodeSelector struct {
@@ -68,6 +163,36 @@ type EgressGatewayStatus struct {
 	NodeList []EgressIPStatus `json:"nodeList,omitempty"`
 	// +kubebuilder:validation:Optional
 	IPUsage IPUsage `json:"ipUsage,omitempty"`
+	// Conflicts are the EIPs the agents found in use by another host on the segment of
+	// a gateway node, they are not allocated until the conflict expires
+	// +kubebuilder:validation:Optional
+	Conflicts []EIPConflict `json:"conflicts,omitempty"`
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// The reasons of the Ready and Degraded conditions of the EgressGateways
+const (
+	ReasonGatewayNodeReady    = "GatewayNodeReady"
+	ReasonNoGatewayNode       = "NoGatewayNode"
+	ReasonNoReadyGatewayNode  = "NoReadyGatewayNode"
+	ReasonGatewayNodeNotReady = "GatewayNodeNotReady"
+	ReasonEIPConflict         = "EIPConflict"
+	ReasonEIPExhausted        = "EIPExhausted"
+)
+
+type EIPConflict struct {
+	IP string `json:"ip"`
+	// MAC of the host which uses the IP
+	MAC string `json:"mac"`
+	// Node is the gateway node which detected the conflict
+	Node string `json:"node"`
+	// +kubebuilder:validation:Optional
+	Interface string `json:"interface,omitempty"`
+	// +kubebuilder:validation:Optional
+	LastDetectedTime metav1.Time `json:"lastDetectedTime,omitempty"`
 }
 
 type IPUsage struct {
@@ -81,10 +206,15 @@ type IPUsage struct {
 	IPv6Free int `json:"ipv6Free"`
 }
 
+// GetNodeIPs returns the EIPs on the node, including the EIPs of stateful pods.
 func (status *EgressGatewayStatus) GetNodeIPs(nodeName string) []Eips {
 	for _, items := range status.NodeList {
 		if items.Name == nodeName {
-			return items.Eips
+			res := append(make([]Eips, 0, len(items.Eips)+len(items.StatefulEips)), items.Eips...)
+			for _, item := range items.StatefulEips {
+				res = append(res, Eips{IPv4: item.IPv4, IPv6: item.IPv6, Policies: []Policy{item.Policy}})
+			}
+			return res
 		}
 	}
 	return make([]Eips, 0)
@@ -96,6 +226,8 @@ type EgressIPStatus struct {
 	// +kubebuilder:validation:Optional
 	Eips []Eips `json:"eips,omitempty"`
 	// +kubebuilder:validation:Optional
+	StatefulEips []StatefulEip `json:"statefulEips,omitempty"`
+	// +kubebuilder:validation:Optional
 	Status string `json:"status,omitempty"`
 }
 
@@ -108,6 +240,14 @@ type Eips struct {
 	Policies []Policy `json:"policies,omitempty"`
 }
 
+// StatefulEip is the EIP of a StatefulSet pod selected by a sticky policy,
+// it is always on the gateway node of the policy.
+type StatefulEip struct {
+	PodEip `json:",inline"`
+	// +kubebuilder:validation:Required
+	Policy Policy `json:"policy"`
+}
+
 type Policy struct {
 	// +kubebuilder:validation:Optional
 	Name string `json:"name,omitempty"`
@@ -115,6 +255,20 @@ type Policy struct {
 	Namespace string `json:"namespace,omitempty"`
 }
 
+// GetConflict returns the conflict of ip detected at or after since.
+func (status *EgressGatewayStatus) GetConflict(ip string, since time.Time) (EIPConflict, bool) {
+	target := net.ParseIP(ip)
+	if target == nil {
+		return EIPConflict{}, false
+	}
+	for _, item := range status.Conflicts {
+		if target.Equal(net.ParseIP(item.IP)) && !item.LastDetectedTime.Time.Before(since) {
+			return item, true
+		}
+	}
+	return EIPConflict{}, false
+}
+
 func init() {
 	SchemeBuilder.Register(&EgressGateway{}, &EgressGatewayList{})
 }
@@ -0,0 +1,101 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EgressIPPoolList contains a list of EgressIPPool
+// +kubebuilder:object:root=true
+type EgressIPPoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []EgressIPPool `json:"items"`
+}
+
+// EgressIPPool is a pool of egress IPs that can be shared by several EgressGateways
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:categories={egressgateway},path="egressippools",singular="egressippool",scope="Cluster",shortName={eippool}
+// +kubebuilder:printcolumn:JSONPath=".status.ipUsage.ipv4Total",description="ipv4Total",name="ipv4Total",type=integer
+// +kubebuilder:printcolumn:JSONPath=".status.ipUsage.ipv4Free",description="ipv4Free",name="ipv4Free",type=integer
+// +kubebuilder:printcolumn:JSONPath=".status.ipUsage.ipv6Total",description="ipv6Total",name="ipv6Total",type=integer
+// +kubebuilder:printcolumn:JSONPath=".status.ipUsage.ipv6Free",description="ipv6Free",name="ipv6Free",type=integer
+// +kubebuilder:subresource:status
+type EgressIPPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+
+	Spec   EgressIPPoolSpec   `json:"spec,omitempty"`
+	Status EgressIPPoolStatus `json:"status,omitempty"`
+}
+
+type EgressIPPoolSpec struct {
+	// +kubebuilder:validation:Optional
+	IPv4 []string `json:"ipv4,omitempty"`
+	// +kubebuilder:validation:Optional
+	IPv6 []string `json:"ipv6,omitempty"`
+	// +kubebuilder:validation:Optional
+	ExcludeIPv4 []string `json:"excludeIPv4,omitempty"`
+	// +kubebuilder:validation:Optional
+	ExcludeIPv6 []string `json:"excludeIPv6,omitempty"`
+	// +kubebuilder:validation:Optional
+	Reservations []EgressIPReservation `json:"reservations,omitempty"`
+}
+
+// EgressIPReservation reserves an IP of the pool, only the policies of the
+// namespace, or the given policy, can use it.
+type EgressIPReservation struct {
+	// +kubebuilder:validation:Optional
+	IPv4 string `json:"ipv4,omitempty"`
+	// +kubebuilder:validation:Optional
+	IPv6 string `json:"ipv6,omitempty"`
+	// +kubebuilder:validation:Optional
+	Namespace string `json:"namespace,omitempty"`
+	// +kubebuilder:validation:Optional
+	Policy *Policy `json:"policy,omitempty"`
+}
+
+// Allows reports whether the policy may use the reserved IP.
+func (r *EgressIPReservation) Allows(policy Policy) bool {
+	if r.Policy != nil {
+		return *r.Policy == policy
+	}
+	return r.Namespace == policy.Namespace
+}
+
+type EgressIPPoolStatus struct {
+	// +kubebuilder:validation:Optional
+	Allocations []EgressIPAllocation `json:"allocations,omitempty"`
+	// +kubebuilder:validation:Optional
+	IPUsage IPUsage `json:"ipUsage,omitempty"`
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+const (
+	// PoolConditionExhausted is True when a family of the pool has no free IP
+	PoolConditionExhausted = "Exhausted"
+
+	ReasonNoFreeIP = "NoFreeIP"
+	ReasonFreeIP   = "FreeIP"
+)
+
+type EgressIPAllocation struct {
+	// +kubebuilder:validation:Optional
+	IP string `json:"ip,omitempty"`
+	// +kubebuilder:validation:Optional
+	EgressGateway string `json:"egressGateway,omitempty"`
+	// +kubebuilder:validation:Optional
+	Node string `json:"node,omitempty"`
+	// +kubebuilder:validation:Optional
+	Policies []Policy `json:"policies,omitempty"`
+}
+
+func init() {
+	SchemeBuilder.Register(&EgressIPPool{}, &EgressIPPoolList{})
+}
@@ -0,0 +1,83 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EgressIPQuotaList contains a list of EgressIPQuota
+// +kubebuilder:object:root=true
+type EgressIPQuotaList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []EgressIPQuota `json:"items"`
+}
+
+// EgressIPQuota limits the EIPs and policies a namespace may consume per gateway
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:categories={egressgateway},path="egressipquotas",singular="egressipquota",scope="Namespaced",shortName={eipquota}
+// +kubebuilder:printcolumn:JSONPath=".spec.egressGatewayName",description="egressGatewayName",name="gateway",type=string
+// +kubebuilder:printcolumn:JSONPath=".spec.maxEIPs",description="maxEIPs",name="maxEIPs",type=integer
+// +kubebuilder:printcolumn:JSONPath=".spec.maxPolicies",description="maxPolicies",name="maxPolicies",type=integer
+// +kubebuilder:subresource:status
+type EgressIPQuota struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+
+	Spec   EgressIPQuotaSpec   `json:"spec,omitempty"`
+	Status EgressIPQuotaStatus `json:"status,omitempty"`
+}
+
+type EgressIPQuotaSpec struct {
+	// EgressGatewayName is the gateway the quota applies to, an empty name
+	// applies the quota to every gateway separately.
+	// +kubebuilder:validation:Optional
+	EgressGatewayName string `json:"egressGatewayName,omitempty"`
+	// MaxEIPs is the maximum number of distinct EIPs the namespace may use.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	MaxEIPs *int `json:"maxEIPs,omitempty"`
+	// MaxPolicies is the maximum number of EgressPolicies of the namespace.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	MaxPolicies *int `json:"maxPolicies,omitempty"`
+}
+
+// AppliesTo reports whether the quota limits the usage of the gateway.
+func (s *EgressIPQuotaSpec) AppliesTo(egwName string) bool {
+	return len(s.EgressGatewayName) == 0 || s.EgressGatewayName == egwName
+}
+
+type EgressIPQuotaStatus struct {
+	// +kubebuilder:validation:Optional
+	Usage []EgressIPQuotaUsage `json:"usage,omitempty"`
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+const (
+	// QuotaConditionExceeded is True when the usage is over the limits, e.g. the limits
+	// were lowered after the EIPs were allocated
+	QuotaConditionExceeded = "Exceeded"
+
+	ReasonOverQuota   = "OverQuota"
+	ReasonWithinQuota = "WithinQuota"
+)
+
+type EgressIPQuotaUsage struct {
+	// +kubebuilder:validation:Optional
+	EgressGateway string `json:"egressGateway,omitempty"`
+	// +kubebuilder:validation:Optional
+	EIPs int `json:"eips"`
+	// +kubebuilder:validation:Optional
+	Policies int `json:"policies"`
+}
+
+func init() {
+	SchemeBuilder.Register(&EgressIPQuota{}, &EgressIPQuotaList{})
+}
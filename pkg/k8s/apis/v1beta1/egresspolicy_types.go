@@ -24,6 +24,8 @@ type EgressPolicyList struct {
 // +kubebuilder:printcolumn:JSONPath=".status.eip.ipv4",description="ipv4",name="ipv4",type=string
 // +kubebuilder:printcolumn:JSONPath=".status.eip.ipv6",description="ipv6",name="ipv6",type=string
 // +kubebuilder:printcolumn:JSONPath=".status.node",description="egressNode",name="egressNode",type=string
+// +kubebuilder:printcolumn:JSONPath=".status.conditions[?(@.type==\"Ready\")].status",description="ready",name="ready",type=string
+// +kubebuilder:printcolumn:JSONPath=".status.datapath.programmedNodes",description="programmedNodes",name="programmed",type=integer,priority=1
 type EgressPolicy struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata"`
@@ -51,6 +53,38 @@ type EgressPolicyStatus struct {
 	Eip Eip `json:"eip,omitempty"`
 	// +kubebuilder:validation:Optional
 	Node string `json:"node,omitempty"`
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// PodEips maps the StatefulSet pods selected by a sticky policy to their own EIP
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=pod
+	PodEips []PodEip `json:"podEips,omitempty"`
+	// Datapath is aggregated from the EgressAgentStatus of the nodes
+	// +kubebuilder:validation:Optional
+	Datapath *PolicyDatapath `json:"datapath,omitempty"`
+}
+
+type PolicyDatapath struct {
+	// ProgrammedNodes is the number of nodes which programmed the current generation of the policy
+	ProgrammedNodes int `json:"programmedNodes"`
+	// TotalNodes is the number of nodes with an agent
+	TotalNodes int `json:"totalNodes"`
+	// FailedNodes are the nodes which failed to program the policy
+	// +kubebuilder:validation:Optional
+	FailedNodes []string `json:"failedNodes,omitempty"`
+}
+
+type PodEip struct {
+	// Pod is the stable identity of the pod, the StatefulSet name plus the ordinal, e.g. web-0
+	// +kubebuilder:validation:Required
+	Pod string `json:"pod"`
+	// +kubebuilder:validation:Optional
+	IPv4 string `json:"ipv4,omitempty"`
+	// +kubebuilder:validation:Optional
+	IPv6 string `json:"ipv6,omitempty"`
 }
 
 type Eip struct {
@@ -70,6 +104,7 @@ type EgressIP struct {
 	UseNodeIP bool `json:"useNodeIP,omitempty"`
 	// +kubebuilder:validation:Optional
 	// +kubebuilder:default:="default"
+	// +kubebuilder:validation:Enum=default;rr;shared;sticky
 	AllocatorPolicy string `json:"allocatorPolicy,omitempty"`
 }
 
@@ -93,4 +128,38 @@ const (
 	EipAllocatorDefault = "default"
 	// The unassigned EIP is preferred. If no EIP is available, select one at random
 	EipAllocatorRR = "rr"
+	// The unassigned EIP is preferred. If no EIP is available, share the EIP used by the
+	// fewest policies, the EIPs on the selected gateway node first
+	EipAllocatorShared = "shared"
+	// The policy gets an EIP like rr, and every StatefulSet pod it selects gets its own EIP,
+	// kept by the pod ordinal across rescheduling and gateway failover
+	EipAllocatorSticky = "sticky"
+)
+
+const (
+	// PolicyConditionEIPShared is True when the EIP of the policy is also used by other policies
+	PolicyConditionEIPShared = "EIPShared"
+
+	ReasonEIPShared    = "EIPShared"
+	ReasonEIPExclusive = "EIPExclusive"
+)
+
+// The conditions of the EgressPolicies and EgressClusterPolicies, Ready is True when
+// all of them are True
+const (
+	// PolicyConditionEIPAllocated is True when the policy is placed on a gateway node, set by the controller
+	PolicyConditionEIPAllocated = "EIPAllocated"
+	// PolicyConditionEndpointsSynced is True when the endpoint slices of the policy match its pods
+	PolicyConditionEndpointsSynced = "EndpointsSynced"
+	// PolicyConditionDatapathProgrammed is True when the agent of the gateway node applied the rules of the policy
+	PolicyConditionDatapathProgrammed = "DatapathProgrammed"
+
+	ReasonAllocated     = "Allocated"
+	ReasonNotAllocated  = "NotAllocated"
+	ReasonSynced        = "Synced"
+	ReasonSyncFailed    = "SyncFailed"
+	ReasonProgrammed    = "Programmed"
+	ReasonProgramFailed = "ProgramFailed"
+	// ReasonPending is a datapath condition waiting for the agent of a new gateway node
+	ReasonPending = "Pending"
 )
@@ -4,6 +4,9 @@
 package v1beta1
 
 import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -23,6 +26,7 @@ type EgressTunnelList struct {
 // +kubebuilder:printcolumn:JSONPath=".status.tunnel.ipv6",description="tunnelIPv6",name="tunnelIPv6",type=string
 // +kubebuilder:printcolumn:JSONPath=".status.mark",description="mark",name="mark",type=string
 // +kubebuilder:printcolumn:JSONPath=".status.phase",description="phase",name="phase",type=string
+// +kubebuilder:printcolumn:JSONPath=".status.conditions[?(@.type==\"Ready\")].status",description="ready",name="ready",type=string
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 type EgressTunnel struct {
@@ -44,6 +48,10 @@ type EgressTunnelStatus struct {
 	Mark string `json:"mark,omitempty"`
 	// +kubebuilder:validation:Optional
 	LastHeartbeatTime metav1.Time `json:"lastHeartbeatTime,omitempty"`
+	// +kubebuilder:validation:Optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
 type Tunnel struct {
@@ -85,6 +93,26 @@ const (
 
 var ReasonStatusChanged = "StatusChanged"
 
+// SetReadyCondition sets the Ready condition from the phase, the phase is the reason.
+// It reports whether the condition changed.
+func (status *EgressTunnelStatus) SetReadyCondition(generation int64) bool {
+	cond := metav1.Condition{
+		Type:               ConditionReady,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: generation,
+		Reason:             string(status.Phase),
+		Message:            fmt.Sprintf("The phase of the tunnel is %s", status.Phase),
+	}
+	if status.Phase == "" {
+		cond.Status = metav1.ConditionUnknown
+		cond.Reason = string(EgressTunnelPending)
+	}
+	if status.Phase == EgressTunnelReady {
+		cond.Status = metav1.ConditionTrue
+	}
+	return meta.SetStatusCondition(&status.Conditions, cond)
+}
+
 func init() {
 	SchemeBuilder.Register(&EgressTunnel{}, &EgressTunnelList{})
 }
@@ -7,3 +7,26 @@ const (
 	LabelPolicyName                    = "spidernet.io/policy-name"
 	LabelNamespaceEgressGatewayDefault = "spidernet.io/egressgateway-default"
 )
+
+const (
+	// AnnotationPolicyPinned keeps the EIP of a policy on its current gateway node,
+	// the rebalancer never moves an EIP that carries a pinned policy.
+	AnnotationPolicyPinned = "egressgateway.spidernet.io/pinned"
+	// AnnotationReannounce is set on an EgressGateway to a new value to make the gateway
+	// nodes send the takeover announcements of their EIPs again.
+	AnnotationReannounce = "egressgateway.spidernet.io/reannounce"
+	// AnnotationTraceParent is the W3C traceparent of the last change of a policy, set by
+	// the webhook when tracing is enabled, the reconciles of the change join its trace.
+	AnnotationTraceParent = "egressgateway.spidernet.io/traceparent"
+	// AnnotationTraceState is the W3C tracestate of the last change of a policy, it holds
+	// the time the trace of the change started at.
+	AnnotationTraceState = "egressgateway.spidernet.io/tracestate"
+	// AnnotationDefaultGateway is set by the webhook on a policy created without
+	// EgressGatewayName, to the default the gateway was taken from, namespace or cluster.
+	AnnotationDefaultGateway = "egressgateway.spidernet.io/default-gateway"
+)
+
+const (
+	DefaultGatewayNamespace = "namespace"
+	DefaultGatewayCluster   = "cluster"
+)
@@ -1,12 +1,13 @@
 // Copyright 2022 Authors of spidernet-io
 // SPDX-License-Identifier: Apache-2.0
 
-// +kubebuilder:rbac:groups=egressgateway.spidernet.io,resources=egressgateways;egresstunnels;egressclusterpolicies;egresspolicies;egressendpointslices;egressclusterendpointslices;egressclusterinfos,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=egressgateway.spidernet.io,resources=egressgateways/status;egresstunnels/status;egressclusterpolicies/status;egresspolicies/status;egressclusterinfos/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=egressgateway.spidernet.io,resources=egressgateways;egresstunnels;egressclusterpolicies;egresspolicies;egressendpointslices;egressclusterendpointslices;egressclusterinfos;egressippools;egressipquotas;egressagentstatuses,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=egressgateway.spidernet.io,resources=egressgateways/status;egresstunnels/status;egressclusterpolicies/status;egresspolicies/status;egressclusterinfos/status;egressippools/status;egressipquotas/status;egressagentstatuses/status,verbs=get;update;patch
 
 // +kubebuilder:rbac:groups="",resources=events,verbs=create;get;list;watch;update;delete
 // +kubebuilder:rbac:groups="coordination.k8s.io",resources=leases,verbs=create;get;update
 // +kubebuilder:rbac:groups="",resources=nodes;namespaces;endpoints;pods;services,verbs=get;list;watch;update
+// +kubebuilder:rbac:groups="apps",resources=statefulsets,verbs=get;list;watch
 
 // +kubebuilder:rbac:groups=crd.projectcalico.org,resources=ippools,verbs=get;list;watch;create;update;patch;delete
 
@@ -8,6 +8,12 @@
 
 // Changes:
 // * replace logger library
+// * add SetBalancers to reconcile the advertisements of a name to the desired state
+// * responders are accessed through interfaces so that they can be faked in tests
+// * add Probe to detect the hosts which already use an IP before it is announced
+// * the gratuitous burst of an IP follows the Profile of its advertisement, add Reannounce
+// * record the requesters of each IP, add State, the metrics are labeled by gateway and interface
+// * the packets sent from any local interface are not reported as conflicts
 
 package layer2
 
@@ -19,20 +25,41 @@ import (
 	"time"
 
 	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/util/sets"
 
 	"github.com/spidernet-io/egressgateway/pkg/lock"
 )
 
+// responder answers the ARP or NDP requests on an interface.
+type responder interface {
+	Interface() string
+	Gratuitous(ip net.IP) error
+	Probe(ip net.IP) error
+	Close() error
+}
+
+// ndpWatcher is a responder which has to join the solicited-node multicast
+// group of an IP to receive the neighbor solicitations for it.
+type ndpWatcher interface {
+	responder
+	Advertise(ip net.IP, override, router bool) error
+	Watch(ip net.IP) error
+	Unwatch(ip net.IP) error
+}
+
 // Announce is used to "announce" new IPs mapped to the node's MAC address.
 type Announce struct {
 	logger logr.Logger
 
 	lock.RWMutex
-	nodeInterfaces []string // current local interfaces' name list
-	arps           map[int]*arpResponder
-	ndps           map[int]*ndpResponder
+	nodeInterfaces []string         // current local interfaces' name list
+	localMACs      sets.Set[string] // the MACs of all the local interfaces
+	arps           map[int]responder
+	ndps           map[int]ndpWatcher
 	ips            map[string][]IPAdvertisement // svcName -> IPAdvertisements
 	ipRefcnt       map[string]int               // ip.String() -> number of uses
+	probes         map[string]chan Conflict     // ip.String() -> conflicts found while probing
+	requesters     map[string][]Requester       // ip.String() -> the hosts which sent requests for the IP
 
 	// This channel can block - do not write to it while holding the mutex
 	// to avoid deadlocking.
@@ -42,21 +69,34 @@ type Announce struct {
 
 // New returns an initialized Announce.
 func New(l logr.Logger, excludeRegexp *regexp.Regexp) (*Announce, error) {
-	ret := &Announce{
+	ret := newAnnounce(l, excludeRegexp)
+
+	go ret.interfaceScan()
+	go ret.spamLoop()
+
+	return ret, nil
+}
+
+func newAnnounce(l logr.Logger, excludeRegexp *regexp.Regexp) *Announce {
+	return &Announce{
 		logger:         l,
 		nodeInterfaces: []string{},
-		arps:           map[int]*arpResponder{},
-		ndps:           map[int]*ndpResponder{},
+		localMACs:      sets.New[string](),
+		arps:           map[int]responder{},
+		ndps:           map[int]ndpWatcher{},
 		ips:            map[string][]IPAdvertisement{},
 		ipRefcnt:       map[string]int{},
+		probes:         map[string]chan Conflict{},
+		requesters:     map[string][]Requester{},
 		spamCh:         make(chan IPAdvertisement, 1024),
 		excludeRegexp:  excludeRegexp,
 	}
+}
 
-	go ret.interfaceScan()
-	go ret.spamLoop()
-
-	return ret, nil
+// RefreshInterfaces creates the responders of the new interfaces right away instead
+// of waiting for the next scan.
+func (a *Announce) RefreshInterfaces() {
+	a.updateInterfaces()
 }
 
 func (a *Announce) interfaceScan() {
@@ -81,8 +121,14 @@ func (a *Announce) updateInterfaces() {
 
 	keepARP, keepNDP := map[int]bool{}, map[int]bool{}
 	curIfs := make([]string, 0, len(ifs))
+	localMACs := sets.New[string]()
 	for _, intf := range ifs {
 		ifi := intf
+		// a probe sent from an interface is received by the responders of the
+		// others on a multi-homed node, excluded interfaces included
+		if len(ifi.HardwareAddr) != 0 {
+			localMACs.Insert(ifi.HardwareAddr.String())
+		}
 
 		if (a.excludeRegexp != nil) && a.excludeRegexp.MatchString(ifi.Name) {
 			a.logger.V(1).Info("announced interface to exclude interface", "interface", ifi.Name)
@@ -130,7 +176,7 @@ func (a *Announce) updateInterfaces() {
 		}
 
 		if keepARP[ifi.Index] && a.arps[ifi.Index] == nil {
-			resp, err := newARPResponder(a.logger, &ifi, a.shouldAnnounce)
+			resp, err := newARPResponder(a.logger, &ifi, a.shouldAnnounce, a.reportConflict, a.recordRequest)
 			if err != nil {
 				l.Error(err, "failed to create ARP responder", "op", "createARPResponder")
 				continue
@@ -139,7 +185,7 @@ func (a *Announce) updateInterfaces() {
 			l.Info("created ARP responder for interface", "event", "createARPResponder")
 		}
 		if keepNDP[ifi.Index] && a.ndps[ifi.Index] == nil {
-			resp, err := newNDPResponder(a.logger, &ifi, a.shouldAnnounce)
+			resp, err := newNDPResponder(a.logger, &ifi, a.shouldAnnounce, a.reportConflict, a.recordRequest)
 			if err != nil {
 				l.Error(err, "failed to create NDP responder", "op", "createNDPResponder")
 				continue
@@ -150,6 +196,7 @@ func (a *Announce) updateInterfaces() {
 	}
 
 	a.nodeInterfaces = curIfs
+	a.localMACs = localMACs
 
 	for i, client := range a.arps {
 		if !keepARP[i] {
@@ -170,43 +217,59 @@ func (a *Announce) updateInterfaces() {
 // spamLoop is used to send gratuitous ARP or NDP in the network to avoid ARP/NDP
 // cache issues, and to periodically stop spam to maintain network performance.
 func (a *Announce) spamLoop() {
-	// Map IP to spam stop time.
+	// Map IP to the remaining announcements and the time of the next one.
 	type timedSpam struct {
-		until time.Time
+		left int
+		next time.Time
 		IPAdvertisement
 	}
-	m := map[string]timedSpam{}
-	// We can't create a stopped ticker, so create one with a big period to avoid ticking for nothing
-	ticker := time.NewTicker(time.Hour)
-	ticker.Stop()
+	m := map[string]*timedSpam{}
+	// We can't create a stopped timer, so create one with a big period to avoid firing for nothing
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	resetTimer := func() {
+		var next time.Time
+		for _, tSpam := range m {
+			if next.IsZero() || tSpam.next.Before(next) {
+				next = tSpam.next
+			}
+		}
+		if next.IsZero() {
+			timer.Stop()
+			return
+		}
+		timer.Reset(time.Until(next))
+	}
 	for {
 		select {
 		case s := <-a.spamCh:
-			if len(m) == 0 {
-				// See https://github.com/metallb/metallb/issues/172 for the 1100 choice.
-				ticker.Reset(1100 * time.Millisecond)
-			}
 			ipStr := s.ip.String()
-			_, ok := m[ipStr]
-			// Set spam stop time to 5 seconds from now.
-			m[ipStr] = timedSpam{time.Now().Add(5 * time.Second), s}
-			if !ok {
-				// Spam right away to avoid waiting up to 1100 milliseconds even if
-				// it means we call gratuitous() twice in a row in a short amount of time.
+			if tSpam, ok := m[ipStr]; ok {
+				// Restart the burst but keep its pace, the IP was announced a moment ago.
+				tSpam.left = s.profile.Count
+				tSpam.IPAdvertisement = s
+			} else {
+				// Spam right away to avoid waiting for the first interval.
 				a.gratuitous(s)
+				if s.profile.Count > 1 {
+					m[ipStr] = &timedSpam{s.profile.Count - 1, time.Now().Add(s.profile.Interval), s}
+				}
 			}
-		case now := <-ticker.C:
+			resetTimer()
+		case now := <-timer.C:
 			for ipStr, tSpam := range m {
-				if now.After(tSpam.until) {
+				if tSpam.next.After(now) {
+					continue
+				}
+				a.gratuitous(tSpam.IPAdvertisement)
+				tSpam.left--
+				tSpam.next = now.Add(tSpam.profile.Interval)
+				if tSpam.left <= 0 {
 					// We have spammed enough - remove the IP from the map.
 					delete(m, ipStr)
-				} else {
-					a.gratuitous(tSpam.IPAdvertisement)
 				}
 			}
-			if len(m) == 0 {
-				ticker.Stop()
-			}
+			resetTimer()
 		}
 	}
 }
@@ -234,28 +297,33 @@ func (a *Announce) gratuitous(adv IPAdvertisement) {
 		// doing announcements.
 		return
 	}
+	gateway := a.gatewayOf(ip)
 
 	if ip.To4() != nil {
 		for _, client := range a.arps {
-			if !adv.matchInterface(client.intf) {
-				a.logger.V(1).Info("skip interfaces", "op", "gratuitousAnnounce", "interface", client.intf)
+			if !adv.matchInterface(client.Interface()) {
+				a.logger.V(1).Info("skip interfaces", "op", "gratuitousAnnounce", "interface", client.Interface())
 				continue
 			}
 			if err := client.Gratuitous(ip); err != nil {
 				a.logger.Error(err, "failed to make gratuitous ARP announcement",
 					"op", "gratuitousAnnounce", "ip", ip)
+				continue
 			}
+			stats.SentGratuitous(gateway, client.Interface(), ip.String())
 		}
 	} else {
 		for _, client := range a.ndps {
-			if !adv.matchInterface(client.intf) {
-				a.logger.V(1).Info("skip interfaces", "op", "gratuitousAnnounce", "interface", client.intf)
+			if !adv.matchInterface(client.Interface()) {
+				a.logger.V(1).Info("skip interfaces", "op", "gratuitousAnnounce", "interface", client.Interface())
 				continue
 			}
-			if err := client.Gratuitous(ip); err != nil {
+			if err := client.Advertise(ip, adv.profile.Override, adv.profile.Router); err != nil {
 				a.logger.Error(err, "failed to make gratuitous NDP announcement",
 					"op", "gratuitousAnnounce", "ip", ip)
+				continue
 			}
+			stats.SentGratuitous(gateway, client.Interface(), ip.String())
 		}
 	}
 }
@@ -298,50 +366,225 @@ func (a *Announce) SetBalancer(name string, adv IPAdvertisement) {
 		}
 	}
 	a.ips[name] = append(a.ips[name], adv)
+	a.acquire(adv.ip)
+}
+
+// DeleteBalancer deletes an address from the set of addresses we should announce.
+func (a *Announce) DeleteBalancer(name string) {
+	a.Lock()
+	defer a.Unlock()
+
+	advs, ok := a.ips[name]
+	if !ok {
+		return
+	}
+	delete(a.ips, name)
+
+	for _, cur := range advs {
+		a.release(cur.ip)
+	}
+}
+
+// SetBalancers replaces the advertisements of name with advs. The IPs which are
+// no longer in advs are withdrawn, the responders stop answering for them unless
+// another name still uses them. Only the new or changed advertisements are
+// announced with gratuitous packets.
+func (a *Announce) SetBalancers(name string, advs []IPAdvertisement) {
+	var spam []IPAdvertisement
+	// Call doSpam at the end of the function without holding the lock
+	defer func() {
+		for _, adv := range spam {
+			a.doSpam(adv)
+		}
+	}()
+	a.Lock()
+	defer a.Unlock()
+
+	desired := make(map[string]IPAdvertisement, len(advs))
+	for _, adv := range advs {
+		desired[adv.ip.String()] = adv
+	}
+
+	current := make(map[string]IPAdvertisement, len(a.ips[name]))
+	for _, cur := range a.ips[name] {
+		current[cur.ip.String()] = cur
+		if _, ok := desired[cur.ip.String()]; !ok {
+			a.logger.V(1).Info("withdraw the advertisement", "name", name, "ip", cur.ip)
+			a.release(cur.ip)
+		}
+	}
+
+	res := make([]IPAdvertisement, 0, len(desired))
+	for _, adv := range advs {
+		ip := adv.ip.String()
+		if _, ok := desired[ip]; !ok {
+			// duplicated in advs
+			continue
+		}
+		delete(desired, ip)
+		res = append(res, adv)
+
+		cur, ok := current[ip]
+		if !ok {
+			a.acquire(adv.ip)
+		}
+		if !ok || !cur.Equal(&adv) {
+			spam = append(spam, adv)
+		}
+	}
+
+	if len(res) == 0 {
+		delete(a.ips, name)
+		return
+	}
+	a.ips[name] = res
+}
 
-	a.ipRefcnt[adv.ip.String()]++
-	if a.ipRefcnt[adv.ip.String()] > 1 {
+// Reannounce sends the gratuitous burst of all the advertisements of name again,
+// e.g. after the upstream routers missed the announcements of a failover.
+func (a *Announce) Reannounce(name string) {
+	a.RLock()
+	advs := append([]IPAdvertisement(nil), a.ips[name]...)
+	a.RUnlock()
+
+	for _, adv := range advs {
+		a.doSpam(adv)
+	}
+}
+
+// acquire increases the refcount of ip, the NDP responders watch it on the first use.
+// The caller must hold the lock.
+func (a *Announce) acquire(ip net.IP) {
+	a.ipRefcnt[ip.String()]++
+	if a.ipRefcnt[ip.String()] > 1 {
 		// Multiple services are using this IP, so there's nothing
 		// else to do right now.
 		return
 	}
-
 	for _, client := range a.ndps {
-		if err := client.Watch(adv.ip); err != nil {
+		if err := client.Watch(ip); err != nil {
 			a.logger.Error(err, "failed to watch NDP multicast group for IP, NDP responder will not respond to requests for this address",
-				"op", "watchMulticastGroup", "ip", adv.ip, "interface", client.intf,
+				"op", "watchMulticastGroup", "ip", ip, "interface", client.Interface(),
 			)
 		}
 	}
 }
 
-// DeleteBalancer deletes an address from the set of addresses we should announce.
-func (a *Announce) DeleteBalancer(name string) {
+// release decreases the refcount of ip, the NDP responders unwatch it on the last use.
+// The caller must hold the lock.
+func (a *Announce) release(ip net.IP) {
+	a.ipRefcnt[ip.String()]--
+	if a.ipRefcnt[ip.String()] > 0 {
+		// Another service is still using this IP, don't touch any
+		// more things.
+		return
+	}
+	delete(a.ipRefcnt, ip.String())
+	delete(a.requesters, ip.String())
+
+	for _, client := range a.ndps {
+		if err := client.Unwatch(ip); err != nil {
+			a.logger.Error(err, "failed to unwatch NDP multicast group for IP",
+				"op", "unwatchMulticastGroup", "ip", ip, "interface", client.Interface(),
+			)
+		}
+	}
+}
+
+// Conflict is a host found using, or probing, an IP being probed.
+type Conflict struct {
+	IP        net.IP
+	MAC       net.HardwareAddr
+	Interface string
+}
+
+// Probe checks whether another host on the segment uses the IP of adv before it
+// is announced, like the ARP probes of RFC 5227 and the IPv6 DAD. count probes are
+// sent interval apart on the interfaces of adv, the first host which answers, or
+// probes the same IP, is returned as the conflict.
+func (a *Announce) Probe(adv IPAdvertisement, count int, interval time.Duration) (*Conflict, error) {
+	key := adv.ip.String()
+	ch := make(chan Conflict, 1)
 	a.Lock()
-	defer a.Unlock()
+	a.probes[key] = ch
+	a.Unlock()
+	defer func() {
+		a.Lock()
+		delete(a.probes, key)
+		a.Unlock()
+	}()
+
+	for i := 0; i < count; i++ {
+		if err := a.sendProbe(adv); err != nil {
+			return nil, err
+		}
+		select {
+		case c := <-ch:
+			return &c, nil
+		case <-time.After(interval):
+		}
+	}
+	return nil, nil
+}
 
-	advs, ok := a.ips[name]
-	if !ok {
-		return
+func (a *Announce) sendProbe(adv IPAdvertisement) error {
+	a.RLock()
+	defer a.RUnlock()
+
+	var clients []responder
+	if adv.ip.To4() != nil {
+		for _, client := range a.arps {
+			clients = append(clients, client)
+		}
+	} else {
+		for _, client := range a.ndps {
+			clients = append(clients, client)
+		}
 	}
-	delete(a.ips, name)
 
-	for _, cur := range advs {
-		a.ipRefcnt[cur.ip.String()]--
-		if a.ipRefcnt[cur.ip.String()] > 0 {
-			// Another service is still using this IP, don't touch any
-			// more things.
+	var probeErr error
+	sent := false
+	for _, client := range clients {
+		if !adv.matchInterface(client.Interface()) {
 			continue
 		}
+		if err := client.Probe(adv.ip); err != nil {
+			a.logger.Error(err, "failed to send probe", "op", "probe", "ip", adv.ip, "interface", client.Interface())
+			probeErr = err
+			continue
+		}
+		sent = true
+	}
+	if !sent && probeErr != nil {
+		return probeErr
+	}
+	return nil
+}
 
-		for _, client := range a.ndps {
-			if err := client.Unwatch(cur.ip); err != nil {
-				a.logger.Error(err, "failed to unwatch NDP multicast group for IP",
-					"op", "unwatchMulticastGroup", "ip", cur.ip, "interface", client.intf,
-				)
-			}
+func (a *Announce) reportConflict(ip net.IP, mac net.HardwareAddr, intf string) {
+	a.RLock()
+	ch, ok := a.probes[ip.String()]
+	local := a.localMACs.Has(mac.String())
+	a.RUnlock()
+	if !ok || local {
+		return
+	}
+	select {
+	case ch <- Conflict{IP: ip, MAC: mac, Interface: intf}:
+	default:
+	}
+}
+
+// AnnounceIP returns true when ip is announced under name.
+func (a *Announce) AnnounceIP(name string, ip net.IP) bool {
+	a.RLock()
+	defer a.RUnlock()
+	for _, adv := range a.ips[name] {
+		if adv.ip.Equal(ip) {
+			return true
 		}
 	}
+	return false
 }
 
 // AnnounceName returns true when we have an announcement under name.
@@ -0,0 +1,323 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package layer2
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/spidernet-io/egressgateway/pkg/logger"
+)
+
+// fakeResponder records the calls instead of sending packets
+type fakeResponder struct {
+	intf       string
+	gratuitous []string
+	watched    map[string]int
+	probes     int
+	// flags are the override and router flags of the last neighbor advertisement
+	flags [2]bool
+	// onProbe simulates the hosts on the segment
+	onProbe func(ip net.IP)
+}
+
+func newFakeResponder(intf string) *fakeResponder {
+	return &fakeResponder{intf: intf, watched: map[string]int{}}
+}
+
+func (f *fakeResponder) Interface() string { return f.intf }
+
+func (f *fakeResponder) Close() error { return nil }
+
+func (f *fakeResponder) Gratuitous(ip net.IP) error {
+	f.gratuitous = append(f.gratuitous, ip.String())
+	return nil
+}
+
+func (f *fakeResponder) Advertise(ip net.IP, override, router bool) error {
+	f.flags = [2]bool{override, router}
+	return f.Gratuitous(ip)
+}
+
+func (f *fakeResponder) Probe(ip net.IP) error {
+	f.probes++
+	if f.onProbe != nil {
+		// the answers are received by the goroutine of the responder
+		go f.onProbe(ip)
+	}
+	return nil
+}
+
+func (f *fakeResponder) Watch(ip net.IP) error {
+	f.watched[ip.String()]++
+	return nil
+}
+
+func (f *fakeResponder) Unwatch(ip net.IP) error {
+	f.watched[ip.String()]--
+	if f.watched[ip.String()] == 0 {
+		delete(f.watched, ip.String())
+	}
+	return nil
+}
+
+func newTestAnnounce() (*Announce, *fakeResponder, *fakeResponder) {
+	a := newAnnounce(logger.NewLogger(logger.Config{}), nil)
+	arp := newFakeResponder("eth0")
+	ndp := newFakeResponder("eth0")
+	a.arps[1] = arp
+	a.ndps[1] = ndp
+	return a, arp, ndp
+}
+
+func adv(ip string) IPAdvertisement {
+	return NewIPAdvertisement(net.ParseIP(ip), true, sets.Set[string]{})
+}
+
+// drainSpam returns the IPs queued for gratuitous announcements
+func drainSpam(a *Announce) []string {
+	var res []string
+	for {
+		select {
+		case item := <-a.spamCh:
+			res = append(res, item.ip.String())
+		default:
+			return res
+		}
+	}
+}
+
+func TestSetBalancers(t *testing.T) {
+	cases := map[string]struct {
+		// init is set for egw1 before advs
+		init      []string
+		advs      []string
+		others    map[string][]string
+		expSpam   []string
+		expAnswer []string
+		expDrop   []string
+		expWatch  map[string]int
+	}{
+		"announce new IPs": {
+			advs:      []string{"10.6.1.21", "fd00::21"},
+			expSpam:   []string{"10.6.1.21", "fd00::21"},
+			expAnswer: []string{"10.6.1.21", "fd00::21"},
+			expWatch:  map[string]int{"10.6.1.21": 1, "fd00::21": 1},
+		},
+		"withdraw the IP moved to another node": {
+			init:      []string{"10.6.1.21", "10.6.1.22", "fd00::21"},
+			advs:      []string{"10.6.1.21"},
+			expAnswer: []string{"10.6.1.21"},
+			expDrop:   []string{"10.6.1.22", "fd00::21"},
+			expWatch:  map[string]int{"10.6.1.21": 1},
+		},
+		"replace the IP": {
+			init:      []string{"10.6.1.21"},
+			advs:      []string{"10.6.1.22"},
+			expSpam:   []string{"10.6.1.22"},
+			expAnswer: []string{"10.6.1.22"},
+			expDrop:   []string{"10.6.1.21"},
+			expWatch:  map[string]int{"10.6.1.22": 1},
+		},
+		"withdraw all": {
+			init:     []string{"10.6.1.21", "fd00::21"},
+			expDrop:  []string{"10.6.1.21", "fd00::21"},
+			expWatch: map[string]int{},
+		},
+		"keep the IP used by another name": {
+			init:      []string{"10.6.1.21"},
+			others:    map[string][]string{"egw2": {"10.6.1.21"}},
+			expAnswer: []string{"10.6.1.21"},
+			expWatch:  map[string]int{"10.6.1.21": 1},
+		},
+		"ignore duplicated IPs": {
+			advs:      []string{"10.6.1.21", "10.6.1.21"},
+			expSpam:   []string{"10.6.1.21"},
+			expAnswer: []string{"10.6.1.21"},
+			expWatch:  map[string]int{"10.6.1.21": 1},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			a, _, ndp := newTestAnnounce()
+			toAdvs := func(ips []string) []IPAdvertisement {
+				var res []IPAdvertisement
+				for _, ip := range ips {
+					res = append(res, adv(ip))
+				}
+				return res
+			}
+
+			for other, ips := range c.others {
+				a.SetBalancers(other, toAdvs(ips))
+			}
+			a.SetBalancers("egw1", toAdvs(c.init))
+			drainSpam(a)
+
+			a.SetBalancers("egw1", toAdvs(c.advs))
+			assert.Equal(t, c.expSpam, drainSpam(a))
+			assert.Equal(t, len(c.advs) > 0, a.AnnounceName("egw1"))
+
+			for _, ip := range c.expAnswer {
+				assert.Equal(t, dropReasonNone, a.shouldAnnounce(net.ParseIP(ip), "eth0"), ip)
+			}
+			for _, ip := range c.expDrop {
+				assert.Equal(t, dropReasonAnnounceIP, a.shouldAnnounce(net.ParseIP(ip), "eth0"), ip)
+			}
+			assert.Equal(t, c.expWatch, ndp.watched)
+
+			// a second reconcile with the same state is a no-op
+			a.SetBalancers("egw1", toAdvs(c.advs))
+			assert.Empty(t, drainSpam(a))
+			assert.Equal(t, c.expWatch, ndp.watched)
+		})
+	}
+}
+
+func TestGratuitousAfterWithdraw(t *testing.T) {
+	a, arp, ndp := newTestAnnounce()
+
+	a.SetBalancers("egw1", []IPAdvertisement{adv("10.6.1.21"), adv("fd00::21")})
+	for _, item := range drainSpam(a) {
+		a.gratuitous(adv(item))
+	}
+	assert.Equal(t, []string{"10.6.1.21"}, arp.gratuitous)
+	assert.Equal(t, []string{"fd00::21"}, ndp.gratuitous)
+
+	// the EIPs moved to another node, the queued announcements are not sent anymore
+	a.SetBalancers("egw1", nil)
+	a.gratuitous(adv("10.6.1.21"))
+	a.gratuitous(adv("fd00::21"))
+	assert.Equal(t, []string{"10.6.1.21"}, arp.gratuitous)
+	assert.Equal(t, []string{"fd00::21"}, ndp.gratuitous)
+	assert.Empty(t, a.ipRefcnt)
+}
+
+func TestSpamLoop(t *testing.T) {
+	a, arp, ndp := newTestAnnounce()
+	go a.spamLoop()
+
+	profile := Profile{Count: 3, Interval: 20 * time.Millisecond, Router: true}
+	a.SetBalancers("egw1", []IPAdvertisement{
+		adv("10.6.1.21").WithProfile(profile),
+		adv("fd00::21").WithProfile(profile),
+	})
+
+	// the responders are called by the loop while it holds the read lock
+	sent := func() (int, int) {
+		a.Lock()
+		defer a.Unlock()
+		return len(arp.gratuitous), len(ndp.gratuitous)
+	}
+	assert.Eventually(t, func() bool {
+		v4, v6 := sent()
+		return v4 == 3 && v6 == 3
+	}, time.Second, 10*time.Millisecond)
+
+	// the burst stops after Count announcements
+	time.Sleep(100 * time.Millisecond)
+	v4, v6 := sent()
+	assert.Equal(t, 3, v4)
+	assert.Equal(t, 3, v6)
+	a.Lock()
+	assert.Equal(t, [2]bool{false, true}, ndp.flags)
+	a.Unlock()
+}
+
+func TestReannounce(t *testing.T) {
+	a, _, _ := newTestAnnounce()
+
+	a.SetBalancers("egw1", []IPAdvertisement{adv("10.6.1.21"), adv("fd00::21")})
+	drainSpam(a)
+
+	a.Reannounce("egw1")
+	assert.Equal(t, []string{"10.6.1.21", "fd00::21"}, drainSpam(a))
+	a.Reannounce("egw2")
+	assert.Empty(t, drainSpam(a))
+}
+
+func TestDeleteBalancer(t *testing.T) {
+	a, _, ndp := newTestAnnounce()
+
+	a.SetBalancer("egw1", adv("fd00::21"))
+	a.SetBalancer("egw2", adv("fd00::21"))
+	a.DeleteBalancer("egw1")
+	assert.Equal(t, dropReasonNone, a.shouldAnnounce(net.ParseIP("fd00::21"), "eth0"))
+	assert.Equal(t, map[string]int{"fd00::21": 1}, ndp.watched)
+
+	a.DeleteBalancer("egw2")
+	assert.Equal(t, dropReasonAnnounceIP, a.shouldAnnounce(net.ParseIP("fd00::21"), "eth0"))
+	assert.Empty(t, ndp.watched)
+}
+
+func TestProbe(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+	cases := map[string]struct {
+		ip       string
+		conflict bool
+		expProbe int
+	}{
+		"free ipv4":     {ip: "10.6.1.21", expProbe: 3},
+		"conflict ipv4": {ip: "10.6.1.21", conflict: true, expProbe: 1},
+		"free ipv6":     {ip: "fd00::21", expProbe: 3},
+		"conflict ipv6": {ip: "fd00::21", conflict: true, expProbe: 1},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			a, arp, ndp := newTestAnnounce()
+			conflict := c.conflict
+			// another host answers for 10.6.1.99 all the time, it is not probed
+			onProbe := func(ip net.IP) {
+				a.reportConflict(net.ParseIP("10.6.1.99"), mac, "eth0")
+				if conflict {
+					a.reportConflict(ip, mac, "eth0")
+				}
+			}
+			arp.onProbe = onProbe
+			ndp.onProbe = onProbe
+
+			res, err := a.Probe(adv(c.ip), 3, 100*time.Millisecond)
+			assert.NoError(t, err)
+			if c.conflict {
+				assert.Equal(t, &Conflict{IP: net.ParseIP(c.ip), MAC: mac, Interface: "eth0"}, res)
+			} else {
+				assert.Nil(t, res)
+			}
+			assert.Equal(t, c.expProbe, arp.probes+ndp.probes)
+			assert.Empty(t, a.probes)
+		})
+	}
+}
+
+func TestProbeLocalInterfaces(t *testing.T) {
+	a, arp, _ := newTestAnnounce()
+	eth0 := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x10}
+	eth1 := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x11}
+	a.localMACs.Insert(eth0.String(), eth1.String())
+	a.arps[2] = newFakeResponder("eth1")
+
+	// the probe sent from eth0 is received by the responder of eth1
+	arp.onProbe = func(ip net.IP) {
+		a.reportConflict(ip, eth0, "eth1")
+	}
+	res, err := a.Probe(adv("10.6.1.21"), 2, 100*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Nil(t, res)
+
+	// another host is still a conflict
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+	arp.onProbe = func(ip net.IP) {
+		a.reportConflict(ip, eth1, "eth0")
+		a.reportConflict(ip, mac, "eth0")
+	}
+	res, err = a.Probe(adv("10.6.1.21"), 2, 100*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, &Conflict{IP: net.ParseIP("10.6.1.21"), MAC: mac, Interface: "eth0"}, res)
+}
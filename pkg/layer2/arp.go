@@ -22,6 +22,12 @@ import (
 
 type announceFunc func(net.IP, string) dropReason
 
+// conflictFunc is called with the hosts seen using, or probing, an IP
+type conflictFunc func(ip net.IP, mac net.HardwareAddr, intf string)
+
+// requestFunc records a request which is answered, it returns the gateway of ip
+type requestFunc func(ip net.IP, intf string, sender net.IP, mac net.HardwareAddr) string
+
 type arpResponder struct {
 	logger       logr.Logger
 	intf         string
@@ -29,9 +35,11 @@ type arpResponder struct {
 	conn         *arp.Client
 	closed       chan struct{}
 	announce     announceFunc
+	conflict     conflictFunc
+	request      requestFunc
 }
 
-func newARPResponder(logger logr.Logger, ifi *net.Interface, ann announceFunc) (*arpResponder, error) {
+func newARPResponder(logger logr.Logger, ifi *net.Interface, ann announceFunc, conflict conflictFunc, request requestFunc) (*arpResponder, error) {
 	client, err := arp.Dial(ifi)
 	if err != nil {
 		return nil, fmt.Errorf("creating ARP responder for %q: %s", ifi.Name, err)
@@ -44,6 +52,8 @@ func newARPResponder(logger logr.Logger, ifi *net.Interface, ann announceFunc) (
 		conn:         client,
 		closed:       make(chan struct{}),
 		announce:     ann,
+		conflict:     conflict,
+		request:      request,
 	}
 	go ret.run()
 	return ret, nil
@@ -65,7 +75,19 @@ func (a *arpResponder) Gratuitous(ip net.IP) error {
 		if err = a.conn.WriteTo(pkt, ethernet.Broadcast); err != nil {
 			return fmt.Errorf("writing %q gratuitous packet for %q: %s", op, ip, err)
 		}
-		stats.SentGratuitous(ip.String())
+	}
+	return nil
+}
+
+// Probe sends an ARP probe for ip, RFC 5227 section 2.1.1. The sender IP is
+// unspecified so that the ARP caches of the other hosts are not changed.
+func (a *arpResponder) Probe(ip net.IP) error {
+	pkt, err := arp.NewPacket(arp.OperationRequest, a.hardwareAddr, net.IPv4zero, make(net.HardwareAddr, len(a.hardwareAddr)), ip)
+	if err != nil {
+		return fmt.Errorf("assembling probe packet for %q: %s", ip, err)
+	}
+	if err = a.conn.WriteTo(pkt, ethernet.Broadcast); err != nil {
+		return fmt.Errorf("writing probe packet for %q: %s", ip, err)
 	}
 	return nil
 }
@@ -92,6 +114,16 @@ func (a *arpResponder) processRequest() dropReason {
 		return dropReasonError
 	}
 
+	// Another host which uses the sender IP, or probes the target IP, conflicts
+	// with us if we are probing the same IP.
+	if !bytes.Equal(pkt.SenderHardwareAddr, a.hardwareAddr) {
+		if pkt.SenderIP.IsUnspecified() {
+			a.conflict(pkt.TargetIP, pkt.SenderHardwareAddr, a.intf)
+		} else {
+			a.conflict(pkt.SenderIP, pkt.SenderHardwareAddr, a.intf)
+		}
+	}
+
 	// Ignore ARP replies.
 	if pkt.Operation != arp.OperationRequest {
 		return dropReasonARPReply
@@ -112,7 +144,8 @@ func (a *arpResponder) processRequest() dropReason {
 		return reason
 	}
 
-	stats.GotRequest(pkt.TargetIP.String())
+	gateway := a.request(pkt.TargetIP, a.intf, pkt.SenderIP, pkt.SenderHardwareAddr)
+	stats.GotRequest(gateway, a.intf, pkt.TargetIP.String())
 	a.logger.V(1).Info("got ARP request for service IP, sending response",
 		"interface", a.intf,
 		"ip", pkt.TargetIP,
@@ -131,7 +164,7 @@ func (a *arpResponder) processRequest() dropReason {
 			"responseMAC", a.hardwareAddr,
 		)
 	} else {
-		stats.SentResponse(pkt.TargetIP.String())
+		stats.SentResponse(gateway, a.intf, pkt.TargetIP.String())
 	}
 	return dropReasonNone
 }
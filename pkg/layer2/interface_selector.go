@@ -0,0 +1,85 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package layer2
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// InterfaceSelector selects the interfaces an IP is advertised on, an interface
+// is selected when its name, or one of its addresses, matches any of the rules.
+type InterfaceSelector struct {
+	names   sets.Set[string]
+	regexps []*regexp.Regexp
+	subnets []*net.IPNet
+}
+
+func NewInterfaceSelector(names, regexps, subnets []string) (*InterfaceSelector, error) {
+	s := &InterfaceSelector{names: sets.New[string](names...)}
+	for _, item := range regexps {
+		reg, err := regexp.Compile(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interface regexp %q: %w", item, err)
+		}
+		s.regexps = append(s.regexps, reg)
+	}
+	for _, item := range subnets {
+		_, ipn, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interface subnet %q: %w", item, err)
+		}
+		s.subnets = append(s.subnets, ipn)
+	}
+	return s, nil
+}
+
+// Match returns true if the interface with the name and addresses is selected.
+func (s *InterfaceSelector) Match(name string, addrs []net.Addr) bool {
+	if s.names.Has(name) {
+		return true
+	}
+	for _, reg := range s.regexps {
+		if reg.MatchString(name) {
+			return true
+		}
+	}
+	for _, addr := range addrs {
+		ipn, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		for _, subnet := range s.subnets {
+			if subnet.Contains(ipn.IP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Interfaces returns the names of the local interfaces which are selected.
+func (s *InterfaceSelector) Interfaces() (sets.Set[string], error) {
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	res := sets.New[string]()
+	for _, ifi := range ifs {
+		var addrs []net.Addr
+		if len(s.subnets) > 0 {
+			addrs, err = ifi.Addrs()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get the addresses of interface %s: %w", ifi.Name, err)
+			}
+		}
+		if s.Match(ifi.Name, addrs) {
+			res.Insert(ifi.Name)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package layer2
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestInterfaceSelector(t *testing.T) {
+	addr := func(cidr string) net.Addr {
+		ip, ipn, _ := net.ParseCIDR(cidr)
+		ipn.IP = ip
+		return ipn
+	}
+
+	cases := map[string]struct {
+		names   []string
+		regexps []string
+		subnets []string
+		intf    string
+		addrs   []net.Addr
+		expErr  bool
+		exp     bool
+	}{
+		"name": {
+			names: []string{"eth1"},
+			intf:  "eth1",
+			exp:   true,
+		},
+		"name not match": {
+			names: []string{"eth1"},
+			intf:  "eth10",
+		},
+		"vlan regexp": {
+			regexps: []string{`^eth1\.\d+$`},
+			intf:    "eth1.100",
+			exp:     true,
+		},
+		"ipv4 subnet": {
+			subnets: []string{"10.6.0.0/16"},
+			intf:    "ens192",
+			addrs:   []net.Addr{addr("172.18.0.2/16"), addr("10.6.1.5/16")},
+			exp:     true,
+		},
+		"ipv6 subnet": {
+			subnets: []string{"fd00:6::/64"},
+			intf:    "ens192",
+			addrs:   []net.Addr{addr("fd00:6::5/64")},
+			exp:     true,
+		},
+		"subnet not match": {
+			subnets: []string{"10.6.0.0/16"},
+			intf:    "ens192",
+			addrs:   []net.Addr{addr("172.18.0.2/16")},
+		},
+		"invalid regexp": {
+			regexps: []string{"eth("},
+			expErr:  true,
+		},
+		"invalid subnet": {
+			subnets: []string{"10.6.0.0"},
+			expErr:  true,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			s, err := NewInterfaceSelector(c.names, c.regexps, c.subnets)
+			if c.expErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.exp, s.Match(c.intf, c.addrs))
+		})
+	}
+}
+
+func TestShouldAnnounceInterfaces(t *testing.T) {
+	a, _, _ := newTestAnnounce()
+	ip := net.ParseIP("10.6.1.21")
+	a.SetBalancers("egw1", []IPAdvertisement{NewIPAdvertisement(ip, false, sets.New[string]("eth1"))})
+
+	assert.Equal(t, dropReasonNone, a.shouldAnnounce(ip, "eth1"))
+	assert.Equal(t, dropReasonNotMatchInterface, a.shouldAnnounce(ip, "eth0"))
+
+	// the gratuitous packets are only sent on the selected interfaces
+	arp := newFakeResponder("eth1")
+	a.arps[2] = arp
+	a.gratuitous(NewIPAdvertisement(ip, false, sets.New[string]("eth1")))
+	assert.Equal(t, []string{"10.6.1.21"}, arp.gratuitous)
+	assert.Empty(t, a.arps[1].(*fakeResponder).gratuitous)
+}
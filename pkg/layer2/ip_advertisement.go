@@ -10,15 +10,30 @@ package layer2
 
 import (
 	"net"
+	"time"
 
 	"k8s.io/apimachinery/pkg/util/sets"
 )
 
+// Profile is the burst of gratuitous ARP or unsolicited NA sent when an IP is announced.
+type Profile struct {
+	Count    int
+	Interval time.Duration
+	// Override and Router are the flags of the unsolicited neighbor advertisements
+	Override bool
+	Router   bool
+}
+
+// DefaultProfile is close to the 5 seconds burst of the original spam loop.
+// See https://github.com/metallb/metallb/issues/172 for the 1100 choice.
+var DefaultProfile = Profile{Count: 5, Interval: 1100 * time.Millisecond, Override: true}
+
 // IPAdvertisement is the advertisement Info about LB IP.
 type IPAdvertisement struct {
 	ip            net.IP
 	interfaces    sets.Set[string]
 	allInterfaces bool
+	profile       Profile
 }
 
 func NewIPAdvertisement(ip net.IP, allInterfaces bool, interfaces sets.Set[string]) IPAdvertisement {
@@ -26,9 +41,21 @@ func NewIPAdvertisement(ip net.IP, allInterfaces bool, interfaces sets.Set[strin
 		ip:            ip,
 		interfaces:    interfaces,
 		allInterfaces: allInterfaces,
+		profile:       DefaultProfile,
 	}
 }
 
+// WithProfile returns a copy of the advertisement which is announced with p.
+func (i IPAdvertisement) WithProfile(p Profile) IPAdvertisement {
+	i.profile = p
+	return i
+}
+
+func (i *IPAdvertisement) IP() net.IP {
+	return i.ip
+}
+
+// Equal ignores the profile, a new profile is used by the next announcement.
 func (i *IPAdvertisement) Equal(other *IPAdvertisement) bool {
 	if i == nil && other == nil {
 		return true
@@ -9,6 +9,7 @@
 package layer2
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -25,12 +26,14 @@ type ndpResponder struct {
 	conn         *ndp.Conn
 	closed       chan struct{}
 	announce     announceFunc
+	conflict     conflictFunc
+	request      requestFunc
 	// Refcount of how many watchers for each solicited node
 	// multicast group.
 	solicitedNodeGroups map[string]int64
 }
 
-func newNDPResponder(logger logr.Logger, ifi *net.Interface, ann announceFunc) (*ndpResponder, error) {
+func newNDPResponder(logger logr.Logger, ifi *net.Interface, ann announceFunc, conflict conflictFunc, request requestFunc) (*ndpResponder, error) {
 	// Use link-local address as the source IPv6 address for NDP communications.
 	conn, _, err := ndp.Dial(ifi, ndp.LinkLocal)
 	if err != nil {
@@ -44,6 +47,8 @@ func newNDPResponder(logger logr.Logger, ifi *net.Interface, ann announceFunc) (
 		conn:                conn,
 		closed:              make(chan struct{}),
 		announce:            ann,
+		conflict:            conflict,
+		request:             request,
 		solicitedNodeGroups: map[string]int64{},
 	}
 	go ret.run()
@@ -58,9 +63,32 @@ func (n *ndpResponder) Close() error {
 }
 
 func (n *ndpResponder) Gratuitous(ip net.IP) error {
-	err := n.advertise(net.IPv6linklocalallnodes, ip, true)
-	stats.SentGratuitous(ip.String())
-	return err
+	return n.Advertise(ip, true, false)
+}
+
+// Advertise sends an unsolicited neighbor advertisement for ip to all nodes
+// with the given override and router flags.
+func (n *ndpResponder) Advertise(ip net.IP, override, router bool) error {
+	return n.advertise(net.IPv6linklocalallnodes, ip, false, override, router)
+}
+
+// Probe sends a neighbor solicitation for ip to its solicited-node multicast
+// group, the host which uses ip answers with a neighbor advertisement.
+func (n *ndpResponder) Probe(ip net.IP) error {
+	group, err := ndp.SolicitedNodeMulticast(ip)
+	if err != nil {
+		return fmt.Errorf("looking up solicited node multicast group for %q: %s", ip, err)
+	}
+	m := &ndp.NeighborSolicitation{
+		TargetAddress: ip,
+		Options: []ndp.Option{
+			&ndp.LinkLayerAddress{
+				Direction: ndp.Source,
+				Addr:      n.hardwareAddr,
+			},
+		},
+	}
+	return n.conn.WriteTo(m, nil, group)
 }
 
 func (n *ndpResponder) Watch(ip net.IP) error {
@@ -116,6 +144,19 @@ func (n *ndpResponder) processRequest() dropReason {
 		return dropReasonError
 	}
 
+	if na, ok := msg.(*ndp.NeighborAdvertisement); ok {
+		// Another host advertises the target address, it conflicts with us if
+		// we are probing the same IP.
+		for _, o := range na.Options {
+			lla, ok := o.(*ndp.LinkLayerAddress)
+			if ok && lla.Direction == ndp.Target && !bytes.Equal(lla.Addr, n.hardwareAddr) {
+				n.conflict(na.TargetAddress, lla.Addr, n.intf)
+				break
+			}
+		}
+		return dropReasonMessageType
+	}
+
 	ns, ok := msg.(*ndp.NeighborSolicitation)
 	if !ok {
 		return dropReasonMessageType
@@ -149,20 +190,22 @@ func (n *ndpResponder) processRequest() dropReason {
 		return reason
 	}
 
-	stats.GotRequest(ns.TargetAddress.String())
+	gateway := n.request(ns.TargetAddress, n.intf, src, nsLLAddr)
+	stats.GotRequest(gateway, n.intf, ns.TargetAddress.String())
 	n.logger.V(1).Info("got NDP request for service IP, sending response", "interface", n.intf, "ip", ns.TargetAddress, "senderIP", src, "senderLLAddr", nsLLAddr, "responseMAC", n.hardwareAddr)
-	if err := n.advertise(src, ns.TargetAddress, false); err != nil {
+	if err := n.advertise(src, ns.TargetAddress, true, false, false); err != nil {
 		n.logger.Error(err, "failed to send ARP reply", "op", "ndpReply", "interface", n.intf, "ip", ns.TargetAddress, "senderIP", src, "senderLLAddr", nsLLAddr, "responseMAC", n.hardwareAddr)
 	} else {
-		stats.SentResponse(ns.TargetAddress.String())
+		stats.SentResponse(gateway, n.intf, ns.TargetAddress.String())
 	}
 	return dropReasonNone
 }
 
-func (n *ndpResponder) advertise(dst, target net.IP, gratuitous bool) error {
+func (n *ndpResponder) advertise(dst, target net.IP, solicited, override, router bool) error {
 	m := &ndp.NeighborAdvertisement{
-		Solicited:     !gratuitous, // <Adam Jensen> I never asked for this...
-		Override:      gratuitous,  // Should clients replace existing cache entries
+		Router:        router,
+		Solicited:     solicited, // <Adam Jensen> I never asked for this...
+		Override:      override,  // Should clients replace existing cache entries
 		TargetAddress: target,
 		Options: []ndp.Option{
 			&ndp.LinkLayerAddress{
@@ -0,0 +1,179 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package layer2
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// maxRequesters is the number of requesters kept for each IP, the least recently
+// seen one is dropped first.
+const maxRequesters = 16
+
+// Requester is a host which sent ARP or NDP requests for an announced IP.
+type Requester struct {
+	IP        string    `json:"ip"`
+	MAC       string    `json:"mac"`
+	Interface string    `json:"interface"`
+	Count     uint64    `json:"count"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// State is a snapshot of the announcer for troubleshooting.
+type State struct {
+	Interfaces    []string  `json:"interfaces"`
+	ARPResponders []string  `json:"arpResponders"`
+	NDPResponders []string  `json:"ndpResponders"`
+	IPs           []IPState `json:"ips"`
+}
+
+type IPState struct {
+	IP       string   `json:"ip"`
+	Gateways []string `json:"gateways"`
+	Refcnt   int      `json:"refcnt"`
+	// Interfaces the IP is answered on, all interfaces when AllInterfaces is set
+	AllInterfaces bool     `json:"allInterfaces"`
+	Interfaces    []string `json:"interfaces,omitempty"`
+	// NDPWatches are the interfaces which joined the solicited-node multicast group of the IP
+	NDPWatches []string    `json:"ndpWatches,omitempty"`
+	Requesters []Requester `json:"requesters,omitempty"`
+}
+
+// recordRequest remembers the sender of a request for ip, it returns the gateway
+// which announces ip.
+func (a *Announce) recordRequest(ip net.IP, intf string, sender net.IP, mac net.HardwareAddr) string {
+	a.Lock()
+	defer a.Unlock()
+
+	key := ip.String()
+	if a.ipRefcnt[key] <= 0 {
+		// the IP was withdrawn after the request was accepted
+		return ""
+	}
+	now := time.Now()
+	requesters := a.requesters[key]
+	found := false
+	for i := range requesters {
+		item := &requesters[i]
+		if item.MAC == mac.String() && item.Interface == intf {
+			item.IP = sender.String()
+			item.Count++
+			item.LastSeen = now
+			found = true
+			break
+		}
+	}
+	if !found {
+		if len(requesters) >= maxRequesters {
+			sort.Slice(requesters, func(i, j int) bool {
+				return requesters[i].LastSeen.After(requesters[j].LastSeen)
+			})
+			requesters = requesters[:maxRequesters-1]
+		}
+		requesters = append(requesters, Requester{
+			IP:        sender.String(),
+			MAC:       mac.String(),
+			Interface: intf,
+			Count:     1,
+			LastSeen:  now,
+		})
+	}
+	a.requesters[key] = requesters
+	return a.gatewayOf(ip)
+}
+
+// gatewayOf returns the first name which announces ip, the caller must hold the lock.
+func (a *Announce) gatewayOf(ip net.IP) string {
+	gateways := a.gatewaysOf(ip)
+	if len(gateways) == 0 {
+		return ""
+	}
+	return gateways[0]
+}
+
+func (a *Announce) gatewaysOf(ip net.IP) []string {
+	var res []string
+	for name, advs := range a.ips {
+		for _, adv := range advs {
+			if adv.ip.Equal(ip) {
+				res = append(res, name)
+				break
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// State returns a snapshot of the announced IPs and the responders.
+func (a *Announce) State() State {
+	a.RLock()
+	defer a.RUnlock()
+
+	state := State{
+		Interfaces:    append([]string{}, a.nodeInterfaces...),
+		ARPResponders: []string{},
+		NDPResponders: []string{},
+		IPs:           []IPState{},
+	}
+	for _, client := range a.arps {
+		state.ARPResponders = append(state.ARPResponders, client.Interface())
+	}
+	for _, client := range a.ndps {
+		state.NDPResponders = append(state.NDPResponders, client.Interface())
+	}
+	sort.Strings(state.ARPResponders)
+	sort.Strings(state.NDPResponders)
+
+	seen := make(map[string]struct{})
+	for _, advs := range a.ips {
+		for _, adv := range advs {
+			key := adv.ip.String()
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+
+			item := IPState{
+				IP:            key,
+				Gateways:      a.gatewaysOf(adv.ip),
+				Refcnt:        a.ipRefcnt[key],
+				AllInterfaces: adv.allInterfaces,
+				Requesters:    append([]Requester{}, a.requesters[key]...),
+			}
+			if !adv.allInterfaces {
+				for intf := range adv.interfaces {
+					item.Interfaces = append(item.Interfaces, intf)
+				}
+				sort.Strings(item.Interfaces)
+			}
+			if adv.ip.To4() == nil && item.Refcnt > 0 {
+				// the NDP responders watch every IPv6 address in use, see acquire
+				item.NDPWatches = append(item.NDPWatches, state.NDPResponders...)
+			}
+			sort.Slice(item.Requesters, func(i, j int) bool {
+				return item.Requesters[i].LastSeen.After(item.Requesters[j].LastSeen)
+			})
+			state.IPs = append(state.IPs, item)
+		}
+	}
+	sort.Slice(state.IPs, func(i, j int) bool {
+		return state.IPs[i].IP < state.IPs[j].IP
+	})
+	return state
+}
+
+// Handler serves the State of a as JSON.
+func Handler(a *Announce) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(a.State())
+	})
+}
@@ -0,0 +1,85 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package layer2
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+func TestState(t *testing.T) {
+	a, _, _ := newTestAnnounce()
+	a.nodeInterfaces = []string{"eth0", "eth1"}
+
+	a.SetBalancers("egw1", []IPAdvertisement{adv("10.6.1.21"), adv("fd00::21")})
+	a.SetBalancers("egw2", []IPAdvertisement{
+		NewIPAdvertisement(net.ParseIP("10.6.1.21"), false, sets.New[string]("eth1")),
+	})
+	drainSpam(a)
+
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+	assert.Equal(t, "egw1", a.recordRequest(net.ParseIP("10.6.1.21"), "eth0", net.ParseIP("10.6.0.1"), mac))
+	assert.Equal(t, "egw1", a.recordRequest(net.ParseIP("10.6.1.21"), "eth0", net.ParseIP("10.6.0.1"), mac))
+	assert.Equal(t, "", a.recordRequest(net.ParseIP("10.6.1.99"), "eth0", net.ParseIP("10.6.0.1"), mac))
+
+	state := a.State()
+	assert.Equal(t, []string{"eth0", "eth1"}, state.Interfaces)
+	assert.Equal(t, []string{"eth0"}, state.ARPResponders)
+	assert.Len(t, state.IPs, 2)
+
+	v4 := state.IPs[0]
+	assert.Equal(t, "10.6.1.21", v4.IP)
+	assert.Equal(t, []string{"egw1", "egw2"}, v4.Gateways)
+	assert.Equal(t, 2, v4.Refcnt)
+	assert.Empty(t, v4.NDPWatches)
+	assert.Len(t, v4.Requesters, 1)
+	assert.Equal(t, uint64(2), v4.Requesters[0].Count)
+	assert.Equal(t, mac.String(), v4.Requesters[0].MAC)
+
+	v6 := state.IPs[1]
+	assert.Equal(t, "fd00::21", v6.IP)
+	assert.Equal(t, []string{"eth0"}, v6.NDPWatches)
+
+	// the requesters are dropped with the IP
+	a.SetBalancers("egw1", nil)
+	a.SetBalancers("egw2", nil)
+	assert.Empty(t, a.State().IPs)
+	assert.Empty(t, a.requesters)
+}
+
+func TestMaxRequesters(t *testing.T) {
+	a, _, _ := newTestAnnounce()
+	a.SetBalancers("egw1", []IPAdvertisement{adv("10.6.1.21")})
+	drainSpam(a)
+
+	for i := 0; i < maxRequesters+4; i++ {
+		mac := net.HardwareAddr{0x02, 0, 0, 0, 0, byte(i)}
+		a.recordRequest(net.ParseIP("10.6.1.21"), "eth0", net.ParseIP(fmt.Sprintf("10.6.0.%d", i)), mac)
+	}
+	requesters := a.State().IPs[0].Requesters
+	assert.Len(t, requesters, maxRequesters)
+	// the most recent one is kept
+	assert.Equal(t, fmt.Sprintf("10.6.0.%d", maxRequesters+3), requesters[0].IP)
+}
+
+func TestHandler(t *testing.T) {
+	a, _, _ := newTestAnnounce()
+	a.SetBalancers("egw1", []IPAdvertisement{adv("10.6.1.21")})
+	drainSpam(a)
+
+	rec := httptest.NewRecorder()
+	Handler(a).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/layer2", nil))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	state := State{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &state))
+	assert.Equal(t, "10.6.1.21", state.IPs[0].IP)
+	assert.Equal(t, []string{"egw1"}, state.IPs[0].Gateways)
+}
@@ -17,6 +17,8 @@ var stats = metrics{
 		Name:      "requests_received",
 		Help:      "Number of layer2 requests received for owned IPs",
 	}, []string{
+		"gateway",
+		"interface",
 		"ip",
 	}),
 
@@ -26,6 +28,8 @@ var stats = metrics{
 		Name:      "responses_sent",
 		Help:      "Number of layer2 responses sent for owned IPs in response to requests",
 	}, []string{
+		"gateway",
+		"interface",
 		"ip",
 	}),
 
@@ -35,6 +39,8 @@ var stats = metrics{
 		Name:      "gratuitous_sent",
 		Help:      "Number of gratuitous layer2 packets sent for owned IPs as a result of failovers",
 	}, []string{
+		"gateway",
+		"interface",
 		"ip",
 	}),
 }
@@ -51,14 +57,14 @@ func init() {
 	prometheus.MustRegister(stats.gratuitous)
 }
 
-func (m *metrics) GotRequest(addr string) {
-	m.in.WithLabelValues(addr).Add(1)
+func (m *metrics) GotRequest(gateway, intf, addr string) {
+	m.in.WithLabelValues(gateway, intf, addr).Add(1)
 }
 
-func (m *metrics) SentResponse(addr string) {
-	m.out.WithLabelValues(addr).Add(1)
+func (m *metrics) SentResponse(gateway, intf, addr string) {
+	m.out.WithLabelValues(gateway, intf, addr).Add(1)
 }
 
-func (m *metrics) SentGratuitous(addr string) {
-	m.gratuitous.WithLabelValues(addr).Add(1)
+func (m *metrics) SentGratuitous(gateway, intf, addr string) {
+	m.gratuitous.WithLabelValues(gateway, intf, addr).Add(1)
 }
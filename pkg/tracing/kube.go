@@ -0,0 +1,141 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package tracing
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// traceStateKey is the member of the W3C tracestate holding the unix time the trace
+// of a change started at, so that the age of the trace is known from the annotations
+const traceStateKey = "egressgateway"
+
+var propagator = propagation.TraceContext{}
+
+// annotationCarrier is the propagation.TextMapCarrier of the annotations of an object,
+// the W3C traceparent and tracestate are kept in annotations of the egressgateway domain.
+type annotationCarrier map[string]string
+
+var annotationKeys = map[string]string{
+	"traceparent": egressv1.AnnotationTraceParent,
+	"tracestate":  egressv1.AnnotationTraceState,
+}
+
+func (c annotationCarrier) Get(key string) string {
+	return c[annotationKeys[key]]
+}
+
+func (c annotationCarrier) Set(key, value string) {
+	if annotation, ok := annotationKeys[key]; ok {
+		c[annotation] = value
+	}
+}
+
+func (c annotationCarrier) Keys() []string {
+	return []string{"traceparent", "tracestate"}
+}
+
+// Annotations returns the annotations carrying the trace context of the span of ctx,
+// they are nil when ctx has no span. The start time of the trace is added to the
+// tracestate when the span starts the trace.
+func Annotations(ctx context.Context) map[string]string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return nil
+	}
+	if sc.TraceState().Get(traceStateKey) == "" {
+		state, err := sc.TraceState().Insert(traceStateKey, strconv.FormatInt(time.Now().Unix(), 10))
+		if err == nil {
+			ctx = trace.ContextWithSpanContext(ctx, sc.WithTraceState(state))
+		}
+	}
+	carrier := annotationCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier
+}
+
+// ContextFromObject returns a context whose spans join the trace of the last change
+// of obj, read from its annotations. The trace is not joined when it is older than
+// the correlation window, the resyncs long after a change start their own traces.
+func ContextFromObject(ctx context.Context, obj metav1.Object) context.Context {
+	p := global.Load()
+	if p == nil || obj == nil {
+		return ctx
+	}
+	remote := propagator.Extract(ctx, annotationCarrier(obj.GetAnnotations()))
+	sc := trace.SpanContextFromContext(remote)
+	if !sc.IsValid() {
+		return ctx
+	}
+	if window := p.opts.CorrelationWindow; window > 0 {
+		start, err := strconv.ParseInt(sc.TraceState().Get(traceStateKey), 10, 64)
+		if err != nil || time.Since(time.Unix(start, 0)) > window {
+			return ctx
+		}
+	}
+	return remote
+}
+
+// ObjectAttributes returns the attributes identifying obj in a span
+func ObjectAttributes(kind string, obj metav1.Object) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{attribute.String("k8s.kind", kind), attribute.String("k8s.name", obj.GetName())}
+	if obj.GetNamespace() != "" {
+		attrs = append(attrs, attribute.String("k8s.namespace", obj.GetNamespace()))
+	}
+	return attrs
+}
+
+// WrapClient returns a client whose status updates and patches are recorded as spans
+// of the traced operations.
+func WrapClient(cli client.Client) client.Client {
+	return &tracedClient{Client: cli}
+}
+
+type tracedClient struct {
+	client.Client
+}
+
+func (c *tracedClient) Status() client.SubResourceWriter {
+	return &statusWriter{SubResourceWriter: c.Client.Status(), client: c.Client}
+}
+
+type statusWriter struct {
+	client.SubResourceWriter
+	client client.Client
+}
+
+func (w *statusWriter) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	ctx, span := StartChild(ctx, "status update", w.attributes(obj)...)
+	defer span.End()
+	err := w.SubResourceWriter.Update(ctx, obj, opts...)
+	RecordError(span, err)
+	return err
+}
+
+func (w *statusWriter) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	ctx, span := StartChild(ctx, "status patch", w.attributes(obj)...)
+	defer span.End()
+	err := w.SubResourceWriter.Patch(ctx, obj, patch, opts...)
+	RecordError(span, err)
+	return err
+}
+
+func (w *statusWriter) attributes(obj client.Object) []attribute.KeyValue {
+	kind := ""
+	if gvk, err := apiutil.GVKForObject(obj, w.client.Scheme()); err == nil {
+		kind = gvk.Kind
+	}
+	return ObjectAttributes(kind, obj)
+}
@@ -0,0 +1,146 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+// Package tracing records OpenTelemetry spans of the webhook, the controller and the
+// agents with the OpenTelemetry SDK, and exports them to an OTLP/HTTP receiver. The
+// spans of a change of a policy share a trace, its W3C trace context is written in the
+// annotations of the policy by the webhook and read by the reconcilers of the
+// controller and agents.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// scopeName is the instrumentation scope of the spans
+const scopeName = "github.com/spidernet-io/egressgateway"
+
+// Options configures a Provider
+type Options struct {
+	// Endpoint is the base URL of the OTLP/HTTP receiver, e.g. http://otel-collector:4318,
+	// the spans are posted to its /v1/traces path
+	Endpoint string
+	// ExportInterval is the interval the ended spans are exported
+	ExportInterval time.Duration
+	// Service is the service.name of the spans, e.g. egressgateway-controller
+	Service string
+	// Resource are the other attributes of the process, e.g. k8s.node.name
+	Resource []attribute.KeyValue
+	// CorrelationWindow is how long after a change of an object its reconciles join the
+	// trace of the change
+	CorrelationWindow time.Duration
+	Log               logr.Logger
+}
+
+// Provider is the TracerProvider of the process. It is a Runnable of the manager, the
+// spans are exported once more when it stops.
+type Provider struct {
+	opts Options
+	tp   *sdktrace.TracerProvider
+}
+
+func New(opts Options) (*Provider, error) {
+	u, err := url.Parse(opts.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tracing endpoint %q: %w", opts.Endpoint, err)
+	}
+	clientOpts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(strings.TrimSuffix(u.Path, "/") + "/v1/traces"),
+	}
+	if u.Scheme == "http" {
+		clientOpts = append(clientOpts, otlptracehttp.WithInsecure())
+	}
+	// the client connects on the first export
+	exporter, err := otlptracehttp.New(context.Background(), clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the otlp exporter: %w", err)
+	}
+
+	var batchOpts []sdktrace.BatchSpanProcessorOption
+	if opts.ExportInterval > 0 {
+		batchOpts = append(batchOpts, sdktrace.WithBatchTimeout(opts.ExportInterval))
+	}
+	attrs := append([]attribute.KeyValue{attribute.String("service.name", opts.Service)}, opts.Resource...)
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter, batchOpts...),
+		sdktrace.WithResource(resource.NewSchemaless(attrs...)),
+	)
+	return &Provider{opts: opts, tp: tp}, nil
+}
+
+func (p *Provider) Start(ctx context.Context) error {
+	p.opts.Log.Info("tracing started", "endpoint", p.opts.Endpoint)
+	<-ctx.Done()
+	// the context is done, the last spans are exported with their own deadline
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.tp.Shutdown(shutdownCtx); err != nil {
+		p.opts.Log.Error(err, "failed to export spans")
+	}
+	return nil
+}
+
+func (p *Provider) NeedLeaderElection() bool { return false }
+
+var global atomic.Pointer[Provider]
+
+// SetProvider sets the provider of the spans started by Start, a nil provider
+// disables tracing
+func SetProvider(p *Provider) {
+	global.Store(p)
+	if p == nil {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		return
+	}
+	otel.SetLogger(p.opts.Log)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		p.opts.Log.Error(err, "tracing")
+	}))
+	otel.SetTracerProvider(p.tp)
+}
+
+// Enabled reports whether the spans are recorded
+func Enabled() bool {
+	return global.Load() != nil
+}
+
+// Start starts a span, child of the span of ctx or of its remote parent. A new trace
+// is started without parent. The span is not recording when tracing is disabled.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(scopeName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartChild starts a span only when ctx has a parent, the span of ctx or a remote
+// parent, so that the operations which are not part of a traced path do not start
+// traces
+func StartChild(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	return Start(ctx, name, attrs...)
+}
+
+// RecordError sets the status of the span to error, a nil err is ignored
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
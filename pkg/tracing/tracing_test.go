@@ -0,0 +1,154 @@
+// Copyright 2022 Authors of spidernet-io
+// SPDX-License-Identifier: Apache-2.0
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	egressv1 "github.com/spidernet-io/egressgateway/pkg/k8s/apis/v1beta1"
+)
+
+// newTestProvider returns a provider whose spans are kept by the returned exporter
+func newTestProvider(window time.Duration) (*Provider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	return &Provider{
+		opts: Options{CorrelationWindow: window, Log: logr.Discard()},
+		tp:   sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)),
+	}, exporter
+}
+
+func TestStart(t *testing.T) {
+	SetProvider(nil)
+	_, span := Start(context.Background(), "disabled")
+	assert.False(t, span.IsRecording())
+	assert.False(t, Enabled())
+
+	provider, exporter := newTestProvider(0)
+	SetProvider(provider)
+	defer SetProvider(nil)
+
+	_, child := StartChild(context.Background(), "child")
+	assert.False(t, child.IsRecording(), "no span without parent")
+
+	ctx, root := Start(context.Background(), "root")
+	_, child = StartChild(ctx, "child")
+	RecordError(child, errors.New("failed"))
+	RecordError(root, nil)
+	child.End()
+	root.End()
+
+	spans := exporter.GetSpans()
+	if !assert.Len(t, spans, 2) {
+		return
+	}
+	assert.Equal(t, "child", spans[0].Name)
+	assert.Equal(t, root.SpanContext().TraceID(), spans[0].SpanContext.TraceID())
+	assert.Equal(t, root.SpanContext().SpanID(), spans[0].Parent.SpanID())
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+	assert.Equal(t, "failed", spans[0].Status.Description)
+	assert.Equal(t, codes.Unset, spans[1].Status.Code)
+	assert.NotEqual(t, root.SpanContext().TraceID(), trace.TraceID{})
+}
+
+func TestContextFromObject(t *testing.T) {
+	provider, _ := newTestProvider(time.Minute)
+	SetProvider(provider)
+	defer SetProvider(nil)
+
+	ctx, webhook := Start(context.Background(), "webhook mutate")
+	fresh := Annotations(ctx)
+	assert.Contains(t, fresh, egressv1.AnnotationTraceParent)
+	assert.Contains(t, fresh, egressv1.AnnotationTraceState)
+
+	stale := map[string]string{
+		egressv1.AnnotationTraceParent: fresh[egressv1.AnnotationTraceParent],
+		egressv1.AnnotationTraceState:  traceStateKey + "=" + strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10),
+	}
+
+	cases := map[string]struct {
+		annotations map[string]string
+		parent      bool
+	}{
+		"no annotation": {},
+		"invalid":       {annotations: map[string]string{egressv1.AnnotationTraceParent: "invalid"}},
+		"no start time": {annotations: map[string]string{egressv1.AnnotationTraceParent: fresh[egressv1.AnnotationTraceParent]}},
+		"stale":         {annotations: stale},
+		"fresh":         {annotations: fresh, parent: true},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			obj := &metav1.ObjectMeta{Name: "policy", Annotations: c.annotations}
+			_, span := StartChild(ContextFromObject(context.Background(), obj), "reconcile")
+			if !c.parent {
+				assert.False(t, span.IsRecording())
+				return
+			}
+			if !assert.True(t, span.IsRecording()) {
+				return
+			}
+			assert.Equal(t, webhook.SpanContext().TraceID(), span.SpanContext().TraceID())
+			assert.Equal(t, webhook.SpanContext().SpanID(), span.(sdktrace.ReadOnlySpan).Parent().SpanID())
+		})
+	}
+}
+
+func TestAnnotationsKeepStartTime(t *testing.T) {
+	provider, _ := newTestProvider(time.Minute)
+	SetProvider(provider)
+	defer SetProvider(nil)
+
+	assert.Nil(t, Annotations(context.Background()))
+
+	ctx, _ := Start(context.Background(), "webhook mutate")
+	first := Annotations(ctx)
+	// a span joining the trace keeps the start time of the trace
+	ctx, _ = StartChild(ContextFromObject(context.Background(), &metav1.ObjectMeta{Annotations: first}), "webhook validate")
+	second := Annotations(ctx)
+	assert.Equal(t, first[egressv1.AnnotationTraceState], second[egressv1.AnnotationTraceState])
+	assert.NotEqual(t, first[egressv1.AnnotationTraceParent], second[egressv1.AnnotationTraceParent])
+}
+
+func TestProviderExport(t *testing.T) {
+	requests := make(chan *http.Request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+	}))
+	defer server.Close()
+
+	provider, err := New(Options{Endpoint: server.URL + "/", ExportInterval: time.Hour, Service: "egressgateway-controller", Log: logr.Discard()})
+	if !assert.NoError(t, err) {
+		return
+	}
+	SetProvider(provider)
+	defer SetProvider(nil)
+
+	_, span := Start(context.Background(), "root")
+	span.End()
+
+	// the spans are exported when the provider stops
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NoError(t, provider.Start(ctx))
+	select {
+	case r := <-requests:
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no export request")
+	}
+}
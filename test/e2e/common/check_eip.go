@@ -108,10 +108,11 @@ func CheckPodEgressIP(ctx context.Context, cfg *Config, pod corev1.Pod, egressIP
 }
 
 func generateCmd(ctx context.Context, config *Config, pod corev1.Pod, eip, serverIP string, expectUsedEip bool) *exec.Cmd {
-	curlServer := fmt.Sprintf("nettools-client -addr %s -protocol %s -tcpPort %v -udpPort %v -webPort %v -eip %s -batch true",
+	// the bool flags need the -flag=value form, the parsing of the flags stops at a bare value
+	curlServer := fmt.Sprintf("nettools-client -addr %s -protocol %s -tcpPort %v -udpPort %v -webPort %v -eip %s -batch=true",
 		serverIP, config.Mod, config.TcpPort, config.UdpPort, config.WebPort, eip)
 	if !expectUsedEip {
-		curlServer = curlServer + " -contain false"
+		curlServer = curlServer + " -contain=false"
 	}
 	args := fmt.Sprintf("kubectl --kubeconfig %s exec %s -n %s -- %s", config.KubeConfigPath, pod.Name, pod.Namespace, curlServer)
 	return exec.CommandContext(ctx, "sh", "-c", args)